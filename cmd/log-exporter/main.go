@@ -1,15 +1,28 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
 	"github.com/msaf1980/log-exporter/pkg/config"
+	_ "github.com/msaf1980/log-exporter/pkg/filter_init"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/pipeline"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "seekdb" {
+		os.Exit(seekdbMain(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	configPath := flag.String("config", "", "Path to the config file.")
 	checkConfig := flag.Bool("check-config", false, "Check config file and exit.")
 	debug := flag.Bool("debug", false, "sets log level to debug")
@@ -31,9 +44,19 @@ func main() {
 		log.Fatal().Err(err).Msg("load config")
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p, err := pipeline.New(ctx, &cfg.Common, cfg.Inputs, cfg.Filters, cfg.Outputs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("init pipeline")
+	}
+
 	if *checkConfig {
 		return
 	}
 
-	_ = cfg
+	if err = p.Start(ctx); err != nil {
+		log.Fatal().Err(err).Msg("pipeline")
+	}
 }
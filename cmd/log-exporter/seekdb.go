@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/flock"
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+)
+
+const seekdbUsage = `Usage: log-exporter seekdb <command> [flags] <seek_file> [args]
+
+Commands:
+  dump [-format json|table] [-input id] <seek_file> [path ...]  print records with current file size and lag
+  set [-input id] <seek_file> <path> <offset|end>               force record offset (record created, if not exist)
+  delete [-input id] <seek_file> <path> ...                     delete records
+  reset [-input id] <seek_file> [path ...]                      reset offsets to the beginning (all records, if no paths)
+  compact [-ttl duration] <seek_file>                           delete records for not existing (or recreated) files and rewrite db
+
+Records are namespaced by input id, without -input records for path are selected from all inputs.
+Commands, which modify db, fail if db is locked by running log-exporter.
+`
+
+// seekRecord is a seek db record with current file state
+type seekRecord struct {
+	Path        string    `json:"path"`
+	Input       string    `json:"input"`
+	Dev         uint64    `json:"dev"`
+	Inode       uint64    `json:"inode"`
+	Nlink       uint64    `json:"nlink"`
+	Offset      int64     `json:"offset"`
+	Updated     time.Time `json:"updated"`
+	Fingerprint uint64    `json:"fingerprint,omitempty"`
+	Size        int64     `json:"size"` // current file size (-1 - not exist or recreated)
+	Lag         int64     `json:"lag"`  // unreaded bytes (-1 - unknown)
+}
+
+// fileState return current file stat, ok is false if file not exist or recreated
+func fileState(path string, rec *fstatdb.Record) (fsutil.Fsnode, bool) {
+	var fnode fsutil.Fsnode
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fnode, false
+	}
+	fsutil.Stat(fi, &fnode)
+	return fnode, fsutil.Same(&fnode, &rec.Fsnode)
+}
+
+func seekdbMain(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, seekdbUsage)
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "dump":
+		err = seekdbDump(args[1:], stdout, stderr)
+	case "set":
+		err = seekdbSet(args[1:], stderr)
+	case "delete":
+		err = seekdbDelete(args[1:], stderr)
+	case "reset":
+		err = seekdbReset(args[1:], stderr)
+	case "compact":
+		err = seekdbCompact(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, seekdbUsage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command: %s\n\n%s", args[0], seekdbUsage)
+		return 2
+	}
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintf(stderr, "seekdb %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func newFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("seekdb "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, seekdbUsage)
+	}
+	return fs
+}
+
+// open db for modify, fail if db is locked by running exporter
+func open(db *fstatdb.Db, path string) error {
+	if err := db.Open(path); err != nil {
+		if err == flock.ErrLocked {
+			return errors.New(path + " is locked, stop log-exporter before modify")
+		}
+		return err
+	}
+	return nil
+}
+
+func openLocked(path string) (*fstatdb.Db, error) {
+	db := fstatdb.New()
+	if err := open(db, path); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// matchKeys return keys for paths (all keys, if paths is empty), filtered by input (if set)
+func matchKeys(db *fstatdb.Db, input string, paths []string) ([]fstatdb.Key, error) {
+	var keys []fstatdb.Key
+	all := db.Keys()
+	if len(paths) == 0 {
+		for _, key := range all {
+			if input == "" || key.Input == input {
+				keys = append(keys, key)
+			}
+		}
+		return keys, nil
+	}
+	for _, path := range paths {
+		found := false
+		for _, key := range all {
+			if key.Path == path && (input == "" || key.Input == input) {
+				keys = append(keys, key)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("record not found: " + path)
+		}
+	}
+	return keys, nil
+}
+
+func seekdbDump(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("dump", stderr)
+	format := fs.String("format", "table", "output format: json or table")
+	input := fs.String("input", "", "input id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return errors.New("seek_file not set")
+	}
+	if *format != "json" && *format != "table" {
+		return errors.New("invalid format " + *format)
+	}
+
+	// saves in exporter are atomic, so read without lock is safe
+	db := fstatdb.New()
+	if err := db.OpenReadOnly(fs.Arg(0)); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := matchKeys(db, *input, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	records := make([]seekRecord, 0, len(keys))
+	for _, key := range keys {
+		rec, _ := db.GetKey(key)
+		r := seekRecord{
+			Path:        key.Path,
+			Input:       rec.Input,
+			Dev:         rec.Dev,
+			Inode:       rec.Inode,
+			Nlink:       rec.Nlink,
+			Offset:      rec.Size,
+			Updated:     rec.Updated,
+			Fingerprint: rec.Fingerprint,
+			Size:        -1,
+			Lag:         -1,
+		}
+		if fnode, ok := fileState(key.Path, &rec); ok {
+			r.Size = fnode.Size
+			r.Lag = fnode.Size - rec.Size
+		}
+		records = append(records, r)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tINPUT\tDEV\tINODE\tNLINK\tOFFSET\tSIZE\tLAG\tUPDATED")
+	for _, r := range records {
+		size, lag := "-", "-"
+		if r.Size >= 0 {
+			size = strconv.FormatInt(r.Size, 10)
+			lag = strconv.FormatInt(r.Lag, 10)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", r.Path, r.Input, r.Dev, r.Inode, r.Nlink, r.Offset, size, lag, r.Updated.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func seekdbSet(args []string, stderr io.Writer) error {
+	fs := newFlagSet("set", stderr)
+	input := fs.String("input", "", "input id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 3 {
+		return errors.New("seek_file, path and offset must be set")
+	}
+	path := fs.Arg(1)
+
+	db, err := openLocked(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	key := fstatdb.Key{Input: *input, Path: path}
+	if *input == "" {
+		// find input for existing record
+		if keys, _ := matchKeys(db, "", []string{path}); len(keys) > 1 {
+			return errors.New("records for " + path + " found in several inputs, set -input")
+		} else if len(keys) == 1 {
+			key = keys[0]
+		}
+	}
+
+	rec, exist := db.GetKey(key)
+	fnode, ok := fileState(path, &rec)
+	if !exist || !ok {
+		// new record (or file recreated), use current file
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		fsutil.Stat(fi, &fnode)
+		rec = fstatdb.Record{Fsnode: fnode}
+		if exist {
+			fmt.Fprintf(stderr, "%s recreated, record replaced\n", path)
+		}
+	}
+
+	if fs.Arg(2) == "end" {
+		rec.Size = fnode.Size
+	} else if rec.Size, err = strconv.ParseInt(fs.Arg(2), 10, 64); err != nil || rec.Size < 0 {
+		return errors.New("invalid offset " + fs.Arg(2))
+	}
+	if rec.Size > fnode.Size {
+		fmt.Fprintf(stderr, "%s offset %d is greater than file size %d\n", path, rec.Size, fnode.Size)
+	}
+	rec.Updated = time.Now()
+	db.SetKey(key, rec)
+
+	return db.Save()
+}
+
+func seekdbDelete(args []string, stderr io.Writer) error {
+	fs := newFlagSet("delete", stderr)
+	input := fs.String("input", "", "input id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("seek_file and paths must be set")
+	}
+
+	db, err := openLocked(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := matchKeys(db, *input, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		db.DeleteKey(key)
+	}
+
+	return db.Save()
+}
+
+func seekdbReset(args []string, stderr io.Writer) error {
+	fs := newFlagSet("reset", stderr)
+	input := fs.String("input", "", "input id")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return errors.New("seek_file not set")
+	}
+
+	db, err := openLocked(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := matchKeys(db, *input, fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rec, _ := db.GetKey(key)
+		rec.Size = 0
+		rec.Updated = time.Now()
+		db.SetKey(key, rec)
+	}
+
+	return db.Save()
+}
+
+func seekdbCompact(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet("compact", stderr)
+	ttl := fs.Duration("ttl", 0, "also delete records, not updated for duration (0 - disabled)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("seek_file not set")
+	}
+
+	db, err := openLocked(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deleted := 0
+	for _, key := range db.Keys() {
+		rec, _ := db.GetKey(key)
+		if _, ok := fileState(key.Path, &rec); !ok || (*ttl > 0 && time.Since(rec.Updated) > *ttl) {
+			db.DeleteKey(key)
+			deleted++
+		}
+	}
+	fmt.Fprintf(stdout, "%d records deleted, %d records left\n", deleted, len(db.Keys()))
+
+	return db.Save()
+}
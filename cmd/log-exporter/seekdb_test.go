@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+)
+
+func TestSeekdbMain(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := path.Join(dir, "seek.db")
+	aPath := path.Join(dir, "a.log")
+	bPath := path.Join(dir, "b.log")
+	cPath := path.Join(dir, "c.log") // removed file
+	fnodes := make(map[string]fsutil.Fsnode)
+	for _, p := range []string{aPath, bPath, cPath} {
+		if err := os.WriteFile(p, []byte("0123456789"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var fnode fsutil.Fsnode
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fsutil.Stat(fi, &fnode)
+		fnodes[p] = fnode
+	}
+
+	// init seek db: a.log in file input, b.log in file and file-1 inputs, c.log removed, b.log in file-1 not updated for a day
+	initDb := func(t *testing.T) {
+		os.Remove(dbPath)
+		db := fstatdb.New()
+		if err := db.Open(dbPath); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		now := time.Now()
+		for _, r := range []struct {
+			key     fstatdb.Key
+			offset  int64
+			updated time.Time
+		}{
+			{key: fstatdb.Key{Input: "file", Path: aPath}, offset: 4, updated: now},
+			{key: fstatdb.Key{Input: "file", Path: bPath}, offset: 2, updated: now},
+			{key: fstatdb.Key{Input: "file-1", Path: bPath}, offset: 6, updated: now.Add(-24 * time.Hour)},
+			{key: fstatdb.Key{Input: "file", Path: cPath}, offset: 8, updated: now},
+		} {
+			fnode := fnodes[r.key.Path]
+			fnode.Size = r.offset
+			db.SetKey(r.key, fstatdb.Record{Fsnode: fnode, Updated: r.updated})
+		}
+		if err := db.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initDb(t)
+	if err := os.Remove(cPath); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		locked     bool // db locked by running exporter
+		wantCode   int
+		wantStdout []string // substrings
+		wantStderr []string // substrings
+		want       map[fstatdb.Key]int64
+	}{
+		{
+			name:       "dump table",
+			args:       []string{"dump", dbPath},
+			wantStdout: []string{"PATH", aPath + "  file    ", bPath + "  file-1  "},
+		},
+		{
+			name:       "dump json",
+			args:       []string{"dump", "-format", "json", "-input", "file", dbPath, aPath},
+			wantStdout: []string{`"offset": 4`, `"size": 10`, `"lag": 6`},
+		},
+		{name: "dump not found", args: []string{"dump", "-input", "file-1", dbPath, aPath}, wantCode: 1, wantStderr: []string{"record not found"}},
+		{
+			name: "set end",
+			args: []string{"set", dbPath, aPath, "end"},
+			want: map[fstatdb.Key]int64{
+				{Input: "file", Path: aPath}: 10, {Input: "file", Path: bPath}: 2, {Input: "file-1", Path: bPath}: 6, {Input: "file", Path: cPath}: 8,
+			},
+		},
+		{name: "set without input", args: []string{"set", dbPath, bPath, "5"}, wantCode: 1, wantStderr: []string{"set -input"}},
+		{
+			name: "set with input",
+			args: []string{"set", "-input", "file-1", dbPath, bPath, "5"},
+			want: map[fstatdb.Key]int64{
+				{Input: "file", Path: aPath}: 4, {Input: "file", Path: bPath}: 2, {Input: "file-1", Path: bPath}: 5, {Input: "file", Path: cPath}: 8,
+			},
+		},
+		{name: "set invalid offset", args: []string{"set", "-input", "file", dbPath, aPath, "-1"}, wantCode: 1, wantStderr: []string{"invalid offset"}},
+		{
+			name: "delete",
+			args: []string{"delete", dbPath, bPath},
+			want: map[fstatdb.Key]int64{{Input: "file", Path: aPath}: 4, {Input: "file", Path: cPath}: 8},
+		},
+		{
+			name: "reset input",
+			args: []string{"reset", "-input", "file", dbPath},
+			want: map[fstatdb.Key]int64{
+				{Input: "file", Path: aPath}: 0, {Input: "file", Path: bPath}: 0, {Input: "file-1", Path: bPath}: 6, {Input: "file", Path: cPath}: 0,
+			},
+		},
+		{
+			name:       "compact",
+			args:       []string{"compact", dbPath},
+			wantStdout: []string{"1 records deleted, 3 records left"},
+			want: map[fstatdb.Key]int64{
+				{Input: "file", Path: aPath}: 4, {Input: "file", Path: bPath}: 2, {Input: "file-1", Path: bPath}: 6,
+			},
+		},
+		{
+			name:       "compact with ttl",
+			args:       []string{"compact", "-ttl", "1h", dbPath},
+			wantStdout: []string{"2 records deleted, 2 records left"},
+			want:       map[fstatdb.Key]int64{{Input: "file", Path: aPath}: 4, {Input: "file", Path: bPath}: 2},
+		},
+		{name: "locked set", args: []string{"set", "-input", "file", dbPath, aPath, "end"}, locked: true, wantCode: 1, wantStderr: []string{"is locked"}},
+		{name: "locked compact", args: []string{"compact", dbPath}, locked: true, wantCode: 1, wantStderr: []string{"is locked"}},
+		// read without lock
+		{name: "locked dump", args: []string{"dump", dbPath}, locked: true, wantStdout: []string{aPath}},
+		{name: "unknown command", args: []string{"list", dbPath}, wantCode: 2, wantStderr: []string{"unknown command"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initDb(t)
+			if tt.locked {
+				db := fstatdb.New()
+				if err := db.Open(dbPath); err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+			}
+
+			var stdout, stderr bytes.Buffer
+			if code := seekdbMain(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Fatalf("seekdbMain() = %d, want %d, stderr:\n%s", code, tt.wantCode, stderr.String())
+			}
+			for _, s := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), s) {
+					t.Errorf("stdout not contain %q:\n%s", s, stdout.String())
+				}
+			}
+			for _, s := range tt.wantStderr {
+				if !strings.Contains(stderr.String(), s) {
+					t.Errorf("stderr not contain %q:\n%s", s, stderr.String())
+				}
+			}
+
+			if tt.want == nil {
+				return
+			}
+			db := fstatdb.New()
+			if err := db.OpenReadOnly(dbPath); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[fstatdb.Key]int64)
+			for _, key := range db.Keys() {
+				rec, _ := db.GetKey(key)
+				got[key] = rec.Size
+			}
+			db.Close()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeekdbDumpJSON(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := path.Join(dir, "seek.db")
+	fPath := path.Join(dir, "a.log")
+	if err := os.WriteFile(fPath, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := seekdbMain([]string{"set", "-input", "file", dbPath, fPath, "3"}, &bytes.Buffer{}, &bytes.Buffer{}); code != 0 {
+		t.Fatalf("seekdbMain(set) = %d", code)
+	}
+	// recreated file (with new inode)
+	if err := os.WriteFile(fPath+".new", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(fPath+".new", fPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := seekdbMain([]string{"dump", "-format", "json", dbPath}, &stdout, &stderr); code != 0 {
+		t.Fatalf("seekdbMain(dump) = %d, stderr:\n%s", code, stderr.String())
+	}
+	var records []seekRecord
+	if err := json.Unmarshal(stdout.Bytes(), &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("records = %+v, want 1 record", records)
+	}
+	r := records[0]
+	if r.Path != fPath || r.Input != "file" || r.Offset != 3 || r.Size != -1 || r.Lag != -1 {
+		t.Errorf("record = %+v, want offset 3 and unknown size and lag", r)
+	}
+}
@@ -3,6 +3,7 @@ module github.com/msaf1980/log-exporter
 go 1.16
 
 require (
+	github.com/golang/snappy v1.0.0
 	github.com/icza/dyno v0.0.0-20220812133438-f0b6f8a18845
 	github.com/json-iterator/go v1.1.12
 	github.com/juju/errors v1.0.0
@@ -0,0 +1,95 @@
+package json
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+)
+
+var ErrNotObject = errors.New("codec json line is not an object")
+
+// JSON is a JSON object per line codec. Object keys are stored as event fields.
+//
+// If object has RFC3339 timestamp field, event timestamp is set from it (receive time by default).
+// Standard fields (type, name, host, path) are set only if not exist in object.
+type JSON struct {
+	name   string
+	typ    string
+	path   string
+	common *config.Common
+}
+
+const Name = "json"
+
+func New(cfg *config.ConfigRaw, common *config.Common, path string) (codec.Codec, error) {
+	return &JSON{
+		typ: cfg.GetStringWithDefault("type", ""), path: path, common: common, name: cfg.GetStringWithDefault("name", Name),
+	}, nil
+}
+
+func (p *JSON) Name() string {
+	return p.name
+}
+
+func (p *JSON) Parse(ts timeutil.Time, data []byte) (*event.Event, error) {
+	if len(data) == 0 {
+		return nil, codec.ErrEmpty
+	}
+	if data[len(data)-1] != '\n' {
+		return nil, codec.ErrIncomplete
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, codec.ErrEmpty
+	}
+	if data[0] != '{' {
+		return nil, ErrNotObject
+	}
+
+	e := event.Get(data)
+	if e == nil {
+		// non-pooled
+		e = &event.Event{
+			Fields: map[string]interface{}{},
+			Tags:   map[string]int{},
+		}
+	} else {
+		for k := range e.Fields {
+			delete(e.Fields, k)
+		}
+		for k := range e.Tags {
+			delete(e.Tags, k)
+		}
+	}
+	if err := json.Unmarshal(data, &e.Fields); err != nil {
+		event.Put(e)
+		return nil, err
+	}
+
+	e.Timestamp = ts.Time()
+	if v, ok := e.Fields["timestamp"].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			e.Timestamp = t
+		}
+	} else {
+		e.Fields["timestamp"] = ts.String()
+	}
+	setDefault(e, "type", p.typ)
+	setDefault(e, "name", p.name)
+	setDefault(e, "host", p.common.Hostname)
+	setDefault(e, "path", p.path)
+
+	return e, nil
+}
+
+func setDefault(e *event.Event, name, value string) {
+	if _, ok := e.Fields[name]; !ok {
+		e.Fields[name] = value
+	}
+}
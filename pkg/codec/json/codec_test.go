@@ -0,0 +1,97 @@
+package json_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+)
+
+func TestJSON_Parse(t *testing.T) {
+	typ := "http"
+	hostname := "abcd"
+	path := "http://127.0.0.1:8080/"
+	p, err := codec.New(&config.ConfigRaw{"type": typ, "codec": "json"}, &config.Common{Hostname: hostname}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := timeutil.Now()
+	tests := []struct {
+		name     string
+		data     []byte
+		want     *event.Event
+		wantTime time.Time
+		wantErr  bool
+	}{
+		{
+			name:    "empty",
+			data:    []byte(" \n"),
+			wantErr: true,
+		},
+		{
+			name:    "incomplete",
+			data:    []byte(`{"message": "test"}`),
+			wantErr: true,
+		},
+		{
+			name:    "not object",
+			data:    []byte("[1, 2]\n"),
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			data:    []byte("{\"message\": \n"),
+			wantErr: true,
+		},
+		{
+			name: "object",
+			data: []byte("{\"message\": \"test\", \"count\": 2, \"host\": \"client\"}\n"),
+			want: &event.Event{
+				Fields: map[string]interface{}{
+					"timestamp": ts.String(), "message": "test", "count": float64(2),
+					"type": typ, "name": "json", "host": "client", "path": path,
+				},
+				Tags: map[string]int{},
+			},
+			wantTime: ts.Time(),
+		},
+		{
+			name: "object with timestamp",
+			data: []byte("{\"message\": \"test\", \"timestamp\": \"2025-10-11T22:14:15.5Z\"}\r\n"),
+			want: &event.Event{
+				Fields: map[string]interface{}{
+					"timestamp": "2025-10-11T22:14:15.5Z", "message": "test",
+					"type": typ, "name": "json", "host": hostname, "path": path,
+				},
+				Tags: map[string]int{},
+			},
+			wantTime: time.Date(2025, 10, 11, 22, 14, 15, 500000000, time.UTC),
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Parse(ts, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("JSON.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if eq, diff := test.EventCmp(tt.want, got, false, false); !eq {
+				t.Errorf("event[%d] mismatch:\n%s", i, diff)
+			}
+			if got != nil {
+				if !got.Timestamp.Equal(tt.wantTime) {
+					t.Errorf("event[%d] timestamp = %s, want %s", i, got.Timestamp, tt.wantTime)
+				}
+				//for check clear reused maps
+				got.Fields["add"] = "test"
+				got.Tags["tag"] = 1
+			}
+			// put event to pool for reuse
+			event.Put(got)
+		})
+	}
+}
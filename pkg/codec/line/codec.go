@@ -61,6 +61,7 @@ func (p *Line) Parse(time timeutil.Time, data []byte) (*event.Event, error) {
 		for k := range e.Fields {
 			delete(e.Fields, k)
 		}
+		e.Timestamp = time.Time()
 		e.Fields["type"] = p.typ
 		e.Fields["name"] = p.name
 		e.Fields["timestamp"] = time.String()
@@ -2,6 +2,7 @@ package line_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/msaf1980/log-exporter/pkg/codec"
 	"github.com/msaf1980/log-exporter/pkg/codec/line"
@@ -118,6 +119,27 @@ func TestLine_ParseWithName(t *testing.T) {
 	event.Put(got)
 }
 
+func TestLine_ParseTimestamp(t *testing.T) {
+	p, err := codec.New(&config.ConfigRaw{"type": "file"}, &config.Common{Hostname: "abcd"}, "/var/log/messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ts := range []timeutil.Time{
+		timeutil.Timestamp(time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC)),
+		timeutil.Timestamp(time.Date(2021, 1, 2, 11, 0, 0, 0, time.UTC)),
+	} {
+		got, err := p.Parse(ts, []byte("line\n"))
+		if err != nil {
+			t.Fatalf("Line.Parse() error = %v", err)
+		}
+		if !got.Timestamp.Equal(ts.Time()) {
+			t.Errorf("event[%d] timestamp = %s, want %s", i, got.Timestamp, ts.Time())
+		}
+		// put event to pool for reuse
+		event.Put(got)
+	}
+}
+
 func benchmarkPase(b *testing.B, data []byte) {
 	ts := timeutil.Now()
 	p, err := codec.New(&config.ConfigRaw{"type": "file", "codec": line.Name}, &config.Common{Hostname: "localhost"}, "/var/log/message")
@@ -0,0 +1,104 @@
+package syslog
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+)
+
+// Syslog is a RFC5424/RFC3164 message codec.
+//
+// Event timestamp is set from message timestamp (receive time, if message without timestamp).
+// RFC3164 timestamps (without year and timezone) parsed in timezone location (local by default).
+type Syslog struct {
+	name   string
+	typ    string
+	path   string
+	common *config.Common
+	loc    *time.Location
+}
+
+const Name = "syslog"
+
+func New(cfg *config.ConfigRaw, common *config.Common, path string) (codec.Codec, error) {
+	loc, err := time.LoadLocation(cfg.GetStringWithDefault("timezone", "Local"))
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{
+		typ: cfg.GetStringWithDefault("type", ""), path: path, common: common, name: cfg.GetStringWithDefault("name", Name),
+		loc: loc,
+	}, nil
+}
+
+func (p *Syslog) Name() string {
+	return p.name
+}
+
+func (p *Syslog) Parse(time timeutil.Time, data []byte) (*event.Event, error) {
+	if len(data) == 0 {
+		return nil, codec.ErrEmpty
+	}
+	if data[len(data)-1] != '\n' {
+		return nil, codec.ErrIncomplete
+	}
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) == 0 {
+		return nil, codec.ErrEmpty
+	}
+
+	var buf []byte
+	e := event.Get(data)
+	if e == nil {
+		// non-pooled
+		buf = append([]byte(nil), data...) // data is a slice of reader buffer and destroyed on next read
+		e = &event.Event{
+			Fields: make(map[string]interface{}, 14),
+			Tags:   map[string]int{},
+		}
+	} else {
+		buf = e.Data[:e.Size]
+		for k := range e.Fields {
+			delete(e.Fields, k)
+		}
+		for k := range e.Tags {
+			delete(e.Tags, k)
+		}
+	}
+
+	m := parse(buf, time.Time(), p.loc)
+
+	e.Fields["type"] = p.typ
+	e.Fields["name"] = p.name
+	if m.timestamp.IsZero() {
+		e.Timestamp = time.Time()
+		e.Fields["timestamp"] = time.String()
+	} else {
+		e.Timestamp = m.timestamp
+		e.Fields["timestamp"] = timeutil.String(m.timestamp)
+	}
+	e.Fields["message"] = stringutils.UnsafeString(m.message)
+	e.Fields["host"] = p.common.Hostname
+	e.Fields["path"] = p.path
+	e.Fields["priority"] = m.priority
+	e.Fields["facility"] = facilities[m.priority>>3]
+	e.Fields["severity"] = severities[m.priority&7]
+	setField(e, "hostname", m.hostname)
+	setField(e, "app_name", m.appName)
+	setField(e, "proc_id", m.procID)
+	setField(e, "msg_id", m.msgID)
+	setField(e, "structured_data", m.structuredData)
+
+	return e, nil
+}
+
+func setField(e *event.Event, name string, value []byte) {
+	if len(value) > 0 {
+		e.Fields[name] = stringutils.UnsafeString(value)
+	}
+}
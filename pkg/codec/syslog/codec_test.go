@@ -0,0 +1,160 @@
+package syslog_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+)
+
+func TestSyslog_Parse(t *testing.T) {
+	typ := "syslog"
+	hostname := "abcd"
+	path := "udp://127.0.0.1:514"
+	p, err := codec.New(&config.ConfigRaw{"type": typ, "codec": "syslog", "timezone": "UTC"}, &config.Common{Hostname: hostname}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := timeutil.Timestamp(time.Date(2026, 1, 1, 0, 0, 10, 0, time.UTC))
+	fields := func(f map[string]interface{}) map[string]interface{} {
+		f["type"] = typ
+		f["name"] = "syslog"
+		f["host"] = hostname
+		f["path"] = path
+		return f
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		want     *event.Event
+		wantTime time.Time
+		wantErr  bool
+	}{
+		{
+			name:    "empty",
+			data:    []byte("\n"),
+			wantErr: true,
+		},
+		{
+			name:    "incomplete",
+			data:    []byte("<13>test"),
+			wantErr: true,
+		},
+		{
+			name: "RFC5424",
+			data: []byte("<165>1 2025-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"App\\]lication\"][examplePriority@32473 class=\"high\"] \xEF\xBB\xBFAn application event\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": "2025-10-11T22:14:15.003Z", "message": "An application event",
+					"priority": 165, "facility": "local4", "severity": "notice",
+					"hostname": "mymachine.example.com", "app_name": "evntslog", "msg_id": "ID47",
+					"structured_data": "[exampleSDID@32473 iut=\"3\" eventSource=\"App\\]lication\"][examplePriority@32473 class=\"high\"]",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: time.Date(2025, 10, 11, 22, 14, 15, 3000000, time.UTC),
+		},
+		{
+			name: "RFC5424 nil values",
+			data: []byte("<14>1 - - - - - -\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": ts.String(), "message": "",
+					"priority": 14, "facility": "user", "severity": "info",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: ts.Time(),
+		},
+		{
+			name: "RFC3164",
+			data: []byte("<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed for lonvick on /dev/pts/8\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": "2025-10-11T22:14:15Z", "message": "'su root' failed for lonvick on /dev/pts/8",
+					"priority": 34, "facility": "auth", "severity": "crit",
+					"hostname": "mymachine", "app_name": "su", "proc_id": "230",
+				}),
+				Tags: map[string]int{},
+			},
+			// previous year, timestamp in the future
+			wantTime: time.Date(2025, 10, 11, 22, 14, 15, 0, time.UTC),
+		},
+		{
+			name: "RFC3164 local socket",
+			data: []byte("<13>Jan  1 00:00:05 app: test message\r\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": "2026-01-01T00:00:05Z", "message": "test message",
+					"priority": 13, "facility": "user", "severity": "notice",
+					"app_name": "app",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: time.Date(2026, 1, 1, 0, 0, 5, 0, time.UTC),
+		},
+		{
+			name: "RFC3164 RFC3339 timestamp",
+			data: []byte("<30>2026-01-01T00:00:01.5+03:00 host cron[1]:job done\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": "2026-01-01T00:00:01.5+03:00", "message": "job done",
+					"priority": 30, "facility": "daemon", "severity": "info",
+					"hostname": "host", "app_name": "cron", "proc_id": "1",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: time.Date(2025, 12, 31, 21, 0, 1, 500000000, time.UTC),
+		},
+		{
+			name: "no header",
+			data: []byte("test message\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": ts.String(), "message": "test message",
+					"priority": 13, "facility": "user", "severity": "notice",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: ts.Time(),
+		},
+		{
+			name: "invalid priority",
+			data: []byte("<192>test\n"),
+			want: &event.Event{
+				Fields: fields(map[string]interface{}{
+					"timestamp": ts.String(), "message": "<192>test",
+					"priority": 13, "facility": "user", "severity": "notice",
+				}),
+				Tags: map[string]int{},
+			},
+			wantTime: ts.Time(),
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Parse(ts, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Syslog.Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if eq, diff := test.EventCmp(tt.want, got, false, false); !eq {
+				t.Errorf("event[%d] mismatch:\n%s", i, diff)
+			}
+			if got != nil {
+				if !got.Timestamp.Equal(tt.wantTime) {
+					t.Errorf("event[%d] timestamp = %s, want %s", i, got.Timestamp, tt.wantTime)
+				}
+				//for check clear reused maps
+				got.Fields["add"] = "test"
+				got.Tags["tag"] = 1
+			}
+			// put event to pool for reuse
+			event.Put(got)
+		})
+	}
+}
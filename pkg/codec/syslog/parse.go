@@ -0,0 +1,244 @@
+package syslog
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/msaf1980/go-stringutils"
+)
+
+// default priority for message without PRI part (user.notice, RFC3164 section 4.3.3)
+const defaultPriority = 13
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "clock",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// message is a parsed syslog message, byte slices are references to parsed buffer
+type message struct {
+	priority       int
+	timestamp      time.Time
+	hostname       []byte
+	appName        []byte
+	procID         []byte
+	msgID          []byte
+	structuredData []byte
+	message        []byte
+}
+
+// parse parse RFC5424 or RFC3164 message. Not parsed parts are stored in message.
+//
+// now and loc used for RFC3164 timestamps (without year and timezone).
+func parse(b []byte, now time.Time, loc *time.Location) (m message) {
+	var ok bool
+	if m.priority, b, ok = parsePriority(b); !ok {
+		m.priority = defaultPriority
+	}
+	if len(b) > 1 && b[0] == '1' && b[1] == ' ' {
+		if parse5424(b[2:], &m) {
+			return
+		}
+		m = message{priority: m.priority}
+	}
+	parse3164(b, now, loc, &m)
+	return
+}
+
+func parsePriority(b []byte) (int, []byte, bool) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, b, false
+	}
+	pri := 0
+	for i := 1; i < len(b) && i < 5; i++ {
+		c := b[i]
+		if c == '>' {
+			if i == 1 || pri > 191 {
+				return 0, b, false
+			}
+			return pri, b[i+1:], true
+		}
+		if c < '0' || c > '9' {
+			return 0, b, false
+		}
+		pri = pri*10 + int(c-'0')
+	}
+	return 0, b, false
+}
+
+// nextToken return space delimited token and rest of buffer (after space)
+func nextToken(b []byte) (tok, rest []byte) {
+	if n := bytes.IndexByte(b, ' '); n == -1 {
+		return b, nil
+	} else {
+		return b[:n], b[n+1:]
+	}
+}
+
+// nilValue return nil for RFC5424 NILVALUE ("-")
+func nilValue(tok []byte) []byte {
+	if len(tok) == 1 && tok[0] == '-' {
+		return nil
+	}
+	return tok
+}
+
+// parse5424 parse RFC5424 message after VERSION SP
+//
+// VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func parse5424(b []byte, m *message) bool {
+	var tok []byte
+	tok, b = nextToken(b)
+	if tok = nilValue(tok); len(tok) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, stringutils.UnsafeString(tok))
+		if err != nil {
+			return false
+		}
+		m.timestamp = t
+	}
+	tok, b = nextToken(b)
+	m.hostname = nilValue(tok)
+	tok, b = nextToken(b)
+	m.appName = nilValue(tok)
+	tok, b = nextToken(b)
+	m.procID = nilValue(tok)
+	tok, b = nextToken(b)
+	m.msgID = nilValue(tok)
+
+	if len(b) == 0 {
+		return false
+	}
+	switch b[0] {
+	case '-':
+		b = b[1:]
+	case '[':
+		n := structuredDataLen(b)
+		if n == -1 {
+			return false
+		}
+		m.structuredData = b[:n]
+		b = b[n:]
+	default:
+		return false
+	}
+	if len(b) > 0 {
+		if b[0] != ' ' {
+			return false
+		}
+		b = bytes.TrimPrefix(b[1:], bom)
+	}
+	m.message = b
+	return true
+}
+
+// structuredDataLen return length of RFC5424 STRUCTURED-DATA elements ([SD-ID PARAM="VALUE" ...]...) or -1 if invalid
+func structuredDataLen(b []byte) int {
+	i := 0
+	for i < len(b) && b[i] == '[' {
+		quoted := false
+		closed := false
+		for i++; i < len(b); i++ {
+			c := b[i]
+			if quoted {
+				if c == '\\' {
+					i++
+				} else if c == '"' {
+					quoted = false
+				}
+			} else if c == '"' {
+				quoted = true
+			} else if c == ']' {
+				closed = true
+				i++
+				break
+			}
+		}
+		if !closed {
+			return -1
+		}
+	}
+	return i
+}
+
+// parse3164 parse RFC3164 (BSD) message after PRI
+//
+// [TIMESTAMP SP [HOSTNAME SP]] [TAG[\[PID\]]: ]MSG
+func parse3164(b []byte, now time.Time, loc *time.Location, m *message) {
+	if t, n := parseStamp(b, now, loc); n > 0 {
+		m.timestamp = t
+		b = b[n:]
+		// hostname is absent in messages from local socket
+		if tok, rest := nextToken(b); len(tok) > 0 && tok[len(tok)-1] != ':' && len(rest) > 0 {
+			m.hostname = tok
+			b = rest
+		}
+	}
+	m.appName, m.procID, b = parseTag(b)
+	m.message = b
+}
+
+// parseStamp parse RFC3164 timestamp (Mmm dd hh:mm:ss) or RFC3339 timestamp, return timestamp and length with trailing space
+func parseStamp(b []byte, now time.Time, loc *time.Location) (time.Time, int) {
+	if len(b) > 15 && b[15] == ' ' {
+		if t, err := time.ParseInLocation(time.Stamp, stringutils.UnsafeString(b[:15]), loc); err == nil {
+			now = now.In(loc)
+			t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+			if t.After(now.Add(24 * time.Hour)) {
+				// message from previous year (received at new year)
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, 16
+		}
+	}
+	if len(b) > 0 && b[0] >= '0' && b[0] <= '9' {
+		tok, _ := nextToken(b)
+		if t, err := time.Parse(time.RFC3339Nano, stringutils.UnsafeString(tok)); err == nil {
+			n := len(tok)
+			if n < len(b) {
+				n++
+			}
+			return t, n
+		}
+	}
+	return time.Time{}, 0
+}
+
+// parseTag parse TAG[PID]: prefix, return tag, pid and rest of message
+func parseTag(b []byte) (tag, pid, rest []byte) {
+	i := 0
+	for ; i < len(b) && i < 64; i++ {
+		c := b[i]
+		if c == ':' || c == '[' || c == ' ' {
+			break
+		}
+	}
+	if i == 0 || i == len(b) {
+		return nil, nil, b
+	}
+	n := i
+	switch b[i] {
+	case '[':
+		end := bytes.IndexByte(b[i:], ']')
+		if end == -1 {
+			return nil, nil, b
+		}
+		pid = b[i+1 : i+end]
+		i += end + 1
+		if i < len(b) && b[i] == ':' {
+			i++
+		}
+	case ':':
+		i++
+	default:
+		return nil, nil, b
+	}
+	if i < len(b) && b[i] == ' ' {
+		i++
+	}
+	return b[:n], pid, b[i:]
+}
@@ -2,9 +2,13 @@ package codec_init
 
 import (
 	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/codec/json"
 	"github.com/msaf1980/log-exporter/pkg/codec/line"
+	"github.com/msaf1980/log-exporter/pkg/codec/syslog"
 )
 
 func init() {
+	codec.Set(json.Name, json.New)
 	codec.Set(line.Name, line.New)
+	codec.Set(syslog.Name, syslog.New)
 }
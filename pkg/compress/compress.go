@@ -0,0 +1,11 @@
+// Package compress implement compression formats, not available in standard library or in dependencies (snappy is github.com/golang/snappy).
+//
+// Encoders are simple (greedy matching without entropy coding) and tuned for speed, not for ratio.
+// Encoders output is checked with reference decoders in tests.
+// Decoders are used in tests and fake servers (lz4 decoder supports only frames with independent blocks).
+package compress
+
+import "errors"
+
+// ErrCorrupt is returned by decoders for invalid or truncated input
+var ErrCorrupt = errors.New("corrupt input")
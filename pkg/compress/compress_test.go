@@ -0,0 +1,111 @@
+package compress
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func testData() map[string][]byte {
+	random := make([]byte, 200000)
+	for i := range random {
+		random[i] = byte(i*7919 + i>>8)
+	}
+	return map[string][]byte{
+		"empty":    {},
+		"short":    []byte("abc"),
+		"repeated": bytes.Repeat([]byte("log line with some text "), 10000),
+		"same":     bytes.Repeat([]byte{'a'}, 300000),
+		"random":   random,
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	codecs := []struct {
+		name     string
+		encode   func(dst, src []byte) []byte
+		decode   func(src []byte) ([]byte, error)
+		compress bool // check repeated data is compressed
+	}{
+		{name: "lz4", encode: LZ4Encode, decode: LZ4Decode, compress: true},
+	}
+	for _, c := range codecs {
+		for name, data := range testData() {
+			t.Run(c.name+"/"+name, func(t *testing.T) {
+				prefix := []byte("prefix")
+				encoded := c.encode(append([]byte{}, prefix...), data)
+				if !bytes.HasPrefix(encoded, prefix) {
+					t.Fatalf("%s encode overwrite dst", c.name)
+				}
+				got, err := c.decode(encoded[len(prefix):])
+				if err != nil {
+					t.Fatalf("%s decode error = %v", c.name, err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("%s decode mismatch, len = %d, want %d", c.name, len(got), len(data))
+				}
+				if c.compress && name == "repeated" && len(encoded) > len(data)/10 {
+					t.Errorf("%s not compressed, len = %d", c.name, len(encoded))
+				}
+			})
+		}
+	}
+}
+
+func TestXXH32(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint32
+	}{
+		{data: "", want: 0x02CC5D05},
+		{data: "a", want: 0x550D7456},
+		{data: "abc", want: 0x32D153FF},
+		{data: "Nobody inspects the spammish repetition", want: 0xE2293B2F},
+	}
+	for _, tt := range tests {
+		if got := xxh32([]byte(tt.data), 0); got != tt.want {
+			t.Errorf("xxh32(%q) = %08x, want %08x", tt.data, got, tt.want)
+		}
+	}
+}
+
+// TestLZ4Reference check encoded frames with reference lz4 command line tool
+func TestLZ4Reference(t *testing.T) {
+	lz4, err := exec.LookPath("lz4")
+	if err != nil {
+		t.Skip("lz4 command not found")
+	}
+	dir := t.TempDir()
+	for name, data := range testData() {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".lz4")
+			if err := os.WriteFile(path, LZ4Encode(nil, data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := exec.Command(lz4, "-d", "-c", path).Output()
+			if err != nil {
+				t.Fatalf("lz4 -d error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("lz4 -d mismatch, len = %d, want %d", len(got), len(data))
+			}
+
+			path = filepath.Join(dir, name)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := exec.Command(lz4, "-z", "-c", "-B4", path).Output()
+			if err != nil {
+				t.Fatalf("lz4 -z error = %v", err)
+			}
+			if got, err = LZ4Decode(encoded); err != nil {
+				t.Fatalf("LZ4Decode() error = %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("LZ4Decode() mismatch, len = %d, want %d", len(got), len(data))
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package compress
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// lz4 frame format (https://github.com/lz4/lz4/blob/dev/doc/lz4_Frame_format.md) with independent 64 KB blocks,
+// used for kafka record batches
+
+const (
+	lz4Magic        = 0x184D2204
+	lz4FrameFlags   = 0x60 // version 01, independent blocks, no checksums and content size
+	lz4BlockMax     = 0x40 // 64 KB max block size
+	lz4BlockSize    = 64 * 1024
+	lz4Uncompressed = 0x80000000
+
+	lz4HashBits  = 14
+	lz4MinMatch  = 4
+	lz4MaxOffset = 65535
+	lz4MFLimit   = 12 // last match must start at least 12 bytes before block end
+	lz4LastLits  = 5  // last 5 bytes are always literals
+)
+
+const (
+	xxhPrime1 uint32 = 2654435761
+	xxhPrime2 uint32 = 2246822519
+	xxhPrime3 uint32 = 3266489917
+	xxhPrime4 uint32 = 668265263
+	xxhPrime5 uint32 = 374761393
+)
+
+func xxh32Round(acc, input uint32) uint32 {
+	return bits.RotateLeft32(acc+input*xxhPrime2, 13) * xxhPrime1
+}
+
+// xxh32 return xxHash32 checksum (for lz4 frame header checksum)
+func xxh32(b []byte, seed uint32) uint32 {
+	n := len(b)
+	var h uint32
+	if n >= 16 {
+		v1 := seed + xxhPrime1 + xxhPrime2
+		v2 := seed + xxhPrime2
+		v3 := seed
+		v4 := seed - xxhPrime1
+		for len(b) >= 16 {
+			v1 = xxh32Round(v1, binary.LittleEndian.Uint32(b))
+			v2 = xxh32Round(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxh32Round(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxh32Round(v4, binary.LittleEndian.Uint32(b[12:]))
+			b = b[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) + bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxhPrime5
+	}
+	h += uint32(n)
+	for len(b) >= 4 {
+		h += binary.LittleEndian.Uint32(b) * xxhPrime3
+		h = bits.RotateLeft32(h, 17) * xxhPrime4
+		b = b[4:]
+	}
+	for _, c := range b {
+		h += uint32(c) * xxhPrime5
+		h = bits.RotateLeft32(h, 11) * xxhPrime1
+	}
+	h ^= h >> 15
+	h *= xxhPrime2
+	h ^= h >> 13
+	h *= xxhPrime3
+	h ^= h >> 16
+	return h
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+// lz4AppendSequence append literals and match (if length > 0)
+func lz4AppendSequence(dst, lit []byte, offset, length int) []byte {
+	token := byte(0)
+	if len(lit) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(lit)) << 4
+	}
+	if length > 0 {
+		if length-lz4MinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(length - lz4MinMatch)
+		}
+	}
+	dst = append(dst, token)
+	if len(lit) >= 15 {
+		dst = lz4AppendLength(dst, len(lit)-15)
+	}
+	dst = append(dst, lit...)
+	if length > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if length-lz4MinMatch >= 15 {
+			dst = lz4AppendLength(dst, length-lz4MinMatch-15)
+		}
+	}
+	return dst
+}
+
+// lz4CompressBlock append lz4 compressed block of src to dst
+func lz4CompressBlock(dst, src []byte) []byte {
+	var table [1 << lz4HashBits]int32
+	anchor := 0
+	if len(src) > lz4MFLimit {
+		limit := len(src) - lz4MFLimit
+		for i := 0; i < limit; {
+			u := binary.LittleEndian.Uint32(src[i:])
+			h := (u * 2654435761) >> (32 - lz4HashBits)
+			candidate := int(table[h]) - 1
+			table[h] = int32(i + 1)
+			if candidate < 0 || i-candidate > lz4MaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != u {
+				i++
+				continue
+			}
+			length := lz4MinMatch
+			for i+length < len(src)-lz4LastLits && src[candidate+length] == src[i+length] {
+				length++
+			}
+			dst = lz4AppendSequence(dst, src[anchor:i], i-candidate, length)
+			i += length
+			anchor = i
+		}
+	}
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// LZ4Encode append lz4 frame of src to dst
+func LZ4Encode(dst, src []byte) []byte {
+	dst = append(dst, 0x04, 0x22, 0x4D, 0x18) // magic
+	dst = append(dst, lz4FrameFlags, lz4BlockMax)
+	dst = append(dst, byte(xxh32(dst[len(dst)-2:], 0)>>8))
+	for len(src) > 0 {
+		block := src
+		if len(block) > lz4BlockSize {
+			block = block[:lz4BlockSize]
+		}
+		src = src[len(block):]
+
+		start := len(dst)
+		dst = append(dst, 0, 0, 0, 0)
+		dst = lz4CompressBlock(dst, block)
+		size := len(dst) - start - 4
+		if size >= len(block) {
+			// store uncompressed
+			dst = append(dst[:start+4], block...)
+			binary.LittleEndian.PutUint32(dst[start:], uint32(len(block))|lz4Uncompressed)
+		} else {
+			binary.LittleEndian.PutUint32(dst[start:], uint32(size))
+		}
+	}
+	// end mark
+	return append(dst, 0, 0, 0, 0)
+}
+
+// lz4DecompressBlock append decompressed block to dst
+func lz4DecompressBlock(dst, src []byte) ([]byte, error) {
+	readLength := func(n int) (int, error) {
+		for {
+			if len(src) == 0 {
+				return 0, ErrCorrupt
+			}
+			c := src[0]
+			src = src[1:]
+			n += int(c)
+			if c != 255 {
+				return n, nil
+			}
+		}
+	}
+	start := len(dst)
+	for len(src) > 0 {
+		token := src[0]
+		src = src[1:]
+		var err error
+		lit := int(token >> 4)
+		if lit == 15 {
+			if lit, err = readLength(lit); err != nil {
+				return nil, err
+			}
+		}
+		if len(src) < lit {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[:lit]...)
+		src = src[lit:]
+		if len(src) == 0 {
+			// last literals
+			break
+		}
+		if len(src) < 2 {
+			return nil, ErrCorrupt
+		}
+		offset := int(src[0]) | int(src[1])<<8
+		src = src[2:]
+		length := int(token & 0x0f)
+		if length == 15 {
+			if length, err = readLength(length); err != nil {
+				return nil, err
+			}
+		}
+		length += lz4MinMatch
+		if offset == 0 || offset > len(dst)-start {
+			return nil, ErrCorrupt
+		}
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	return dst, nil
+}
+
+// LZ4Decode decode lz4 frame (frames with dependent blocks or dictionary are not supported)
+func LZ4Decode(src []byte) ([]byte, error) {
+	if len(src) < 7 || binary.LittleEndian.Uint32(src) != lz4Magic {
+		return nil, ErrCorrupt
+	}
+	flags := src[4]
+	if flags>>6 != 1 {
+		return nil, ErrCorrupt
+	}
+	if flags&0x20 == 0 {
+		return nil, errors.New("lz4 dependent blocks not supported")
+	}
+	if flags&0x01 != 0 {
+		return nil, errors.New("lz4 dictionary not supported")
+	}
+	blockChecksum := flags&0x10 != 0
+	contentChecksum := flags&0x04 != 0
+	headerSize := 7
+	if flags&0x08 != 0 {
+		// content size
+		headerSize += 8
+	}
+	if len(src) < headerSize || byte(xxh32(src[4:headerSize-1], 0)>>8) != src[headerSize-1] {
+		return nil, ErrCorrupt
+	}
+	src = src[headerSize:]
+
+	var (
+		dst []byte
+		err error
+	)
+	for {
+		if len(src) < 4 {
+			return nil, ErrCorrupt
+		}
+		size := binary.LittleEndian.Uint32(src)
+		src = src[4:]
+		if size == 0 {
+			break
+		}
+		n := int(size &^ lz4Uncompressed)
+		if len(src) < n {
+			return nil, ErrCorrupt
+		}
+		if size&lz4Uncompressed != 0 {
+			dst = append(dst, src[:n]...)
+		} else if dst, err = lz4DecompressBlock(dst, src[:n]); err != nil {
+			return nil, err
+		}
+		src = src[n:]
+		if blockChecksum {
+			if len(src) < 4 {
+				return nil, ErrCorrupt
+			}
+			src = src[4:]
+		}
+	}
+	if contentChecksum {
+		if len(src) < 4 || binary.LittleEndian.Uint32(src) != xxh32(dst, 0) {
+			return nil, ErrCorrupt
+		}
+	}
+	return dst, nil
+}
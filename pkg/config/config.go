@@ -48,8 +48,10 @@ type Common struct {
 }
 
 type Config struct {
-	Inputs []ConfigRaw `hcl:"input" yaml:"input" json:"input"`
-	Common Common      `hcl:"common" yaml:"common" json:"common"`
+	Inputs  []ConfigRaw `hcl:"input" yaml:"input" json:"input"`
+	Filters []ConfigRaw `hcl:"filter" yaml:"filter" json:"filter"`
+	Outputs []ConfigRaw `hcl:"output" yaml:"output" json:"output"`
+	Common  Common      `hcl:"common" yaml:"common" json:"common"`
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+
+	json "github.com/json-iterator/go"
+)
+
+// Strings is a string list, can be set as single string or list of strings
+type Strings []string
+
+func (s *Strings) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err == nil {
+		*s = Strings{v}
+		return nil
+	}
+	var l []string
+	if err := json.Unmarshal(data, &l); err != nil {
+		return err
+	}
+	*s = l
+	return nil
+}
+
+// UnmarshalYAML for use Strings in yaml files
+func (s *Strings) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v string
+	if err := unmarshal(&v); err == nil {
+		*s = Strings{v}
+		return nil
+	}
+	var l []string
+	if err := unmarshal(&l); err != nil {
+		return err
+	}
+	*s = l
+	return nil
+}
+
+func (s Strings) String() string {
+	return strings.Join(s, ",")
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+)
+
+// TLS is a TLS config with certificates from local files
+type TLS struct {
+	Enabled            bool   `hcl:"enabled" yaml:"enabled" json:"enabled"`
+	CertFile           string `hcl:"cert_file" yaml:"cert_file" json:"cert_file"`                                  // certificate (for server or client auth)
+	KeyFile            string `hcl:"key_file" yaml:"key_file" json:"key_file"`                                     // certificate key
+	CAFile             string `hcl:"ca_file" yaml:"ca_file" json:"ca_file"`                                        // CA for verify peer certificates (system pool if not set)
+	ClientAuth         bool   `hcl:"client_auth" yaml:"client_auth" json:"client_auth"`                            // server require and verify client certificates
+	InsecureSkipVerify bool   `hcl:"insecure_skip_verify" yaml:"insecure_skip_verify" json:"insecure_skip_verify"` // client not verify server certificate
+	ServerName         string `hcl:"server_name" yaml:"server_name" json:"server_name"`                            // client server name for verify
+}
+
+func (t *TLS) certPool() (*x509.CertPool, error) {
+	if t.CAFile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(t.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New("no certificates found in " + t.CAFile)
+	}
+	return pool, nil
+}
+
+// ServerConfig return server TLS config (nil if TLS is disabled)
+func (t *TLS) ServerConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, errors.New("tls cert_file or key_file not set")
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := t.certPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if t.ClientAuth {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientConfig return client TLS config (nil if TLS is disabled)
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+	pool, err := t.certPool()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		RootCAs:            pool,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,43 @@
+package event
+
+import "sync/atomic"
+
+// Acker is notified, when event processing is completed: event is returned to pool (with Put) after outputs
+// or dropped by filters. Acker is used by inputs for commit read position only for processed events.
+type Acker interface {
+	// Ack called once per event, delivered is false, if output failed to deliver event (Failed is set)
+	Ack(delivered bool)
+}
+
+// Ack notify event acker (if set) and reset ack state, called by Put
+func Ack(e *Event) {
+	if e.Acker != nil {
+		e.Acker.Ack(!e.Failed)
+		e.Acker = nil
+	}
+	e.Failed = false
+}
+
+// ackGroup notify acker after all event copies are acknowledged
+type ackGroup struct {
+	acker   Acker
+	pending int32
+	failed  int32
+}
+
+func (g *ackGroup) Ack(delivered bool) {
+	if !delivered {
+		atomic.StoreInt32(&g.failed, 1)
+	}
+	if atomic.AddInt32(&g.pending, -1) == 0 {
+		g.acker.Ack(atomic.LoadInt32(&g.failed) == 0)
+	}
+}
+
+// ShareAck prepare event acker for n event copies (like clones for parallel outputs), copies must use event Acker.
+// Original acker notified after all copies are acknowledged, delivered only if all copies are delivered.
+func ShareAck(e *Event, n int) {
+	if e.Acker != nil && n > 1 {
+		e.Acker = &ackGroup{acker: e.Acker, pending: int32(n)}
+	}
+}
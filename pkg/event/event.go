@@ -11,6 +11,8 @@ type Event struct {
 	Timestamp time.Time
 	Fields    map[string]interface{}
 	Tags      map[string]int
+	Acker     Acker // notified on event processing completion (by Put), set by inputs
+	Failed    bool  // delivery failed, set by outputs (passed to Acker)
 }
 
 func New(size int) *Event {
@@ -28,3 +30,27 @@ func String(e *Event) string {
 	}
 	return fmt.Sprintf("{ timestamp: '%s', fields: %#v, tags: %#v }", e.Timestamp.Format(time.RFC3339Nano), e.Fields, e.Tags)
 }
+
+// Clone return non-pooled event copy (not deep copy for fields, except strings).
+//
+// String fields are copied, because pooled event fields can reference to pooled buffer.
+// Acker is not copied, use ShareAck for events copies.
+func Clone(e *Event) *Event {
+	c := &Event{
+		Timestamp: e.Timestamp,
+		Fields:    make(map[string]interface{}, len(e.Fields)),
+		Tags:      make(map[string]int, len(e.Tags)),
+	}
+	for k, v := range e.Fields {
+		if s, ok := v.(string); ok {
+			c.Fields[k] = string([]byte(s))
+		} else {
+			c.Fields[k] = v
+		}
+	}
+	for k, v := range e.Tags {
+		c.Tags[k] = v
+	}
+
+	return c
+}
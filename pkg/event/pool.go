@@ -48,8 +48,13 @@ func Get(data []byte) *Event {
 	return e
 }
 
+// Put acknowledge event and return pooled event to pool
 func Put(e *Event) {
-	if e == nil || e.Size == 0 {
+	if e == nil {
+		return
+	}
+	Ack(e)
+	if e.Size == 0 {
 		// non-pooled
 		return
 	}
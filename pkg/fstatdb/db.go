@@ -1,165 +1,532 @@
 package fstatdb
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"io"
+	"hash/crc32"
 	"os"
+	"path/filepath"
+	"sort"
+	"time"
 
 	"github.com/msaf1980/log-exporter/pkg/flock"
 	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/rs/zerolog/log"
 )
 
 const (
+	SIZE_INT32 = 4
 	SIZE_INT64 = 8
 	MAX_SIZE   = 1024
+
+	// Version is a current db format version
+	Version uint32 = 2
 )
 
 var (
-	ErrUnexpectedEnd  = errors.New("unexpected end")
-	ErrInvalidPathLen = errors.New("empty or long path")
+	ErrUnexpectedEnd   = errors.New("unexpected end")
+	ErrInvalidPathLen  = errors.New("empty or long path")
+	ErrInvalidChecksum = errors.New("invalid checksum")
+	ErrInvalidVersion  = errors.New("unsupported version")
+	ErrReadOnly        = errors.New("db opened in read-only mode")
 )
 
-// Db store files state in bimary file
-// filelen_u64 filname dev_u64 inode_u64 offset_i64
-// filelen_u64 filname dev_u64 inode_u64 offset_i64
+// Magic is a db file header prefix
+var Magic = [4]byte{'F', 'S', 'D', 'B'}
+
+// Options is a db options
+type Options struct {
+	Input           string        // input name (namespace for records)
+	TTL             time.Duration // expire records, not updated (or seen) for TTL (0 - disabled)
+	FingerprintSize int           // store fingerprint of first bytes of file for detect inode reuse (0 - disabled)
+}
+
+// Db store files state in binary file
+//
+// magic_4b version_u32
+// filelen_u64 filname inputlen_u64 input dev_u64 inode_u64 nlink_u64 offset_i64 updated_i64 fingerprint_u64 crc32_u32
+// filelen_u64 filname inputlen_u64 input dev_u64 inode_u64 nlink_u64 offset_i64 updated_i64 fingerprint_u64 crc32_u32
+//
+// Previous formats are migrated on load and rewrited in current format on next save:
+//
+// version 1: filelen_u64 filname dev_u64 inode_u64 offset_i64 crc32_u32
+//
+// legacy (without header): filelen_u64 filname dev_u64 inode_u64 offset_i64
+//
+// Db file is replaced on save (write to temporary file, fsync and rename), so db locked with path.lock file.
+// Db file itself is also locked (and relocked after save), like in previous versions (they lock only db file).
+// Upgrade order: stop exporter with previous version before start new version (if previous version
+// is started after new version, it can open db in short window between save rename and relock).
 type Db struct {
-	f *os.File
-	b bytes.Buffer
+	path string
+	lock *os.File
+	f    *os.File // locked db file
+	b    bytes.Buffer
+	opts Options
+	ttls map[string]time.Duration // TTL for input namespaces
 
-	v map[string]fsutil.Fsnode
+	v map[Key]Record
+}
+
+// Key is a record key, records are namespaced by input
+type Key struct {
+	Input string
+	Path  string
 }
 
 func New() *Db {
+	return NewWithOptions(Options{})
+}
+
+func NewWithOptions(opts Options) *Db {
 	return &Db{
-		v: make(map[string]fsutil.Fsnode),
+		opts: opts,
+		v:    make(map[Key]Record),
 	}
 }
 
 func (db *Db) Open(path string) (err error) {
-	if db.f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666); err != nil {
+	if db.lock, err = os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0666); err != nil {
 		return err
 	}
-	if err = flock.TryLock(db.f); err != nil {
+	if err = flock.TryLock(db.lock); err != nil {
+		db.lock.Close()
+		return err
+	}
+	db.path = path
+
+	// lock db file, for detect running previous versions
+	if db.f, err = lockFile(path); err != nil {
+		db.lock.Close()
 		return err
 	}
 
 	if err = db.load(); err != nil {
+		db.f.Close()
+		db.lock.Close()
 		return err
 	}
 
 	return nil
 }
 
-func (db *Db) Close() (err error) {
-	if len(db.v) > 0 {
-		db.v = make(map[string]fsutil.Fsnode)
+// lockFile open (or create) and lock file
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
 	}
-	return db.f.Close()
+	if err = flock.TryLock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
 }
 
-func read(r io.Reader, b []byte) error {
-	if n, err := r.Read(b); err != nil {
-		return err
-	} else if n != len(b) {
-		return ErrUnexpectedEnd
+// OpenReadOnly load db without lock (for inspect db of running exporter), Save is not allowed
+func (db *Db) OpenReadOnly(path string) error {
+	db.path = path
+	db.lock = nil
+	return db.load()
+}
+
+// Path return db file path
+func (db *Db) Path() string {
+	return db.path
+}
+
+func (db *Db) Close() (err error) {
+	if len(db.v) > 0 {
+		db.v = make(map[Key]Record)
 	}
-	return nil
+	if db.lock == nil {
+		return nil
+	}
+	if db.f != nil {
+		err = db.f.Close()
+		db.f = nil
+	}
+	if cerr := db.lock.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
 func (db *Db) load() error {
-	var err error
 	if len(db.v) > 0 {
-		db.v = make(map[string]fsutil.Fsnode)
+		db.v = make(map[Key]Record)
 	}
-	r := bufio.NewReader(db.f)
-	var buf [MAX_SIZE]byte
-	for {
-		// path len
-		err = read(r, buf[:SIZE_INT64])
-		if err != nil {
-			if err == io.EOF {
-				return nil
-			}
-			return err
+	data, err := os.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
 		}
-		n := binary.LittleEndian.Uint64(buf[:SIZE_INT64])
-		if n < 1 || n > MAX_SIZE {
-			return ErrInvalidPathLen
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	if len(data) < len(Magic)+SIZE_INT32 || !bytes.Equal(data[:len(Magic)], Magic[:]) {
+		// legacy format, without header and checksums
+		db.decode(data, 0)
+	} else {
+		version := binary.LittleEndian.Uint32(data[len(Magic):])
+		if version < 1 || version > Version {
+			return ErrInvalidVersion
 		}
-		// path
-		err = read(r, buf[:n])
+		db.decode(data[len(Magic)+SIZE_INT32:], version)
+	}
+	db.Expire()
+
+	return nil
+}
+
+// decode read records in format version, corrupted records skipped with warning
+func (db *Db) decode(data []byte, version uint32) {
+	now := time.Now()
+	for len(data) > 0 {
+		size, err := recordSize(data, version)
 		if err != nil {
-			return err
+			// can't find next record
+			db.corrupted(err, len(data))
+			return
+		}
+		record := data[:size]
+		data = data[size:]
+		if version > 0 {
+			crc := binary.LittleEndian.Uint32(record[size-SIZE_INT32:])
+			record = record[:size-SIZE_INT32]
+			if crc32.ChecksumIEEE(record) != crc {
+				db.corrupted(ErrInvalidChecksum, size)
+				continue
+			}
 		}
-		path := string(buf[:n])
 
-		var fsnode fsutil.Fsnode
-		// inode
-		err = read(r, buf[:SIZE_INT64])
-		if err != nil {
-			return err
+		var rec Record
+		path, record := readString(record)
+		if version > 1 {
+			rec.Input, record = readString(record)
 		}
-		fsnode.Dev = binary.LittleEndian.Uint64(buf[:SIZE_INT64])
-		// inode
-		err = read(r, buf[:SIZE_INT64])
-		if err != nil {
-			return err
+		rec.Dev, record = readUint64(record)
+		rec.Inode, record = readUint64(record)
+		if version > 1 {
+			rec.Nlink, record = readUint64(record)
+		}
+		var v uint64
+		v, record = readUint64(record)
+		rec.Size = int64(v)
+		if version > 1 {
+			v, record = readUint64(record)
+			rec.Updated = time.Unix(0, int64(v))
+			rec.Fingerprint, _ = readUint64(record)
+		} else {
+			// migrated, ttl started from now
+			rec.Updated = now
 		}
-		fsnode.Inode = binary.LittleEndian.Uint64(buf[:SIZE_INT64])
 
-		// offset
-		err = read(r, buf[:SIZE_INT64])
+		db.v[Key{Input: rec.Input, Path: path}] = rec
+	}
+}
+
+// recordSize return record size (with checksum) or error, if record is truncated or invalid
+func recordSize(data []byte, version uint32) (int, error) {
+	size, err := stringSize(data, false)
+	if err != nil {
+		return 0, err
+	}
+	if version > 1 {
+		// input name can be empty
+		inputLen, err := stringSize(data[size:], true)
 		if err != nil {
-			return err
+			return 0, err
 		}
-		fsnode.Size = int64(binary.LittleEndian.Uint64(buf[:SIZE_INT64]))
+		// dev inode nlink offset updated fingerprint crc32
+		size += inputLen + 6*SIZE_INT64 + SIZE_INT32
+	} else {
+		// dev inode offset
+		size += 3 * SIZE_INT64
+		if version > 0 {
+			size += SIZE_INT32
+		}
+	}
+	if len(data) < size {
+		return 0, ErrUnexpectedEnd
+	}
+	return size, nil
+}
 
-		db.Set(path, fsnode)
+// stringSize return size of length prefixed string
+func stringSize(data []byte, allowEmpty bool) (int, error) {
+	if len(data) < SIZE_INT64 {
+		return 0, ErrUnexpectedEnd
 	}
+	n := binary.LittleEndian.Uint64(data)
+	if (n < 1 && !allowEmpty) || n > MAX_SIZE {
+		return 0, ErrInvalidPathLen
+	}
+	return SIZE_INT64 + int(n), nil
+}
+
+func readString(data []byte) (string, []byte) {
+	n := int(binary.LittleEndian.Uint64(data))
+	return string(data[SIZE_INT64 : SIZE_INT64+n]), data[SIZE_INT64+n:]
+}
+
+func readUint64(data []byte) (uint64, []byte) {
+	return binary.LittleEndian.Uint64(data), data[SIZE_INT64:]
+}
+
+func (db *Db) corrupted(err error, size int) {
+	log.Warn().Str("seek", db.path).Int("size", size).Err(err).Msg("corrupted records skipped")
 }
 
+// Set update file state (Size is a readed offset)
 func (db *Db) Set(path string, fsnode fsutil.Fsnode) {
-	db.v[path] = fsnode
+	db.set(&db.opts, path, fsnode)
+}
+
+func (db *Db) set(opts *Options, path string, fsnode fsutil.Fsnode) {
+	key := Key{Input: opts.Input, Path: path}
+	rec := Record{Fsnode: fsnode, Input: opts.Input, Updated: time.Now()}
+	if opts.FingerprintSize > 0 {
+		if old, exist := db.v[key]; exist && old.Fingerprint != 0 && fsutil.Same(&old.Fsnode, &fsnode) {
+			rec.Fingerprint = old.Fingerprint
+		} else if fsnode.Size >= int64(opts.FingerprintSize) {
+			rec.Fingerprint = Fingerprint(path, &fsnode, opts.FingerprintSize)
+		}
+	}
+	db.v[key] = rec
 }
 
+// Get return file state (Size is a readed offset).
+//
+// If fingerprint enabled and file on path has same inode, but other fingerprint (inode reused), record is deleted.
 func (db *Db) Get(path string) (fsutil.Fsnode, bool) {
-	fsnode, exist := db.v[path]
+	fsnode, exist, _ := db.get(&db.opts, path)
 	return fsnode, exist
 }
 
+// get return file state and changed flag (record claimed or deleted)
+func (db *Db) get(opts *Options, path string) (fsnode fsutil.Fsnode, exist, changed bool) {
+	key := Key{Input: opts.Input, Path: path}
+	rec, exist := db.v[key]
+	if !exist && opts.Input != "" {
+		// record without input (migrated from old format), claim it
+		orphan := Key{Path: path}
+		if rec, exist = db.v[orphan]; exist {
+			delete(db.v, orphan)
+			rec.Input = opts.Input
+			db.v[key] = rec
+			changed = true
+		}
+	}
+	if exist && opts.FingerprintSize > 0 && rec.Fingerprint != 0 {
+		if fp := Fingerprint(path, &rec.Fsnode, opts.FingerprintSize); fp != 0 && fp != rec.Fingerprint {
+			log.Debug().Str("seek", db.path).Str("input", opts.Input).Str("file", path).Msg("fingerprint changed, record deleted")
+			delete(db.v, key)
+			return fsutil.Fsnode{}, false, true
+		}
+	}
+	return rec.Fsnode, exist, changed
+}
+
+// GetRecord return stored record
+func (db *Db) GetRecord(path string) (Record, bool) {
+	return db.GetKey(Key{Input: db.opts.Input, Path: path})
+}
+
+// SetRecord store record as is
+func (db *Db) SetRecord(path string, rec Record) {
+	db.SetKey(Key{Input: db.opts.Input, Path: path}, rec)
+}
+
+// GetKey return stored record from any input namespace
+func (db *Db) GetKey(key Key) (Record, bool) {
+	rec, exist := db.v[key]
+	return rec, exist
+}
+
+// SetKey store record as is to any input namespace
+func (db *Db) SetKey(key Key, rec Record) {
+	rec.Input = key.Input
+	db.v[key] = rec
+}
+
+// Touch mark record as seen (reset TTL)
+func (db *Db) Touch(path string) {
+	db.touch(Key{Input: db.opts.Input, Path: path})
+}
+
+func (db *Db) touch(key Key) {
+	if rec, exist := db.v[key]; exist {
+		rec.Updated = time.Now()
+		db.v[key] = rec
+	}
+}
+
+// Delete delete record, return false if record not exist
+func (db *Db) Delete(path string) bool {
+	return db.DeleteKey(Key{Input: db.opts.Input, Path: path})
+}
+
+// DeleteKey delete record from any input namespace, return false if record not exist
+func (db *Db) DeleteKey(key Key) bool {
+	if _, exist := db.v[key]; exist {
+		delete(db.v, key)
+		return true
+	}
+	return false
+}
+
+// Paths return sorted paths of stored records (in db input namespace)
+func (db *Db) Paths() []string {
+	paths := make([]string, 0, len(db.v))
+	for key := range db.v {
+		if key.Input == db.opts.Input {
+			paths = append(paths, key.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Keys return sorted (by input and path) keys of all stored records
+func (db *Db) Keys() []Key {
+	keys := make([]Key, 0, len(db.v))
+	for key := range db.v {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Input == keys[j].Input {
+			return keys[i].Path < keys[j].Path
+		}
+		return keys[i].Input < keys[j].Input
+	})
+	return keys
+}
+
 func (db *Db) IsExist(path string) bool {
-	_, exist := db.v[path]
+	_, exist := db.v[Key{Input: db.opts.Input, Path: path}]
 	return exist
 }
 
-func (db *Db) Save() (err error) {
-	db.b.Reset()
+// Expire delete records, not updated for TTL (input TTL, if set with SetTTL). Return deleted records count
+func (db *Db) Expire() int {
+	n := 0
+	now := time.Now()
+	for key, rec := range db.v {
+		ttl, ok := db.ttls[key.Input]
+		if !ok {
+			ttl = db.opts.TTL
+		}
+		if ttl > 0 && rec.Updated.Before(now.Add(-ttl)) {
+			delete(db.v, key)
+			n++
+		}
+	}
+	if n > 0 {
+		log.Debug().Str("seek", db.path).Int("count", n).Msg("expired records deleted")
+	}
+	return n
+}
+
+// SetTTL set records TTL for input namespace
+func (db *Db) SetTTL(input string, ttl time.Duration) {
+	if db.ttls == nil {
+		db.ttls = make(map[string]time.Duration)
+	}
+	db.ttls[input] = ttl
+}
+
+func (db *Db) writeString(s string) {
+	var buf [SIZE_INT64]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(len(s)))
+	db.b.Write(buf[:])
+	db.b.WriteString(s)
+}
+
+func (db *Db) writeUint64(v uint64) {
 	var buf [SIZE_INT64]byte
-	for path, fsnode := range db.v {
-		// path
-		binary.LittleEndian.PutUint64(buf[:SIZE_INT64], uint64(len(path)))
-		db.b.Write(buf[:SIZE_INT64])
-		db.b.WriteString(path)
-		// dev
-		binary.LittleEndian.PutUint64(buf[:SIZE_INT64], fsnode.Dev)
-		db.b.Write(buf[:SIZE_INT64])
-		// inode
-		binary.LittleEndian.PutUint64(buf[:SIZE_INT64], fsnode.Inode)
-		db.b.Write(buf[:SIZE_INT64])
-		// offset
-		binary.LittleEndian.PutUint64(buf[:SIZE_INT64], uint64(fsnode.Size))
-		db.b.Write(buf[:SIZE_INT64])
-	}
-	if err = db.f.Truncate(int64(db.b.Len())); err != nil {
+	binary.LittleEndian.PutUint64(buf[:], v)
+	db.b.Write(buf[:])
+}
+
+func (db *Db) encode() {
+	db.b.Reset()
+	var buf [SIZE_INT32]byte
+	db.b.Write(Magic[:])
+	binary.LittleEndian.PutUint32(buf[:], Version)
+	db.b.Write(buf[:])
+	for key, rec := range db.v {
+		start := db.b.Len()
+		db.writeString(key.Path)
+		db.writeString(key.Input)
+		db.writeUint64(rec.Dev)
+		db.writeUint64(rec.Inode)
+		db.writeUint64(rec.Nlink)
+		db.writeUint64(uint64(rec.Size))
+		db.writeUint64(uint64(rec.Updated.UnixNano()))
+		db.writeUint64(rec.Fingerprint)
+		// checksum
+		binary.LittleEndian.PutUint32(buf[:], crc32.ChecksumIEEE(db.b.Bytes()[start:]))
+		db.b.Write(buf[:])
+	}
+}
+
+// Flush save db (for use Db as Seeker)
+func (db *Db) Flush() error {
+	return db.Save()
+}
+
+// Save write db to temporary file and atomically replace db file (expired records are deleted before)
+func (db *Db) Save() (err error) {
+	if db.lock == nil {
+		return ErrReadOnly
+	}
+	db.Expire()
+	db.encode()
+
+	tmp := db.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return
+	}
+	if _, err = f.Write(db.b.Bytes()); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, db.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
 		return
 	}
-	db.f.Seek(0, 0)
-	if _, err = db.f.Write(db.b.Bytes()); err != nil {
+	if err = syncDir(filepath.Dir(db.path)); err != nil {
 		return
 	}
-	err = db.f.Sync()
-	return
+	// relock replaced db file
+	if f, err = lockFile(db.path); err != nil {
+		return
+	}
+	db.f.Close()
+	db.f = f
+	return nil
+}
+
+// syncDir fsync directory for persist rename
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
@@ -1,9 +1,15 @@
 package fstatdb
 
 import (
+	"encoding/binary"
+	"hash/crc32"
 	"os"
+	"path"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/msaf1980/log-exporter/pkg/flock"
 	"github.com/msaf1980/log-exporter/pkg/fsutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,27 +21,28 @@ func TestDb(t *testing.T) {
 	path := f.Name()
 	require.NoError(t, err)
 	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
 
 	err = db.Open(path)
 	assert.NoError(t, err)
 	require.Equal(t, 0, len(db.v))
 
 	files := map[string]fsutil.Fsnode{
-		"/var/log/messages": {Dev: 1, Inode: 1024, Size: 4096},
-		"/var/log/yum.log":  {Dev: 1, Inode: 2001, Size: 1},
+		"/var/log/messages": {Dev: 1, Inode: 1024, Size: 4096, Nlink: 1},
+		"/var/log/yum.log":  {Dev: 1, Inode: 2001, Size: 1, Nlink: 1},
 	}
 
 	for path, fsnode := range files {
 		db.Set(path, fsnode)
 	}
-	assert.Equal(t, files, db.v)
+	assert.Equal(t, files, fsnodes(db))
 
 	err = db.Save()
 	assert.NoError(t, err)
 
 	fsnode, exist := db.Get("/var/log/yum.log")
 	if exist {
-		assert.Equal(t, fsutil.Fsnode{Dev: 1, Inode: 2001, Size: 1}, fsnode)
+		assert.Equal(t, fsutil.Fsnode{Dev: 1, Inode: 2001, Size: 1, Nlink: 1}, fsnode)
 	} else {
 		assert.True(t, exist)
 	}
@@ -57,7 +64,255 @@ func TestDb(t *testing.T) {
 	err = db.Open(path)
 	assert.NoError(t, err)
 	if err == nil {
-		assert.Equal(t, files, db.v)
+		assert.Equal(t, files, fsnodes(db))
+	}
+	db.Close()
+}
+
+func fsnodes(db *Db) map[string]fsutil.Fsnode {
+	m := make(map[string]fsutil.Fsnode)
+	for key, rec := range db.v {
+		m[key.Path] = rec.Fsnode
+	}
+	return m
+}
+
+// oldRecord encode record in legacy (0) or version 1 format
+func oldRecord(path string, fsnode fsutil.Fsnode, version uint32) []byte {
+	b := make([]byte, 4*SIZE_INT64+len(path))
+	binary.LittleEndian.PutUint64(b, uint64(len(path)))
+	copy(b[SIZE_INT64:], path)
+	pos := SIZE_INT64 + len(path)
+	binary.LittleEndian.PutUint64(b[pos:], fsnode.Dev)
+	binary.LittleEndian.PutUint64(b[pos+SIZE_INT64:], fsnode.Inode)
+	binary.LittleEndian.PutUint64(b[pos+2*SIZE_INT64:], uint64(fsnode.Size))
+	if version > 0 {
+		var buf [SIZE_INT32]byte
+		binary.LittleEndian.PutUint32(buf[:], crc32.ChecksumIEEE(b))
+		b = append(b, buf[:]...)
+	}
+	return b
+}
+
+func TestDbMigrate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	files := map[string]fsutil.Fsnode{
+		"/var/log/messages": {Dev: 1, Inode: 1024, Size: 4096},
+		"/var/log/yum.log":  {Dev: 1, Inode: 2001, Size: 1},
 	}
+
+	for _, version := range []uint32{0, 1} {
+		t.Run(strconv.Itoa(int(version)), func(t *testing.T) {
+			var data []byte
+			if version > 0 {
+				data = append(data, Magic[:]...)
+				data = append(data, byte(version), 0, 0, 0)
+			}
+			for path, fsnode := range files {
+				data = append(data, oldRecord(path, fsnode, version)...)
+			}
+			require.NoError(t, os.WriteFile(dbPath, data, 0644))
+
+			db := NewWithOptions(Options{Input: "file", TTL: time.Hour})
+			require.NoError(t, db.Open(dbPath))
+			assert.Equal(t, files, fsnodes(db))
+
+			// rewrited in current format
+			require.NoError(t, db.Save())
+			require.NoError(t, db.Close())
+			data, err = os.ReadFile(dbPath)
+			require.NoError(t, err)
+			assert.Equal(t, Magic[:], data[:len(Magic)])
+			assert.Equal(t, Version, binary.LittleEndian.Uint32(data[len(Magic):]))
+
+			require.NoError(t, db.Open(dbPath))
+			assert.Equal(t, files, fsnodes(db))
+			for path, rec := range db.v {
+				// migrated records not expired
+				assert.WithinDuration(t, time.Now(), rec.Updated, time.Minute, path)
+			}
+			db.Close()
+		})
+	}
+}
+
+func TestDbTTL(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	db := NewWithOptions(Options{Input: "file", TTL: time.Hour})
+	require.NoError(t, db.Open(dbPath))
+	db.Set("/var/log/messages", fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 4096, Nlink: 1})
+	db.SetRecord("/var/log/old.log", Record{Fsnode: fsutil.Fsnode{Dev: 1, Inode: 1025, Size: 10}, Updated: time.Now().Add(-2 * time.Hour)})
+	db.SetRecord("/var/log/seen.log", Record{Fsnode: fsutil.Fsnode{Dev: 1, Inode: 1026, Size: 20}, Updated: time.Now().Add(-2 * time.Hour)})
+	db.Touch("/var/log/seen.log")
+	require.NoError(t, db.Save())
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open(dbPath))
+	assert.Equal(t, map[string]fsutil.Fsnode{
+		"/var/log/messages": {Dev: 1, Inode: 1024, Size: 4096, Nlink: 1},
+		"/var/log/seen.log": {Dev: 1, Inode: 1026, Size: 20},
+	}, fsnodes(db))
+	rec, _ := db.GetRecord("/var/log/messages")
+	assert.Equal(t, "file", rec.Input)
+	db.Close()
+}
+
+func TestDbFingerprint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+	logPath := path.Join(dir, "test.log")
+
+	require.NoError(t, os.WriteFile(logPath, []byte("line 1\nline 2\n"), 0644))
+	var fnode fsutil.Fsnode
+	require.NoError(t, fsutil.LStat(logPath, &fnode))
+
+	db := NewWithOptions(Options{FingerprintSize: 6})
+	require.NoError(t, db.Open(dbPath))
+	db.Set(logPath, fnode)
+	require.NoError(t, db.Save())
+	require.NoError(t, db.Close())
+
+	require.NoError(t, db.Open(dbPath))
+	rec, _ := db.GetRecord(logPath)
+	assert.NotEqual(t, uint64(0), rec.Fingerprint)
+	got, exist := db.Get(logPath)
+	assert.True(t, exist)
+	assert.Equal(t, fnode, got)
+
+	// same inode, other content (like inode reuse)
+	f, err := os.OpenFile(logPath, os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("other"), 0)
+	require.NoError(t, err)
+	f.Close()
+
+	_, exist = db.Get(logPath)
+	assert.False(t, exist)
 	db.Close()
 }
+
+func TestDbCorrupted(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	db := New()
+	require.NoError(t, db.Open(dbPath))
+	db.Set("/var/log/messages", fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 4096})
+	require.NoError(t, db.Save())
+	db.Set("/var/log/yum.log", fsutil.Fsnode{Dev: 1, Inode: 2001, Size: 1})
+	db.Set("/var/log/secure", fsutil.Fsnode{Dev: 1, Inode: 2002, Size: 2})
+	require.NoError(t, db.Save())
+
+	// second open must fail, db is locked
+	db2 := New()
+	assert.Error(t, db2.Open(dbPath))
+
+	require.NoError(t, db.Close())
+
+	data, err := os.ReadFile(dbPath)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		want    int
+	}{
+		{
+			name:    "empty",
+			corrupt: func(data []byte) []byte { return nil },
+			want:    0,
+		},
+		{
+			name: "checksum",
+			corrupt: func(data []byte) []byte {
+				// flip byte in offset of first record
+				data[len(Magic)+SIZE_INT32+SIZE_INT64+2] ^= 0xff
+				return data
+			},
+			want: 2,
+		},
+		{
+			name: "truncated",
+			corrupt: func(data []byte) []byte {
+				return data[:len(data)-2]
+			},
+			want: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.corrupt(append([]byte{}, data...))
+			require.NoError(t, os.WriteFile(dbPath, b, 0644))
+			require.NoError(t, db.Open(dbPath))
+			assert.Equal(t, tt.want, len(db.v))
+			db.Close()
+		})
+	}
+}
+
+func TestDbReadOnly(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	db := New()
+	require.NoError(t, db.Open(dbPath))
+	db.Set("/var/log/messages", fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 4096, Nlink: 1})
+	db.Set("/var/log/auth.log", fsutil.Fsnode{Dev: 1, Inode: 1025, Size: 10, Nlink: 1})
+	require.NoError(t, db.Save())
+
+	// read without lock, while db is opened
+	ro := New()
+	require.NoError(t, ro.OpenReadOnly(dbPath))
+	assert.Equal(t, []string{"/var/log/auth.log", "/var/log/messages"}, ro.Paths())
+	assert.True(t, ro.Delete("/var/log/auth.log"))
+	assert.False(t, ro.Delete("/var/log/auth.log"))
+	assert.Equal(t, ErrReadOnly, ro.Save())
+	require.NoError(t, ro.Close())
+
+	require.NoError(t, db.Close())
+}
+
+func TestDbLockFile(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	// db file locked by previous version
+	f, err := os.OpenFile(dbPath, os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+	require.NoError(t, flock.TryLock(f))
+	db := New()
+	assert.Equal(t, flock.ErrLocked, db.Open(dbPath))
+	f.Close()
+
+	require.NoError(t, db.Open(dbPath))
+	db.Set("/var/log/messages", fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 4096})
+	require.NoError(t, db.Save())
+
+	// replaced db file is locked after save
+	f, err = os.Open(dbPath)
+	require.NoError(t, err)
+	assert.Equal(t, flock.ErrLocked, flock.TryLock(f))
+	f.Close()
+
+	require.NoError(t, db.Close())
+	f, err = os.Open(dbPath)
+	require.NoError(t, err)
+	assert.NoError(t, flock.TryLock(f))
+	f.Close()
+}
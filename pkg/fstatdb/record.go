@@ -0,0 +1,43 @@
+package fstatdb
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+)
+
+// Record is a file state, stored in db
+type Record struct {
+	fsutil.Fsnode           // Size is a readed offset
+	Input         string    // input name
+	Updated       time.Time // last update (or seen) time
+	Fingerprint   uint64    // fingerprint of file head (0 - not set)
+}
+
+// Fingerprint return fingerprint (FNV-64a hash) of first size bytes of file.
+//
+// Return 0, if file is shorter than size or file on path is not a fnode (rotated or recreated).
+func Fingerprint(path string, fnode *fsutil.Fsnode, size int) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var fn fsutil.Fsnode
+	if err = fsutil.FStat(f, &fn); err != nil || fsutil.Other(&fn, fnode) || fn.Size < int64(size) {
+		return 0
+	}
+
+	h := fnv.New64a()
+	if _, err = io.CopyN(h, f, int64(size)); err != nil {
+		return 0
+	}
+	if v := h.Sum64(); v != 0 {
+		return v
+	}
+	return 1
+}
@@ -0,0 +1,234 @@
+package fstatdb
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/rs/zerolog/log"
+)
+
+// FlushInterval is a save interval for shared stores
+var FlushInterval = time.Second
+
+var ErrDuplicateInput = errors.New("input namespace already registered in seek store")
+
+// Seeker is a files state storage for input
+type Seeker interface {
+	// Open open storage (can be reopened after Close)
+	Open() error
+	// Get return file state (Size is a readed offset)
+	Get(path string) (fsutil.Fsnode, bool)
+	// Set update file state (Size is a readed offset)
+	Set(path string, fsnode fsutil.Fsnode)
+	// Touch mark record as seen (reset TTL)
+	Touch(path string)
+	// Flush save changes
+	Flush() error
+	// Close flush changes and release storage
+	Close() error
+}
+
+// Stores is a seek stores, owned by pipeline. Inputs with same seek file share one Store.
+type Stores struct {
+	mu     sync.Mutex
+	stores map[string]*Store
+}
+
+func NewStores() *Stores {
+	return &Stores{stores: make(map[string]*Store)}
+}
+
+// Store is a seek db, shared by inputs with same seek file.
+//
+// Records are namespaced by input, changes saved by one writer goroutine (batched with FlushInterval).
+// Store is opened by first opened namespace and closed by last closed.
+type Store struct {
+	path string
+
+	openMu     sync.Mutex
+	namespaces map[string]bool // registered namespaces
+	active     int             // opened namespaces
+
+	mu    sync.Mutex
+	db    *Db
+	dirty bool
+
+	flush chan chan error
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// Namespace is an input namespace in shared Store, implements Seeker
+type Namespace struct {
+	store  *Store
+	opts   Options
+	opened bool
+}
+
+// Namespace register namespace for input (opts.Input) in store for seek file (store is not opened).
+// Return ErrDuplicateInput, if namespace already registered.
+func (r *Stores) Namespace(path string, opts Options) (*Namespace, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, exist := r.stores[absPath]
+	if !exist {
+		s = &Store{
+			path:       absPath,
+			namespaces: make(map[string]bool),
+		}
+		r.stores[absPath] = s
+	}
+	if s.namespaces[opts.Input] {
+		return nil, ErrDuplicateInput
+	}
+	s.namespaces[opts.Input] = true
+
+	return &Namespace{store: s, opts: opts}, nil
+}
+
+// open open db (for first namespace) and set namespace options
+func (s *Store) open(ns *Namespace) error {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	if ns.opened {
+		return nil
+	}
+	if s.active == 0 {
+		db := New()
+		if err := db.Open(s.path); err != nil {
+			return err
+		}
+		s.db = db
+		s.dirty = false
+		s.flush = make(chan chan error)
+		s.done = make(chan struct{})
+		s.wg.Add(1)
+		go s.writer()
+	}
+	s.active++
+	ns.opened = true
+	s.mu.Lock()
+	s.db.SetTTL(ns.opts.Input, ns.opts.TTL)
+	s.mu.Unlock()
+	return nil
+}
+
+// writer save changes with FlushInterval and on flush request
+func (s *Store) writer() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case result := <-s.flush:
+			result <- s.save()
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				log.Error().Str("seek", s.path).Err(err).Msg("save stat failed")
+			}
+		}
+	}
+}
+
+// save write db, if changed
+func (s *Store) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	if err := s.db.Save(); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// release namespace, last namespace close store
+func (s *Store) release(ns *Namespace) error {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	if !ns.opened {
+		// already released
+		return nil
+	}
+	ns.opened = false
+	s.active--
+	if s.active > 0 {
+		return s.Flush()
+	}
+
+	close(s.done)
+	s.wg.Wait()
+
+	err := s.save()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush save changes (by writer goroutine)
+func (s *Store) Flush() error {
+	result := make(chan error, 1)
+	select {
+	case s.flush <- result:
+		return <-result
+	case <-s.done:
+		return s.save()
+	}
+}
+
+func (ns *Namespace) Open() error {
+	return ns.store.open(ns)
+}
+
+func (ns *Namespace) Get(path string) (fsutil.Fsnode, bool) {
+	ns.store.mu.Lock()
+	defer ns.store.mu.Unlock()
+	fsnode, exist, changed := ns.store.db.get(&ns.opts, path)
+	if changed {
+		ns.store.dirty = true
+	}
+	return fsnode, exist
+}
+
+func (ns *Namespace) Set(path string, fsnode fsutil.Fsnode) {
+	ns.store.mu.Lock()
+	ns.store.db.set(&ns.opts, path, fsnode)
+	ns.store.dirty = true
+	ns.store.mu.Unlock()
+}
+
+func (ns *Namespace) Touch(path string) {
+	ns.store.mu.Lock()
+	ns.store.db.touch(Key{Input: ns.opts.Input, Path: path})
+	ns.store.dirty = true
+	ns.store.mu.Unlock()
+}
+
+func (ns *Namespace) Flush() error {
+	return ns.store.Flush()
+}
+
+func (ns *Namespace) Close() error {
+	return ns.store.release(ns)
+}
+
+// Path return store file path
+func (ns *Namespace) Path() string {
+	return ns.store.path
+}
@@ -0,0 +1,135 @@
+package fstatdb
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	stores := NewStores()
+	ns1, err := stores.Namespace(dbPath, Options{Input: "file1"})
+	require.NoError(t, err)
+	ns2, err := stores.Namespace(dbPath, Options{Input: "file2"})
+	require.NoError(t, err)
+	_, err = stores.Namespace(dbPath, Options{Input: "file2"})
+	assert.Equal(t, ErrDuplicateInput, err)
+	// registered namespaces don't open db
+	db := New()
+	require.NoError(t, db.Open(dbPath))
+	db.Close()
+
+	require.NoError(t, ns1.Open())
+	require.NoError(t, ns2.Open())
+	// db locked by store
+	assert.Error(t, db.Open(dbPath))
+
+	var wg sync.WaitGroup
+	for i, ns := range []*Namespace{ns1, ns2} {
+		wg.Add(1)
+		go func(ns *Namespace, i int) {
+			defer wg.Done()
+			for n := 1; n <= 100; n++ {
+				ns.Set("/var/log/messages", fsutil.Fsnode{Dev: 1, Inode: 1024, Size: int64(n * (i + 1)), Nlink: 1})
+			}
+		}(ns, i)
+	}
+	wg.Wait()
+
+	fsnode, exist := ns1.Get("/var/log/messages")
+	assert.True(t, exist)
+	assert.Equal(t, int64(100), fsnode.Size)
+	fsnode, exist = ns2.Get("/var/log/messages")
+	assert.True(t, exist)
+	assert.Equal(t, int64(200), fsnode.Size)
+	_, exist = ns1.Get("/var/log/secure")
+	assert.False(t, exist)
+
+	require.NoError(t, ns1.Flush())
+	ro := New()
+	require.NoError(t, ro.OpenReadOnly(dbPath))
+	assert.Equal(t, []Key{{Input: "file1", Path: "/var/log/messages"}, {Input: "file2", Path: "/var/log/messages"}}, ro.Keys())
+	ro.Close()
+
+	require.NoError(t, ns1.Close())
+	require.NoError(t, ns1.Close())
+	ns2.Set("/var/log/secure", fsutil.Fsnode{Dev: 1, Inode: 1025, Size: 10, Nlink: 1})
+	require.NoError(t, ns2.Close())
+
+	// store closed, db unlocked
+	require.NoError(t, db.Open(dbPath))
+	assert.Equal(t, []Key{
+		{Input: "file1", Path: "/var/log/messages"},
+		{Input: "file2", Path: "/var/log/messages"},
+		{Input: "file2", Path: "/var/log/secure"},
+	}, db.Keys())
+	db.Close()
+
+	// reopen
+	require.NoError(t, ns2.Open())
+	fsnode, exist = ns2.Get("/var/log/secure")
+	assert.True(t, exist)
+	assert.Equal(t, int64(10), fsnode.Size)
+	require.NoError(t, ns2.Close())
+}
+
+func TestStoreClaimMigrated(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	// legacy db, records without input
+	fsnode := fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 4096}
+	require.NoError(t, os.WriteFile(dbPath, oldRecord("/var/log/messages", fsnode, 0), 0644))
+
+	ns, err := NewStores().Namespace(dbPath, Options{Input: "file"})
+	require.NoError(t, err)
+	require.NoError(t, ns.Open())
+	got, exist := ns.Get("/var/log/messages")
+	assert.True(t, exist)
+	assert.Equal(t, fsnode, got)
+	require.NoError(t, ns.Close())
+
+	db := New()
+	require.NoError(t, db.Open(dbPath))
+	assert.Equal(t, []Key{{Input: "file", Path: "/var/log/messages"}}, db.Keys())
+	db.Close()
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fstatdb")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := path.Join(dir, "seek")
+
+	ns, err := NewStores().Namespace(dbPath, Options{Input: "file"})
+	require.NoError(t, err)
+	require.NoError(t, ns.Open())
+
+	statChan := make(chan StatEvent, 10)
+	for i := 1; i <= 5; i++ {
+		statChan <- StatEvent{Path: "/var/log/messages", Stat: fsutil.Fsnode{Dev: 1, Inode: 1024, Size: int64(i)}}
+	}
+	close(statChan)
+	require.NoError(t, Watch(context.Background(), "file", ns, statChan, time.Second))
+
+	db := NewWithOptions(Options{Input: "file"})
+	require.NoError(t, db.Open(dbPath))
+	got, exist := db.Get("/var/log/messages")
+	assert.True(t, exist)
+	assert.Equal(t, fsutil.Fsnode{Dev: 1, Inode: 1024, Size: 5}, got)
+	db.Close()
+}
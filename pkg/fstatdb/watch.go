@@ -13,13 +13,9 @@ type StatEvent struct {
 	Stat fsutil.Fsnode
 }
 
-func (db *Db) Watch(ctx context.Context, typ string, statChan <-chan StatEvent, flush uint64, timeout time.Duration) error {
-	var (
-		i       uint64
-		err     error
-		changed bool
-	)
-	path := db.f.Name()
+// Watch store stat events from statChan until ctx is done or statChan closed (after ctx is done, wait for timeout
+// for drain statChan). Seeker is closed on exit.
+func Watch(ctx context.Context, typ string, s Seeker, statChan <-chan StatEvent, timeout time.Duration) error {
 LOOP1:
 	for {
 		select {
@@ -29,19 +25,12 @@ LOOP1:
 			if !opened {
 				break LOOP1
 			}
-			changed = true
-			db.Set(stat.Path, stat.Stat)
-			if i%flush == 0 {
-				if err = db.Save(); err == nil {
-					changed = false
-				} else {
-					log.Error().Str("input", typ).Str("seek", path).Err(err).Msg("save stat")
-				}
-			}
+			s.Set(stat.Path, stat.Stat)
 		}
 	}
 
 	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
 LOOP2:
 	for {
 		select {
@@ -51,24 +40,13 @@ LOOP2:
 			if !opened {
 				break LOOP2
 			}
-			i++
-			changed = true
-			db.Set(stat.Path, stat.Stat)
-			if i%flush == 0 {
-				if err = db.Save(); err == nil {
-					changed = false
-				} else {
-					log.Error().Str("input", typ).Str("seek", path).Err(err).Msg("save stat failed")
-				}
-			}
+			s.Set(stat.Path, stat.Stat)
 		}
 	}
 
-	if changed {
-		if err = db.Save(); err != nil {
-			log.Error().Str("input", typ).Str("seek", path).Err(err).Msg("save stat failed on shutdown")
-		}
+	err := s.Close()
+	if err != nil {
+		log.Error().Str("input", typ).Err(err).Msg("save stat failed on shutdown")
 	}
-	db.Close()
 	return err
 }
@@ -0,0 +1,24 @@
+package fsutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// IsFifo check if path is a named pipe (FIFO)
+func IsFifo(fpath string) (bool, error) {
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return false, err
+	}
+	return fi.Mode()&os.ModeNamedPipe != 0, nil
+}
+
+// Mkfifo create named pipe (FIFO)
+func Mkfifo(fpath string, perm os.FileMode) error {
+	if err := syscall.Mkfifo(fpath, uint32(perm.Perm())); err != nil {
+		return &os.PathError{Op: "mkfifo", Path: fpath, Err: err}
+	}
+	// mkfifo permissions is masked by umask
+	return os.Chmod(fpath, perm.Perm())
+}
@@ -0,0 +1,108 @@
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sep = string(filepath.Separator)
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[\\")
+}
+
+// HasDoublestar check for '**' (match zero or more directories) in pattern
+func HasDoublestar(pattern string) bool {
+	for _, part := range strings.Split(pattern, sep) {
+		if part == "**" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchParts(pp, np []string) (bool, error) {
+	for len(pp) > 0 {
+		if pp[0] == "**" {
+			if len(pp) == 1 {
+				return true, nil
+			}
+			for i := 0; i <= len(np); i++ {
+				if ok, err := matchParts(pp[1:], np[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(np) == 0 {
+			return false, nil
+		}
+		if ok, err := filepath.Match(pp[0], np[0]); !ok || err != nil {
+			return false, err
+		}
+		pp = pp[1:]
+		np = np[1:]
+	}
+	return len(np) == 0, nil
+}
+
+// Match is a filepath.Match with '**' support (as separate path element, match zero or more directories)
+func Match(pattern, name string) (bool, error) {
+	if !HasDoublestar(pattern) {
+		return filepath.Match(pattern, name)
+	}
+	return matchParts(strings.Split(pattern, sep), strings.Split(name, sep))
+}
+
+// Glob is a filepath.Glob with '**' support (as separate path element, match zero or more directories).
+//
+// For '**' directories walked from pattern static prefix no deeper than maxDepth (0 - unlimited), symlinks not followed.
+func Glob(pattern string, maxDepth int) ([]string, error) {
+	if !HasDoublestar(pattern) {
+		return filepath.Glob(pattern)
+	}
+	// check pattern syntax
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	pattern = filepath.Clean(pattern)
+
+	parts := strings.Split(pattern, sep)
+	i := 0
+	for ; i < len(parts); i++ {
+		if hasMeta(parts[i]) {
+			break
+		}
+	}
+	root := strings.Join(parts[:i], sep)
+	if root == "" {
+		if strings.HasPrefix(pattern, sep) {
+			root = sep
+		} else {
+			root = "."
+		}
+	}
+
+	var matches []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) || os.IsPermission(err) {
+				return nil
+			}
+			return err
+		}
+		if ok, _ := Match(pattern, path); ok {
+			matches = append(matches, path)
+		}
+		if d.IsDir() && maxDepth > 0 && path != root {
+			if rel, err := filepath.Rel(root, path); err == nil && strings.Count(rel, sep)+1 > maxDepth {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+
+	return matches, err
+}
@@ -0,0 +1,84 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "/var/log/*.log", name: "/var/log/messages.log", want: true},
+		{pattern: "/var/log/*.log", name: "/var/log/nginx/access.log", want: false},
+		{pattern: "/var/log/**/*.log", name: "/var/log/messages.log", want: true},
+		{pattern: "/var/log/**/*.log", name: "/var/log/nginx/access.log", want: true},
+		{pattern: "/var/log/**/*.log", name: "/var/log/nginx/a/b/access.log", want: true},
+		{pattern: "/var/log/**/*.log", name: "/var/log/nginx/access.log.gz", want: false},
+		{pattern: "/var/log/**", name: "/var/log/nginx/access.log.gz", want: true},
+		{pattern: "/var/**/nginx/*.log", name: "/var/log/nginx/access.log", want: true},
+		{pattern: "/var/**/nginx/*.log", name: "/var/log/apache/access.log", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			got, err := Match(tt.pattern, tt.name)
+			if err != nil {
+				t.Fatalf("Match() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlob(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	for _, name := range []string{"a.log", "b.txt", "d1/a.log", "d1/d2/a.log", "d1/d2/d3/a.log"} {
+		fpath := filepath.Join(testDir, name)
+		if err = os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern  string
+		maxDepth int
+		want     []string
+	}{
+		{pattern: "*.log", want: []string{"a.log"}},
+		{pattern: "**/*.log", want: []string{"a.log", "d1/a.log", "d1/d2/a.log", "d1/d2/d3/a.log"}},
+		{pattern: "**/*.log", maxDepth: 2, want: []string{"a.log", "d1/a.log", "d1/d2/a.log"}},
+		{pattern: "d1/**/a.log", want: []string{"d1/a.log", "d1/d2/a.log", "d1/d2/d3/a.log"}},
+		{pattern: "d1/**/d3/*", want: []string{"d1/d2/d3/a.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := Glob(filepath.Join(testDir, tt.pattern), tt.maxDepth)
+			if err != nil {
+				t.Fatalf("Glob() error = %v", err)
+			}
+			want := make([]string, len(tt.want))
+			for i := range tt.want {
+				want[i] = filepath.Join(testDir, tt.want[i])
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Glob() = %v, want %v", got, want)
+			}
+		})
+	}
+}
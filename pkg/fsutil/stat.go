@@ -3,6 +3,7 @@ package fsutil
 import (
 	"os"
 	"syscall"
+	"time"
 )
 
 type Fsnode struct {
@@ -95,3 +96,11 @@ func IsDir(fpath string) (bool, error) {
 		return fi.IsDir(), nil
 	}
 }
+
+func ModTime(fpath string) (time.Time, error) {
+	if fi, err := os.Stat(fpath); err != nil {
+		return time.Time{}, err
+	} else {
+		return fi.ModTime(), nil
+	}
+}
@@ -0,0 +1,181 @@
+package input
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultMaxPeers    = 1024
+	DefaultPeerTimeout = 5 * time.Minute
+
+	// read retry delay on temporary errors (doubled on every failure)
+	minReadBackoff = 5 * time.Millisecond
+	maxReadBackoff = time.Second
+)
+
+// DatagramReader read datagrams from packet connection, parse it with codec and send events to outChan.
+//
+// If Split is set, datagram can contain several new line delimited messages, else datagram is a one message.
+// Codec is created per peer address (codec can be stateful, like multiline), so messages from different peers are not mixed.
+// Peer codecs are limited with MaxPeers (least recently used is removed) and removed after PeerTimeout idle.
+// Peer address added to event fields (peer_addr, peer_port).
+type DatagramReader struct {
+	NewCodec      func() (codec.Codec, error)
+	MaxPacketSize int
+	MaxPeers      int           // default DefaultMaxPeers
+	PeerTimeout   time.Duration // default DefaultPeerTimeout
+	Split         bool
+	Logger        zerolog.Logger
+
+	peers *list.List               // peers, most recently used first
+	index map[string]*list.Element // peers by address
+}
+
+type datagramPeer struct {
+	addr   string
+	codec  codec.Codec
+	fields map[string]interface{}
+	last   time.Time
+}
+
+// peer return peer (with codec) for address, peers idle for PeerTimeout and least recently used (over MaxPeers) are removed
+func (d *DatagramReader) peer(addr net.Addr, now time.Time) (*datagramPeer, error) {
+	if d.peers == nil {
+		d.peers = list.New()
+		d.index = make(map[string]*list.Element)
+		if d.MaxPeers <= 0 {
+			d.MaxPeers = DefaultMaxPeers
+		}
+		if d.PeerTimeout <= 0 {
+			d.PeerTimeout = DefaultPeerTimeout
+		}
+	}
+	for back := d.peers.Back(); back != nil && now.Sub(back.Value.(*datagramPeer).last) >= d.PeerTimeout; back = d.peers.Back() {
+		d.remove(back)
+	}
+
+	var key string
+	if addr != nil {
+		key = addr.String()
+	}
+	if el, ok := d.index[key]; ok {
+		p := el.Value.(*datagramPeer)
+		p.last = now
+		d.peers.MoveToFront(el)
+		return p, nil
+	}
+
+	c, err := d.NewCodec()
+	if err != nil {
+		return nil, err
+	}
+	if d.peers.Len() >= d.MaxPeers {
+		d.remove(d.peers.Back())
+	}
+	p := &datagramPeer{addr: key, codec: c, fields: PeerFields(addr), last: now}
+	d.index[key] = d.peers.PushFront(p)
+	return p, nil
+}
+
+func (d *DatagramReader) remove(el *list.Element) {
+	p := d.peers.Remove(el).(*datagramPeer)
+	delete(d.index, p.addr)
+}
+
+// Read read datagrams until connection closed.
+//
+// Return nil on shutdown (connection must be closed on ctx cancel). Read is retried (with backoff) on temporary errors,
+// other errors (like closed connection) are returned.
+func (d *DatagramReader) Read(ctx context.Context, conn net.PacketConn, outChan chan<- *event.Event) error {
+	var backoff time.Duration
+	// one spare byte for append '\n' to last message
+	buf := make([]byte, d.MaxPacketSize+1)
+	for {
+		n, addr, err := conn.ReadFrom(buf[:len(buf)-1])
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.Is(err, net.ErrClosed) || !errors.As(err, &ne) || !ne.Temporary() {
+				d.Logger.Error().Err(err).Msg("read failed")
+				return err
+			}
+			if backoff == 0 {
+				backoff = minReadBackoff
+			} else if backoff *= 2; backoff > maxReadBackoff {
+				backoff = maxReadBackoff
+			}
+			d.Logger.Error().Err(err).Dur("retry", backoff).Msg("read failed")
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+		p, err := d.peer(addr, time.Now())
+		if err != nil {
+			d.Logger.Error().Err(err).Msg("codec init failed")
+			continue
+		}
+		if !d.parse(ctx, buf[:n], p.codec, p.fields, outChan) {
+			return nil
+		}
+	}
+}
+
+// parse split datagram to messages, parse it with codec and send events to outChan.
+//
+// data must have a one spare byte capacity (for append '\n' to last message). Return false on shutdown.
+func (d *DatagramReader) parse(ctx context.Context, data []byte, c codec.Codec, fields map[string]interface{}, outChan chan<- *event.Event) bool {
+	ts := timeutil.Now()
+	for len(data) > 0 {
+		var line []byte
+		if !d.Split {
+			line = append(bytes.TrimRight(data, "\r\n"), '\n')
+			data = nil
+		} else if n := bytes.IndexByte(data, '\n'); n == -1 {
+			line = append(data, '\n')
+			data = nil
+		} else {
+			line = data[:n+1]
+			data = data[n+1:]
+		}
+		e, err := c.Parse(ts, line)
+		if err != nil {
+			if err != codec.ErrEmpty {
+				d.Logger.Debug().Str("text", stringutils.UnsafeString(line)).Err(err).Msg("parse")
+			}
+			continue
+		}
+		if e == nil {
+			continue
+		}
+		for k, v := range fields {
+			e.Fields[k] = v
+		}
+		if zerolog.GlobalLevel() == zerolog.TraceLevel {
+			d.Logger.Trace().Str("text", stringutils.UnsafeString(line)).Str("event", event.String(e)).Msg("parse")
+		}
+		select {
+		case outChan <- e:
+		case <-ctx.Done():
+			event.Put(e)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package input
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+)
+
+// joinCodec is a stateful codec, lines joined until "end" line
+type joinCodec struct {
+	lines string
+}
+
+func (c *joinCodec) Name() string {
+	return "join"
+}
+
+func (c *joinCodec) Parse(ts timeutil.Time, data []byte) (*event.Event, error) {
+	line := string(data[:len(data)-1])
+	if line != "end" {
+		c.lines += line
+		return nil, nil
+	}
+	e := &event.Event{Timestamp: ts.Time(), Fields: map[string]interface{}{"message": c.lines}}
+	c.lines = ""
+	return e, nil
+}
+
+func TestDatagramReaderPeers(t *testing.T) {
+	codecs := 0
+	d := DatagramReader{
+		NewCodec: func() (codec.Codec, error) {
+			codecs++
+			return &joinCodec{}, nil
+		},
+		MaxPeers:    2,
+		PeerTimeout: time.Minute,
+		Split:       true,
+	}
+	peer1 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1001}
+	peer2 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1002}
+	peer3 := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1003}
+
+	ctx := context.Background()
+	outChan := make(chan *event.Event, 10)
+	now := time.Now()
+	read := func(addr net.Addr, data string, now time.Time) {
+		p, err := d.peer(addr, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(data), len(data)+1)
+		copy(buf, data)
+		d.parse(ctx, buf, p.codec, p.fields, outChan)
+	}
+	want := func(messages ...string) {
+		t.Helper()
+		if len(outChan) != len(messages) {
+			t.Fatalf("events = %d, want %d", len(outChan), len(messages))
+		}
+		for _, message := range messages {
+			if e := <-outChan; e.Fields["message"] != message {
+				t.Errorf("message = %q, want %q", e.Fields["message"], message)
+			}
+		}
+	}
+
+	// messages from different peers are not mixed
+	read(peer1, "a1\n", now)
+	read(peer2, "b1\n", now)
+	read(peer1, "a2\nend", now)
+	read(peer2, "b2\nend", now)
+	want("a1a2", "b1b2")
+	if n := len(d.index); n != 2 || codecs != 2 {
+		t.Fatalf("peers = %d, codecs = %d, want 2", n, codecs)
+	}
+
+	// least recently used peer (peer2) removed
+	read(peer2, "b3\n", now)
+	read(peer1, "a3\n", now)
+	read(peer3, "c1\nend", now)
+	want("c1")
+	if _, ok := d.index[peer2.String()]; ok || d.peers.Len() != 2 {
+		t.Errorf("peer %s not removed", peer2)
+	}
+	read(peer1, "end", now)
+	want("a3")
+
+	// idle peers removed
+	read(peer1, "a4\n", now)
+	read(peer2, "b4\nend", now.Add(time.Minute))
+	want("b4")
+	if _, ok := d.index[peer1.String()]; ok || d.peers.Len() != 1 {
+		t.Errorf("idle peer %s not removed", peer1)
+	}
+	if codecs != 4 {
+		t.Errorf("codecs = %d, want 4", codecs)
+	}
+}
+
+// errConn is a packet connection, ReadFrom return errors in order
+type errConn struct {
+	net.PacketConn
+	errs  []error
+	reads int
+}
+
+func (c *errConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	err := c.errs[c.reads]
+	c.reads++
+	return 0, nil, err
+}
+
+func TestDatagramReaderReadError(t *testing.T) {
+	temporary := &net.OpError{Op: "read", Net: "udp", Err: syscall.EINTR}
+	conn := &errConn{errs: []error{temporary, temporary, temporary, net.ErrClosed}}
+	d := DatagramReader{MaxPacketSize: 1024, Logger: zerolog.Nop()}
+
+	start := time.Now()
+	err := d.Read(context.Background(), conn, make(chan *event.Event))
+	if err != net.ErrClosed {
+		t.Errorf("Read() error = %v, want %v", err, net.ErrClosed)
+	}
+	if conn.reads != len(conn.errs) {
+		t.Errorf("reads = %d, want %d", conn.reads, len(conn.errs))
+	}
+	// backoff on temporary errors: 5ms + 10ms + 20ms
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("Read() retried without backoff in %v", elapsed)
+	}
+}
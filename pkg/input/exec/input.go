@@ -0,0 +1,367 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "exec"
+
+var (
+	// grace period between SIGTERM and SIGKILL on shutdown or timeout
+	killTimeout = 5 * time.Second
+	// read rest of output after command exit (childs can hold pipes)
+	drainTimeout = time.Second
+)
+
+type Mode int8
+
+const (
+	ModeOnce Mode = iota
+	ModePeriodic
+	ModeDaemon
+)
+
+var modeStrings []string = []string{"once", "periodic", "daemon"}
+
+func (m *Mode) Set(value string) error {
+	switch value {
+	case "once", "":
+		*m = ModeOnce
+	case "periodic":
+		*m = ModePeriodic
+	case "daemon":
+		*m = ModeDaemon
+	default:
+		return fmt.Errorf("invalid mode %s", value)
+	}
+	return nil
+}
+
+func (m *Mode) String() string {
+	return modeStrings[*m]
+}
+
+func (m *Mode) UnmarshalText(text []byte) error {
+	return m.Set(string(text))
+}
+
+type Config struct {
+	input.Config
+
+	Command         config.Strings `hcl:"command" yaml:"command" json:"command"`                               // command with args (single string executed with sh -c)
+	Dir             string         `hcl:"dir" yaml:"dir" json:"dir"`                                           // working directory
+	Env             config.Strings `hcl:"env" yaml:"env" json:"env"`                                           // additional environment variables (KEY=VALUE)
+	Mode            Mode           `hcl:"mode" yaml:"mode" json:"mode"`                                        // once (default), periodic or daemon
+	Interval        time.Duration  `hcl:"interval" yaml:"interval" json:"interval"`                            // run interval in periodic mode
+	Timeout         time.Duration  `hcl:"timeout" yaml:"timeout" json:"timeout"`                               // kill command after timeout (0 - disabled)
+	RestartDelay    time.Duration  `hcl:"restart_delay" yaml:"restart_delay" json:"restart_delay"`             // initial restart delay in daemon mode (doubled on every restart)
+	MaxRestartDelay time.Duration  `hcl:"max_restart_delay" yaml:"max_restart_delay" json:"max_restart_delay"` // max restart delay in daemon mode
+	ReadBuffer      config.Size    `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"`                   // read buffer size
+	MaxReadBuffer   config.Size    `hcl:"max_read_buffer" yaml:"max_read_buffer" json:"max_read_buffer"`       // max read buffer size (for long lines)
+	Codec           string         `hcl:"codec" yaml:"codec" json:"codec"`                                     // codec name (deefault - line)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          input.Config{Type: Name},
+		Interval:        time.Minute,
+		RestartDelay:    time.Second,
+		MaxRestartDelay: time.Minute,
+		ReadBuffer:      config.Size(64 * 1024),
+		MaxReadBuffer:   config.Size(1024 * 1024),
+	}
+}
+
+// Exec run command (once, periodic or as supervised daemon) and read it's stdout and stderr.
+//
+// Command and stream name added to event fields (command, stream).
+// After command exit, event with exit code (stream is exit, exit_code, duration in seconds) is sended.
+type Exec struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	args []string
+	path string
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &Exec{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if len(in.cfg.Command) == 0 || in.cfg.Command[0] == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': command not set")
+	}
+	if len(in.cfg.Command) == 1 {
+		in.args = []string{"/bin/sh", "-c", in.cfg.Command[0]}
+	} else {
+		in.args = in.cfg.Command
+	}
+	in.path = strings.Join(in.cfg.Command, " ")
+
+	if in.cfg.Interval <= 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': interval must be > 0")
+	}
+	if in.cfg.Timeout < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': timeout must be >= 0")
+	}
+	if in.cfg.RestartDelay <= 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': restart_delay must be > 0")
+	}
+	if in.cfg.MaxRestartDelay < in.cfg.RestartDelay {
+		in.cfg.MaxRestartDelay = in.cfg.RestartDelay
+	}
+	if in.cfg.ReadBuffer.Value() < 1 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_buffer must be > 0")
+	}
+	if in.cfg.MaxReadBuffer.Value() < in.cfg.ReadBuffer.Value() {
+		in.cfg.MaxReadBuffer = in.cfg.ReadBuffer
+	}
+
+	// Check codec config
+	if _, err := codec.New(in.cfgRaw, in.common, in.path); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' command='"+in.path+"'")
+	}
+
+	return in, nil
+}
+
+func (in *Exec) Name() string {
+	return Name
+}
+
+func (in *Exec) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("command", in.path).Logger()
+
+	switch in.cfg.Mode {
+	case ModeOnce:
+		in.run(ctx, outChan, logger)
+	case ModePeriodic:
+		for {
+			start := time.Now()
+			in.run(ctx, outChan, logger)
+			if !sleep(ctx, in.cfg.Interval-time.Since(start)) {
+				break
+			}
+		}
+	case ModeDaemon:
+		delay := in.cfg.RestartDelay
+		for {
+			start := time.Now()
+			in.run(ctx, outChan, logger)
+			if ctx.Err() != nil {
+				break
+			}
+			if time.Since(start) > in.cfg.MaxRestartDelay {
+				// command worked long enough, reset backoff
+				delay = in.cfg.RestartDelay
+			}
+			logger.Warn().Dur("delay", delay).Msg("command exited, restart")
+			if !sleep(ctx, delay) {
+				break
+			}
+			if delay *= 2; delay > in.cfg.MaxRestartDelay {
+				delay = in.cfg.MaxRestartDelay
+			}
+		}
+	}
+
+	if ctx.Err() == nil {
+		logger.Info().Msg("done")
+	} else {
+		logger.Info().Msg("shutdown")
+	}
+	return nil
+}
+
+// sleep return false on shutdown
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// run run command, read stdout and stderr until exit and send exit event
+func (in *Exec) run(ctx context.Context, outChan chan<- *event.Event, logger zerolog.Logger) {
+	cmd := exec.Command(in.args[0], in.args[1:]...)
+	cmd.Dir = in.cfg.Dir
+	if len(in.cfg.Env) > 0 {
+		cmd.Env = append(os.Environ(), in.cfg.Env...)
+	}
+
+	var (
+		pipes   [2][2]*os.File // read and write ends for stdout and stderr
+		streams = [2]string{"stdout", "stderr"}
+		err     error
+	)
+	defer func() {
+		for i := range pipes {
+			for _, f := range pipes[i] {
+				if f != nil {
+					f.Close()
+				}
+			}
+		}
+	}()
+	for i := range pipes {
+		if pipes[i][0], pipes[i][1], err = os.Pipe(); err != nil {
+			logger.Error().Err(err).Msg("pipe create failed")
+			in.sendExit(ctx, -1, err.Error(), 0, outChan)
+			return
+		}
+	}
+	cmd.Stdout = pipes[0][1]
+	cmd.Stderr = pipes[1][1]
+
+	start := time.Now()
+	if err = cmd.Start(); err != nil {
+		logger.Error().Err(err).Msg("command start failed")
+		in.sendExit(ctx, -1, err.Error(), 0, outChan)
+		return
+	}
+	logger.Debug().Int("pid", cmd.Process.Pid).Msg("command started")
+	for i := range pipes {
+		pipes[i][1].Close()
+		pipes[i][1] = nil
+	}
+
+	var wg sync.WaitGroup
+	for i := range pipes {
+		wg.Add(1)
+		go func(r *os.File, stream string) {
+			defer wg.Done()
+			in.readStream(ctx, r, stream, outChan, logger)
+		}(pipes[i][0], streams[i])
+	}
+
+	done := make(chan struct{})
+	go func() {
+		var timeout <-chan time.Time
+		if in.cfg.Timeout > 0 {
+			t := time.NewTimer(in.cfg.Timeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		case <-timeout:
+			logger.Warn().Dur("timeout", in.cfg.Timeout).Msg("command timeout, terminate")
+		}
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			_ = cmd.Process.Kill()
+			return
+		}
+		t := time.NewTimer(killTimeout)
+		defer t.Stop()
+		select {
+		case <-done:
+		case <-t.C:
+			logger.Warn().Msg("command not terminated, kill")
+			_ = cmd.Process.Kill()
+		}
+	}()
+
+	err = cmd.Wait()
+	close(done)
+	duration := time.Since(start)
+
+	deadline := time.Now().Add(drainTimeout)
+	for i := range pipes {
+		_ = pipes[i][0].SetReadDeadline(deadline)
+	}
+	wg.Wait()
+
+	exitCode := cmd.ProcessState.ExitCode()
+	message := cmd.ProcessState.String()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			message = err.Error()
+		}
+	}
+	logger.Debug().Int("exit_code", exitCode).Dur("duration", duration).Msg("command exited")
+	in.sendExit(ctx, exitCode, message, duration, outChan)
+}
+
+func (in *Exec) readStream(ctx context.Context, r *os.File, stream string, outChan chan<- *event.Event, logger zerolog.Logger) {
+	logger = logger.With().Str("stream", stream).Logger()
+	codec, err := codec.New(in.cfgRaw, in.common, in.path)
+	if err != nil {
+		logger.Error().Str("codec", in.cfg.Codec).Err(err).Msg("codec init failed")
+		return
+	}
+	s := input.StreamReader{
+		Reader:    lreader.New(r, int(in.cfg.ReadBuffer.Value())),
+		Codec:     codec,
+		MaxBuffer: int(in.cfg.MaxReadBuffer.Value()),
+		Fields:    map[string]interface{}{"command": in.path, "stream": stream},
+		Logger:    logger,
+	}
+	err = s.Read(ctx, r, outChan)
+	switch {
+	case err == nil, ctx.Err() != nil:
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		logger.Debug().Msg("output still open after command exit, closed")
+	default:
+		logger.Error().Err(err).Msg("read failed")
+	}
+}
+
+func (in *Exec) sendExit(ctx context.Context, exitCode int, message string, duration time.Duration, outChan chan<- *event.Event) {
+	ts := timeutil.Now()
+	e := &event.Event{
+		Timestamp: ts.Time(),
+		Fields: map[string]interface{}{
+			"type":      in.cfg.Type,
+			"name":      Name,
+			"timestamp": ts.String(),
+			"message":   message,
+			"host":      in.common.Hostname,
+			"path":      in.path,
+			"command":   in.path,
+			"stream":    "exit",
+			"exit_code": exitCode,
+			"duration":  duration.Seconds(),
+		},
+		Tags: map[string]int{},
+	}
+	select {
+	case outChan <- e:
+	case <-ctx.Done():
+	}
+}
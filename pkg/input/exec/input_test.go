@@ -0,0 +1,193 @@
+package exec_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestExecOnce(t *testing.T) {
+	command := "printf 'test 1\\ntest 2'; echo $TEST_VAR >&2; exit 3"
+	cfg := config.ConfigRaw{"type": "exec", "command": command, "env": "TEST_VAR=error"}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fchan := make(chan *event.Event, 10)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+
+	var events []*event.Event
+	for e := range fchan {
+		events = append(events, e)
+	}
+	exit := events[len(events)-1]
+	if exit.Fields["stream"] != "exit" || exit.Fields["exit_code"] != 3 {
+		t.Errorf("last event not an exit event with code 3: %s", event.String(exit))
+	}
+	delete(exit.Fields, "duration")
+
+	fields := func(message, stream string) map[string]interface{} {
+		return map[string]interface{}{
+			"type": "exec", "name": "line", "host": "localhost", "path": command,
+			"message": message, "command": command, "stream": stream,
+		}
+	}
+	wantEvents := []*event.Event{
+		{Fields: fields("error", "stderr"), Tags: map[string]int{}},
+		{Fields: fields("exit status 3", "exit"), Tags: map[string]int{}},
+		{Fields: fields("test 1", "stdout"), Tags: map[string]int{}},
+		{Fields: fields("test 2", "stdout"), Tags: map[string]int{}},
+	}
+	wantEvents[1].Fields["name"] = "exec"
+	wantEvents[1].Fields["exit_code"] = 3
+	if eq, diff := test.EventsCmp(wantEvents, events, true, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+}
+
+func TestExecTimeout(t *testing.T) {
+	cfg := config.ConfigRaw{"type": "exec", "command": []string{"sleep", "10"}, "timeout": 100 * time.Millisecond}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fchan := make(chan *event.Event, 10)
+	start := time.Now()
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command not killed on timeout, run %s", d)
+	}
+	close(fchan)
+
+	e := <-fchan
+	if e == nil || e.Fields["stream"] != "exit" || e.Fields["exit_code"] != -1 || e.Fields["command"] != "sleep 10" {
+		t.Errorf("want exit event with code -1, got %s", event.String(e))
+	}
+}
+
+func TestExecStartFailed(t *testing.T) {
+	cfg := config.ConfigRaw{"type": "exec", "command": []string{"/nonexistent", "arg"}}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fchan := make(chan *event.Event, 10)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+
+	e := <-fchan
+	if e == nil || e.Fields["stream"] != "exit" || e.Fields["exit_code"] != -1 {
+		t.Errorf("want exit event with code -1, got %s", event.String(e))
+	}
+}
+
+func runUntil(t *testing.T, cfg config.ConfigRaw, d time.Duration) []*event.Event {
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 100)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+	time.Sleep(d)
+	start := time.Now()
+	cancel()
+	wg.Wait()
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("shutdown too long: %s", d)
+	}
+
+	var events []*event.Event
+	for e := range fchan {
+		events = append(events, e)
+	}
+	return events
+}
+
+func countStream(events []*event.Event, stream string) int {
+	n := 0
+	for _, e := range events {
+		if e.Fields["stream"] == stream {
+			n++
+		}
+	}
+	return n
+}
+
+func TestExecPeriodic(t *testing.T) {
+	events := runUntil(t, config.ConfigRaw{"type": "exec", "command": "echo test", "mode": "periodic", "interval": 50 * time.Millisecond}, 280*time.Millisecond)
+	if n := countStream(events, "stdout"); n < 3 || n > 7 {
+		t.Errorf("periodic runs = %d, want ~6", n)
+	}
+	if countStream(events, "exit") != countStream(events, "stdout") {
+		t.Errorf("exit events count mismatch")
+	}
+	event.PutSlice(events)
+}
+
+func TestExecDaemon(t *testing.T) {
+	// restarts with backoff: 0, 20ms, 60ms, 140ms, 300ms
+	events := runUntil(t, config.ConfigRaw{
+		"type": "exec", "command": "echo test; exit 1", "mode": "daemon",
+		"restart_delay": 20 * time.Millisecond, "max_restart_delay": time.Second,
+	}, 250*time.Millisecond)
+	if n := countStream(events, "exit"); n < 3 || n > 5 {
+		t.Errorf("daemon restarts = %d, want 4", n)
+	}
+	event.PutSlice(events)
+
+	// terminated on shutdown
+	events = runUntil(t, config.ConfigRaw{"type": "exec", "command": []string{"sleep", "10"}, "mode": "daemon"}, 50*time.Millisecond)
+	if n := countStream(events, "exit"); n > 1 {
+		t.Errorf("daemon restarted after shutdown, exit events = %d", n)
+	}
+	event.PutSlice(events)
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
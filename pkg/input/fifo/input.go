@@ -0,0 +1,204 @@
+package fifo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "fifo"
+
+type Config struct {
+	input.Config
+
+	Path          string      `hcl:"path" yaml:"path" json:"path"`                                  // named pipe path
+	Create        bool        `hcl:"create" yaml:"create" json:"create"`                            // create named pipe, if not exist (default true)
+	Perm          string      `hcl:"perm" yaml:"perm" json:"perm"`                                  // created named pipe permissions (octal, default 0600)
+	ReadBuffer    config.Size `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"`             // read buffer size
+	MaxReadBuffer config.Size `hcl:"max_read_buffer" yaml:"max_read_buffer" json:"max_read_buffer"` // max read buffer size (for long lines)
+	Codec         string      `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - line)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:        input.Config{Type: Name},
+		Create:        true,
+		Perm:          "0600",
+		ReadBuffer:    config.Size(64 * 1024),
+		MaxReadBuffer: config.Size(1024 * 1024),
+	}
+}
+
+// Fifo is a named pipe (FIFO) input.
+//
+// Named pipe reopened after all writers disconnected.
+// Incomplete last line (and codec state) is keeped and continued after reopen.
+type Fifo struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	perm os.FileMode
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &Fifo{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.Path == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': path not set")
+	}
+
+	if perm, err := strconv.ParseUint(in.cfg.Perm, 8, 32); err != nil || perm > 0777 {
+		return nil, errors.New("input '" + in.cfg.Type + "': invalid perm " + in.cfg.Perm)
+	} else {
+		in.perm = os.FileMode(perm)
+	}
+
+	if in.cfg.ReadBuffer.Value() < 1 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_buffer must be > 0")
+	}
+
+	if in.cfg.MaxReadBuffer.Value() < in.cfg.ReadBuffer.Value() {
+		in.cfg.MaxReadBuffer = in.cfg.ReadBuffer
+	}
+
+	if isFifo, err := fsutil.IsFifo(in.cfg.Path); err == nil {
+		if !isFifo {
+			return nil, errors.New("input '" + in.cfg.Type + "': " + in.cfg.Path + " is not a named pipe")
+		}
+	} else if !os.IsNotExist(err) || !in.cfg.Create {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"'")
+	}
+
+	// Check codec config
+	if _, err := codec.New(in.cfgRaw, in.common, in.cfg.Path); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' path='"+in.cfg.Path+"'")
+	}
+
+	return in, nil
+}
+
+func (in *Fifo) Name() string {
+	return Name
+}
+
+func (in *Fifo) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("path", in.cfg.Path).Logger()
+
+	if in.cfg.Create {
+		if err := fsutil.Mkfifo(in.cfg.Path, in.perm); err != nil && !os.IsExist(err) {
+			logger.Error().Err(err).Msg("create failed")
+			return err
+		}
+	}
+
+	codec, err := codec.New(in.cfgRaw, in.common, in.cfg.Path)
+	if err != nil {
+		logger.Error().Str("codec", in.cfg.Codec).Err(err).Msg("codec init failed")
+		return err
+	}
+
+	// reader and codec are shared between reopens, so incomplete line is continued
+	s := input.StreamReader{
+		Reader:      lreader.New(nil, int(in.cfg.ReadBuffer.Value())),
+		Codec:       codec,
+		MaxBuffer:   int(in.cfg.MaxReadBuffer.Value()),
+		Logger:      logger,
+		KeepPartial: true,
+	}
+
+	for {
+		f, err := in.open(ctx, logger)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Error().Err(err).Msg("open failed")
+			return err
+		}
+		if f == nil {
+			// shutdown
+			break
+		}
+
+		logger.Debug().Msg("writer connected")
+		done := make(chan struct{})
+		go func() {
+			// unblock read on shutdown
+			select {
+			case <-ctx.Done():
+			case <-done:
+			}
+			f.Close()
+		}()
+		err = s.Read(ctx, f, outChan)
+		close(done)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("read failed")
+			return err
+		}
+		logger.Debug().Msg("writers disconnected, reopen")
+	}
+
+	logger.Info().Msg("shutdown")
+	return nil
+}
+
+// open open named pipe for read (blocked until writer connected), return nil file on shutdown
+func (in *Fifo) open(ctx context.Context, logger zerolog.Logger) (*os.File, error) {
+	type result struct {
+		f   *os.File
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		f, err := os.OpenFile(in.cfg.Path, os.O_RDONLY, 0)
+		ch <- result{f, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.f, res.err
+	case <-ctx.Done():
+		// unblock open with fake writer (retry, until reader not blocked in open)
+		for {
+			if w, err := os.OpenFile(in.cfg.Path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+				w.Close()
+			} else if !errors.Is(err, syscall.ENXIO) {
+				logger.Warn().Err(err).Msg("unblock open failed")
+			}
+			select {
+			case res := <-ch:
+				if res.f != nil {
+					res.f.Close()
+				}
+				return nil, nil
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package fifo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func writeFifo(t *testing.T, path, data string) {
+	var (
+		f   *os.File
+		err error
+	)
+	for i := 0; i < 50; i++ {
+		// wait for reader
+		if f, err = os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestFifo(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "pipe")
+	cfg := config.ConfigRaw{"type": "fifo", "path": path, "read_buffer": "4"}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 10)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+
+	// wait for create
+	for i := 0; i < 50; i++ {
+		if _, err = os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if isFifo, err := fsutil.IsFifo(path); err != nil || !isFifo {
+		t.Fatalf("named pipe not created: %v", err)
+	}
+
+	// partial line continued after writer reconnect
+	writeFifo(t, path, "test 1\ntest")
+	writeFifo(t, path, " 2\n")
+	writeFifo(t, path, "long test 3\n")
+
+	wantEvents := make([]*event.Event, 0, 3)
+	for _, line := range []string{"test 1", "test 2", "long test 3"} {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": line, "type": "fifo", "path": path},
+			Tags:   map[string]int{},
+		})
+	}
+	events := test.EventsFromChannel(fchan, 50*time.Millisecond)
+	if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+
+	// shutdown without writer
+	cancel()
+	wg.Wait()
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+}
+
+func TestFifoNotPipe(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "file")
+	if err = os.WriteFile(path, []byte("test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ConfigRaw{"type": "fifo", "path": path}
+	if _, err = input.New(&cfg, &config.Common{Hostname: "localhost"}); err == nil {
+		t.Errorf("New() must fail for regular file")
+	}
+
+	cfg = config.ConfigRaw{"type": "fifo", "path": filepath.Join(testDir, "pipe"), "create": false}
+	if _, err = input.New(&cfg, &config.Common{Hostname: "localhost"}); err == nil {
+		t.Errorf("New() must fail for not existing pipe without create")
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
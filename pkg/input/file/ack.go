@@ -0,0 +1,143 @@
+package file
+
+import (
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/rs/zerolog/log"
+)
+
+// offsetAcker track acknowledges of file events (with wait_ack) and commit offset of contiguous processed lines to seek db.
+//
+// After failed delivery offset is not advanced anymore, so lines from first not delivered event are readed again after restart.
+type offsetAcker struct {
+	in   *File
+	path string
+
+	mu      sync.Mutex
+	stat    fsutil.Fsnode // committed state (Size is a offset)
+	offset  int64         // last readed offset
+	pending []*lineAck    // not acknowledged events (and skipped lines after them) in read order
+	failed  bool
+	closed  bool // replaced after file truncate or recreate
+}
+
+// lineAck is a event acknowledge, offset is a line end
+type lineAck struct {
+	a      *offsetAcker
+	offset int64
+	done   bool
+}
+
+func (l *lineAck) Ack(delivered bool) {
+	l.a.ack(l, delivered)
+}
+
+// commit advance committed offset to contiguous acknowledged lines
+func (a *offsetAcker) commit() {
+	n := 0
+	for n < len(a.pending) && a.pending[n].done {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	a.stat.Size = a.pending[n-1].offset
+	a.pending = a.pending[:copy(a.pending, a.pending[n:])]
+	if !a.closed {
+		a.in.db.Set(a.path, a.stat)
+	}
+}
+
+func (a *offsetAcker) ack(l *lineAck, delivered bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l.done = true
+	if a.failed {
+		return
+	}
+	if !delivered {
+		a.failed = true
+		a.pending = nil
+		log.Error().Str("config", a.in.common.Config).Str("input", a.in.cfg.Type).Str("file", a.path).Int64("offset", a.stat.Size).
+			Msg("event delivery failed, offset not advanced until restart")
+		return
+	}
+	a.commit()
+}
+
+// add return acknowledge for event (line ended at offset)
+func (a *offsetAcker) add(offset int64) *lineAck {
+	l := &lineAck{a: a, offset: offset}
+	a.mu.Lock()
+	a.offset = offset
+	if !a.failed {
+		a.pending = append(a.pending, l)
+	}
+	a.mu.Unlock()
+	return l
+}
+
+// skip mark line (without event) as processed
+func (a *offsetAcker) skip(offset int64) {
+	a.mu.Lock()
+	a.offset = offset
+	if !a.failed {
+		a.pending = append(a.pending, &lineAck{a: a, offset: offset, done: true})
+		a.commit()
+	}
+	a.mu.Unlock()
+}
+
+// waiting return true, if acknowledges are pending
+func (a *offsetAcker) waiting() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.failed && !a.closed && len(a.pending) > 0
+}
+
+// offsetAcker return file acker (new, if file truncated or recreated)
+func (in *File) offsetAcker(fpath string, fnode *fsutil.Fsnode) *offsetAcker {
+	in.acksMu.Lock()
+	defer in.acksMu.Unlock()
+	a := in.acks[fpath]
+	if a != nil {
+		a.mu.Lock()
+		same := !fsutil.Other(&a.stat, fnode) && fnode.Size >= a.offset
+		if !same {
+			a.closed = true
+		}
+		a.mu.Unlock()
+		if same {
+			return a
+		}
+	}
+	a = &offsetAcker{in: in, path: fpath, stat: *fnode, offset: fnode.Size}
+	in.acks[fpath] = a
+	return a
+}
+
+// waitAcks wait for pending acknowledges (until ack_timeout)
+func (in *File) waitAcks() {
+	deadline := time.Now().Add(in.cfg.AckTimeout)
+	for {
+		pending := 0
+		in.acksMu.Lock()
+		for _, a := range in.acks {
+			if a.waiting() {
+				pending++
+			}
+		}
+		in.acksMu.Unlock()
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Int("files", pending).
+				Msg("acknowledges not received, offsets not committed")
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
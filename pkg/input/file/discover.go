@@ -0,0 +1,142 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/rs/zerolog/log"
+)
+
+// excluded check file path (and base name) with exclude patterns
+func (in *File) excluded(fpath string) bool {
+	name := filepath.Base(fpath)
+	for _, pattern := range in.cfg.Exclude {
+		if ok, _ := fsutil.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := fsutil.Match(pattern, fpath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type discovery struct {
+	files    []string
+	fnodes   []fsutil.Fsnode
+	filesMap map[string]bool
+	dirsMap  map[string]bool // walked directories, for symlink loop protection
+	now      time.Time
+}
+
+// discover expand path globs, walk matched directories and init files stat
+func (in *File) discover(ctx context.Context) ([]string, []fsutil.Fsnode, error) {
+	d := discovery{
+		filesMap: make(map[string]bool),
+		dirsMap:  make(map[string]bool),
+		now:      time.Now(),
+	}
+
+	for _, pattern := range in.cfg.Path {
+		matches, err := fsutil.Glob(pattern, in.cfg.MaxDepth)
+		if err != nil {
+			return nil, nil, jerrors.Annotate(err, "glob expand failed: "+pattern)
+		}
+		for _, match := range matches {
+			if in.excluded(match) {
+				continue
+			}
+			fpath, err := evalSymlinks(ctx, match)
+			if err != nil {
+				log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("eval symlink failed")
+				continue
+			}
+			if err = in.discoverPath(ctx, &d, fpath, 0); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return d.files, d.fnodes, nil
+}
+
+func (in *File) discoverPath(ctx context.Context, d *discovery, fpath string, depth int) error {
+	if _, exist := d.filesMap[fpath]; exist {
+		return nil
+	}
+
+	if isDir, err := fsutil.IsDir(fpath); err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("stat failed")
+		return err
+	} else if isDir {
+		return in.discoverDir(ctx, d, fpath, depth)
+	}
+
+	if isFifo, err := fsutil.IsFifo(fpath); err == nil && isFifo {
+		// size based reading not work for named pipes
+		log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Msg("named pipe skipped, use fifo input")
+		return nil
+	}
+
+	if in.cfg.IgnoreOlder > 0 {
+		if mtime, err := fsutil.ModTime(fpath); err != nil {
+			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("stat failed")
+			return nil
+		} else if d.now.Sub(mtime) > in.cfg.IgnoreOlder {
+			log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Time("mtime", mtime).Msg("ignore older")
+			return nil
+		}
+	}
+
+	d.filesMap[fpath] = true
+	d.files = append(d.files, fpath)
+	d.fnodes = append(d.fnodes, fsutil.Fsnode{})
+	in.fileStatInit(fpath, len(d.fnodes)-1, d.fnodes)
+
+	return nil
+}
+
+// discoverDir walk directory recursively (no deeper than max_depth)
+func (in *File) discoverDir(ctx context.Context, d *discovery, dir string, depth int) error {
+	if _, exist := d.dirsMap[dir]; exist {
+		// symlink loop or already walked
+		return nil
+	}
+	d.dirsMap[dir] = true
+
+	if in.cfg.MaxDepth > 0 && depth > in.cfg.MaxDepth {
+		log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("dir", dir).Msg("max depth reached, dir skipping")
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("dir", dir).Err(err).Msg("read dir failed")
+		return nil
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if in.excluded(path) {
+			continue
+		}
+		if IsNotExist(path) {
+			// broken symlink
+			continue
+		}
+		fpath, err := evalSymlinks(ctx, path)
+		if err != nil {
+			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", path).Err(err).Msg("eval symlink failed")
+			continue
+		}
+		if err = in.discoverPath(ctx, d, fpath, depth+1); err != nil {
+			// logged in discoverPath (like file removed while walk), skip it and continue walk
+			continue
+		}
+	}
+
+	return nil
+}
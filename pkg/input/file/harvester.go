@@ -0,0 +1,190 @@
+package file
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/rs/zerolog/log"
+)
+
+// harvestWorker is a worker with max_open_files limit. No more than max_open_files workers are started.
+//
+// Worker pull ready files from scheduler and read no more than harvest_chunk bytes per turn.
+// File kept opened between turns while (dev, inode) is not changed. Rotated (renamed) file is drained
+// for one more interval before new file is opened. Least recently harvested file is closed, if max_open_files is reached.
+func (in *File) harvestWorker(ctx context.Context, statChan chan<- fstatdb.StatEvent, outChan chan<- *event.Event) error {
+	// reader is shared by harvested files, reset on each turn
+	reader := lreader.New(nil, int(in.cfg.ReadBuffer.Value()))
+	for {
+		h, err := in.sched.next(ctx)
+		if err != nil {
+			// all files are completed or shutdown
+			return nil
+		}
+		if err = in.harvestTurn(ctx, h, reader, statChan, outChan); err != nil {
+			return err
+		}
+	}
+}
+
+// harvestInit init file on first turn
+func (in *File) harvestInit(ctx context.Context, h *harvest) (bool, error) {
+	var (
+		err   error
+		isDir bool
+	)
+	if h.codec, err = codec.New(in.cfgRaw, in.common, h.path); err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("codec", in.cfg.Codec).Str("file", h.path).Err(err).Msg("codec init failed")
+		return false, err
+	}
+
+	if h.path, err = evalSymlinks(ctx, h.path); err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Err(err).Msg("eval symlink failed")
+		return false, err
+	}
+
+	if isDir, err = fsutil.IsDir(h.path); err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Err(err).Msg("stat failed")
+		return false, err
+	} else if isDir {
+		log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("dir skipping")
+		return false, nil
+	}
+
+	if in.window != nil {
+		if err = in.windowStart(h.path, &h.fnode, h.codec); err != nil {
+			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Err(err).Msg("search window start failed")
+			return false, err
+		}
+	}
+	h.active = time.Now()
+	h.touched = h.active
+
+	return true, nil
+}
+
+// harvestTurn read file until EOF or harvest_chunk limit and schedule next turn
+func (in *File) harvestTurn(ctx context.Context, h *harvest, reader *lreader.Reader, statChan chan<- fstatdb.StatEvent, outChan chan<- *event.Event) error {
+	var (
+		err                  error
+		ok                   bool
+		truncated, recreated bool
+	)
+
+	if h.codec == nil {
+		if ok, err = in.harvestInit(ctx, h); !ok {
+			in.sched.finish(h)
+			return err
+		}
+	}
+
+	if h.fp == nil {
+		in.sched.reserve(h)
+		h.fp, truncated, recreated, err = in.openFile(nil, reader, h.path, &h.fnode)
+		h.rotated = false
+		if err == nil && h.fnode.Inode == 0 {
+			// file not known (start without seek db record), remember opened file for detect rotate
+			var fn fsutil.Fsnode
+			if err = fsutil.FStat(h.fp, &fn); err == nil {
+				h.fnode.Dev, h.fnode.Inode, h.fnode.Nlink = fn.Dev, fn.Inode, fn.Nlink
+			}
+		}
+	} else if _, err = h.fp.Seek(h.fnode.Size, io.SeekStart); err == nil {
+		// file kept opened from previous turn, reader can contain data of other file
+		reader.Reset(h.fp)
+		h.fp, truncated, recreated, err = in.openFile(h.fp, reader, h.path, &h.fnode)
+	}
+	if err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Err(err).Msg("open failed")
+		in.sched.close(h)
+		if in.cfg.Mode == ModeRead {
+			in.sched.finish(h)
+			return err
+		}
+		in.harvestSleep(ctx, h)
+		return nil
+	}
+	if truncated {
+		log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("reopen truncated")
+	} else if recreated {
+		log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("reopen recreated")
+	}
+
+	offset := h.fnode.Size
+	err = in.fileReadUntilEOF(ctx, reader, h.codec, h.path, &h.fnode, in.cfg.HarvestChunk.Value(), statChan, outChan)
+	if fsutil.FStat(h.fp, &h.last) != nil {
+		h.last = h.fnode
+	}
+	h.turn = time.Now()
+	if truncated || recreated || h.fnode.Size != offset {
+		h.active = h.turn
+	}
+
+	switch err {
+	case nil:
+		// harvest_chunk limit reached, wait for next turn
+		in.sched.release(h)
+		in.sched.push(h)
+	case errShutdown:
+		in.sched.release(h)
+	case errWindowEnd:
+		log.Info().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("read ended on window end")
+		in.sched.finish(h)
+	case io.EOF:
+		if in.cfg.Mode == ModeRead {
+			log.Info().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("read ended on EOF")
+			in.sched.finish(h)
+			return nil
+		}
+		var fn fsutil.Fsnode
+		if fsutil.LStat(h.path, &fn) == nil && fsutil.Other(&fn, &h.last) {
+			if h.rotated {
+				// rotated file is drained, open new file on next turn
+				log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("close rotated")
+				in.sched.close(h)
+				in.sched.push(h)
+				return nil
+			}
+			// drain rotated file for one more interval (data can be written before writer reopen file)
+			h.rotated = true
+			in.sched.release(h)
+		} else if in.cfg.CloseInactive > 0 && time.Since(h.active) >= in.cfg.CloseInactive {
+			log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Msg("close inactive")
+			in.sched.close(h)
+		} else {
+			in.sched.release(h)
+		}
+		in.harvestSleep(ctx, h)
+	default:
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", h.path).Err(err).Msg("read failed")
+		in.sched.close(h)
+		if in.cfg.Mode == ModeRead {
+			in.sched.finish(h)
+			return nil
+		}
+		in.harvestSleep(ctx, h)
+	}
+
+	return nil
+}
+
+// harvestSleep schedule next turn after file change (or after interval for rotated file)
+func (in *File) harvestSleep(ctx context.Context, h *harvest) {
+	time.AfterFunc(in.cfg.Interval, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		in.touch(h.path, &h.touched)
+		if h.rotated || fileChanged(h.path, &h.last) {
+			in.sched.push(h)
+		} else {
+			in.harvestSleep(ctx, h)
+		}
+	})
+}
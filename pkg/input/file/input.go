@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -71,25 +71,60 @@ func (m *Mode) UnmarshalYAML(unmarshal func(interface{}) error) error {
 type Config struct {
 	input.Config
 
-	Path       string      `hcl:"path" yaml:"path" json:"path"`                      // path glob
-	ReadBuffer config.Size `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"` // read buffer size
-	Codec      string      `hcl:"codec" yaml:"codec" json:"codec"`                   // codec name (deefault - line)
+	Path       config.Strings `hcl:"path" yaml:"path" json:"path"`                      // path globs (with '**' support), matched directories walked recursively
+	Exclude    config.Strings `hcl:"exclude" yaml:"exclude" json:"exclude"`             // exclude patterns (with '**' support), checked with file path and base name
+	MaxDepth   int            `hcl:"max_depth" yaml:"max_depth" json:"max_depth"`       // max depth for '**' and directory walk (0 - unlimited)
+	ReadBuffer config.Size    `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"` // read buffer size
+	Codec      string         `hcl:"codec" yaml:"codec" json:"codec"`                   // codec name (deefault - line)
 	// Username string        `hcl:"username" yaml:"username"`
 	// Pasword  string        `hcl:"usernam" yaml:"username"`
 	Interval time.Duration `hcl:"interval" yaml:"interval" json:"interval"`
 	// mode = tail If no file record in seek db, no shutdown on io.EOF.  If no file record in seek db, depend on start_end
 	// mode = read If no file record in seek db, read from start and exit on io.OEF (for completed files), start_end is ignored
-	Mode     Mode   `hcl:"mode" yaml:"mode" json:"mode"`
-	StartEnd bool   `hcl:"start_end" yaml:"start_end" json:"start_end"` // read from end  if no file record in seek db
+	Mode     Mode `hcl:"mode" yaml:"mode" json:"mode"`
+	StartEnd bool `hcl:"start_end" yaml:"start_end" json:"start_end"` // read from end  if no file record in seek db
+	// seek_file can be shared by inputs (records namespaced by input id, must be unique for inputs with same seek_file)
 	SeekFile string `hcl:"seek_file" yaml:"seek_file" json:"seek_file"` // if not set, read from end after start
+	// seek_ttl expire seek db records for files, not seen (at discovery or while watched) or readed for duration (0 - disabled)
+	SeekTTL time.Duration `hcl:"seek_ttl" yaml:"seek_ttl" json:"seek_ttl"`
+	// fingerprint_size store hash of file head in seek db for detect inode reuse (0 - disabled)
+	FingerprintSize config.Size `hcl:"fingerprint_size" yaml:"fingerprint_size" json:"fingerprint_size"`
+	// ignore_older skip files with modification time older than duration at discovery (0 - disabled)
+	IgnoreOlder time.Duration `hcl:"ignore_older" yaml:"ignore_older" json:"ignore_older"`
+	// close_inactive close file descriptor, if file not changed for duration, file reopened on size change (0 - disabled)
+	CloseInactive time.Duration `hcl:"close_inactive" yaml:"close_inactive" json:"close_inactive"`
+	// max_open_files limit opened files and harvest workers (0 - unlimited). If set, files are readed in turns by workers,
+	// least recently harvested file is closed, if limit is reached
+	MaxOpenFiles int `hcl:"max_open_files" yaml:"max_open_files" json:"max_open_files"`
+	// schedule is order of read turns with max_open_files: round_robin (default) or oldest_first (by modification time)
+	Schedule Schedule `hcl:"schedule" yaml:"schedule" json:"schedule"`
+	// harvest_chunk is max bytes, readed from file per turn with max_open_files
+	HarvestChunk config.Size `hcl:"harvest_chunk" yaml:"harvest_chunk" json:"harvest_chunk"`
+	// read_from and read_until is a read window (only for mode = read), compared with event timestamp, range is [read_from, read_until).
+	// Event timestamp must be parsed by codec (line codec set it to read time, so can't be used)
+	ReadFrom  time.Time `hcl:"read_from" yaml:"read_from" json:"read_from"`
+	ReadUntil time.Time `hcl:"read_until" yaml:"read_until" json:"read_until"`
+	// read_from_offset and read_until_offset is a read window in bytes (only for mode = read), range is [read_from_offset, read_until_offset)
+	ReadFromOffset  int64 `hcl:"read_from_offset" yaml:"read_from_offset" json:"read_from_offset"`
+	ReadUntilOffset int64 `hcl:"read_until_offset" yaml:"read_until_offset" json:"read_until_offset"`
+	// monotonic event timestamps in files, start position searched with binary search and read stopped after read_until
+	Monotonic bool `hcl:"monotonic" yaml:"monotonic" json:"monotonic"`
+	// wait_ack commit offsets to seek db only for events, processed by outputs (requires seek_file).
+	// After failed delivery offset is not advanced, so not delivered lines are readed again after restart
+	WaitAck bool `hcl:"wait_ack" yaml:"wait_ack" json:"wait_ack"`
+	// ack_timeout is a max wait for pending acknowledges on shutdown (not acknowledged lines are readed again after restart)
+	AckTimeout time.Duration `hcl:"ack_timeout" yaml:"ack_timeout" json:"ack_timeout"`
 	// ExitAfterRead bool   `hcl:"exit_after_read" yaml:"exit_after_read" json:"exit_after_read"` // shutdown file watcher on io.EOF (for static files and bencmarks)
 }
 
 func defaultConfig() Config {
 	return Config{
-		Config:     input.Config{Type: Name},
-		Interval:   time.Second,
-		ReadBuffer: config.Size(64 * 1024),
+		Config:       input.Config{Type: Name},
+		Interval:     time.Second,
+		ReadBuffer:   config.Size(64 * 1024),
+		MaxDepth:     8,
+		HarvestChunk: config.Size(1024 * 1024),
+		AckTimeout:   30 * time.Second,
 	}
 }
 
@@ -103,8 +138,14 @@ type File struct {
 	cfgRaw *config.ConfigRaw
 	common *config.Common
 
-	db      *fstatdb.Db
+	seeker  fstatdb.Seeker // namespace in seek store (set by pipeline)
+	db      fstatdb.Seeker // opened seeker
+	sched   *scheduler
+	window  *window
 	running int32
+
+	acksMu sync.Mutex
+	acks   map[string]*offsetAcker // by file path (with wait_ack)
 }
 
 func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
@@ -118,7 +159,7 @@ func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
 		return nil, err
 	}
 
-	if in.cfg.Path == "" {
+	if len(in.cfg.Path) == 0 {
 		return nil, errors.New("input '" + in.cfg.Type + "': path not set")
 	}
 
@@ -126,15 +167,67 @@ func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
 		return nil, errors.New("input '" + in.cfg.Type + "': interval must be <= 20s")
 	}
 
+	if in.cfg.IgnoreOlder < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': ignore_older must be >= 0")
+	}
+
+	if in.cfg.CloseInactive < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': close_inactive must be >= 0")
+	}
+
+	if in.cfg.MaxDepth < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_depth must be >= 0")
+	}
+
+	if in.cfg.SeekTTL < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': seek_ttl must be >= 0")
+	}
+
+	if in.cfg.FingerprintSize.Value() < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': fingerprint_size must be >= 0")
+	}
+
+	if in.cfg.MaxOpenFiles < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_open_files must be >= 0")
+	}
+
 	if in.cfg.Mode == ModeRead {
 		// disable seek file and read from end
 		in.cfg.StartEnd = false
 	}
 
+	if in.cfg.ReadFromOffset < 0 || in.cfg.ReadUntilOffset < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_from_offset and read_until_offset must be >= 0")
+	}
+
+	if in.cfg.ReadUntilOffset > 0 && in.cfg.ReadUntilOffset <= in.cfg.ReadFromOffset {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_until_offset must be > read_from_offset")
+	}
+
+	if !in.cfg.ReadUntil.IsZero() && !in.cfg.ReadUntil.After(in.cfg.ReadFrom) {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_until must be after read_from")
+	}
+
+	if in.cfg.WaitAck && in.cfg.SeekFile == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': wait_ack requires seek_file")
+	}
+
+	if in.cfg.AckTimeout < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': ack_timeout must be >= 0")
+	}
+
+	if in.window = newWindow(&in.cfg); in.window != nil && in.cfg.Mode != ModeRead {
+		return nil, errors.New("input '" + in.cfg.Type + "': read window can be used only with mode = read")
+	}
+
+	if (!in.cfg.ReadFrom.IsZero() || !in.cfg.ReadUntil.IsZero() || in.cfg.Monotonic) && (in.cfg.Codec == "" || in.cfg.Codec == "line") {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_from, read_until and monotonic require codec with timestamp parsing, line codec set read time")
+	}
+
 	// Check codec config
-	_, err := codec.New(in.cfgRaw, in.common, in.cfg.Path)
+	_, err := codec.New(in.cfgRaw, in.common, in.cfg.Path.String())
 	if err != nil {
-		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' path='"+in.cfg.Path+"'")
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' path='"+in.cfg.Path.String()+"'")
 	}
 
 	return in, nil
@@ -144,7 +237,36 @@ func (in *File) Name() string {
 	return Name
 }
 
+// id return input id (namespace in seek db)
+func (in *File) id() string {
+	if in.cfg.ID == "" {
+		return in.cfg.Type
+	}
+	return in.cfg.ID
+}
+
+// Seek return seek file and namespace options
+func (in *File) Seek() (string, fstatdb.Options) {
+	return in.cfg.SeekFile, fstatdb.Options{
+		Input:           in.id(),
+		TTL:             in.cfg.SeekTTL,
+		FingerprintSize: int(in.cfg.FingerprintSize.Value()),
+	}
+}
+
+func (in *File) SetSeeker(db fstatdb.Seeker) {
+	in.seeker = db
+}
+
+func (in *File) WaitAck() bool {
+	return in.cfg.WaitAck
+}
+
 func (in *File) fileStatInit(fpath string, n int, fnodes []fsutil.Fsnode) {
+	if in.db != nil {
+		// file is seen, reset seek_ttl
+		in.db.Touch(fpath)
+	}
 	if in.cfg.Mode == ModeRead {
 		if in.db != nil {
 			if in.db != nil {
@@ -178,69 +300,113 @@ func (in *File) fileStatInit(fpath string, n int, fnodes []fsutil.Fsnode) {
 	}
 }
 
-func (in *File) Start(ctx context.Context, outChan chan<- *event.Event) error {
-	matches, err := filepath.Glob(in.cfg.Path)
-	if err != nil {
-		return jerrors.Annotate(err, "glob expand failed: "+in.cfg.Path)
+// touch reset seek_ttl for watched file (record of idle file must not be expired while file is watched),
+// record is touched no often than half of seek_ttl
+func (in *File) touch(fpath string, touched *time.Time) {
+	if in.db == nil || in.cfg.SeekTTL == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(*touched) >= in.cfg.SeekTTL/2 {
+		in.db.Touch(fpath)
+		*touched = now
 	}
+}
 
+func (in *File) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	var err error
 	if in.cfg.SeekFile == "" {
 		if !in.cfg.StartEnd {
-			log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", in.cfg.Path).Err(err).Msg("seek file not set, force start from end")
+			log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Strs("file", in.cfg.Path).Msg("seek file not set, force start from end")
 			in.cfg.StartEnd = true
 		}
 	} else {
-		in.db = fstatdb.New()
-		if err = in.db.Open(in.cfg.SeekFile); err != nil {
+		if in.seeker == nil {
+			// started without pipeline, use own store
+			path, opts := in.Seek()
+			if in.seeker, err = fstatdb.NewStores().Namespace(path, opts); err != nil {
+				return jerrors.Annotate(err, "open file failed: "+in.cfg.SeekFile)
+			}
+		}
+		if err = in.seeker.Open(); err != nil {
+			in.db = nil
 			return jerrors.Annotate(err, "open file failed: "+in.cfg.SeekFile)
 		}
+		in.db = in.seeker
 	}
 
-	files := make([]string, 0, len(matches))
-	filesMap := make(map[string]bool)
-	fnodes := make([]fsutil.Fsnode, len(matches))
-	i := 0
-	for _, match := range matches {
-		var isDir bool
-		fpath, err := evalSymlinks(ctx, match)
-		if err != nil {
-			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("eval symlink failed")
-			continue
-		}
-
-		if _, exist := filesMap[fpath]; exist {
-			continue
-		}
-
-		if isDir, err = fsutil.IsDir(fpath); err != nil {
-			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("stat failed")
-			return err
-		} else if isDir {
-			log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Msg("dir skipping")
-			return err
+	files, fnodes, err := in.discover(ctx)
+	if err != nil {
+		if in.db != nil {
+			in.db.Close()
 		}
+		return err
+	}
 
-		filesMap[fpath] = true
-		files = append(files, fpath)
-		in.fileStatInit(fpath, i, fnodes)
-		i++
+	if in.cfg.MaxOpenFiles > 0 {
+		in.sched = newScheduler(in.cfg.Schedule, in.cfg.MaxOpenFiles)
+	} else {
+		in.sched = nil
 	}
 
 	var statChan chan fstatdb.StatEvent
 
 	if in.db != nil {
-		if err = in.db.Save(); err != nil {
+		if err = in.db.Flush(); err != nil {
+			in.db.Close()
 			return err
 		}
-		statChan = make(chan fstatdb.StatEvent, 10*len(matches))
+		statChan = make(chan fstatdb.StatEvent, 10*len(files)+1)
 	}
 
 	eg, ctx := errgroup.WithContext(ctx)
 
 	if in.db != nil {
+		timeout := 2 * in.cfg.Interval
+		if in.cfg.WaitAck {
+			// offsets are committed by acknowledges, wait for them on shutdown
+			in.acks = make(map[string]*offsetAcker)
+			timeout += in.cfg.AckTimeout
+		}
 		eg.Go(func() error {
-			return in.db.Watch(ctx, in.cfg.Type, statChan, uint64(len(matches)), 2*in.cfg.Interval)
+			return fstatdb.Watch(ctx, in.cfg.Type, in.db, statChan, timeout)
 		})
+		if len(files) == 0 {
+			// no watchers, nothing to wait
+			close(statChan)
+		}
+	}
+
+	done := func() {
+		running := atomic.AddInt32(&in.running, -1)
+		if running < 1 {
+			if in.db != nil {
+				if in.acks != nil {
+					in.waitAcks()
+				}
+				close(statChan)
+			}
+		}
+	}
+
+	if in.sched != nil {
+		for n, fpath := range files {
+			in.sched.add(&harvest{path: fpath, fnode: fnodes[n], index: -1})
+		}
+		workers := in.cfg.MaxOpenFiles
+		if workers > len(files) {
+			workers = len(files)
+		}
+		for i := 0; i < workers; i++ {
+			atomic.AddInt32(&in.running, 1)
+			eg.Go(func() error {
+				defer done()
+				return in.harvestWorker(ctx, statChan, outChan)
+			})
+		}
+		err = eg.Wait()
+		in.sched.closeAll()
+		return err
 	}
 
 	for i, fpath := range files {
@@ -248,14 +414,7 @@ func (in *File) Start(ctx context.Context, outChan chan<- *event.Event) error {
 		n := i
 		atomic.AddInt32(&in.running, 1)
 		eg.Go(func() error {
-			defer func() {
-				running := atomic.AddInt32(&in.running, -1)
-				if running < 1 {
-					if in.db != nil {
-						close(statChan)
-					}
-				}
-			}()
+			defer done()
 			return in.fileWatchLoop(ctx, path, fnodes[n], statChan, outChan)
 		})
 	}
@@ -270,6 +429,8 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 		size                 int64
 		truncated, recreated bool
 		isDir                bool
+		inactive             bool          // file closed by close_inactive
+		closed               fsutil.Fsnode // file stat on close by close_inactive
 	)
 
 	codec, err := codec.New(in.cfgRaw, in.common, fpath)
@@ -292,6 +453,13 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 		return err
 	}
 
+	if in.window != nil {
+		if err = in.windowStart(fpath, &fnode, codec); err != nil {
+			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("search window start failed")
+			return err
+		}
+	}
+
 	bufSize := int(in.cfg.ReadBuffer.Value())
 	reader := lreader.New(fp, bufSize)
 
@@ -314,10 +482,14 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 
 	// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Int64("offset", fnode.Size).Int64("size", fsutil.FSizeN(fp)).Msg("file read loop")
 	if err == nil {
-		if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, statChan, outChan); err != nil {
+		if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, 0, statChan, outChan); err != nil {
 			if err == errShutdown {
 				return nil
 			}
+			if err == errWindowEnd {
+				log.Info().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Msg("read ended on window end")
+				return nil
+			}
 			if err != io.EOF {
 				log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("read failed")
 				fp.Close()
@@ -333,6 +505,8 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 	}
 
 	// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("file watch started")
+	lastActive := time.Now()
+	touched := lastActive
 	t := time.NewTimer(in.cfg.Interval)
 	defer t.Stop()
 	for {
@@ -342,11 +516,25 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 			return nil
 		case <-t.C:
 			// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("file watch timer")
+			in.touch(fpath, &touched)
+			if inactive {
+				if !fileChanged(fpath, &closed) {
+					break
+				}
+				inactive = false
+				lastActive = time.Now()
+				if fp, truncated, recreated, err = in.openFile(fp, reader, fpath, &fnode); err == nil {
+					log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Bool("truncated", truncated).Bool("recreated", recreated).Msg("reopen inactive")
+				} else {
+					log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("open failed")
+				}
+			}
 			if fp != nil {
 				size = fsutil.FSizeN(fp)
 				if size > fnode.Size {
+					lastActive = time.Now()
 					// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Int64("offset", fnode.Size).Int64("size", fsutil.FSizeN(fp)).Msg("file read loop")
-					if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, statChan, outChan); err != nil {
+					if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, 0, statChan, outChan); err != nil {
 						if err == errShutdown {
 							return nil
 						}
@@ -365,8 +553,9 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 					log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Msg("reopen recreated")
 				}
 				if truncated || recreated {
+					lastActive = time.Now()
 					// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Int64("offset", fnode.Size).Int64("size", fsutil.FSizeN(fp)).Msg("file read loop")
-					if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, statChan, outChan); err != nil {
+					if err = in.fileReadUntilEOF(ctx, reader, codec, fpath, &fnode, 0, statChan, outChan); err != nil {
 						if err == errShutdown {
 							return nil
 						}
@@ -380,13 +569,23 @@ func (in *File) fileWatchLoop(ctx context.Context, fpath string, fnode fsutil.Fs
 			} else {
 				log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("open failed")
 			}
+			if fp != nil && in.cfg.CloseInactive > 0 && time.Since(lastActive) >= in.cfg.CloseInactive {
+				if err = fsutil.FStat(fp, &closed); err == nil {
+					fp.Close()
+					fp = nil
+					inactive = true
+					log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Msg("close inactive")
+				}
+			}
 		}
 		t.Reset(in.cfg.Interval)
 		// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("file watch timer reset")
 	}
 }
 
-func (in *File) fileReadUntilEOF(ctx context.Context, reader *lreader.Reader, codec codec.Codec, fpath string, fnode *fsutil.Fsnode, statChan chan<- fstatdb.StatEvent, outChan chan<- *event.Event) (err error) {
+// fileReadUntilEOF read lines until EOF (or error). If limit > 0, stop after limit bytes readed and return nil error.
+// With read window events outside window dropped, errWindowEnd returned if window end reached.
+func (in *File) fileReadUntilEOF(ctx context.Context, reader *lreader.Reader, codec codec.Codec, fpath string, fnode *fsutil.Fsnode, limit int64, statChan chan<- fstatdb.StatEvent, outChan chan<- *event.Event) (err error) {
 	var (
 		e    *event.Event
 		data []byte
@@ -398,27 +597,54 @@ func (in *File) fileReadUntilEOF(ctx context.Context, reader *lreader.Reader, co
 		return
 	default:
 	}
+	var acker *offsetAcker
+	if in.acks != nil {
+		acker = in.offsetAcker(fpath, fnode)
+	}
 	processed := 0
+	start := fnode.Size
 	ts := timeutil.Now()
 	for {
+		if in.window != nil && in.window.untilOffset > 0 && fnode.Size >= in.window.untilOffset {
+			err = errWindowEnd
+			break
+		}
 		if data, err = reader.ReadUntil('\n'); err != nil {
 			break
 		}
 		processed++
 		fnode.Size += int64(len(data))
+		sended := false
 		if e, err = codec.Parse(ts, data); err == nil {
+			if e != nil && in.window != nil {
+				if keep, stop := in.window.check(e); !keep {
+					event.Put(e)
+					if stop {
+						err = errWindowEnd
+						break
+					}
+					e = nil
+				}
+			}
 			if e != nil {
 				if zerolog.GlobalLevel() == zerolog.TraceLevel {
 					log.Trace().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Str("text", stringutils.UnsafeString(data)).Str("event", event.String(e)).Err(err).Msg("parse")
 				}
+				if acker != nil {
+					e.Acker = acker.add(fnode.Size)
+				}
 				outChan <- e
+				sended = true
 			}
 		} else {
 			log.Debug().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Str("text", stringutils.UnsafeString(data)).Err(err).Msg("parse")
 		}
+		if acker != nil && !sended {
+			acker.skip(fnode.Size)
+		}
 
 		if processed > 20 {
-			if statChan != nil {
+			if statChan != nil && acker == nil {
 				statChan <- fstatdb.StatEvent{Path: fpath, Stat: *fnode}
 				processed = 0
 			}
@@ -430,8 +656,13 @@ func (in *File) fileReadUntilEOF(ctx context.Context, reader *lreader.Reader, co
 			default:
 			}
 		}
+
+		if limit > 0 && fnode.Size-start >= limit {
+			err = nil
+			break
+		}
 	}
-	if statChan != nil && processed > 0 {
+	if statChan != nil && acker == nil && processed > 0 {
 		statChan <- fstatdb.StatEvent{Path: fpath, Stat: *fnode}
 	}
 	// log.Trace.Str("config", in.common.Config).Str("input", in.cfg.Type).Str("file", fpath).Err(err).Msg("file read loop end")
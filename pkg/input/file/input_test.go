@@ -5,6 +5,9 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -12,6 +15,7 @@ import (
 	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
 	"github.com/msaf1980/log-exporter/pkg/config"
 	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
 	"github.com/msaf1980/log-exporter/pkg/fsutil"
 	"github.com/msaf1980/log-exporter/pkg/input"
 	"github.com/msaf1980/log-exporter/pkg/input/file"
@@ -35,33 +39,175 @@ func TestNew(t *testing.T) {
 				"path": "/var/log/*.log",
 			},
 			want: &file.Config{
-				Config:     input.Config{Type: file.Name},
-				ReadBuffer: 65536,
-				Interval:   time.Second,
-				Path:       "/var/log/*.log",
+				Config:       input.Config{Type: file.Name},
+				ReadBuffer:   65536,
+				Interval:     time.Second,
+				Path:         config.Strings{"/var/log/*.log"},
+				MaxDepth:     8,
+				HarvestChunk: 1048576,
+				AckTimeout:   30 * time.Second,
 			},
 			wantErr: false,
 		},
 		{
 			name: "seekf",
 			cfg: config.ConfigRaw{
-				"type":        "file",
-				"path":        "/var/log/*.log",
-				"read_buffer": "12k",
-				"interval":    5 * time.Second,
-				"start_end":   true,
-				"seek_file":   "/var/lib/log-exporter/file/seek",
+				"type":             "file",
+				"path":             "/var/log/*.log",
+				"read_buffer":      "12k",
+				"interval":         5 * time.Second,
+				"start_end":        true,
+				"seek_file":        "/var/lib/log-exporter/file/seek",
+				"seek_ttl":         720 * time.Hour,
+				"fingerprint_size": "1k",
 			},
 			want: &file.Config{
-				Config:     input.Config{Type: file.Name},
-				ReadBuffer: 12288,
-				Interval:   5 * time.Second,
-				Path:       "/var/log/*.log",
-				StartEnd:   true,
-				SeekFile:   "/var/lib/log-exporter/file/seek",
+				Config:          input.Config{Type: file.Name},
+				ReadBuffer:      12288,
+				Interval:        5 * time.Second,
+				Path:            config.Strings{"/var/log/*.log"},
+				MaxDepth:        8,
+				StartEnd:        true,
+				SeekFile:        "/var/lib/log-exporter/file/seek",
+				HarvestChunk:    1048576,
+				AckTimeout:      30 * time.Second,
+				SeekTTL:         720 * time.Hour,
+				FingerprintSize: 1024,
 			},
 			wantErr: false,
 		},
+		{
+			name: "inactive",
+			cfg: config.ConfigRaw{
+				"type":           "file",
+				"path":           "/var/log/*.log",
+				"ignore_older":   24 * time.Hour,
+				"close_inactive": 5 * time.Minute,
+			},
+			want: &file.Config{
+				Config:        input.Config{Type: file.Name},
+				ReadBuffer:    65536,
+				Interval:      time.Second,
+				Path:          config.Strings{"/var/log/*.log"},
+				MaxDepth:      8,
+				IgnoreOlder:   24 * time.Hour,
+				CloseInactive: 5 * time.Minute,
+				HarvestChunk:  1048576,
+				AckTimeout:    30 * time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "max_open_files",
+			cfg: config.ConfigRaw{
+				"type":           "file",
+				"path":           "/var/log/*.log",
+				"max_open_files": 100,
+				"schedule":       "oldest_first",
+				"harvest_chunk":  "128k",
+			},
+			want: &file.Config{
+				Config:       input.Config{Type: file.Name},
+				ReadBuffer:   65536,
+				Interval:     time.Second,
+				Path:         config.Strings{"/var/log/*.log"},
+				MaxDepth:     8,
+				MaxOpenFiles: 100,
+				Schedule:     file.ScheduleOldestFirst,
+				HarvestChunk: 131072,
+				AckTimeout:   30 * time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "paths",
+			cfg: config.ConfigRaw{
+				"type":      "file",
+				"path":      []string{"/var/log/**/*.log", "/var/log/nginx"},
+				"exclude":   []string{"*.gz", "*.tmp"},
+				"max_depth": 2,
+			},
+			want: &file.Config{
+				Config:       input.Config{Type: file.Name},
+				ReadBuffer:   65536,
+				Interval:     time.Second,
+				Path:         config.Strings{"/var/log/**/*.log", "/var/log/nginx"},
+				Exclude:      config.Strings{"*.gz", "*.tmp"},
+				MaxDepth:     2,
+				HarvestChunk: 1048576,
+				AckTimeout:   30 * time.Second,
+			},
+			wantErr: false,
+		},
+		{
+			name: "read window",
+			cfg: config.ConfigRaw{
+				"type":              "file",
+				"path":              "/var/log/*.log",
+				"mode":              file.ModeRead,
+				"read_from":         "2021-01-02T10:00:00Z",
+				"read_until":        "2021-01-02T11:00:00Z",
+				"read_until_offset": 1048576,
+				"monotonic":         true,
+				"codec":             "json",
+			},
+			want: &file.Config{
+				Config:          input.Config{Type: file.Name},
+				ReadBuffer:      65536,
+				Interval:        time.Second,
+				Path:            config.Strings{"/var/log/*.log"},
+				MaxDepth:        8,
+				Codec:           "json",
+				Mode:            file.ModeRead,
+				HarvestChunk:    1048576,
+				AckTimeout:      30 * time.Second,
+				ReadFrom:        time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC),
+				ReadUntil:       time.Date(2021, 1, 2, 11, 0, 0, 0, time.UTC),
+				ReadUntilOffset: 1048576,
+				Monotonic:       true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "read window with line codec",
+			cfg: config.ConfigRaw{
+				"type":      "file",
+				"path":      "/var/log/*.log",
+				"mode":      file.ModeRead,
+				"read_from": "2021-01-02T10:00:00Z",
+			},
+			wantErr: true,
+		},
+		{
+			name: "read window in tail mode",
+			cfg: config.ConfigRaw{
+				"type":      "file",
+				"path":      "/var/log/*.log",
+				"read_from": "2021-01-02T10:00:00Z",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid read window",
+			cfg: config.ConfigRaw{
+				"type":       "file",
+				"path":       "/var/log/*.log",
+				"mode":       file.ModeRead,
+				"read_from":  "2021-01-02T10:00:00Z",
+				"read_until": "2021-01-02T10:00:00Z",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid schedule",
+			cfg: config.ConfigRaw{
+				"type":           "file",
+				"path":           "/var/log/*.log",
+				"max_open_files": 100,
+				"schedule":       "newest",
+			},
+			wantErr: true,
+		},
 	}
 	common := &config.Common{Hostname: "localhost"}
 	for _, tt := range tests {
@@ -70,6 +216,9 @@ func TestNew(t *testing.T) {
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err != nil {
+				return
+			}
 			cfg := got.(*file.File).Cfg()
 
 			if !reflect.DeepEqual(cfg, tt.want) {
@@ -572,6 +721,738 @@ func TestFileStress(t *testing.T) {
 	event.PutSlice(events)
 }
 
+func TestFileIgnoreOlder(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	f1Path := path.Join(testDir, "f1.log")
+	f2Path := path.Join(testDir, "f2.log")
+
+	if err = os.WriteFile(f1Path, []byte("test 1 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(f2Path, []byte("test 2 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err = os.Chtimes(f2Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.ConfigRaw{
+		"type":         "file",
+		"path":         path.Join(testDir, "*.log"),
+		"seek_file":    path.Join(testDir, "seek.db"),
+		"mode":         file.ModeRead,
+		"ignore_older": time.Hour,
+	}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fchan := make(chan *event.Event, 10)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+
+	wantEvents := []*event.Event{
+		{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": "test 1 1", "path": f1Path, "type": "file"},
+			Tags:   map[string]int{},
+		},
+	}
+	events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+	if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+
+	// all files are ignored, read must be completed without hang
+	if err = os.Chtimes(f1Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	fchan = make(chan *event.Event, 10)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("second in.Start() error = %v", err)
+	}
+	close(fchan)
+	events = test.EventsFromChannel(fchan, 100*time.Millisecond)
+	if eq, diff := test.EventsCmp(nil, events, false, true, false); !eq {
+		t.Errorf("second events (want %d, got %d) mismatch:\n%s", 0, len(events), diff)
+	}
+}
+
+func TestFileReadWindow(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	start := time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC)
+	var (
+		sorted   strings.Builder
+		unsorted strings.Builder
+		offsets  []int64 // line offsets in sorted file
+	)
+	for i := 0; i < 1000; i++ {
+		offsets = append(offsets, int64(sorted.Len()))
+		sorted.WriteString(`{"timestamp":"` + start.Add(time.Duration(i)*time.Second).Format(time.RFC3339) + `","message":"` + strconv.Itoa(i) + `"}` + "\n")
+		// swap pairs
+		n := i ^ 1
+		unsorted.WriteString(`{"timestamp":"` + start.Add(time.Duration(n)*time.Second).Format(time.RFC3339) + `","message":"` + strconv.Itoa(n) + `"}` + "\n")
+	}
+	sortedPath := path.Join(testDir, "sorted.log")
+	unsortedPath := path.Join(testDir, "unsorted.log")
+	if err = os.WriteFile(sortedPath, []byte(sorted.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(unsortedPath, []byte(unsorted.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.ConfigRaw
+		want []int // sorted messages
+	}{
+		{
+			name: "monotonic",
+			cfg: config.ConfigRaw{
+				"path":       sortedPath,
+				"read_from":  start.Add(500 * time.Second).Format(time.RFC3339),
+				"read_until": start.Add(510 * time.Second).Format(time.RFC3339),
+				"monotonic":  true,
+			},
+			want: []int{500, 501, 502, 503, 504, 505, 506, 507, 508, 509},
+		},
+		{
+			name: "monotonic from start",
+			cfg: config.ConfigRaw{
+				"path":       sortedPath,
+				"read_until": start.Add(3 * time.Second).Format(time.RFC3339),
+				"monotonic":  true,
+			},
+			want: []int{0, 1, 2},
+		},
+		{
+			name: "monotonic to end",
+			cfg: config.ConfigRaw{
+				"path":      sortedPath,
+				"read_from": start.Add(997 * time.Second).Format(time.RFC3339),
+				"monotonic": true,
+			},
+			want: []int{997, 998, 999},
+		},
+		{
+			name: "unsorted",
+			cfg: config.ConfigRaw{
+				"path":       unsortedPath,
+				"read_from":  start.Add(101 * time.Second).Format(time.RFC3339),
+				"read_until": start.Add(104 * time.Second).Format(time.RFC3339),
+			},
+			want: []int{101, 102, 103},
+		},
+		{
+			name: "offsets",
+			cfg: config.ConfigRaw{
+				"path": sortedPath,
+				// start in the middle of line 20
+				"read_from_offset":  offsets[20] + 2,
+				"read_until_offset": offsets[24],
+			},
+			want: []int{21, 22, 23},
+		},
+	}
+	common := &config.Common{Hostname: "localhost"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg["type"] = "file"
+			tt.cfg["mode"] = file.ModeRead
+			tt.cfg["codec"] = "json"
+			tt.cfg["read_buffer"] = "1k"
+			in, err := input.New(&tt.cfg, common)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			fchan := make(chan *event.Event, 1000)
+			if err = in.Start(context.Background(), fchan); err != nil {
+				t.Fatalf("in.Start() error = %v", err)
+			}
+			close(fchan)
+
+			events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+			got := make([]int, 0, len(events))
+			for _, e := range events {
+				n, _ := strconv.Atoi(e.Fields["message"].(string))
+				got = append(got, n)
+			}
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+			event.PutSlice(events)
+		})
+	}
+}
+
+func TestFileWaitAck(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	fPath := path.Join(testDir, "f.log")
+	if err = os.WriteFile(fPath, []byte("line 1\nline 2\nline 3\nline 4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.ConfigRaw{
+		"type":      "file",
+		"path":      fPath,
+		"seek_file": path.Join(testDir, "seek.db"),
+		"mode":      file.ModeRead,
+		"wait_ack":  true,
+	}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// run read events and acknowledge it (delivery of failed lines is failed), return readed lines
+	run := func(failed string) []string {
+		var (
+			lines []string
+			wg    sync.WaitGroup
+		)
+		fchan := make(chan *event.Event, 10)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range fchan {
+				message := e.Fields["message"].(string)
+				lines = append(lines, message)
+				if message == failed {
+					e.Failed = true
+				}
+				event.Put(e)
+			}
+		}()
+		if err := in.Start(context.Background(), fchan); err != nil {
+			t.Fatalf("in.Start() error = %v", err)
+		}
+		close(fchan)
+		wg.Wait()
+		return lines
+	}
+
+	// delivery of third line is failed, so offset must stay after second line
+	if got, want := run("line 3"), []string{"line 1", "line 2", "line 3", "line 4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if got, want := run(""), []string{"line 3", "line 4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second events = %v, want %v", got, want)
+	}
+	if got := run(""); len(got) != 0 {
+		t.Errorf("third events = %v, want none", got)
+	}
+}
+
+func TestFileSharedSeekFile(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	f1Path := path.Join(testDir, "f1.log")
+	f2Path := path.Join(testDir, "f2.log")
+	if err = os.WriteFile(f1Path, []byte("test 1 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(f2Path, []byte("test 2 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	common := &config.Common{Hostname: "localhost"}
+	inputs := make([]input.Input, 0, 2)
+	// seek stores are owned by pipeline
+	stores := fstatdb.NewStores()
+	for _, id := range []string{"f1", "f2"} {
+		cfg := config.ConfigRaw{
+			"type":      "file",
+			"id":        id,
+			"path":      path.Join(testDir, id+".log"),
+			"seek_file": path.Join(testDir, "seek.db"),
+			"mode":      file.ModeRead,
+		}
+		in, err := input.New(&cfg, common)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		s := in.(input.Seeking)
+		ns, err := stores.Namespace(s.Seek())
+		if err != nil {
+			t.Fatalf("Namespace() error = %v", err)
+		}
+		s.SetSeeker(ns)
+		inputs = append(inputs, in)
+	}
+
+	wantEvents := []*event.Event{
+		{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": "test 1 1", "path": f1Path, "type": "file"},
+			Tags:   map[string]int{},
+		},
+		{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": "test 2 1", "path": f2Path, "type": "file"},
+			Tags:   map[string]int{},
+		},
+	}
+	for n := 0; n < 2; n++ {
+		fchan := make(chan *event.Event, 10)
+		var wg sync.WaitGroup
+		for _, in := range inputs {
+			wg.Add(1)
+			go func(in input.Input) {
+				defer wg.Done()
+				if err := in.Start(context.Background(), fchan); err != nil {
+					t.Errorf("in.Start() error = %v", err)
+				}
+			}(in)
+		}
+		wg.Wait()
+		close(fchan)
+
+		events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].Fields["path"].(string) < events[j].Fields["path"].(string)
+		})
+		if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+			t.Errorf("[%d] events (want %d, got %d) mismatch:\n%s", n, len(wantEvents), len(events), diff)
+		}
+		event.PutSlice(events)
+
+		// offsets are stored, next read is empty
+		wantEvents = nil
+	}
+}
+
+func TestFileRecursive(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	files := map[string]string{
+		"app/a.log":             "app a",
+		"app/a.log.gz":          "app a gz",
+		"app/sub/b.log":         "app sub b",
+		"app/sub/deep/c.log":    "app sub deep c",
+		"app/sub/deep/d/e.log":  "app too deep",
+		"nginx/access.log":      "nginx access",
+		"nginx/access.log.1":    "nginx access 1",
+		"nginx/tmp/error.tmp":   "nginx tmp",
+		"other/not_matched.log": "not matched",
+	}
+	for name, message := range files {
+		fpath := path.Join(testDir, name)
+		if err = os.MkdirAll(path.Dir(fpath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(fpath, []byte(message+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// symlink loop
+	if err = os.Symlink(path.Join(testDir, "nginx"), path.Join(testDir, "nginx", "tmp", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.ConfigRaw{
+		"type":      "file",
+		"path":      []string{path.Join(testDir, "app", "**", "*.log"), path.Join(testDir, "nginx")},
+		"exclude":   []string{"*.tmp", "*.gz"},
+		"max_depth": 2,
+		"seek_file": path.Join(testDir, "seek.db"),
+		"mode":      file.ModeRead,
+	}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	fchan := make(chan *event.Event, 20)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+
+	wantEvents := make([]*event.Event, 0, 5)
+	for _, name := range []string{"app/a.log", "app/sub/b.log", "app/sub/deep/c.log", "nginx/access.log", "nginx/access.log.1"} {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": files[name], "path": path.Join(testDir, name), "type": "file"},
+			Tags:   map[string]int{},
+		})
+	}
+	events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+	if eq, diff := test.EventsCmp(wantEvents, events, true, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+}
+
+func TestFileMaxOpenFiles(t *testing.T) {
+	testData := test.Strings(256, 1024)
+	testDir, err := writeFiles4("f1.log", "f2.log", "f3.log", "f4.log", testData)
+	if testDir != "" {
+		defer os.RemoveAll(testDir)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, schedule := range []string{"round_robin", "oldest_first"} {
+		t.Run(schedule, func(t *testing.T) {
+			cfg := config.ConfigRaw{
+				"type":           "file",
+				"path":           path.Join(testDir, "*.log"),
+				"seek_file":      path.Join(testDir, schedule+".db"),
+				"mode":           file.ModeRead,
+				"read_buffer":    "4k",
+				"max_open_files": 1,
+				"schedule":       schedule,
+				"harvest_chunk":  "8k",
+			}
+			common := &config.Common{Hostname: "localhost"}
+
+			in, err := input.New(&cfg, common)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			wantEvents := make([]*event.Event, 0, len(testData))
+			for _, s := range testData {
+				e := &event.Event{
+					Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": s, "path": "", "type": "file"},
+					Tags:   map[string]int{},
+				}
+				wantEvents = append(wantEvents, e)
+			}
+
+			var (
+				wg       sync.WaitGroup
+				startErr error
+			)
+			events := make([]*event.Event, 0, len(testData))
+			fchan := make(chan *event.Event, 10)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				startErr = in.Start(context.Background(), fchan)
+				close(fchan)
+			}()
+
+			for e := range fchan {
+				events = append(events, e)
+			}
+			wg.Wait()
+
+			if startErr != nil {
+				t.Fatalf("in.Start() error = %v", startErr)
+			}
+
+			if eq, diff := test.EventsCmp(wantEvents, events, true, true, true); !eq {
+				t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+			}
+			// put to pool for reuse
+			event.PutSlice(events)
+		})
+	}
+}
+
+func TestFileMaxOpenFilesRotate(t *testing.T) {
+	interval := 50 * time.Millisecond
+	testDir := t.TempDir()
+
+	f1Path := path.Join(testDir, "f1.log")
+	f2Path := path.Join(testDir, "f2.log")
+	f1, err := os.Create(f1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	if err = os.WriteFile(f2Path, []byte("test 2 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f1.WriteString("test 1 1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.ConfigRaw{
+		"type":           "file",
+		"path":           path.Join(testDir, "*.log"),
+		"seek_file":      path.Join(testDir, "seek.db"),
+		"interval":       interval,
+		"max_open_files": 2,
+	}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 10)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+
+	steps := []struct {
+		write  func() error
+		events []string
+	}{
+		{
+			write:  func() error { return nil },
+			events: []string{"test 1 1", "test 2 1"},
+		},
+		{
+			// rotate f1.log, data written to rotated file must be readed before new file
+			write: func() error {
+				if err := os.Rename(f1Path, f1Path+".1"); err != nil {
+					return err
+				}
+				if err := os.WriteFile(f1Path, []byte("test 1 3\n"), 0644); err != nil {
+					return err
+				}
+				_, err := f1.WriteString("test 1 2\n")
+				return err
+			},
+			events: []string{"test 1 2", "test 1 3"},
+		},
+	}
+	for i, step := range steps {
+		if err = step.write(); err != nil {
+			t.Fatal(err)
+		}
+		wantEvents := make([]*event.Event, 0, len(step.events))
+		for _, s := range step.events {
+			wantEvents = append(wantEvents, &event.Event{
+				Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": s, "path": "", "type": "file"},
+				Tags:   map[string]int{},
+			})
+		}
+		events := test.EventsFromChannel(fchan, 4*interval+100*time.Millisecond)
+		if eq, diff := test.EventsCmp(wantEvents, events, i == 0, true, true); !eq {
+			t.Errorf("[%d] events (want %d, got %d) mismatch:\n%s", i, len(wantEvents), len(events), diff)
+		}
+		// put to pool for reuse
+		event.PutSlice(events)
+	}
+
+	cancel()
+	wg.Wait()
+
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+}
+
+// openedFiles return count of process file descriptors, opened for path
+func openedFiles(t *testing.T, fpath string) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("opened files can't be checked: %v", err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if link, err := os.Readlink(path.Join("/proc/self/fd", entry.Name())); err == nil && link == fpath {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFileCloseInactive(t *testing.T) {
+	interval := 50 * time.Millisecond
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	f1Path := path.Join(testDir, "f1.log")
+	f1, err := os.Create(f1Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+
+	cfg := config.ConfigRaw{
+		"type":           "file",
+		"path":           path.Join(testDir, "*.log"),
+		"seek_file":      path.Join(testDir, "seek.db"),
+		"interval":       interval,
+		"close_inactive": 2 * interval,
+	}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 10)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	for i, s := range []string{"test 1 1", "test 1 2"} {
+		if _, err = f1.WriteString(s + "\n"); err != nil {
+			t.Fatal(err)
+		}
+		wantEvents := []*event.Event{
+			{
+				Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": s, "path": f1Path, "type": "file"},
+				Tags:   map[string]int{},
+			},
+		}
+		var events []*event.Event
+		select {
+		case e := <-fchan:
+			events = append(events, e)
+		case <-time.After(2*interval + 100*time.Millisecond):
+		}
+		if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+			t.Errorf("[%d] events (want %d, got %d) mismatch:\n%s", i, len(wantEvents), len(events), diff)
+		}
+		// put to pool for reuse
+		event.PutSlice(events)
+		// opened by test writer and by input
+		if n := openedFiles(t, f1Path); n != 2 {
+			t.Errorf("[%d] opened %s = %d, want 2", i, f1Path, n)
+		}
+
+		// wait for close inactive
+		time.Sleep(4 * interval)
+		if n := openedFiles(t, f1Path); n != 1 {
+			t.Errorf("[%d] opened %s after close_inactive = %d, want 1", i, f1Path, n)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+}
+
+func TestFileSeekTTLWatched(t *testing.T) {
+	interval := 20 * time.Millisecond
+	for _, maxOpenFiles := range []int{0, 1} {
+		t.Run("max_open_files="+strconv.Itoa(maxOpenFiles), func(t *testing.T) {
+			testDir := t.TempDir()
+			fpath := path.Join(testDir, "f1.log")
+			if err := os.WriteFile(fpath, []byte("test 1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := config.ConfigRaw{
+				"type":           "file",
+				"path":           fpath,
+				"seek_file":      path.Join(testDir, "seek.db"),
+				"seek_ttl":       10 * interval,
+				"interval":       interval,
+				"max_open_files": maxOpenFiles,
+			}
+			common := &config.Common{Hostname: "localhost"}
+
+			run := func(idle time.Duration) []*event.Event {
+				in, err := input.New(&cfg, common)
+				if err != nil {
+					t.Fatalf("New() error = %v", err)
+				}
+				ctx, cancel := context.WithCancel(context.Background())
+				fchan := make(chan *event.Event, 10)
+				errCh := make(chan error, 1)
+				go func() {
+					errCh <- in.Start(ctx, fchan)
+				}()
+				time.Sleep(idle)
+				cancel()
+				if err = <-errCh; err != nil {
+					t.Fatalf("in.Start() error = %v", err)
+				}
+				close(fchan)
+				return test.EventsFromChannel(fchan, 10*time.Millisecond)
+			}
+
+			// file is idle for more than seek_ttl, but watched, so record is not expired
+			events := run(30 * interval)
+			if len(events) != 1 || events[0].Fields["message"] != "test 1" {
+				t.Fatalf("events = %d, want 1", len(events))
+			}
+			event.PutSlice(events)
+
+			f, err := os.OpenFile(fpath, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = f.WriteString("test 2\n"); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			// continue from stored offset
+			events = run(5 * interval)
+			if len(events) != 1 || events[0].Fields["message"] != "test 2" {
+				for _, e := range events {
+					t.Errorf("event %s", event.String(e))
+				}
+				t.Fatalf("events = %d after restart, want 1", len(events))
+			}
+			event.PutSlice(events)
+		})
+	}
+}
+
 func benchmarkFile(b *testing.B, testDir string, n int, readBuffer string) {
 	interval := 100 * time.Millisecond
 	cfg := config.ConfigRaw{
@@ -0,0 +1,260 @@
+package file
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+)
+
+type Schedule int8
+
+const (
+	ScheduleRoundRobin Schedule = iota
+	ScheduleOldestFirst
+)
+
+var scheduleStrings []string = []string{"round_robin", "oldest_first"}
+
+func (s *Schedule) Set(value string) error {
+	switch value {
+	case "round_robin", "":
+		*s = ScheduleRoundRobin
+	case "oldest_first":
+		*s = ScheduleOldestFirst
+	default:
+		return fmt.Errorf("invalid schedule %s", value)
+	}
+	return nil
+}
+
+func (s *Schedule) String() string {
+	return scheduleStrings[*s]
+}
+
+func (s *Schedule) UnmarshalText(text []byte) error {
+	return s.Set(string(text))
+}
+
+// harvest is a file state, harvested in turns by workers (with max_open_files)
+type harvest struct {
+	path    string
+	fnode   fsutil.Fsnode // Size is a readed offset
+	codec   codec.Codec   // nil before first turn
+	fp      *os.File      // kept opened between turns, while file not recreated
+	last    fsutil.Fsnode // file stat after last turn
+	active  time.Time     // last turn with readed data (for close_inactive)
+	turn    time.Time     // last turn end (least recently harvested file closed, if max_open_files reached)
+	rotated bool          // opened file is rotated, drained before open new file
+	touched time.Time     // last seek record touch (for seek_ttl)
+
+	key      int64
+	seq      uint64
+	index    int  // index in ready queue
+	opened   bool // in opened files (fp is not nil and not in turn)
+	reserved bool // opened file reserved for turn
+}
+
+type readyQueue []*harvest
+
+func (q readyQueue) Len() int { return len(q) }
+
+func (q readyQueue) Less(i, j int) bool {
+	if q[i].key == q[j].key {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].key < q[j].key
+}
+
+func (q readyQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *readyQueue) Push(x interface{}) {
+	h := x.(*harvest)
+	h.index = len(*q)
+	*q = append(*q, h)
+}
+
+func (q *readyQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	h := old[n-1]
+	old[n-1] = nil
+	h.index = -1
+	*q = old[:n-1]
+	return h
+}
+
+// scheduler queue ready files (readed with harvest_chunk limit or changed after EOF) for max_open_files workers.
+//
+// Ready files ordered by key (sequence for round_robin or file modification time for oldest_first).
+// Opened files are limited by max_open_files, least recently harvested file is closed before open new one.
+type scheduler struct {
+	mu       sync.Mutex
+	schedule Schedule
+	maxOpen  int
+	seq      uint64
+	ready    readyQueue
+	opened   map[*harvest]bool // opened files, not in turn
+	inTurn   int               // opened files in turn
+	pending  int               // not completed files
+
+	wakeup chan struct{}
+	done   chan struct{} // closed, when all files are completed
+}
+
+func newScheduler(schedule Schedule, maxOpen int) *scheduler {
+	return &scheduler{
+		schedule: schedule,
+		maxOpen:  maxOpen,
+		opened:   make(map[*harvest]bool),
+		wakeup:   make(chan struct{}, maxOpen),
+		done:     make(chan struct{}),
+	}
+}
+
+// add add new file (ready for first turn)
+func (s *scheduler) add(h *harvest) {
+	s.mu.Lock()
+	s.pending++
+	s.mu.Unlock()
+	s.push(h)
+}
+
+// push queue file for next turn
+func (s *scheduler) push(h *harvest) {
+	var key int64
+	if s.schedule == ScheduleOldestFirst {
+		if mtime, err := fsutil.ModTime(h.path); err == nil {
+			key = mtime.UnixNano()
+		} else {
+			key = time.Now().UnixNano()
+		}
+	}
+	s.mu.Lock()
+	s.seq++
+	h.key = key
+	h.seq = s.seq
+	heap.Push(&s.ready, h)
+	s.mu.Unlock()
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// next wait for ready file. Return errShutdown on ctx cancel and io.EOF, if all files are completed.
+func (s *scheduler) next(ctx context.Context) (*harvest, error) {
+	for {
+		s.mu.Lock()
+		if len(s.ready) > 0 {
+			h := heap.Pop(&s.ready).(*harvest)
+			if h.opened {
+				delete(s.opened, h)
+				h.opened = false
+				h.reserved = true
+				s.inTurn++
+			}
+			s.mu.Unlock()
+			return h, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.wakeup:
+		case <-s.done:
+			return nil, io.EOF
+		case <-ctx.Done():
+			return nil, errShutdown
+		}
+	}
+}
+
+// reserve reserve opened file for turn (least recently harvested file is closed, if max_open_files reached)
+func (s *scheduler) reserve(h *harvest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h.reserved {
+		return
+	}
+	if len(s.opened)+s.inTurn >= s.maxOpen {
+		var lru *harvest
+		for o := range s.opened {
+			if lru == nil || o.turn.Before(lru.turn) {
+				lru = o
+			}
+		}
+		if lru != nil {
+			delete(s.opened, lru)
+			lru.opened = false
+			lru.fp.Close()
+			lru.fp = nil
+		}
+	}
+	h.reserved = true
+	s.inTurn++
+}
+
+// release end turn, opened file stay opened for next turn
+func (s *scheduler) release(h *harvest) {
+	s.mu.Lock()
+	if h.reserved {
+		h.reserved = false
+		s.inTurn--
+	}
+	if h.fp != nil {
+		h.opened = true
+		s.opened[h] = true
+	}
+	s.mu.Unlock()
+}
+
+// close close file (in turn or not)
+func (s *scheduler) close(h *harvest) {
+	s.mu.Lock()
+	if h.reserved {
+		h.reserved = false
+		s.inTurn--
+	}
+	if h.opened {
+		delete(s.opened, h)
+		h.opened = false
+	}
+	if h.fp != nil {
+		h.fp.Close()
+		h.fp = nil
+	}
+	s.mu.Unlock()
+}
+
+// finish complete file (read ended or failed)
+func (s *scheduler) finish(h *harvest) {
+	s.close(h)
+	s.mu.Lock()
+	s.pending--
+	if s.pending == 0 {
+		close(s.done)
+	}
+	s.mu.Unlock()
+}
+
+// closeAll close opened files (on shutdown)
+func (s *scheduler) closeAll() {
+	s.mu.Lock()
+	for h := range s.opened {
+		delete(s.opened, h)
+		h.opened = false
+		h.fp.Close()
+		h.fp = nil
+	}
+	s.mu.Unlock()
+}
@@ -0,0 +1,178 @@
+package file
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOldestFirst(t *testing.T) {
+	dir := t.TempDir()
+	s := newScheduler(ScheduleOldestFirst, 1)
+	ctx := context.Background()
+
+	now := time.Now()
+	// modification time offsets
+	for _, name := range []string{"30", "10", "20"} {
+		fpath := path.Join(dir, name)
+		if err := os.WriteFile(fpath, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		d, _ := time.ParseDuration(name + "s")
+		if err := os.Chtimes(fpath, now.Add(d), now.Add(d)); err != nil {
+			t.Fatal(err)
+		}
+		s.add(&harvest{path: fpath, index: -1})
+	}
+
+	for _, want := range []string{"10", "20", "30"} {
+		h, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		if name := path.Base(h.path); name != want {
+			t.Errorf("next() order = %s, want %s", name, want)
+		}
+	}
+}
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := newScheduler(ScheduleRoundRobin, 2)
+	ctx := context.Background()
+
+	files := []string{"30", "10", "20"}
+	for _, name := range files {
+		s.add(&harvest{path: name, index: -1})
+	}
+
+	for _, want := range []string{"30", "10", "20", "30", "10"} {
+		h, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		if h.path != want {
+			t.Errorf("next() order = %s, want %s", h.path, want)
+		}
+		// next turn
+		s.release(h)
+		s.push(h)
+	}
+
+	hs := make([]*harvest, 0, len(files))
+	for _, want := range []string{"20", "30", "10"} {
+		h, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		if h.path != want {
+			t.Errorf("next() order = %s, want %s", h.path, want)
+		}
+		hs = append(hs, h)
+	}
+
+	// cancelled wait
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.next(cctx); err != errShutdown {
+		t.Errorf("next() with cancelled context error = %v, want %v", err, errShutdown)
+	}
+
+	// wait for ready file
+	got := make(chan *harvest, 1)
+	go func() {
+		h, _ := s.next(ctx)
+		got <- h
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.push(hs[1])
+	select {
+	case h := <-got:
+		if h != hs[1] {
+			t.Errorf("next() = %s, want %s", h.path, hs[1].path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next() not waked up")
+	}
+}
+
+func TestSchedulerDone(t *testing.T) {
+	s := newScheduler(ScheduleRoundRobin, 2)
+	ctx := context.Background()
+
+	for _, name := range []string{"1", "2"} {
+		s.add(&harvest{path: name, index: -1})
+	}
+	for i := 0; i < 2; i++ {
+		h, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		s.finish(h)
+	}
+	if _, err := s.next(ctx); err != io.EOF {
+		t.Errorf("next() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestSchedulerOpenLimit(t *testing.T) {
+	dir := t.TempDir()
+	s := newScheduler(ScheduleRoundRobin, 2)
+	ctx := context.Background()
+
+	hs := make([]*harvest, 3)
+	for i, name := range []string{"1", "2", "3"} {
+		fpath := path.Join(dir, name)
+		if err := os.WriteFile(fpath, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hs[i] = &harvest{path: fpath, index: -1}
+		s.add(hs[i])
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		h, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		s.reserve(h)
+		if h.fp, err = os.Open(h.path); err != nil {
+			t.Fatal(err)
+		}
+		h.turn = now.Add(time.Duration(i) * time.Second)
+		s.release(h)
+		if len(s.opened) > 2 {
+			t.Fatalf("opened = %d, want <= 2", len(s.opened))
+		}
+	}
+	// least recently harvested file closed
+	if hs[0].fp != nil || hs[0].opened {
+		t.Errorf("file %s not closed", hs[0].path)
+	}
+	for _, h := range hs[1:] {
+		if h.fp == nil || !h.opened {
+			t.Errorf("file %s closed", h.path)
+		}
+	}
+
+	// opened file stay opened for next turn
+	s.push(hs[1])
+	h, err := s.next(ctx)
+	if err != nil {
+		t.Fatalf("next() error = %v", err)
+	}
+	if h != hs[1] || h.fp == nil || !h.reserved || s.inTurn != 1 {
+		t.Errorf("next() = %s, opened %v, reserved %v, in turn %d", h.path, h.fp != nil, h.reserved, s.inTurn)
+	}
+	s.release(h)
+
+	s.closeAll()
+	for _, h := range hs {
+		if h.fp != nil {
+			t.Errorf("file %s not closed", h.path)
+		}
+	}
+}
@@ -36,6 +36,15 @@ func evalSymlinks(ctx context.Context, path string) (string, error) {
 	return filepath.EvalSymlinks(path)
 }
 
+// fileChanged check if file (closed by close_inactive) was changed (size changed or recreated)
+func fileChanged(fpath string, closed *fsutil.Fsnode) bool {
+	var fn fsutil.Fsnode
+	if err := fsutil.LStat(fpath, &fn); err != nil {
+		return false
+	}
+	return fsutil.Other(&fn, closed) || fn.Size != closed.Size
+}
+
 // openFile open (or reopen file, if truncated or recreated). Return *os.File, truncated, recreated, error
 func (in *File) openFile(fp *os.File, reader *lreader.Reader, fpath string, fnode *fsutil.Fsnode) (*os.File, bool, bool, error) {
 	var (
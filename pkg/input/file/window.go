@@ -0,0 +1,177 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fsutil"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+)
+
+var errWindowEnd = errors.New("read window end")
+
+// window is a read window (for read mode), timestamps checked with event timestamp (parsed by codec), offsets are in bytes.
+//
+// Range is [from, until), zero values are unbounded.
+type window struct {
+	from        time.Time
+	until       time.Time
+	fromOffset  int64
+	untilOffset int64
+	monotonic   bool // timestamps are monotonic in file, so binary search start and stop on until
+}
+
+func newWindow(cfg *Config) *window {
+	if cfg.ReadFrom.IsZero() && cfg.ReadUntil.IsZero() && cfg.ReadFromOffset == 0 && cfg.ReadUntilOffset == 0 {
+		return nil
+	}
+	return &window{
+		from:        cfg.ReadFrom,
+		until:       cfg.ReadUntil,
+		fromOffset:  cfg.ReadFromOffset,
+		untilOffset: cfg.ReadUntilOffset,
+		monotonic:   cfg.Monotonic,
+	}
+}
+
+// check return keep (event in window) and stop (no more events in window can be readed)
+func (w *window) check(e *event.Event) (keep, stop bool) {
+	if !w.from.IsZero() && e.Timestamp.Before(w.from) {
+		return false, false
+	}
+	if !w.until.IsZero() && !e.Timestamp.Before(w.until) {
+		return false, w.monotonic
+	}
+	return true, false
+}
+
+// start return file offset for start read (line start), offset is a current file offset (from seek db)
+func (w *window) start(fp *os.File, codec codec.Codec, offset int64, bufSize int) (int64, error) {
+	if offset >= w.fromOffset && (w.from.IsZero() || !w.monotonic) {
+		return offset, nil
+	}
+	buf := make([]byte, bufSize)
+	var err error
+	if w.fromOffset > offset {
+		if offset, err = lineStart(fp, w.fromOffset, buf); err != nil {
+			return 0, err
+		}
+	}
+	if w.from.IsZero() || !w.monotonic {
+		return offset, nil
+	}
+	size, err := fp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	hi := size
+	if w.untilOffset > 0 && w.untilOffset < hi {
+		hi = w.untilOffset
+	}
+	return searchFrom(fp, codec, offset, hi, w.from, buf)
+}
+
+// searchFrom do binary search for line start, before first line with timestamp >= from (timestamps must be monotonic)
+//
+// lo must be a line start. Result is not exact, lines before from can be readed and must be filtered.
+func searchFrom(fp *os.File, codec codec.Codec, lo, hi int64, from time.Time, buf []byte) (int64, error) {
+	for hi-lo > int64(len(buf)) {
+		mid := lo + (hi-lo)/2
+		start, err := lineStart(fp, mid, buf)
+		if err != nil {
+			return lo, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+		ts, ok, err := lineTime(fp, codec, start, hi, buf)
+		if err != nil {
+			return lo, err
+		}
+		if ok && ts.Before(from) {
+			lo = start
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// lineStart return offset of first line start at or after offset
+func lineStart(fp *os.File, offset int64, buf []byte) (int64, error) {
+	if offset <= 0 {
+		return 0, nil
+	}
+	// check from previous byte, offset can be a line start
+	pos := offset - 1
+	for {
+		n, err := fp.ReadAt(buf, pos)
+		if i := bytes.IndexByte(buf[:n], '\n'); i != -1 {
+			return pos + int64(i) + 1, nil
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			return pos, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// lineTime return timestamp of first parsed line (limited by buf size) in [start, hi)
+func lineTime(fp *os.File, codec codec.Codec, start, hi int64, buf []byte) (time.Time, bool, error) {
+	n, err := fp.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return time.Time{}, false, err
+	}
+	if max := hi - start; int64(n) > max {
+		n = int(max)
+	}
+	data := buf[:n]
+	ts := timeutil.Now()
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n')
+		if end == -1 {
+			break
+		}
+		e, err := codec.Parse(ts, data[:end+1])
+		data = data[end+1:]
+		if err == nil && e != nil {
+			t := e.Timestamp
+			event.Put(e)
+			return t, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// windowStart set fnode offset to read window start (if offset from seek db is lower)
+func (in *File) windowStart(fpath string, fnode *fsutil.Fsnode, codec codec.Codec) error {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var fn fsutil.Fsnode
+	if err = fsutil.FStat(fp, &fn); err != nil {
+		return err
+	}
+	offset := fnode.Size
+	if fsutil.Other(&fn, fnode) || fn.Size < offset {
+		// no record in seek db or file is recreated/truncated
+		offset = 0
+	}
+	if offset, err = in.window.start(fp, codec, offset, int(in.cfg.ReadBuffer.Value())); err != nil {
+		return err
+	}
+	*fnode = fn
+	fnode.Size = offset
+	return nil
+}
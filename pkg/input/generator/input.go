@@ -0,0 +1,259 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "generator"
+
+// Path is a path field value for template generated events
+const Path = "generator"
+
+type Config struct {
+	input.Config
+
+	Message        string        `hcl:"message" yaml:"message" json:"message"`                         // message template (with %{seq}, %{timestamp} and %{host} placeholders)
+	SampleFile     string        `hcl:"sample_file" yaml:"sample_file" json:"sample_file"`             // replay lines from sample file (instead of message template)
+	Rate           float64       `hcl:"rate" yaml:"rate" json:"rate"`                                  // events per second (0 - as fast as possible)
+	Count          int64         `hcl:"count" yaml:"count" json:"count"`                               // sended events count (0 - unlimited)
+	Duration       time.Duration `hcl:"duration" yaml:"duration" json:"duration"`                      // generation duration (0 - unlimited)
+	ReportInterval time.Duration `hcl:"report_interval" yaml:"report_interval" json:"report_interval"` // throughput report interval (0 - only on finish)
+	Codec          string        `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - line)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:         input.Config{Type: Name},
+		Message:        "%{seq} generated test message",
+		ReportInterval: 10 * time.Second,
+	}
+}
+
+// Generator is a synthetic events generator for load testing.
+//
+// Messages are generated from template or replayed (cycled) from sample file and parsed with codec.
+// Sequence number added to event fields (seq). Achieved throughput is reported to log.
+type Generator struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	tpl     stringutils.Template
+	samples [][]byte
+	path    string
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &Generator{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+		path:   Path,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.Rate < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': rate must be >= 0")
+	}
+	if in.cfg.Count < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': count must be >= 0")
+	}
+	if in.cfg.Duration < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': duration must be >= 0")
+	}
+	if in.cfg.ReportInterval < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': report_interval must be >= 0")
+	}
+
+	var err error
+	if in.cfg.SampleFile == "" {
+		if in.cfg.Message == "" {
+			return nil, errors.New("input '" + in.cfg.Type + "': message not set")
+		}
+		if in.tpl, err = stringutils.InitTemplate(in.cfg.Message); err != nil {
+			return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' message")
+		}
+	} else {
+		in.path = in.cfg.SampleFile
+		if in.samples, err = readSamples(in.cfg.SampleFile); err != nil {
+			return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"'")
+		}
+		if len(in.samples) == 0 {
+			return nil, errors.New("input '" + in.cfg.Type + "': sample_file is empty")
+		}
+	}
+
+	// Check codec config
+	c, err := codec.New(in.cfgRaw, in.common, in.path)
+	if err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"'")
+	}
+	// Check messages, not parsed messages are never sended
+	if in.samples == nil {
+		params := map[string]interface{}{"host": in.common.Hostname, "seq": int64(1), "timestamp": timeutil.Now().String()}
+		s, _ := in.tpl.ExecutePartial(params)
+		if err = checkParse(c, append([]byte(s), '\n')); err != nil {
+			return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' message not parsed with codec '"+c.Name()+"'")
+		}
+	} else {
+		for i, line := range in.samples {
+			if err = checkParse(c, line); err != nil {
+				return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' sample "+strconv.Itoa(i+1)+" not parsed with codec '"+c.Name()+"'")
+			}
+		}
+	}
+
+	return in, nil
+}
+
+func checkParse(c codec.Codec, line []byte) error {
+	e, err := c.Parse(timeutil.Now(), line)
+	if e != nil {
+		event.Put(e)
+	}
+	return err
+}
+
+// readSamples read non-empty lines (with '\n' at end) from sample file
+func readSamples(fpath string) ([][]byte, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimRight(scanner.Bytes(), "\r")
+		if len(line) > 0 {
+			samples = append(samples, append(append(make([]byte, 0, len(line)+1), line...), '\n'))
+		}
+	}
+	return samples, scanner.Err()
+}
+
+func (in *Generator) Name() string {
+	return Name
+}
+
+func (in *Generator) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("path", in.path).Logger()
+
+	codec, err := codec.New(in.cfgRaw, in.common, in.path)
+	if err != nil {
+		logger.Error().Str("codec", in.cfg.Codec).Err(err).Msg("codec init failed")
+		return err
+	}
+
+	var (
+		seq    int64 // generated lines
+		sent   int64 // sended events
+		line   []byte
+		params = map[string]interface{}{"host": in.common.Hostname}
+	)
+	start := time.Now()
+	lastReport := start
+	var lastSent int64
+	for ctx.Err() == nil {
+		if in.cfg.Count > 0 && sent >= in.cfg.Count {
+			break
+		}
+		now := time.Now()
+		if in.cfg.Duration > 0 && now.Sub(start) >= in.cfg.Duration {
+			break
+		}
+		if in.cfg.ReportInterval > 0 && now.Sub(lastReport) >= in.cfg.ReportInterval {
+			report(logger, "throughput", sent-lastSent, now.Sub(lastReport))
+			lastReport = now
+			lastSent = sent
+		}
+		if in.cfg.Rate > 0 {
+			// wait for next event time
+			next := start.Add(time.Duration(float64(seq) / in.cfg.Rate * float64(time.Second)))
+			if d := next.Sub(now); d > time.Millisecond {
+				if !sleep(ctx, d) {
+					break
+				}
+			}
+		}
+
+		seq++
+		ts := timeutil.Now()
+		if in.samples == nil {
+			params["seq"] = seq
+			params["timestamp"] = ts.String()
+			s, _ := in.tpl.ExecutePartial(params)
+			line = append(append(line[:0], s...), '\n')
+		} else {
+			line = in.samples[(seq-1)%int64(len(in.samples))]
+		}
+
+		e, err := codec.Parse(ts, line)
+		if err != nil {
+			logger.Debug().Str("text", stringutils.UnsafeString(line)).Err(err).Msg("parse")
+			continue
+		}
+		if e == nil {
+			continue
+		}
+		e.Fields["seq"] = seq
+		if zerolog.GlobalLevel() == zerolog.TraceLevel {
+			logger.Trace().Str("text", stringutils.UnsafeString(line)).Str("event", event.String(e)).Msg("parse")
+		}
+		select {
+		case outChan <- e:
+			sent++
+		case <-ctx.Done():
+			event.Put(e)
+		}
+	}
+
+	report(logger, "total", sent, time.Since(start))
+	if ctx.Err() == nil {
+		logger.Info().Msg("done")
+	} else {
+		logger.Info().Msg("shutdown")
+	}
+	return nil
+}
+
+func report(logger zerolog.Logger, msg string, count int64, d time.Duration) {
+	var rate float64
+	if d > 0 {
+		rate = float64(count) / d.Seconds()
+	}
+	logger.Info().Int64("events", count).Dur("duration", d).Float64("rate", rate).Msg(msg)
+}
+
+// sleep return false on shutdown
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
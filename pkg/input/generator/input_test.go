@@ -0,0 +1,204 @@
+package generator_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func run(t *testing.T, cfg config.ConfigRaw) []*event.Event {
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	fchan := make(chan *event.Event, 100)
+	if err = in.Start(context.Background(), fchan); err != nil {
+		t.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+
+	var events []*event.Event
+	for e := range fchan {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestGenerator(t *testing.T) {
+	events := run(t, config.ConfigRaw{"type": "generator", "message": "%{seq} %{host} test", "count": 3})
+
+	wantEvents := make([]*event.Event, 0, 3)
+	for i := 1; i <= 3; i++ {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{
+				"name": "line", "host": "localhost", "message": strconv.Itoa(i) + " localhost test", "type": "generator",
+				"path": "generator", "seq": int64(i),
+			},
+			Tags: map[string]int{},
+		})
+	}
+	if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+}
+
+func TestGeneratorSampleFile(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	path := filepath.Join(testDir, "sample.log")
+	if err = os.WriteFile(path, []byte("test 1\n\ntest 2\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	events := run(t, config.ConfigRaw{"type": "generator", "sample_file": path, "count": 3})
+
+	wantEvents := make([]*event.Event, 0, 3)
+	for i, message := range []string{"test 1", "test 2", "test 1"} {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{
+				"name": "line", "host": "localhost", "message": message, "type": "generator",
+				"path": path, "seq": int64(i + 1),
+			},
+			Tags: map[string]int{},
+		})
+	}
+	if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+}
+
+func TestGeneratorNotParsed(t *testing.T) {
+	testDir := t.TempDir()
+
+	path := filepath.Join(testDir, "sample.log")
+	if err := os.WriteFile(path, []byte("{\"message\":\"test 1\"}\n{invalid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr string
+	}{
+		{
+			name:    "default message with json codec",
+			cfg:     config.ConfigRaw{"type": "generator", "codec": "json"},
+			wantErr: "input 'generator' message not parsed with codec 'json'",
+		},
+		{
+			name:    "invalid sample",
+			cfg:     config.ConfigRaw{"type": "generator", "sample_file": path, "codec": "json"},
+			wantErr: "input 'generator' sample 2 not parsed with codec 'json'",
+		},
+		{
+			name: "json message",
+			cfg:  config.ConfigRaw{"type": "generator", "message": "{\"seq\":%{seq}}", "codec": "json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := input.New(&tt.cfg, &config.Common{Hostname: "localhost"})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGeneratorShutdown(t *testing.T) {
+	in, err := input.New(&config.ConfigRaw{"type": "generator"}, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		// unlimited count and rate, stopped only on shutdown
+		errCh <- in.Start(ctx, make(chan *event.Event, 100))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err = <-errCh:
+		if err != nil {
+			t.Errorf("in.Start() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("in.Start() not stopped on shutdown")
+	}
+}
+
+func TestGeneratorRate(t *testing.T) {
+	start := time.Now()
+	events := run(t, config.ConfigRaw{"type": "generator", "rate": 200, "duration": 200 * time.Millisecond})
+	d := time.Since(start)
+	if d < 200*time.Millisecond || d > time.Second {
+		t.Errorf("duration = %s, want ~200ms", d)
+	}
+	if len(events) < 30 || len(events) > 45 {
+		t.Errorf("events count = %d, want ~40", len(events))
+	}
+	event.PutSlice(events)
+}
+
+func BenchmarkGenerator(b *testing.B) {
+	cfg := config.ConfigRaw{"type": "generator", "count": b.N, "report_interval": 0}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	fchan := make(chan *event.Event, 1024)
+	done := make(chan int)
+	go func() {
+		n := 0
+		for e := range fchan {
+			n++
+			event.Put(e)
+		}
+		done <- n
+	}()
+
+	b.ResetTimer()
+	if err = in.Start(context.Background(), fchan); err != nil {
+		b.Fatalf("in.Start() error = %v", err)
+	}
+	close(fchan)
+	if n := <-done; n != b.N {
+		b.Fatalf("events count = %d, want %d", n, b.N)
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
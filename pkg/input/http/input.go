@@ -0,0 +1,354 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/json-iterator/go"
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "http"
+
+var errBodyTooLarge = errors.New("request body too large")
+
+type Config struct {
+	input.Config
+
+	Listen      string        `hcl:"listen" yaml:"listen" json:"listen"`                      // listen address
+	Path        string        `hcl:"path" yaml:"path" json:"path"`                            // url path (default /)
+	MaxBodySize config.Size   `hcl:"max_body_size" yaml:"max_body_size" json:"max_body_size"` // max request body size (also after decompression)
+	ReadTimeout time.Duration `hcl:"read_timeout" yaml:"read_timeout" json:"read_timeout"`    // request read timeout
+	SendTimeout time.Duration `hcl:"send_timeout" yaml:"send_timeout" json:"send_timeout"`    // max wait for event send to pipeline queue, request rejected with 429 after it
+	Username    string        `hcl:"username" yaml:"username" json:"username"`                // basic auth username (auth disabled if empty)
+	Password    string        `hcl:"password" yaml:"password" json:"password"`                // basic auth password
+	Codec       string        `hcl:"codec" yaml:"codec" json:"codec"`                         // codec name (deefault - line)
+	TLS         config.TLS    `hcl:"tls" yaml:"tls" json:"tls"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:      input.Config{Type: Name},
+		Path:        "/",
+		MaxBodySize: config.Size(10 * 1024 * 1024),
+		ReadTimeout: time.Minute,
+		SendTimeout: 100 * time.Millisecond,
+	}
+}
+
+// HTTP is http ingest input. POST body is a new line delimited messages (plain text lines or NDJSON) or JSON array.
+//
+// Every line (or array element) parsed with codec (string array elements are unquoted).
+// If pipeline queue is full (for send_timeout), request rejected with 429 (Too Many Requests) status,
+// already queued events count returned in X-Events-Queued header (client can skip them on retry).
+// Gzip request body (Content-Encoding: gzip) is supported.
+// Peer address added to event fields (peer_addr, peer_port).
+type HTTP struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	tlsConfig *tls.Config
+	path      string
+	codecs    sync.Pool // codecs can be non thread-safe
+	bufs      sync.Pool
+	outChan   chan<- *event.Event
+	logger    zerolog.Logger
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &HTTP{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.Listen == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': listen not set")
+	}
+
+	if !strings.HasPrefix(in.cfg.Path, "/") {
+		return nil, errors.New("input '" + in.cfg.Type + "': path must be started with /")
+	}
+
+	if in.cfg.MaxBodySize.Value() < 1 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_body_size must be > 0")
+	}
+
+	if in.cfg.ReadTimeout < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_timeout must be >= 0")
+	}
+
+	if in.cfg.SendTimeout < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': send_timeout must be >= 0")
+	}
+
+	var err error
+	if in.tlsConfig, err = in.cfg.TLS.ServerConfig(); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' listen='"+in.cfg.Listen+"'")
+	}
+
+	if in.tlsConfig == nil {
+		in.path = "http://" + in.cfg.Listen + in.cfg.Path
+	} else {
+		in.path = "https://" + in.cfg.Listen + in.cfg.Path
+	}
+
+	// Check codec config
+	if _, err = codec.New(in.cfgRaw, in.common, in.path); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' listen='"+in.cfg.Listen+"'")
+	}
+
+	in.codecs.New = func() interface{} {
+		// config already checked
+		c, _ := codec.New(in.cfgRaw, in.common, in.path)
+		return c
+	}
+	in.bufs.New = func() interface{} {
+		return new(bytes.Buffer)
+	}
+
+	return in, nil
+}
+
+func (in *HTTP) Name() string {
+	return Name
+}
+
+func (in *HTTP) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	in.outChan = outChan
+	in.logger = log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Logger()
+
+	ln, err := net.Listen("tcp", in.cfg.Listen)
+	if err != nil {
+		in.logger.Error().Err(err).Msg("listen failed")
+		return err
+	}
+	if in.tlsConfig != nil {
+		ln = tls.NewListener(ln, in.tlsConfig)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(in.cfg.Path, in)
+	srv := &http.Server{
+		Handler:     mux,
+		ReadTimeout: in.cfg.ReadTimeout,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err = srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		in.logger.Error().Err(err).Msg("serve failed")
+		return err
+	}
+
+	in.logger.Info().Msg("shutdown")
+	return nil
+}
+
+func (in *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if in.cfg.Username != "" && !in.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="log-exporter"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	buf := in.bufs.Get().(*bytes.Buffer)
+	defer in.bufs.Put(buf)
+	buf.Reset()
+
+	if err := in.readBody(w, r, buf); err != nil {
+		if err == errBodyTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	c := in.codecs.Get().(codec.Codec)
+	events, err := in.parse(buf.Bytes(), c, peerFields(r.RemoteAddr))
+	in.codecs.Put(c)
+	if err != nil {
+		event.PutSlice(events)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var t *time.Timer
+	for i, e := range events {
+		select {
+		case in.outChan <- e:
+			continue
+		default:
+		}
+		if t == nil {
+			t = time.NewTimer(in.cfg.SendTimeout)
+			defer t.Stop()
+		} else {
+			timeutil.TimerReset(t, in.cfg.SendTimeout)
+		}
+		select {
+		case in.outChan <- e:
+		case <-t.C:
+			// backpressure, client must retry later
+			event.PutSlice(events[i:])
+			queued := strconv.Itoa(i)
+			in.logger.Warn().Str("peer", r.RemoteAddr).Int("queued", i).Int("events", len(events)).Msg("pipeline queue is full")
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("X-Events-Queued", queued)
+			http.Error(w, "pipeline queue is full, "+queued+" of "+strconv.Itoa(len(events))+" events queued", http.StatusTooManyRequests)
+			return
+		case <-r.Context().Done():
+			event.PutSlice(events[i:])
+			http.Error(w, "shutdown", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (in *HTTP) authorized(r *http.Request) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOk := subtle.ConstantTimeCompare([]byte(username), []byte(in.cfg.Username)) == 1
+	passOk := subtle.ConstantTimeCompare([]byte(password), []byte(in.cfg.Password)) == 1
+	return userOk && passOk
+}
+
+// readBody read request body (decompressed, if needed) with size limit
+func (in *HTTP) readBody(w http.ResponseWriter, r *http.Request, buf *bytes.Buffer) error {
+	maxSize := in.cfg.MaxBodySize.Value()
+	if r.ContentLength > maxSize {
+		return errBodyTooLarge
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxSize)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		return errors.New("unsupported content encoding")
+	}
+	n, err := buf.ReadFrom(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return errBodyTooLarge
+		}
+		return err
+	}
+	if n > maxSize {
+		return errBodyTooLarge
+	}
+	return nil
+}
+
+// parse split body to messages (lines or JSON array elements) and parse it with codec
+func (in *HTTP) parse(data []byte, c codec.Codec, fields map[string]interface{}) ([]*event.Event, error) {
+	var (
+		events []*event.Event
+		line   []byte
+	)
+	ts := timeutil.Now()
+
+	parseLine := func(line []byte) {
+		e, err := c.Parse(ts, line)
+		if err != nil {
+			if err != codec.ErrEmpty {
+				in.logger.Debug().Str("text", stringutils.UnsafeString(line)).Err(err).Msg("parse")
+			}
+			return
+		}
+		if e == nil {
+			return
+		}
+		for k, v := range fields {
+			e.Fields[k] = v
+		}
+		if zerolog.GlobalLevel() == zerolog.TraceLevel {
+			in.logger.Trace().Str("text", stringutils.UnsafeString(line)).Str("event", event.String(e)).Msg("parse")
+		}
+		events = append(events, e)
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		// JSON array
+		var elements []json.RawMessage
+		if err := json.Unmarshal(trimmed, &elements); err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			if len(element) > 0 && element[0] == '"' {
+				var s string
+				if err := json.Unmarshal(element, &s); err != nil {
+					return events, err
+				}
+				line = append(append(line[:0], s...), '\n')
+			} else {
+				line = append(append(line[:0], element...), '\n')
+			}
+			parseLine(line)
+		}
+		return events, nil
+	}
+
+	for len(data) > 0 {
+		if n := bytes.IndexByte(data, '\n'); n == -1 {
+			line = append(data[:len(data):len(data)], '\n')
+			data = nil
+		} else {
+			line = data[:n+1]
+			data = data[n+1:]
+		}
+		parseLine(line)
+	}
+	return events, nil
+}
+
+func peerFields(remoteAddr string) map[string]interface{} {
+	host, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return map[string]interface{}{"peer_addr": remoteAddr}
+	}
+	return map[string]interface{}{"peer_addr": host, "peer_port": port}
+}
@@ -0,0 +1,304 @@
+package http_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+type server struct {
+	url    string
+	fchan  chan *event.Event
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	err    error
+}
+
+func startServer(t *testing.T, cfg config.ConfigRaw, queueSize int) *server {
+	addr, err := test.FreeAddr("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg["type"] = "http"
+	cfg["listen"] = addr
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	var ctx context.Context
+	s := &server{url: "http://" + addr + "/", fchan: make(chan *event.Event, queueSize)}
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.err = in.Start(ctx, s.fchan)
+		close(s.fchan)
+	}()
+
+	for i := 0; i < 20; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (s *server) stop(t *testing.T) {
+	s.cancel()
+	s.wg.Wait()
+	if s.err != nil {
+		t.Fatalf("in.Start() error = %v", s.err)
+	}
+}
+
+func gzipBody(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func post(t *testing.T, url string, body []byte, header map[string]string, auth []string) int {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if len(auth) == 2 {
+		req.SetBasicAuth(auth[0], auth[1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestHTTP(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        config.ConfigRaw
+		body       []byte
+		header     map[string]string
+		auth       []string
+		wantStatus int
+		want       []map[string]interface{}
+	}{
+		{
+			name:       "lines",
+			cfg:        config.ConfigRaw{},
+			body:       []byte("test 1\ntest 2\n\ntest 3"),
+			wantStatus: http.StatusOK,
+			want:       []map[string]interface{}{{"message": "test 1"}, {"message": "test 2"}, {"message": "test 3"}},
+		},
+		{
+			name:       "strings array",
+			cfg:        config.ConfigRaw{},
+			body:       []byte(` ["test 1", "test\t2"]`),
+			wantStatus: http.StatusOK,
+			want:       []map[string]interface{}{{"message": "test 1"}, {"message": "test\t2"}},
+		},
+		{
+			name:       "ndjson gzip",
+			cfg:        config.ConfigRaw{"codec": "json"},
+			body:       gzipBody(t, "{\"message\": \"test 1\", \"level\": \"info\"}\n{\"message\": \"test 2\"}\n"),
+			header:     map[string]string{"Content-Encoding": "gzip"},
+			wantStatus: http.StatusOK,
+			want:       []map[string]interface{}{{"message": "test 1", "level": "info"}, {"message": "test 2"}},
+		},
+		{
+			name:       "json array",
+			cfg:        config.ConfigRaw{"codec": "json"},
+			body:       []byte(`[{"message": "test 1"}, {"message": "test 2", "count": 1}]`),
+			wantStatus: http.StatusOK,
+			want:       []map[string]interface{}{{"message": "test 1"}, {"message": "test 2", "count": float64(1)}},
+		},
+		{
+			name:       "invalid json array",
+			cfg:        config.ConfigRaw{"codec": "json"},
+			body:       []byte(`[{"message": "test 1"}`),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "basic auth",
+			cfg:        config.ConfigRaw{"username": "user", "password": "secret"},
+			body:       []byte("test 1\n"),
+			auth:       []string{"user", "secret"},
+			wantStatus: http.StatusOK,
+			want:       []map[string]interface{}{{"message": "test 1"}},
+		},
+		{
+			name:       "basic auth invalid",
+			cfg:        config.ConfigRaw{"username": "user", "password": "secret"},
+			body:       []byte("test 1\n"),
+			auth:       []string{"user", "invalid"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "basic auth not set",
+			cfg:        config.ConfigRaw{"username": "user", "password": "secret"},
+			body:       []byte("test 1\n"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "body too large",
+			cfg:        config.ConfigRaw{"max_body_size": "16"},
+			body:       []byte("test 1\ntest 2\ntest 3\n"),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "decompressed body too large",
+			cfg:        config.ConfigRaw{"max_body_size": "64"},
+			body:       gzipBody(t, strings.Repeat("test\n", 100)),
+			header:     map[string]string{"Content-Encoding": "gzip"},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := startServer(t, tt.cfg, 10)
+			defer s.stop(t)
+
+			if status := post(t, s.url, tt.body, tt.header, tt.auth); status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+
+			wantEvents := make([]*event.Event, 0, len(tt.want))
+			for _, fields := range tt.want {
+				fields["type"] = "http"
+				fields["host"] = "localhost"
+				fields["peer_addr"] = "127.0.0.1"
+				fields["path"] = s.url
+				if tt.cfg["codec"] == "json" {
+					fields["name"] = "json"
+				} else {
+					fields["name"] = "line"
+				}
+				wantEvents = append(wantEvents, &event.Event{Fields: fields, Tags: map[string]int{}})
+			}
+			events := test.EventsFromChannel(s.fchan, 50*time.Millisecond)
+			for _, e := range events {
+				delete(e.Fields, "peer_port")
+			}
+			if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+				t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+			}
+			// put to pool for reuse
+			event.PutSlice(events)
+		})
+	}
+}
+
+func TestHTTPBackpressure(t *testing.T) {
+	s := startServer(t, config.ConfigRaw{"path": "/ingest"}, 1)
+	defer s.stop(t)
+
+	if status := post(t, s.url, []byte("test 1\n"), nil, nil); status != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	resp, err := http.Get(s.url + "ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	if status := post(t, s.url+"ingest", []byte("test 1\n"), nil, nil); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	// queue is full
+	if status := post(t, s.url+"ingest", []byte("test 2\n"), nil, nil); status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", status, http.StatusTooManyRequests)
+	}
+	event.Put(<-s.fchan)
+	if status := post(t, s.url+"ingest", []byte("test 3\n"), nil, nil); status != http.StatusOK {
+		t.Fatalf("status = %d, want %d", status, http.StatusOK)
+	}
+	event.Put(<-s.fchan)
+
+	// partially queued
+	resp, err = http.Post(s.url+"ingest", "text/plain", strings.NewReader("test 4\ntest 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusTooManyRequests)
+	}
+	if queued := resp.Header.Get("X-Events-Queued"); queued != "1" {
+		t.Errorf("X-Events-Queued = %q, want %q", queued, "1")
+	}
+	if e := <-s.fchan; e.Fields["message"] != "test 4" {
+		t.Errorf("queued event = %s, want test 4", event.String(e))
+	}
+}
+
+func TestHTTPUnbuffered(t *testing.T) {
+	s := startServer(t, config.ConfigRaw{}, 0)
+
+	done := make(chan int)
+	go func() {
+		n := 0
+		for e := range s.fchan {
+			event.Put(e)
+			n++
+		}
+		done <- n
+	}()
+
+	for i := 0; i < 10; i++ {
+		if status := post(t, s.url, []byte("test 1\ntest 2\n"), nil, nil); status != http.StatusOK {
+			t.Fatalf("status = %d, want %d", status, http.StatusOK)
+		}
+	}
+	s.stop(t)
+	if n := <-done; n != 20 {
+		t.Errorf("events = %d, want 20", n)
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
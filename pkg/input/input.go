@@ -6,6 +6,7 @@ import (
 
 	"github.com/msaf1980/log-exporter/pkg/config"
 	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
 )
 
 type Input interface {
@@ -13,8 +14,26 @@ type Input interface {
 	Start(ctx context.Context, outChan chan<- *event.Event) error
 }
 
+// Seeking is an input with files state in seek db. Seek stores are owned by pipeline,
+// input namespace is registered in store (shared by inputs with same seek file) before Start.
+type Seeking interface {
+	// Seek return seek file and input namespace options (empty path, if seek db is not used)
+	Seek() (string, fstatdb.Options)
+	// SetSeeker set input namespace in seek store
+	SetSeeker(db fstatdb.Seeker)
+}
+
+// Acking is an input, which can wait for events acknowledges from outputs before commit state.
+type Acking interface {
+	// WaitAck return true, if input wait for acknowledges
+	WaitAck() bool
+}
+
 type Config struct {
 	Type string `hcl:"type" yaml:"type"` // input type (from inputs map)
+	// input id (for inputs state, like seek db), must be unique for inputs with same seek file.
+	// If not set, type is used for first input of type and type with input index (like file-2) for next
+	ID string `hcl:"id" yaml:"id"`
 }
 
 type InputFn func(*config.ConfigRaw, *config.Common) (Input, error)
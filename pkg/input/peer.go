@@ -0,0 +1,40 @@
+package input
+
+import (
+	"net"
+	"time"
+)
+
+// PeerFields return peer address fields (peer_addr and peer_port) for network inputs
+func PeerFields(addr net.Addr) map[string]interface{} {
+	if addr == nil {
+		return nil
+	}
+	if uaddr, ok := addr.(*net.UnixAddr); ok {
+		// unix socket peer is usually unnamed
+		if uaddr == nil || uaddr.Name == "" {
+			return nil
+		}
+		return map[string]interface{}{"peer_addr": uaddr.Name}
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return map[string]interface{}{"peer_addr": addr.String()}
+	}
+	return map[string]interface{}{"peer_addr": host, "peer_port": port}
+}
+
+// DeadlineReader set read deadline before every read (if Timeout > 0)
+type DeadlineReader struct {
+	Conn    net.Conn
+	Timeout time.Duration
+}
+
+func (r DeadlineReader) Read(b []byte) (int, error) {
+	if r.Timeout > 0 {
+		if err := r.Conn.SetReadDeadline(time.Now().Add(r.Timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return r.Conn.Read(b)
+}
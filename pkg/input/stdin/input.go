@@ -0,0 +1,143 @@
+package stdin
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "stdin"
+
+// Path is a path field value for stdin events
+const Path = "-"
+
+type Config struct {
+	input.Config
+
+	ReadBuffer    config.Size `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"`             // read buffer size
+	MaxReadBuffer config.Size `hcl:"max_read_buffer" yaml:"max_read_buffer" json:"max_read_buffer"` // max read buffer size (for long lines)
+	Codec         string      `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - line)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:        input.Config{Type: Name},
+		ReadBuffer:    config.Size(64 * 1024),
+		MaxReadBuffer: config.Size(1024 * 1024),
+	}
+}
+
+// Stdin is stdin input reader (for use in shell pipelines).
+//
+// Input is completed on EOF, incomplete last line is also parsed.
+// For pipes and sockets blocked read is interrupted on shutdown (stdin is switched to non-blocking mode while read,
+// original mode restored on exit), for terminal read is completed after next line.
+type Stdin struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	r io.Reader // if not set, stdin is used
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &Stdin{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.ReadBuffer.Value() < 1 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_buffer must be > 0")
+	}
+
+	if in.cfg.MaxReadBuffer.Value() < in.cfg.ReadBuffer.Value() {
+		in.cfg.MaxReadBuffer = in.cfg.ReadBuffer
+	}
+
+	// Check codec config
+	_, err := codec.New(in.cfgRaw, in.common, Path)
+	if err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"'")
+	}
+
+	return in, nil
+}
+
+// stdinReader return stdin with read deadline support for pipes and sockets
+// (os.Stdin is in blocking mode, so read can't be interrupted). Non-blocking mode is set on duplicated fd
+// (for not close fd 0), but file status flags are shared with parent shell, so restore func must be called after read.
+func stdinReader() (io.Reader, func()) {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&(os.ModeNamedPipe|os.ModeSocket) == 0 {
+		return os.Stdin, func() {}
+	}
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(syscall.Stdin), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return os.Stdin, func() {}
+	}
+	fd, err := syscall.Dup(syscall.Stdin)
+	if err != nil {
+		return os.Stdin, func() {}
+	}
+	if err = syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return os.Stdin, func() {}
+	}
+	// non-blocking file is added to runtime poller
+	f := os.NewFile(uintptr(fd), "/dev/stdin")
+	return f, func() {
+		f.Close()
+		if flags&syscall.O_NONBLOCK == 0 {
+			_ = syscall.SetNonblock(syscall.Stdin, false)
+		}
+	}
+}
+
+func (in *Stdin) Name() string {
+	return Name
+}
+
+func (in *Stdin) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	codec, err := codec.New(in.cfgRaw, in.common, Path)
+	if err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("codec", in.cfg.Codec).Err(err).Msg("codec init failed")
+		return err
+	}
+
+	r := in.r
+	if r == nil {
+		var restore func()
+		r, restore = stdinReader()
+		defer restore()
+	}
+
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Logger()
+	reader := lreader.New(r, int(in.cfg.ReadBuffer.Value()))
+
+	err = input.ReadLines(ctx, r, reader, codec, int(in.cfg.MaxReadBuffer.Value()), outChan, logger)
+	switch err {
+	case nil:
+		logger.Info().Msg("read ended on EOF")
+	case context.Canceled, context.DeadlineExceeded:
+		logger.Info().Msg("shutdown")
+	default:
+		logger.Error().Err(err).Msg("read failed")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package stdin
+
+import (
+	"io"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+)
+
+func (in *Stdin) Cfg() *Config {
+	return &in.cfg
+}
+
+func (in *Stdin) Common() *config.Common {
+	return in.common
+}
+
+func (in *Stdin) SetReader(r io.Reader) {
+	in.r = r
+}
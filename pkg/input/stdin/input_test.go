@@ -0,0 +1,132 @@
+package stdin_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/input/stdin"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestStdin(t *testing.T) {
+	longLine := test.String(100)
+	tests := []struct {
+		name  string
+		cfg   config.ConfigRaw
+		data  string
+		lines []string
+	}{
+		{
+			name:  "lines",
+			cfg:   config.ConfigRaw{"type": "stdin"},
+			data:  "test 1\ntest 2\n\ntest 3\n",
+			lines: []string{"test 1", "test 2", "test 3"},
+		},
+		{
+			name:  "incomplete last line",
+			cfg:   config.ConfigRaw{"type": "stdin"},
+			data:  "test 1\ntest 2",
+			lines: []string{"test 1", "test 2"},
+		},
+		{
+			name:  "grow buffer",
+			cfg:   config.ConfigRaw{"type": "stdin", "read_buffer": "16", "max_read_buffer": "256"},
+			data:  "test 1\n" + longLine + "\ntest 2\n",
+			lines: []string{"test 1", longLine, "test 2"},
+		},
+	}
+	common := &config.Common{Hostname: "localhost"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := input.New(&tt.cfg, common)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			in.(*stdin.Stdin).SetReader(strings.NewReader(tt.data))
+
+			fchan := make(chan *event.Event, len(tt.lines)+1)
+			if err = in.Start(context.Background(), fchan); err != nil {
+				t.Fatalf("in.Start() error = %v", err)
+			}
+			close(fchan)
+
+			wantEvents := make([]*event.Event, 0, len(tt.lines))
+			for _, line := range tt.lines {
+				wantEvents = append(wantEvents, &event.Event{
+					Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": line, "path": stdin.Path, "type": stdin.Name},
+					Tags:   map[string]int{},
+				})
+			}
+			events := test.EventsFromChannel(fchan, 10*time.Millisecond)
+			if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+				t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+			}
+			// put to pool for reuse
+			event.PutSlice(events)
+		})
+	}
+}
+
+func TestStdinShutdown(t *testing.T) {
+	cfg := config.ConfigRaw{"type": "stdin"}
+	in, err := input.New(&cfg, &config.Common{Hostname: "localhost"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	// pipe without writes, read is blocked until shutdown
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	in.(*stdin.Stdin).SetReader(r)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- in.Start(ctx, fchan)
+	}()
+	if _, err = w.WriteString("test 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-fchan:
+		event.Put(e)
+	case <-time.After(time.Second):
+		t.Fatal("event not readed")
+	}
+
+	cancel()
+	select {
+	case err = <-result:
+		if err != nil {
+			t.Errorf("in.Start() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not interrupted on shutdown")
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
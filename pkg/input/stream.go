@@ -0,0 +1,235 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/msaf1980/go-stringutils"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/msaf1980/log-exporter/pkg/timeutil"
+	"github.com/rs/zerolog"
+)
+
+var ErrInvalidFrame = errors.New("invalid octet counted frame")
+
+type Framing int8
+
+const (
+	FramingLF Framing = iota
+	FramingOctet
+	FramingAuto
+)
+
+var framingStrings []string = []string{"lf", "octet_counted", "auto"}
+
+func (f *Framing) Set(value string) error {
+	switch value {
+	case "lf", "":
+		*f = FramingLF
+	case "octet_counted":
+		*f = FramingOctet
+	case "auto":
+		*f = FramingAuto
+	default:
+		return fmt.Errorf("invalid framing %s", value)
+	}
+	return nil
+}
+
+func (f *Framing) String() string {
+	return framingStrings[*f]
+}
+
+func (f *Framing) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+// StreamReader read framed messages from stream, parse it with codec and send events to outChan.
+//
+// Framing is new line delimited (lf), octet counted (RFC6587, "LEN SP MSG") or auto (octet counted,
+// if message starts with digit, RFC6587 section 3.4).
+// For lf framing incomplete last line (without '\n') parsed on EOF, so stream must be completed.
+// On long message read buffer grows up to MaxBuffer, after that buffered part of line is dropped.
+type StreamReader struct {
+	Reader    *lreader.Reader
+	Codec     codec.Codec
+	Framing   Framing
+	MaxBuffer int
+	Fields    map[string]interface{} // fields, added to every event
+	Logger    zerolog.Logger
+	// KeepPartial keep incomplete last line on EOF in buffer, next Read continue it (like reopened FIFO)
+	KeepPartial bool
+
+	buf []byte // buffer for octet counted message (codec need message with '\n' at end)
+}
+
+// ReadLines read lines from stream until EOF, parse it with codec and send events to outChan.
+//
+// Return nil on EOF, ctx.Err() on shutdown or read error.
+func ReadLines(ctx context.Context, r io.Reader, reader *lreader.Reader, c codec.Codec, maxBuffer int, outChan chan<- *event.Event, logger zerolog.Logger) error {
+	s := StreamReader{Reader: reader, Codec: c, MaxBuffer: maxBuffer, Logger: logger}
+	return s.Read(ctx, r, outChan)
+}
+
+func (s *StreamReader) grow() bool {
+	if s.Reader.Cap() < s.MaxBuffer {
+		newSize := 2 * s.Reader.Cap()
+		if newSize > s.MaxBuffer {
+			newSize = s.MaxBuffer
+		}
+		s.Reader.Grow(newSize)
+		return true
+	}
+	return false
+}
+
+func (s *StreamReader) readLine(r io.Reader) (data []byte, err error) {
+	for {
+		if data, err = s.Reader.ReadUntil('\n'); err == nil {
+			return
+		}
+		if err == lreader.ErrorReadOverflow {
+			if !s.grow() {
+				s.Logger.Warn().Int("size", s.Reader.Len()).Msg("line too long, dropped")
+				s.Reader.Reset(r)
+			}
+			continue
+		}
+		if err == io.EOF && !s.KeepPartial {
+			if data = s.Reader.Unreaded(); len(data) > 0 {
+				// incomplete last line
+				data = append(data[:len(data):len(data)], '\n')
+				s.Reader.Reset(r)
+				err = nil
+			}
+		}
+		return
+	}
+}
+
+func (s *StreamReader) readFrame() (data []byte, err error) {
+	var prefix []byte
+	for {
+		if prefix, err = s.Reader.ReadUntil(' '); err != nil {
+			if err == lreader.ErrorReadOverflow {
+				err = ErrInvalidFrame
+			}
+			return
+		}
+		if prefix = bytes.TrimLeft(prefix[:len(prefix)-1], "\r\n"); len(prefix) > 0 {
+			break
+		}
+	}
+	n, err := strconv.Atoi(stringutils.UnsafeString(prefix))
+	if err != nil || n < 1 {
+		return nil, ErrInvalidFrame
+	}
+	for {
+		if data, err = s.Reader.ReadN(n); err == lreader.ErrorReadOverflow {
+			if n > s.MaxBuffer {
+				return nil, ErrInvalidFrame
+			}
+			s.Reader.Grow(n)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return
+	}
+	if data[len(data)-1] != '\n' {
+		s.buf = append(append(s.buf[:0], data...), '\n')
+		data = s.buf
+	}
+	return
+}
+
+// readDeadliner is a reader with read deadline support (like pollable os.File or net.Conn)
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// Read read messages from stream until EOF.
+//
+// For readers with read deadline support blocked read is interrupted on shutdown (deadline is set on ctx cancel).
+// Return nil on EOF, ctx.Err() on shutdown or read error.
+func (s *StreamReader) Read(ctx context.Context, r io.Reader, outChan chan<- *event.Event) error {
+	var (
+		e    *event.Event
+		data []byte
+		err  error
+	)
+	if d, ok := r.(readDeadliner); ok {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = d.SetReadDeadline(time.Now())
+			case <-done:
+			}
+		}()
+	}
+	if s.KeepPartial {
+		s.Reader.SetReader(r)
+	} else {
+		s.Reader.Reset(r)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		switch s.Framing {
+		case FramingOctet:
+			data, err = s.readFrame()
+		case FramingAuto:
+			var b []byte
+			if b, err = s.Reader.Peek(1); err == nil && b[0] >= '0' && b[0] <= '9' {
+				data, err = s.readFrame()
+			} else if err == nil || err == io.EOF {
+				data, err = s.readLine(r)
+			}
+		default:
+			data, err = s.readLine(r)
+		}
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+				// interrupted on shutdown
+				return ctx.Err()
+			}
+			return err
+		}
+
+		if e, err = s.Codec.Parse(timeutil.Now(), data); err == nil {
+			if e != nil {
+				for k, v := range s.Fields {
+					e.Fields[k] = v
+				}
+				if zerolog.GlobalLevel() == zerolog.TraceLevel {
+					s.Logger.Trace().Str("text", stringutils.UnsafeString(data)).Str("event", event.String(e)).Msg("parse")
+				}
+				select {
+				case outChan <- e:
+				case <-ctx.Done():
+					event.Put(e)
+					return ctx.Err()
+				}
+			}
+		} else {
+			s.Logger.Debug().Str("text", stringutils.UnsafeString(data)).Err(err).Msg("parse")
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package syslog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	syslogcodec "github.com/msaf1980/log-exporter/pkg/codec/syslog"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/input/tcp"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const Name = "syslog"
+
+// Config is a syslog server config.
+//
+// TCP options (read_buffer, max_read_buffer, max_connections, read_timeout, tls) are the same as for tcp input.
+type Config struct {
+	input.Config
+
+	UDP           string        `hcl:"udp" yaml:"udp" json:"udp"`                                     // udp listen address
+	TCP           string        `hcl:"tcp" yaml:"tcp" json:"tcp"`                                     // tcp listen address
+	Unix          string        `hcl:"unix" yaml:"unix" json:"unix"`                                  // unix datagram socket path (like /dev/log)
+	Framing       input.Framing `hcl:"framing" yaml:"framing" json:"framing"`                         // tcp framing: auto (default), lf or octet_counted
+	MaxPacketSize config.Size   `hcl:"max_packet_size" yaml:"max_packet_size" json:"max_packet_size"` // max datagram size
+	Codec         string        `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - syslog)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:        input.Config{Type: Name},
+		Framing:       input.FramingAuto,
+		MaxPacketSize: config.Size(64 * 1024),
+		Codec:         syslogcodec.Name,
+	}
+}
+
+// Syslog is a syslog server input (udp, tcp and unix datagram socket).
+//
+// Every datagram is a one message, tcp messages are new line delimited or octet counted (RFC6587).
+// Peer address added to event fields (peer_addr, peer_port).
+type Syslog struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	tcp input.Input
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &Syslog{
+		cfg:    defaultConfig(),
+		cfgRaw: cfgWith(cfg, nil),
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+	(*in.cfgRaw)["codec"] = in.cfg.Codec
+
+	if in.cfg.UDP == "" && in.cfg.TCP == "" && in.cfg.Unix == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': udp, tcp or unix not set")
+	}
+
+	if in.cfg.MaxPacketSize.Value() < 1 || in.cfg.MaxPacketSize.Value() > 65536 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_packet_size must be > 0 and <= 64k")
+	}
+
+	if in.cfg.TCP != "" {
+		var err error
+		tcpCfg := cfgWith(in.cfgRaw, map[string]interface{}{"listen": in.cfg.TCP, "framing": in.cfg.Framing.String()})
+		if in.tcp, err = tcp.New(tcpCfg, common); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check codec config
+	if _, err := codec.New(in.cfgRaw, in.common, ""); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"'")
+	}
+
+	return in, nil
+}
+
+// cfgWith return config copy with overwrited values
+func cfgWith(cfg *config.ConfigRaw, values map[string]interface{}) *config.ConfigRaw {
+	c := make(config.ConfigRaw, len(*cfg)+len(values))
+	for k, v := range *cfg {
+		c[k] = v
+	}
+	for k, v := range values {
+		c[k] = v
+	}
+	return &c
+}
+
+func (in *Syslog) Name() string {
+	return Name
+}
+
+func (in *Syslog) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	g, gctx := errgroup.WithContext(ctx)
+	if in.tcp != nil {
+		g.Go(func() error {
+			return in.tcp.Start(gctx, outChan)
+		})
+	}
+	if in.cfg.UDP != "" {
+		g.Go(func() error {
+			return in.datagramLoop(gctx, "udp", in.cfg.UDP, outChan)
+		})
+	}
+	if in.cfg.Unix != "" {
+		g.Go(func() error {
+			return in.datagramLoop(gctx, "unixgram", in.cfg.Unix, outChan)
+		})
+	}
+	return g.Wait()
+}
+
+// removeStaleSocket remove socket, left after unclean shutdown. Socket with listener (like /dev/log, owned by journald or rsyslog)
+// is not removed.
+func removeStaleSocket(addr string) error {
+	st, err := os.Lstat(addr)
+	if err != nil || st.Mode()&os.ModeSocket == 0 {
+		// not exist or not a socket (listen failed later)
+		return nil
+	}
+	conn, err := net.Dial("unixgram", addr)
+	if err == nil {
+		conn.Close()
+		return errors.New("listen unixgram " + addr + ": address already in use")
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return err
+	}
+	return os.Remove(addr)
+}
+
+func (in *Syslog) datagramLoop(ctx context.Context, network, addr string, outChan chan<- *event.Event) error {
+	path := "udp://" + addr
+	if network == "unixgram" {
+		path = "unix://" + addr
+	}
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", path).Logger()
+
+	if network == "unixgram" {
+		if err := removeStaleSocket(addr); err != nil {
+			logger.Error().Err(err).Msg("listen failed")
+			return err
+		}
+	}
+
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		logger.Error().Err(err).Msg("listen failed")
+		return err
+	}
+
+	if network == "unixgram" {
+		defer os.Remove(addr)
+		// any local process must be able to log (like /dev/log)
+		if err = os.Chmod(addr, 0666); err != nil {
+			logger.Warn().Err(err).Msg("socket chmod failed")
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	d := input.DatagramReader{
+		NewCodec: func() (codec.Codec, error) {
+			return codec.New(in.cfgRaw, in.common, path)
+		},
+		MaxPacketSize: int(in.cfg.MaxPacketSize.Value()),
+		Logger:        logger,
+	}
+	err = d.Read(ctx, conn, outChan)
+
+	logger.Info().Msg("shutdown")
+	return err
+}
@@ -0,0 +1,192 @@
+package syslog_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func dial(t *testing.T, network, addr string) net.Conn {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 20; i++ {
+		if conn, err = net.Dial(network, addr); err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("dial %s %s error = %v", network, addr, err)
+	return nil
+}
+
+func TestSyslog(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	udpAddr, err := test.FreeAddr("udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr, err := test.FreeAddr("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixPath := filepath.Join(testDir, "log.sock")
+
+	cfg := config.ConfigRaw{"type": "syslog", "udp": udpAddr, "tcp": tcpAddr, "unix": unixPath, "timezone": "UTC"}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 10)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+
+	var wantEvents []*event.Event
+	wantEvent := func(message, appName, path, peerAddr, peerPort string) {
+		fields := map[string]interface{}{
+			"name": "syslog", "host": "localhost", "message": message, "type": "syslog",
+			"priority": 13, "facility": "user", "severity": "notice",
+			"hostname": "host", "app_name": appName, "path": path,
+		}
+		if peerAddr != "" {
+			fields["peer_addr"] = peerAddr
+			fields["peer_port"] = peerPort
+		}
+		wantEvents = append(wantEvents, &event.Event{Fields: fields, Tags: map[string]int{}})
+	}
+
+	// tcp (auto framing)
+	conn := dial(t, "tcp", tcpAddr)
+	if _, err = conn.Write([]byte("<13>Oct 11 22:14:15 host tcp: lf message\n" +
+		"55 <13>1 2025-10-11T22:14:15Z host tcp - - - octet\nmessage" +
+		"<13>Oct 11 22:14:15 host tcp: last message\n")); err != nil {
+		t.Fatal(err)
+	}
+	peerAddr, peerPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+	conn.Close()
+	wantEvent("lf message", "tcp", "tcp://"+tcpAddr, peerAddr, peerPort)
+	wantEvent("octet\nmessage", "tcp", "tcp://"+tcpAddr, peerAddr, peerPort)
+	wantEvent("last message", "tcp", "tcp://"+tcpAddr, peerAddr, peerPort)
+
+	// udp
+	conn = dial(t, "udp", udpAddr)
+	if _, err = conn.Write([]byte("<13>Oct 11 22:14:15 host udp: udp\nmessage\n")); err != nil {
+		t.Fatal(err)
+	}
+	peerAddr, peerPort, _ = net.SplitHostPort(conn.LocalAddr().String())
+	conn.Close()
+	wantEvent("udp\nmessage", "udp", "udp://"+udpAddr, peerAddr, peerPort)
+
+	// unix datagram socket
+	conn = dial(t, "unixgram", unixPath)
+	if _, err = conn.Write([]byte("<13>Oct 11 22:14:15 host unix: unix message")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	wantEvent("unix message", "unix", "unix://"+unixPath, "", "")
+
+	events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+	if eq, diff := test.EventsCmp(wantEvents, events, true, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	for _, e := range events {
+		if e.Timestamp.Month() != time.October || e.Timestamp.Day() != 11 {
+			t.Errorf("event timestamp = %s, want Oct 11", e.Timestamp)
+		}
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+
+	cancel()
+	wg.Wait()
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+	if _, err = os.Stat(unixPath); !os.IsNotExist(err) {
+		t.Errorf("unix socket not removed on shutdown: %v", err)
+	}
+}
+
+func TestSyslogUnixSocketInUse(t *testing.T) {
+	testDir := t.TempDir()
+	unixPath := filepath.Join(testDir, "log.sock")
+	common := &config.Common{Hostname: "localhost"}
+
+	// live socket (like /dev/log, owned by other syslog daemon) must not be removed
+	ln, err := net.ListenPacket("unixgram", unixPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := input.New(&config.ConfigRaw{"type": "syslog", "unix": unixPath}, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err = in.Start(context.Background(), make(chan *event.Event)); err == nil || !strings.Contains(err.Error(), "address already in use") {
+		t.Errorf("Start() error = %v, want address already in use", err)
+	}
+	if _, err = os.Stat(unixPath); err != nil {
+		t.Fatalf("live socket removed: %v", err)
+	}
+
+	// stale socket (listener closed without unlink) is removed
+	ln.Close()
+	in, err = input.New(&config.ConfigRaw{"type": "syslog", "unix": unixPath}, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- in.Start(ctx, make(chan *event.Event))
+	}()
+	conn := dial(t, "unixgram", unixPath)
+	conn.Close()
+	cancel()
+	if err = <-errCh; err != nil {
+		t.Errorf("Start() with stale socket error = %v", err)
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
@@ -0,0 +1,200 @@
+package tcp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/lreader"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "tcp"
+
+type Config struct {
+	input.Config
+
+	Listen         string        `hcl:"listen" yaml:"listen" json:"listen"`                            // listen address
+	Framing        input.Framing `hcl:"framing" yaml:"framing" json:"framing"`                         // lf (default), octet_counted or auto
+	ReadBuffer     config.Size   `hcl:"read_buffer" yaml:"read_buffer" json:"read_buffer"`             // read buffer size
+	MaxReadBuffer  config.Size   `hcl:"max_read_buffer" yaml:"max_read_buffer" json:"max_read_buffer"` // max read buffer size (for long lines)
+	MaxConnections int           `hcl:"max_connections" yaml:"max_connections" json:"max_connections"` // max connections (0 - unlimited)
+	ReadTimeout    time.Duration `hcl:"read_timeout" yaml:"read_timeout" json:"read_timeout"`          // close connection, if no data readed for timeout (0 - disabled)
+	Codec          string        `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - line)
+	TLS            config.TLS    `hcl:"tls" yaml:"tls" json:"tls"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:         input.Config{Type: Name},
+		ReadBuffer:     config.Size(16 * 1024),
+		MaxReadBuffer:  config.Size(1024 * 1024),
+		MaxConnections: 1024,
+		ReadTimeout:    5 * time.Minute,
+	}
+}
+
+// TCP is tcp server input (new line delimited or octet counted messages).
+//
+// Codec allocated for every connection, peer address added to event fields (peer_addr, peer_port).
+type TCP struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	tlsConfig *tls.Config
+	path      string
+	conns     int32
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &TCP{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.Listen == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': listen not set")
+	}
+
+	if in.cfg.ReadBuffer.Value() < 1 {
+		return nil, errors.New("input '" + in.cfg.Type + "': read_buffer must be > 0")
+	}
+
+	if in.cfg.MaxReadBuffer.Value() < in.cfg.ReadBuffer.Value() {
+		in.cfg.MaxReadBuffer = in.cfg.ReadBuffer
+	}
+
+	if in.cfg.MaxConnections < 0 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_connections must be >= 0")
+	}
+
+	var err error
+	if in.tlsConfig, err = in.cfg.TLS.ServerConfig(); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' listen='"+in.cfg.Listen+"'")
+	}
+
+	if in.tlsConfig == nil {
+		in.path = "tcp://" + in.cfg.Listen
+	} else {
+		in.path = "tls://" + in.cfg.Listen
+	}
+
+	// Check codec config
+	if _, err = codec.New(in.cfgRaw, in.common, in.path); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' listen='"+in.cfg.Listen+"'")
+	}
+
+	return in, nil
+}
+
+func (in *TCP) Name() string {
+	return Name
+}
+
+func (in *TCP) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	ln, err := net.Listen("tcp", in.cfg.Listen)
+	if err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Err(err).Msg("listen failed")
+		return err
+	}
+	if in.tlsConfig != nil {
+		ln = tls.NewListener(ln, in.tlsConfig)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Err(err).Msg("accept failed")
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if in.cfg.MaxConnections > 0 && atomic.LoadInt32(&in.conns) >= int32(in.cfg.MaxConnections) {
+			log.Warn().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Str("peer", conn.RemoteAddr().String()).Msg("max connections reached, connection closed")
+			conn.Close()
+			continue
+		}
+		atomic.AddInt32(&in.conns, 1)
+		wg.Add(1)
+		go func() {
+			defer func() {
+				atomic.AddInt32(&in.conns, -1)
+				wg.Done()
+			}()
+			in.connLoop(ctx, conn, outChan)
+		}()
+	}
+
+	wg.Wait()
+	log.Info().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Msg("shutdown")
+	return nil
+}
+
+func (in *TCP) connLoop(ctx context.Context, conn net.Conn, outChan chan<- *event.Event) {
+	peer := conn.RemoteAddr().String()
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Str("peer", peer).Logger()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	codec, err := codec.New(in.cfgRaw, in.common, in.path)
+	if err != nil {
+		logger.Error().Str("codec", in.cfg.Codec).Err(err).Msg("codec init failed")
+		return
+	}
+
+	r := input.DeadlineReader{Conn: conn, Timeout: in.cfg.ReadTimeout}
+	s := input.StreamReader{
+		Reader:    lreader.New(r, int(in.cfg.ReadBuffer.Value())),
+		Codec:     codec,
+		Framing:   in.cfg.Framing,
+		MaxBuffer: int(in.cfg.MaxReadBuffer.Value()),
+		Fields:    input.PeerFields(conn.RemoteAddr()),
+		Logger:    logger,
+	}
+
+	logger.Debug().Msg("connection accepted")
+	err = s.Read(ctx, r, outChan)
+	switch {
+	case err == nil:
+		logger.Debug().Msg("connection closed")
+	case ctx.Err() != nil:
+	default:
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			logger.Debug().Msg("read timeout, connection closed")
+		} else {
+			logger.Error().Err(err).Msg("read failed")
+		}
+	}
+}
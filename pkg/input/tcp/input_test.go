@@ -0,0 +1,204 @@
+package tcp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func dial(t *testing.T, addr string, tlsConfig *tls.Config) net.Conn {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 20; i++ {
+		if tlsConfig == nil {
+			conn, err = net.Dial("tcp", addr)
+		} else {
+			conn, err = tls.Dial("tcp", addr, tlsConfig)
+		}
+		if err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("dial %s error = %v", addr, err)
+	return nil
+}
+
+func TestTCP(t *testing.T) {
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	certFile, keyFile, err := test.GenerateCert(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		cfg       config.ConfigRaw
+		tlsConfig *tls.Config
+		data      []string
+		lines     []string
+	}{
+		{
+			name:  "lf",
+			cfg:   config.ConfigRaw{"type": "tcp"},
+			data:  []string{"test 1\ntest", " 2\n\n", "test 3"},
+			lines: []string{"test 1", "test 2", "test 3"},
+		},
+		{
+			name:  "octet_counted",
+			cfg:   config.ConfigRaw{"type": "tcp", "framing": "octet_counted", "read_buffer": "8"},
+			data:  []string{"6 test 17 test", " 2\n\n16 multiline\nte", "st 3"},
+			lines: []string{"test 1", "test 2", "multiline\ntest 3"},
+		},
+		{
+			name: "tls",
+			cfg: config.ConfigRaw{
+				"type": "tcp",
+				"tls":  map[string]interface{}{"enabled": true, "cert_file": certFile, "key_file": keyFile},
+			},
+			tlsConfig: &tls.Config{InsecureSkipVerify: true},
+			data:      []string{"test 1\n", "test 2\n"},
+			lines:     []string{"test 1", "test 2"},
+		},
+	}
+	common := &config.Common{Hostname: "localhost"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := test.FreeAddr("tcp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.cfg["listen"] = addr
+			scheme := "tcp://"
+			if tt.tlsConfig != nil {
+				scheme = "tls://"
+			}
+
+			in, err := input.New(&tt.cfg, common)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			fchan := make(chan *event.Event, 10)
+			var (
+				wg       sync.WaitGroup
+				startErr error
+			)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				startErr = in.Start(ctx, fchan)
+				close(fchan)
+			}()
+
+			conn := dial(t, addr, tt.tlsConfig)
+			for _, s := range tt.data {
+				if _, err = conn.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			localAddr, localPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+			conn.Close()
+
+			wantEvents := make([]*event.Event, 0, len(tt.lines))
+			for _, line := range tt.lines {
+				wantEvents = append(wantEvents, &event.Event{
+					Fields: map[string]interface{}{
+						"name": "line", "host": "localhost", "message": line, "type": "tcp", "path": scheme + addr,
+						"peer_addr": localAddr, "peer_port": localPort,
+					},
+					Tags: map[string]int{},
+				})
+			}
+			events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+			if eq, diff := test.EventsCmp(wantEvents, events, false, true, true); !eq {
+				t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+			}
+			// put to pool for reuse
+			event.PutSlice(events)
+
+			cancel()
+			wg.Wait()
+			if startErr != nil {
+				t.Fatalf("in.Start() error = %v", startErr)
+			}
+		})
+	}
+}
+
+func TestTCPMaxConnections(t *testing.T) {
+	addr, err := test.FreeAddr("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ConfigRaw{"type": "tcp", "listen": addr, "max_connections": 1, "read_timeout": 200 * time.Millisecond}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fchan := make(chan *event.Event, 10)
+	go func() {
+		_ = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+
+	conn1 := dial(t, addr, nil)
+	defer conn1.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// second connection must be closed
+	conn2 := dial(t, addr, nil)
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	var b [1]byte
+	if _, err = conn2.Read(b[:]); err == nil {
+		t.Errorf("second connection not closed")
+	}
+
+	// first connection must be closed by read timeout
+	conn1.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn1.Read(b[:]); err == nil {
+		t.Errorf("first connection not closed by read timeout")
+	} else if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		t.Errorf("first connection not closed by read timeout")
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
@@ -0,0 +1,102 @@
+package udp
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	jerrors "github.com/juju/errors"
+	"github.com/msaf1980/log-exporter/pkg/codec"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "udp"
+
+type Config struct {
+	input.Config
+
+	Listen        string      `hcl:"listen" yaml:"listen" json:"listen"`                            // listen address
+	MaxPacketSize config.Size `hcl:"max_packet_size" yaml:"max_packet_size" json:"max_packet_size"` // max datagram size
+	Codec         string      `hcl:"codec" yaml:"codec" json:"codec"`                               // codec name (deefault - line)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:        input.Config{Type: Name},
+		MaxPacketSize: config.Size(64 * 1024),
+	}
+}
+
+// UDP is udp server input. Datagram can contain several new line delimited messages.
+//
+// Peer address added to event fields (peer_addr, peer_port).
+type UDP struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	path string
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (input.Input, error) {
+	in := &UDP{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	if err := cfg.Decode(&in.cfg); err != nil {
+		return nil, err
+	}
+
+	if in.cfg.Listen == "" {
+		return nil, errors.New("input '" + in.cfg.Type + "': listen not set")
+	}
+
+	if in.cfg.MaxPacketSize.Value() < 1 || in.cfg.MaxPacketSize.Value() > 65536 {
+		return nil, errors.New("input '" + in.cfg.Type + "': max_packet_size must be > 0 and <= 64k")
+	}
+
+	in.path = "udp://" + in.cfg.Listen
+
+	// Check codec config
+	if _, err := codec.New(in.cfgRaw, in.common, in.path); err != nil {
+		return nil, jerrors.Annotate(err, "input '"+in.cfg.Type+"' listen='"+in.cfg.Listen+"'")
+	}
+
+	return in, nil
+}
+
+func (in *UDP) Name() string {
+	return Name
+}
+
+func (in *UDP) Start(ctx context.Context, outChan chan<- *event.Event) error {
+	conn, err := net.ListenPacket("udp", in.cfg.Listen)
+	if err != nil {
+		log.Error().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Err(err).Msg("listen failed")
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	logger := log.With().Str("config", in.common.Config).Str("input", in.cfg.Type).Str("listen", in.cfg.Listen).Logger()
+	d := input.DatagramReader{
+		NewCodec: func() (codec.Codec, error) {
+			return codec.New(in.cfgRaw, in.common, in.path)
+		},
+		MaxPacketSize: int(in.cfg.MaxPacketSize.Value()),
+		Split:         true,
+		Logger:        logger,
+	}
+	err = d.Read(ctx, conn, outChan)
+
+	logger.Info().Msg("shutdown")
+	return err
+}
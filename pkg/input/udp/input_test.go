@@ -0,0 +1,94 @@
+package udp_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/input"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestUDP(t *testing.T) {
+	addr, err := test.FreeAddr("udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ConfigRaw{"type": "udp", "listen": addr}
+	common := &config.Common{Hostname: "localhost"}
+
+	in, err := input.New(&cfg, common)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fchan := make(chan *event.Event, 10)
+	var (
+		wg       sync.WaitGroup
+		startErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startErr = in.Start(ctx, fchan)
+		close(fchan)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"test 1", "test 2\ntest 3\n", "\n"} {
+		if _, err = conn.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	localAddr, localPort, _ := net.SplitHostPort(conn.LocalAddr().String())
+	conn.Close()
+
+	wantEvents := make([]*event.Event, 0, 3)
+	for _, line := range []string{"test 1", "test 2", "test 3"} {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{
+				"name": "line", "host": "localhost", "message": line, "type": "udp", "path": "udp://" + addr,
+				"peer_addr": localAddr, "peer_port": localPort,
+			},
+			Tags: map[string]int{},
+		})
+	}
+	events := test.EventsFromChannel(fchan, 100*time.Millisecond)
+	if eq, diff := test.EventsCmp(wantEvents, events, false, true, false); !eq {
+		t.Errorf("events (want %d, got %d) mismatch:\n%s", len(wantEvents), len(events), diff)
+	}
+	// put to pool for reuse
+	event.PutSlice(events)
+
+	cancel()
+	wg.Wait()
+	if startErr != nil {
+		t.Fatalf("in.Start() error = %v", startErr)
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
@@ -2,9 +2,25 @@ package input_init
 
 import (
 	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/input/exec"
+	"github.com/msaf1980/log-exporter/pkg/input/fifo"
 	"github.com/msaf1980/log-exporter/pkg/input/file"
+	"github.com/msaf1980/log-exporter/pkg/input/generator"
+	httpinput "github.com/msaf1980/log-exporter/pkg/input/http"
+	"github.com/msaf1980/log-exporter/pkg/input/stdin"
+	"github.com/msaf1980/log-exporter/pkg/input/syslog"
+	"github.com/msaf1980/log-exporter/pkg/input/tcp"
+	"github.com/msaf1980/log-exporter/pkg/input/udp"
 )
 
 func init() {
+	input.Set(exec.Name, exec.New)
+	input.Set(fifo.Name, fifo.New)
 	input.Set(file.Name, file.New)
+	input.Set(generator.Name, generator.New)
+	input.Set(httpinput.Name, httpinput.New)
+	input.Set(stdin.Name, stdin.New)
+	input.Set(syslog.Name, syslog.New)
+	input.Set(tcp.Name, tcp.New)
+	input.Set(udp.Name, udp.New)
 }
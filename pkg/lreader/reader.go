@@ -30,6 +30,12 @@ func (r *Reader) Reset(reader io.Reader) {
 	r.end = 0
 }
 
+// SetReader replace underlying reader, but keep unreaded buffer (for continue stream from new reader)
+func (r *Reader) SetReader(reader io.Reader) {
+	r.reader = reader
+	r.lastErr = nil
+}
+
 func (r *Reader) Grow(newSize int) {
 	if len(r.buf) < newSize {
 		buf := make([]byte, newSize)
@@ -118,3 +124,58 @@ func (r *Reader) ReadUntil(delim byte) (b []byte, err error) {
 	err = r.lastErr
 	return
 }
+
+// Peek return next n bytes without advancing the reader (from buffer, valid until next read).
+//
+// If n is greater than buffer capacity, ErrorReadOverflow returned, use Grow and call next Peek if needed.
+//
+// If enf of file, io.EOF returned and incomplete data stay in buffer.
+func (r *Reader) Peek(n int) (b []byte, err error) {
+	if n > len(r.buf) {
+		err = ErrorReadOverflow
+		return
+	}
+	if r.Len() < n {
+		if r.pos != 0 {
+			copy(r.buf, r.buf[r.pos:r.end])
+			r.end = r.Len()
+			r.pos = 0
+		}
+		if r.lastErr == nil || r.lastErr == io.EOF {
+			var m int
+			for r.Len() < n {
+				m, r.lastErr = r.reader.Read(r.buf[r.end:])
+				r.end += m
+				if r.lastErr != nil {
+					break
+				}
+			}
+		}
+		if r.Len() < n {
+			if err = r.lastErr; err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+	b = r.buf[r.pos : r.pos+n]
+	return
+}
+
+// ReadN return next n bytes (from buffer, copy if need for future use).
+//
+// If n is greater than buffer capacity, ErrorReadOverflow returned, use Grow and call next ReadN if needed.
+//
+// If enf of file, io.EOF returned and incomplete data stay in buffer.
+func (r *Reader) ReadN(n int) (b []byte, err error) {
+	if b, err = r.Peek(n); err != nil {
+		return
+	}
+	if end := r.pos + n; end == r.end {
+		r.pos = 0
+		r.end = 0
+	} else {
+		r.pos = end
+	}
+	return
+}
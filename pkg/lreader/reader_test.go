@@ -339,3 +339,64 @@ func benchmarkReaders(b *testing.B, size int) {
 func BenchmarkReader1024M(b *testing.B) {
 	benchmarkReaders(b, 1024*1024)
 }
+
+func TestReader_ReadN(t *testing.T) {
+	in := []byte("8 string 1line 2\nincomplete")
+	r := bytes.NewReader(in)
+
+	reader := New(r, 10)
+
+	got, err := reader.ReadUntil(' ')
+	if err != nil || string(got) != "8 " {
+		t.Fatalf("ReadUntil(' ') = ('%s', %v), want ('8 ', nil)", got, err)
+	}
+
+	tests := []struct {
+		n            int
+		want         []byte
+		wantEOF      bool
+		wantOverflow bool
+	}{
+		{n: 8, want: []byte("string 1")},
+		{n: 7, want: []byte("line 2\n")},
+		{n: 11, wantOverflow: true},
+		{n: 10, want: []byte("incomplete")},
+		{n: 1, wantEOF: true},
+	}
+
+	for i, tt := range tests {
+		got, err := reader.ReadN(tt.n)
+		if !bytes.Equal(tt.want, got) {
+			t.Errorf("[%d] ReadN(%d) want '%s', got '%s'", i, tt.n, tt.want, got)
+		}
+		if tt.wantOverflow {
+			if err != ErrorReadOverflow {
+				t.Fatalf("[%d] ReadN(%d) error = %#v, wantOverflow %v", i, tt.n, err, tt.wantOverflow)
+			}
+		} else if tt.wantEOF {
+			if err != io.EOF {
+				t.Fatalf("[%d] ReadN(%d) error = %#v, wantEOF %v", i, tt.n, err, tt.wantEOF)
+			}
+		} else if err != nil {
+			t.Fatalf("[%d] ReadN(%d) error = %#v", i, tt.n, err)
+		}
+	}
+}
+
+func TestReader_Peek(t *testing.T) {
+	reader := New(bytes.NewReader([]byte("12 string")), 4)
+
+	for i := 0; i < 2; i++ {
+		got, err := reader.Peek(1)
+		if err != nil || string(got) != "1" {
+			t.Fatalf("[%d] Peek(1) = ('%s', %v), want ('1', nil)", i, got, err)
+		}
+	}
+	if _, err := reader.Peek(5); err != ErrorReadOverflow {
+		t.Fatalf("Peek(5) error = %#v, want overflow", err)
+	}
+	got, err := reader.ReadUntil(' ')
+	if err != nil || string(got) != "12 " {
+		t.Fatalf("ReadUntil(' ') = ('%s', %v), want ('12 ', nil)", got, err)
+	}
+}
@@ -0,0 +1,400 @@
+package clickhouse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "clickhouse"
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type Format int8
+
+const (
+	FormatJSONEachRow Format = iota
+	FormatRowBinary
+)
+
+var formatStrings []string = []string{"JSONEachRow", "RowBinary"}
+
+func (f *Format) Set(value string) error {
+	switch value {
+	case "JSONEachRow", "":
+		*f = FormatJSONEachRow
+	case "RowBinary":
+		*f = FormatRowBinary
+	default:
+		return fmt.Errorf("invalid format %s", value)
+	}
+	return nil
+}
+
+func (f *Format) String() string {
+	return formatStrings[*f]
+}
+
+func (f *Format) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+// Column is a table column, mapped to event field
+type Column struct {
+	Name  string `hcl:"name" yaml:"name" json:"name"`    // column name
+	Field string `hcl:"field" yaml:"field" json:"field"` // event field (default is a column name, nested fields like a.b), @timestamp for event timestamp, tags for event tags
+	Type  string `hcl:"type" yaml:"type" json:"type"`    // column type, like String, UInt16, DateTime64(3), Nullable(String), Array(String) (required for RowBinary)
+}
+
+type Config struct {
+	output.Config
+
+	URL      string   `hcl:"url" yaml:"url" json:"url"`                // http interface url
+	Database string   `hcl:"database" yaml:"database" json:"database"` // database (default from user settings)
+	Table    string   `hcl:"table" yaml:"table" json:"table"`          // table
+	Columns  []Column `hcl:"columns" yaml:"columns" json:"columns"`    // columns, mapped to event fields
+	Format   Format   `hcl:"format" yaml:"format" json:"format"`       // insert format: JSONEachRow (default) or RowBinary
+	Username string   `hcl:"username" yaml:"username" json:"username"` // user
+	Password string   `hcl:"password" yaml:"password" json:"password"` // password
+	Gzip     bool     `hcl:"gzip" yaml:"gzip" json:"gzip"`             // compress requests
+	Dedup    bool     `hcl:"dedup" yaml:"dedup" json:"dedup"`          // set insert_deduplication_token (same for retries of batch)
+
+	BatchSize       int           `hcl:"batch_size" yaml:"batch_size" json:"batch_size"`                      // max rows in insert
+	BatchBytes      config.Size   `hcl:"batch_bytes" yaml:"batch_bytes" json:"batch_bytes"`                   // max insert size (uncompressed)
+	FlushInterval   time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"`          // insert not full batch after interval
+	Timeout         time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                               // request timeout
+	MaxRetries      int           `hcl:"max_retries" yaml:"max_retries" json:"max_retries"`                   // retries on network errors and 429/5xx statuses
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial retry delay (doubled on every retry)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max retry delay
+	TLS             config.TLS    `hcl:"tls" yaml:"tls" json:"tls"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		URL:             "http://127.0.0.1:8123",
+		Dedup:           true,
+		BatchSize:       10000,
+		BatchBytes:      config.Size(10 * 1024 * 1024),
+		FlushInterval:   time.Second,
+		Timeout:         time.Minute,
+		MaxRetries:      5,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 10 * time.Second,
+	}
+}
+
+// column is a parsed column config
+type column struct {
+	name string
+	path []string
+	typ  *colType
+}
+
+// Clickhouse is output for insert events to ClickHouse table with HTTP interface.
+//
+// Rows are buffered and inserted with JSONEachRow or RowBinary format, field values converted to column types
+// (timestamps to DateTime/DateTime64). Failed inserts are retried with same body and insert_deduplication_token,
+// so retry of already inserted (but not acknowledged) batch is deduplicated by server
+// (table engine must support deduplication, for non-replicated MergeTree non_replicated_deduplication_window must be set).
+// Events are returned after insert result, not inserted events are marked as failed (skipped rows are not failed).
+type Clickhouse struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	columns []column
+	query   string
+	client  *http.Client
+
+	rows    []byte         // buffered rows
+	events  []*event.Event // buffered rows events, hold until insert result
+	row     []byte
+	body    bytes.Buffer
+	zw      *gzip.Writer
+	tokenID string // token prefix (unique for output start)
+	batch   uint64
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Clickhouse{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(o.cfg.URL, "http://") && !strings.HasPrefix(o.cfg.URL, "https://") {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid url " + o.cfg.URL)
+	}
+	if o.cfg.Table == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': table not set")
+	}
+	if len(o.cfg.Columns) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': columns not set")
+	}
+	names := make([]string, 0, len(o.cfg.Columns))
+	for _, c := range o.cfg.Columns {
+		if c.Name == "" {
+			return nil, errors.New("output '" + o.cfg.Type + "': column name not set")
+		}
+		col := column{name: c.Name}
+		if c.Field == "" {
+			c.Field = c.Name
+		}
+		col.path = strings.Split(c.Field, ".")
+		if col.typ, err = parseType(c.Type); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': column " + c.Name + ": " + err.Error())
+		}
+		if col.typ.kind == kindAny && o.cfg.Format == FormatRowBinary {
+			return nil, errors.New("output '" + o.cfg.Type + "': column " + c.Name + ": type not set")
+		}
+		o.columns = append(o.columns, col)
+		names = append(names, quoteIdent(c.Name))
+	}
+	table := quoteIdent(o.cfg.Table)
+	if o.cfg.Database != "" {
+		table = quoteIdent(o.cfg.Database) + "." + table
+	}
+	o.query = "INSERT INTO " + table + " (" + strings.Join(names, ", ") + ") FORMAT " + o.cfg.Format.String()
+
+	if o.cfg.BatchSize < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_size must be > 0")
+	}
+	if o.cfg.BatchBytes.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_bytes must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.MaxRetries < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_retries must be >= 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	tlsConfig, err := o.cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	o.client = &http.Client{
+		Timeout:   o.cfg.Timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+
+	if o.cfg.Gzip {
+		o.zw = gzip.NewWriter(&o.body)
+	}
+
+	return o, nil
+}
+
+func (o *Clickhouse) Name() string {
+	return Name
+}
+
+func (o *Clickhouse) Acknowledging() {}
+
+func quoteIdent(s string) string {
+	return "`" + strings.ReplaceAll(strings.ReplaceAll(s, "\\", "\\\\"), "`", "\\`") + "`"
+}
+
+// value return column value from event (nil if not exist)
+func (c *column) value(e *event.Event) interface{} {
+	if len(c.path) == 1 {
+		switch c.path[0] {
+		case "@timestamp":
+			if _, ok := e.Fields["@timestamp"]; !ok {
+				return e.Timestamp
+			}
+		case "tags":
+			if _, ok := e.Fields["tags"]; !ok {
+				return output.Tags(e)
+			}
+		}
+	}
+	v, _ := output.Lookup(e.Fields, c.path)
+	return v
+}
+
+// appendRow append serialized row to b
+func (o *Clickhouse) appendRow(b []byte, e *event.Event) ([]byte, error) {
+	var err error
+	if o.cfg.Format == FormatRowBinary {
+		for i := range o.columns {
+			if b, err = o.columns[i].typ.appendBinary(b, o.columns[i].value(e)); err != nil {
+				return b, errors.New("column " + o.columns[i].name + ": " + err.Error())
+			}
+		}
+		return b, nil
+	}
+
+	b = append(b, '{')
+	n := 0
+	for i := range o.columns {
+		v, err := o.columns[i].typ.convert(o.columns[i].value(e))
+		if err != nil {
+			return b, errors.New("column " + o.columns[i].name + ": " + err.Error())
+		}
+		if v == nil {
+			// omitted, default value will be used
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return b, errors.New("column " + o.columns[i].name + ": " + err.Error())
+		}
+		if n > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendQuote(b, o.columns[i].name)
+		b = append(b, ':')
+		b = append(b, data...)
+		n++
+	}
+	return append(b, "}\n"...), nil
+}
+
+// insert send insert request, return true for retry
+func (o *Clickhouse) insert(body []byte, token string) (bool, error) {
+	params := url.Values{}
+	params.Set("query", o.query)
+	if o.cfg.Database != "" {
+		params.Set("database", o.cfg.Database)
+	}
+	if token != "" {
+		params.Set("insert_deduplicate", "1")
+		params.Set("insert_deduplication_token", token)
+	}
+	if o.cfg.Format == FormatJSONEachRow {
+		params.Set("date_time_input_format", "best_effort")
+		params.Set("input_format_skip_unknown_fields", "1")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.cfg.URL+"/?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	if o.zw != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if o.cfg.Username != "" {
+		req.Header.Set("X-ClickHouse-User", o.cfg.Username)
+		req.Header.Set("X-ClickHouse-Key", o.cfg.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// flush insert buffered rows (with retries), rows events returned to out channel
+func (o *Clickhouse) flush(outChan chan<- *event.Event) {
+	if len(o.events) == 0 {
+		return
+	}
+	body := o.rows
+	if o.zw != nil {
+		o.body.Reset()
+		o.zw.Reset(&o.body)
+		o.zw.Write(o.rows)
+		o.zw.Close()
+		body = o.body.Bytes()
+	}
+	failed := false
+	var token string
+	if o.cfg.Dedup {
+		o.batch++
+		token = o.tokenID + "-" + strconv.FormatUint(o.batch, 10)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(output.Backoff(attempt-1, o.cfg.RetryBackoff, o.cfg.MaxRetryBackoff))
+		}
+		retry, err := o.insert(body, token)
+		if err == nil {
+			break
+		}
+		if !retry {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("rows", len(o.events)).Err(err).Msg("insert rejected, rows dropped")
+			failed = true
+			break
+		}
+		if attempt >= o.cfg.MaxRetries {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("rows", len(o.events)).Err(err).Msg("insert failed, rows dropped")
+			failed = true
+			break
+		}
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("rows", len(o.events)).Int("attempt", attempt).Err(err).Msg("insert failed")
+	}
+
+	o.rows = o.rows[:0]
+	for i, e := range o.events {
+		if failed {
+			e.Failed = true
+		}
+		outChan <- e
+		o.events[i] = nil
+	}
+	o.events = o.events[:0]
+}
+
+func (o *Clickhouse) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+	o.tokenID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	var err error
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				o.flush(outChan)
+				return nil
+			}
+			if o.row, err = o.appendRow(o.row[:0], e); err != nil {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("row skipped")
+				outChan <- e
+				continue
+			}
+			o.rows = append(o.rows, o.row...)
+			o.events = append(o.events, e)
+			if len(o.events) >= o.cfg.BatchSize || int64(len(o.rows)) >= o.cfg.BatchBytes.Value() {
+				o.flush(outChan)
+			}
+		case <-ticker.C:
+			o.flush(outChan)
+		}
+	}
+}
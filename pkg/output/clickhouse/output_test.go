@@ -0,0 +1,226 @@
+package clickhouse_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	columns := []map[string]string{{"name": "message"}}
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "clickhouse", "table": "logs", "columns": columns}},
+		{name: "table not set", cfg: config.ConfigRaw{"type": "clickhouse", "columns": columns}, wantErr: true},
+		{name: "columns not set", cfg: config.ConfigRaw{"type": "clickhouse", "table": "logs"}, wantErr: true},
+		{name: "invalid format", cfg: config.ConfigRaw{"type": "clickhouse", "table": "logs", "columns": columns, "format": "CSV"}, wantErr: true},
+		{name: "RowBinary without type", cfg: config.ConfigRaw{"type": "clickhouse", "table": "logs", "columns": columns, "format": "RowBinary"}, wantErr: true},
+		{
+			name:    "invalid type",
+			cfg:     config.ConfigRaw{"type": "clickhouse", "table": "logs", "columns": []map[string]string{{"name": "a", "type": "Map(String, String)"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// insertServer is a ClickHouse HTTP interface stand-in with deduplication by insert_deduplication_token.
+// First insert is stored, but failed with 500 (like lost response).
+type insertServer struct {
+	mu       sync.Mutex
+	requests int
+	queries  map[string]bool
+	tokens   map[string]bool
+	inserts  [][]byte
+}
+
+func (s *insertServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-ClickHouse-User") != "default" || r.Header.Get("X-ClickHouse-Key") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.queries[r.URL.Query().Get("query")] = true
+	token := r.URL.Query().Get("insert_deduplication_token")
+	if token == "" || !s.tokens[token] {
+		s.tokens[token] = true
+		s.inserts = append(s.inserts, data)
+	}
+	if s.requests == 1 {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestClickhouse(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	events := []*event.Event{
+		{
+			Timestamp: ts,
+			Fields: map[string]interface{}{
+				"message": "GET /", "status": "200", "http": map[string]interface{}{"method": "GET"},
+			},
+			Tags: map[string]int{"access": 1},
+		},
+		{
+			Timestamp: ts.Add(time.Second),
+			Fields:    map[string]interface{}{"message": "POST /api", "status": 404},
+		},
+		{
+			Timestamp: ts.Add(2 * time.Second),
+			Fields:    map[string]interface{}{"message": "GET /", "status": "invalid"},
+		},
+	}
+	columns := []map[string]string{
+		{"name": "ts", "field": "@timestamp", "type": "DateTime64(3)"},
+		{"name": "message", "type": "String"},
+		{"name": "status", "type": "UInt16"},
+		{"name": "method", "field": "http.method", "type": "Nullable(String)"},
+		{"name": "tags", "type": "Array(String)"},
+	}
+
+	tests := []struct {
+		name        string
+		cfg         config.ConfigRaw
+		wantQuery   string
+		wantInserts []string
+	}{
+		{
+			name:      "JSONEachRow",
+			cfg:       config.ConfigRaw{},
+			wantQuery: "INSERT INTO `logs`.`access` (`ts`, `message`, `status`, `method`, `tags`) FORMAT JSONEachRow",
+			wantInserts: []string{
+				`{"ts":"1614834367.123","message":"GET /","status":200,"method":"GET","tags":["access"]}` + "\n" +
+					`{"ts":"1614834368.123","message":"POST /api","status":404,"tags":[]}` + "\n",
+			},
+		},
+		{
+			name:      "RowBinary",
+			cfg:       config.ConfigRaw{"format": "RowBinary", "gzip": true, "batch_size": 1},
+			wantQuery: "INSERT INTO `logs`.`access` (`ts`, `message`, `status`, `method`, `tags`) FORMAT RowBinary",
+			wantInserts: []string{
+				string([]byte{0x93, 0xfa, 0xa0, 0xfb, 0x77, 0x01, 0, 0}) + "\x05GET /" + "\xc8\x00" + "\x00\x03GET" + "\x01\x06access",
+				string([]byte{0x7b, 0xfe, 0xa0, 0xfb, 0x77, 0x01, 0, 0}) + "\x09POST /api" + "\x94\x01" + "\x01" + "\x00",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &insertServer{queries: make(map[string]bool), tokens: make(map[string]bool)}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			cfg := config.ConfigRaw{
+				"type":          "clickhouse",
+				"url":           srv.URL,
+				"database":      "logs",
+				"table":         "access",
+				"columns":       columns,
+				"username":      "default",
+				"password":      "secret",
+				"retry_backoff": time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				inChan <- e
+			}
+			close(inChan)
+
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+
+			if len(s.queries) != 1 || !s.queries[tt.wantQuery] {
+				t.Errorf("queries = %v, want %q", s.queries, tt.wantQuery)
+			}
+			// first request retried, but deduplicated
+			if s.requests != len(tt.wantInserts)+1 {
+				t.Errorf("requests = %d, want %d", s.requests, len(tt.wantInserts)+1)
+			}
+			if len(s.inserts) != len(tt.wantInserts) {
+				t.Fatalf("inserts = %q, want %q", s.inserts, tt.wantInserts)
+			}
+			for i := range tt.wantInserts {
+				if string(s.inserts[i]) != tt.wantInserts[i] {
+					t.Errorf("inserts[%d] = %q, want %q", i, s.inserts[i], tt.wantInserts[i])
+				}
+			}
+			if tt.name == "JSONEachRow" {
+				// rows must be valid json
+				scanner := bufio.NewScanner(bytes.NewReader(s.inserts[0]))
+				for scanner.Scan() {
+					if !jsoniter.Valid(scanner.Bytes()) {
+						t.Errorf("invalid row %q", scanner.Text())
+					}
+				}
+			}
+		})
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
@@ -0,0 +1,388 @@
+package clickhouse
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/output"
+)
+
+type kind int8
+
+const (
+	kindAny kind = iota // type not set (JSONEachRow only), value passed as is
+	kindString
+	kindInt
+	kindUint
+	kindFloat
+	kindBool
+	kindDateTime
+	kindDateTime64
+	kindArray
+)
+
+// colType is a parsed ClickHouse column type
+type colType struct {
+	name      string
+	kind      kind
+	size      int // bytes for numbers
+	precision int // DateTime64 precision
+	nullable  bool
+	elem      *colType // Array element
+}
+
+var pow10 = [...]int64{1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000, 1000000000}
+
+// parseType parse ClickHouse column type, like DateTime64(3), Nullable(UInt16), LowCardinality(String), Array(String)
+func parseType(s string) (*colType, error) {
+	s = strings.TrimSpace(s)
+	t := &colType{name: s}
+	if s == "" {
+		return t, nil
+	}
+	if inner, ok := unwrap(s, "LowCardinality"); ok {
+		return parseType(inner)
+	}
+	if inner, ok := unwrap(s, "Nullable"); ok {
+		elem, err := parseType(inner)
+		if err != nil {
+			return nil, err
+		}
+		if elem.nullable || elem.kind == kindArray || elem.kind == kindAny {
+			return nil, errors.New("unsupported type " + s)
+		}
+		elem.name = s
+		elem.nullable = true
+		return elem, nil
+	}
+	if inner, ok := unwrap(s, "Array"); ok {
+		elem, err := parseType(inner)
+		if err != nil {
+			return nil, err
+		}
+		if elem.kind == kindAny {
+			return nil, errors.New("unsupported type " + s)
+		}
+		t.kind = kindArray
+		t.elem = elem
+		return t, nil
+	}
+	if inner, ok := unwrap(s, "DateTime64"); ok {
+		// DateTime64(precision[, timezone])
+		if n := strings.IndexByte(inner, ','); n != -1 {
+			inner = inner[:n]
+		}
+		p, err := strconv.Atoi(strings.TrimSpace(inner))
+		if err != nil || p < 0 || p > 9 {
+			return nil, errors.New("unsupported type " + s)
+		}
+		t.kind = kindDateTime64
+		t.size = 8
+		t.precision = p
+		return t, nil
+	}
+	if strings.HasPrefix(s, "DateTime(") || s == "DateTime" {
+		t.kind = kindDateTime
+		t.size = 4
+		return t, nil
+	}
+	switch s {
+	case "String":
+		t.kind = kindString
+	case "Bool":
+		t.kind = kindBool
+		t.size = 1
+	case "Int8", "Int16", "Int32", "Int64":
+		t.kind = kindInt
+		t.size, _ = strconv.Atoi(s[3:])
+		t.size /= 8
+	case "UInt8", "UInt16", "UInt32", "UInt64":
+		t.kind = kindUint
+		t.size, _ = strconv.Atoi(s[4:])
+		t.size /= 8
+	case "Float32":
+		t.kind = kindFloat
+		t.size = 4
+	case "Float64":
+		t.kind = kindFloat
+		t.size = 8
+	default:
+		return nil, errors.New("unsupported type " + s)
+	}
+	return t, nil
+}
+
+func unwrap(s, name string) (string, bool) {
+	if strings.HasPrefix(s, name+"(") && strings.HasSuffix(s, ")") {
+		return s[len(name)+1 : len(s)-1], true
+	}
+	return "", false
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int32:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case float64:
+		return int64(n), nil
+	case float32:
+		return int64(n), nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	default:
+		return 0, fmt.Errorf("can't convert %T to integer", v)
+	}
+}
+
+func toUint64(v interface{}) (uint64, error) {
+	if s, ok := v.(string); ok {
+		return strconv.ParseUint(s, 10, 64)
+	}
+	n, err := toInt64(v)
+	if err == nil && n < 0 {
+		return 0, fmt.Errorf("negative value %d for unsigned integer", n)
+	}
+	return uint64(n), err
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	default:
+		i, err := toInt64(v)
+		return float64(i), err
+	}
+}
+
+func toBool(v interface{}) (bool, error) {
+	switch n := v.(type) {
+	case bool:
+		return n, nil
+	case string:
+		return strconv.ParseBool(n)
+	default:
+		i, err := toInt64(v)
+		return i != 0, err
+	}
+}
+
+// toTime convert time.Time, string (RFC3339 or "2006-01-02 15:04:05[.999999999]" in UTC) or number (unix timestamp)
+func toTime(v interface{}) (time.Time, error) {
+	switch n := v.(type) {
+	case time.Time:
+		return n, nil
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, n); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02 15:04:05.999999999", n)
+	default:
+		f, err := toFloat64(v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+}
+
+// ticks return DateTime64 value (ticks with precision)
+func (t *colType) ticks(ts time.Time) int64 {
+	return ts.Unix()*pow10[t.precision] + int64(ts.Nanosecond())/pow10[9-t.precision]
+}
+
+// appendBinary append value in RowBinary format (v is nil for not existing field - default or NULL)
+func (t *colType) appendBinary(b []byte, v interface{}) ([]byte, error) {
+	if t.nullable {
+		if v == nil {
+			return append(b, 1), nil
+		}
+		b = append(b, 0)
+	}
+	var buf [8]byte
+	switch t.kind {
+	case kindString:
+		if v == nil {
+			return append(b, 0), nil
+		}
+		s := output.AppendValue(nil, v)
+		n := binary.PutUvarint(buf[:], uint64(len(s)))
+		b = append(b, buf[:n]...)
+		return append(b, s...), nil
+	case kindInt, kindUint, kindBool:
+		var (
+			u   uint64
+			err error
+		)
+		if v != nil {
+			switch t.kind {
+			case kindInt:
+				var i int64
+				i, err = toInt64(v)
+				u = uint64(i)
+			case kindUint:
+				u, err = toUint64(v)
+			default:
+				var ok bool
+				if ok, err = toBool(v); ok {
+					u = 1
+				}
+			}
+			if err != nil {
+				return b, err
+			}
+		}
+		binary.LittleEndian.PutUint64(buf[:], u)
+		return append(b, buf[:t.size]...), nil
+	case kindFloat:
+		var f float64
+		if v != nil {
+			var err error
+			if f, err = toFloat64(v); err != nil {
+				return b, err
+			}
+		}
+		if t.size == 4 {
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(f)))
+		} else {
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		}
+		return append(b, buf[:t.size]...), nil
+	case kindDateTime, kindDateTime64:
+		var ts time.Time
+		if v != nil {
+			var err error
+			if ts, err = toTime(v); err != nil {
+				return b, err
+			}
+		}
+		if t.kind == kindDateTime {
+			var sec int64
+			if !ts.IsZero() {
+				sec = ts.Unix()
+			}
+			binary.LittleEndian.PutUint32(buf[:], uint32(sec))
+		} else {
+			var ticks int64
+			if !ts.IsZero() {
+				ticks = t.ticks(ts)
+			}
+			binary.LittleEndian.PutUint64(buf[:], uint64(ticks))
+		}
+		return append(b, buf[:t.size]...), nil
+	case kindArray:
+		values, err := toArray(v)
+		if err != nil {
+			return b, err
+		}
+		n := binary.PutUvarint(buf[:], uint64(len(values)))
+		b = append(b, buf[:n]...)
+		for _, e := range values {
+			if b, err = t.elem.appendBinary(b, e); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	default:
+		return b, errors.New("column type not set")
+	}
+}
+
+func toArray(v interface{}) ([]interface{}, error) {
+	switch n := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return n, nil
+	case []string:
+		values := make([]interface{}, len(n))
+		for i := range n {
+			values[i] = n[i]
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("can't convert %T to array", v)
+	}
+}
+
+// convert return value, converted for JSONEachRow (nil for not existing field)
+func (t *colType) convert(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t.kind {
+	case kindString:
+		if _, ok := v.(string); ok {
+			return v, nil
+		}
+		return string(output.AppendValue(nil, v)), nil
+	case kindInt:
+		return toInt64(v)
+	case kindUint:
+		return toUint64(v)
+	case kindFloat:
+		return toFloat64(v)
+	case kindBool:
+		return toBool(v)
+	case kindDateTime:
+		ts, err := toTime(v)
+		if err != nil {
+			return nil, err
+		}
+		return ts.Unix(), nil
+	case kindDateTime64:
+		// decimal unix timestamp with precision, like 1614834367.123
+		ts, err := toTime(v)
+		if err != nil {
+			return nil, err
+		}
+		s := strconv.FormatInt(ts.Unix(), 10)
+		if t.precision > 0 {
+			frac := strconv.FormatInt(int64(ts.Nanosecond())/pow10[9-t.precision]+pow10[t.precision], 10)
+			s += "." + frac[1:]
+		}
+		return s, nil
+	case kindArray:
+		values, err := toArray(v)
+		if err != nil {
+			return nil, err
+		}
+		converted := make([]interface{}, len(values))
+		for i := range values {
+			if converted[i], err = t.elem.convert(values[i]); err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	default:
+		if ts, ok := v.(time.Time); ok {
+			return ts.UTC().Format(time.RFC3339Nano), nil
+		}
+		return v, nil
+	}
+}
@@ -0,0 +1,125 @@
+package clickhouse
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		typ       string
+		kind      kind
+		size      int
+		precision int
+		nullable  bool
+		wantErr   bool
+	}{
+		{typ: "", kind: kindAny},
+		{typ: "String", kind: kindString},
+		{typ: "LowCardinality(String)", kind: kindString},
+		{typ: "Nullable(String)", kind: kindString, nullable: true},
+		{typ: "UInt16", kind: kindUint, size: 2},
+		{typ: "Int64", kind: kindInt, size: 8},
+		{typ: "Float32", kind: kindFloat, size: 4},
+		{typ: "Bool", kind: kindBool, size: 1},
+		{typ: "DateTime", kind: kindDateTime, size: 4},
+		{typ: "DateTime('UTC')", kind: kindDateTime, size: 4},
+		{typ: "DateTime64(3)", kind: kindDateTime64, size: 8, precision: 3},
+		{typ: "DateTime64(6, 'UTC')", kind: kindDateTime64, size: 8, precision: 6},
+		{typ: "Array(String)", kind: kindArray},
+		{typ: "DateTime64(10)", wantErr: true},
+		{typ: "Nullable(Array(String))", wantErr: true},
+		{typ: "Decimal(10, 2)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			got, err := parseType(tt.typ)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.kind != tt.kind || got.size != tt.size || got.precision != tt.precision || got.nullable != tt.nullable {
+				t.Errorf("parseType() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestAppendBinary(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	tests := []struct {
+		typ     string
+		v       interface{}
+		want    []byte
+		wantErr bool
+	}{
+		{typ: "String", v: "abc", want: []byte{3, 'a', 'b', 'c'}},
+		{typ: "String", v: 12, want: []byte{2, '1', '2'}},
+		{typ: "String", v: nil, want: []byte{0}},
+		{typ: "Nullable(String)", v: nil, want: []byte{1}},
+		{typ: "Nullable(String)", v: "a", want: []byte{0, 1, 'a'}},
+		{typ: "UInt16", v: "513", want: []byte{1, 2}},
+		{typ: "UInt16", v: -1, wantErr: true},
+		{typ: "Int32", v: float64(-2), want: []byte{0xfe, 0xff, 0xff, 0xff}},
+		{typ: "Bool", v: true, want: []byte{1}},
+		{typ: "Float32", v: 1.5, want: []byte{0, 0, 0xc0, 0x3f}},
+		{typ: "DateTime", v: ts, want: []byte{0xbf, 0x6a, 0x40, 0x60}},
+		// 1614834367123 ms
+		{typ: "DateTime64(3)", v: ts, want: []byte{0x93, 0xfa, 0xa0, 0xfb, 0x77, 0x01, 0, 0}},
+		{typ: "DateTime64(3)", v: "2021-03-04T05:06:07.123456789Z", want: []byte{0x93, 0xfa, 0xa0, 0xfb, 0x77, 0x01, 0, 0}},
+		{typ: "DateTime64(3)", v: "invalid", wantErr: true},
+		{typ: "Array(String)", v: []string{"a", "b"}, want: []byte{2, 1, 'a', 1, 'b'}},
+		{typ: "Array(UInt8)", v: []interface{}{1, "2"}, want: []byte{2, 1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			typ, err := parseType(tt.typ)
+			if err != nil {
+				t.Fatalf("parseType() error = %v", err)
+			}
+			got, err := typ.appendBinary(nil, tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("appendBinary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, tt.want) {
+				t.Errorf("appendBinary() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	tests := []struct {
+		typ  string
+		v    interface{}
+		want interface{}
+	}{
+		{typ: "DateTime64(3)", v: ts, want: "1614834367.123"},
+		{typ: "DateTime64(9)", v: ts, want: "1614834367.123456789"},
+		{typ: "DateTime64(0)", v: ts, want: "1614834367"},
+		{typ: "DateTime", v: ts, want: int64(1614834367)},
+		{typ: "UInt16", v: "200", want: uint64(200)},
+		{typ: "String", v: 200, want: "200"},
+		{typ: "", v: ts, want: "2021-03-04T05:06:07.123456789Z"},
+		{typ: "", v: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			typ, err := parseType(tt.typ)
+			if err != nil {
+				t.Fatalf("parseType() error = %v", err)
+			}
+			got, err := typ.convert(tt.v)
+			if err != nil {
+				t.Fatalf("convert() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("convert() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
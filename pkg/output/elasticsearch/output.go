@@ -0,0 +1,370 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "elasticsearch"
+
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type Config struct {
+	output.Config
+
+	Hosts    []string `hcl:"hosts" yaml:"hosts" json:"hosts"`          // cluster urls (used round-robin on failures)
+	Index    string   `hcl:"index" yaml:"index" json:"index"`          // index template, like "logs-%{+2006.01.02}"
+	Action   string   `hcl:"action" yaml:"action" json:"action"`       // bulk action: index (default) or create (for data streams)
+	Username string   `hcl:"username" yaml:"username" json:"username"` // basic auth username
+	Password string   `hcl:"password" yaml:"password" json:"password"` // basic auth password
+	Gzip     bool     `hcl:"gzip" yaml:"gzip" json:"gzip"`             // compress requests
+
+	BatchSize     int           `hcl:"batch_size" yaml:"batch_size" json:"batch_size"`             // max documents in bulk request
+	BatchBytes    config.Size   `hcl:"batch_bytes" yaml:"batch_bytes" json:"batch_bytes"`          // max bulk request size (uncompressed)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // send not full batch after interval
+	Timeout       time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                      // request timeout
+
+	MaxRetries      int           `hcl:"max_retries" yaml:"max_retries" json:"max_retries"`                   // retries for failed (429/5xx) documents, before dead letter
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial retry delay (doubled on every retry)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max retry delay
+	DeadLetterPath  string        `hcl:"dead_letter_path" yaml:"dead_letter_path" json:"dead_letter_path"`    // file for permanently failed documents (dropped if not set)
+
+	TLS config.TLS `hcl:"tls" yaml:"tls" json:"tls"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		Hosts:           []string{"http://127.0.0.1:9200"},
+		Index:           "logs-%{+2006.01.02}",
+		Action:          "index",
+		BatchSize:       1000,
+		BatchBytes:      config.Size(5 * 1024 * 1024),
+		FlushInterval:   time.Second,
+		Timeout:         30 * time.Second,
+		MaxRetries:      5,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 10 * time.Second,
+	}
+}
+
+// item is a serialized document for bulk request, event is hold until bulk result
+type item struct {
+	index string
+	doc   []byte
+	e     *event.Event
+}
+
+// Elasticsearch is output for send events to Elasticsearch/OpenSearch with bulk API.
+//
+// Documents are event fields (with tags) and @timestamp (if not exist in fields).
+// Bulk request retried (with backoff) on network errors and 429/5xx statuses, for partial failures
+// only failed with 429/5xx documents are retried. Permanently failed documents (and documents, exceeded max_retries)
+// are written to dead letter file. Events are returned after bulk result, not delivered events are marked as failed.
+type Elasticsearch struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	index      *output.Template
+	client     *http.Client
+	host       int
+	deadLetter *output.DeadLetter
+
+	body bytes.Buffer
+	zw   *gzip.Writer
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Elasticsearch{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if len(o.cfg.Hosts) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': hosts not set")
+	}
+	for i, host := range o.cfg.Hosts {
+		if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+			return nil, errors.New("output '" + o.cfg.Type + "': invalid host " + host)
+		}
+		o.cfg.Hosts[i] = strings.TrimRight(host, "/")
+	}
+	if o.cfg.Index == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': index not set")
+	}
+	if o.index, err = output.NewTemplate(o.cfg.Index); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.cfg.Action != "index" && o.cfg.Action != "create" {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid action " + o.cfg.Action)
+	}
+	if o.cfg.BatchSize < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_size must be > 0")
+	}
+	if o.cfg.BatchBytes.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_bytes must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.MaxRetries < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_retries must be >= 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	tlsConfig, err := o.cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	o.client = &http.Client{
+		Timeout:   o.cfg.Timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+
+	if o.cfg.DeadLetterPath != "" {
+		if o.deadLetter, err = output.NewDeadLetter(o.cfg.DeadLetterPath); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+		}
+	}
+
+	if o.cfg.Gzip {
+		o.zw = gzip.NewWriter(&o.body)
+	}
+
+	return o, nil
+}
+
+func (o *Elasticsearch) Name() string {
+	return Name
+}
+
+func (o *Elasticsearch) Acknowledging() {}
+
+// bulkResponse is a bulk API response
+type bulkResponse struct {
+	Errors bool                          `json:"errors"`
+	Items  []map[string]bulkResponseItem `json:"items"`
+}
+
+type bulkResponseItem struct {
+	Status int                 `json:"status"`
+	Error  jsoniter.RawMessage `json:"error"`
+}
+
+// fail write document to dead letter, event is marked as not delivered
+func (o *Elasticsearch) fail(it *item, status int, reason string) {
+	it.e.Failed = true
+	if o.deadLetter == nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("index", it.index).Int("status", status).
+			Str("error", reason).Msg("document dropped")
+		return
+	}
+	if err := o.deadLetter.Write(o.cfg.Type, it.index, status, reason, it.doc); err != nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("dead_letter", o.deadLetter.Path()).
+			Err(err).Msg("dead letter write failed")
+	}
+}
+
+// request build bulk request body
+func (o *Elasticsearch) request(items []item) error {
+	o.body.Reset()
+	var w io.Writer = &o.body
+	if o.zw != nil {
+		o.zw.Reset(&o.body)
+		w = o.zw
+	}
+	var b []byte
+	for i := range items {
+		b = append(b[:0], `{"`...)
+		b = append(b, o.cfg.Action...)
+		b = append(b, `":{"_index":`...)
+		b = strconv.AppendQuote(b, items[i].index)
+		b = append(b, "}}\n"...)
+		b = append(b, items[i].doc...)
+		b = append(b, '\n')
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	if o.zw != nil {
+		return o.zw.Close()
+	}
+	return nil
+}
+
+// bulk send bulk request, return documents for retry (failed documents writed to dead letter)
+func (o *Elasticsearch) bulk(items []item) ([]item, error) {
+	if err := o.request(items); err != nil {
+		return items, err
+	}
+	host := o.cfg.Hosts[o.host]
+	req, err := http.NewRequest(http.MethodPost, host+"/_bulk", bytes.NewReader(o.body.Bytes()))
+	if err != nil {
+		return items, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if o.zw != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if o.cfg.Username != "" {
+		req.SetBasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		// try next host
+		o.host = (o.host + 1) % len(o.cfg.Hosts)
+		return items, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return items, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			o.host = (o.host + 1) % len(o.cfg.Hosts)
+		}
+		return items, fmt.Errorf("%s: %s", resp.Status, body)
+	}
+	if resp.StatusCode >= 300 {
+		// request rejected, retry not helped
+		for i := range items {
+			o.fail(&items[i], resp.StatusCode, string(body))
+		}
+		return nil, nil
+	}
+
+	var r bulkResponse
+	if err = json.Unmarshal(body, &r); err != nil {
+		return items, err
+	}
+	if !r.Errors {
+		return nil, nil
+	}
+	if len(r.Items) != len(items) {
+		return items, fmt.Errorf("bulk response items mismatch: %d, want %d", len(r.Items), len(items))
+	}
+
+	var retry []item
+	for i, result := range r.Items {
+		for _, it := range result {
+			if it.Status == http.StatusTooManyRequests || it.Status >= 500 {
+				retry = append(retry, items[i])
+			} else if it.Status >= 300 {
+				o.fail(&items[i], it.Status, string(it.Error))
+			}
+		}
+	}
+	return retry, nil
+}
+
+// flush send batch, failed documents retried with backoff
+func (o *Elasticsearch) flush(items []item) {
+	var err error
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(output.Backoff(attempt-1, o.cfg.RetryBackoff, o.cfg.MaxRetryBackoff))
+		}
+		n := len(items)
+		if items, err = o.bulk(items); err != nil {
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("documents", n).Int("attempt", attempt).
+				Err(err).Msg("bulk failed")
+		} else if len(items) > 0 {
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("documents", n).Int("retry", len(items)).
+				Int("attempt", attempt).Msg("bulk partially failed")
+		}
+		if attempt >= o.cfg.MaxRetries {
+			reason := "max retries exceeded"
+			if err != nil {
+				reason += ": " + err.Error()
+			}
+			for i := range items {
+				o.fail(&items[i], 0, reason)
+			}
+			break
+		}
+	}
+}
+
+// release return batch events to out channel (after flush)
+func release(items []item, outChan chan<- *event.Event) {
+	for i := range items {
+		outChan <- items[i].e
+		items[i].e = nil
+	}
+}
+
+func (o *Elasticsearch) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	var (
+		items []item
+		size  int64
+		index []byte
+	)
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				if len(items) > 0 {
+					o.flush(items)
+					release(items, outChan)
+				}
+				if o.deadLetter != nil {
+					o.deadLetter.Close()
+				}
+				return nil
+			}
+			doc, err := output.Document(e)
+			if err != nil {
+				log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("serialize")
+				outChan <- e
+				continue
+			}
+			index, _ = o.index.Append(index[:0], e)
+			items = append(items, item{index: string(index), doc: doc, e: e})
+			size += int64(len(doc) + len(index) + 32)
+			if len(items) >= o.cfg.BatchSize || size >= o.cfg.BatchBytes.Value() {
+				o.flush(items)
+				release(items, outChan)
+				items = items[:0]
+				size = 0
+			}
+		case <-ticker.C:
+			if len(items) > 0 {
+				o.flush(items)
+				release(items, outChan)
+				items = items[:0]
+				size = 0
+			}
+		}
+	}
+}
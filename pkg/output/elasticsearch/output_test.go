@@ -0,0 +1,246 @@
+package elasticsearch_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "elasticsearch"}},
+		{name: "invalid host", cfg: config.ConfigRaw{"type": "elasticsearch", "hosts": []string{"127.0.0.1:9200"}}, wantErr: true},
+		{name: "invalid index", cfg: config.ConfigRaw{"type": "elasticsearch", "index": "logs-%{+2006"}, wantErr: true},
+		{name: "invalid action", cfg: config.ConfigRaw{"type": "elasticsearch", "action": "update"}, wantErr: true},
+		{name: "invalid batch_size", cfg: config.ConfigRaw{"type": "elasticsearch", "batch_size": 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+type bulkItem struct {
+	action map[string]map[string]string
+	doc    map[string]interface{}
+}
+
+// bulkServer mimics bulk API, response status for documents returned by status func (by message and request number)
+type bulkServer struct {
+	t      *testing.T
+	status func(n int, message string) int
+
+	mu       sync.Mutex
+	requests int
+	indexed  map[string]string // message -> index
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	var items []bulkItem
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var it bulkItem
+		if err := jsoniter.Unmarshal(scanner.Bytes(), &it.action); err != nil {
+			s.t.Errorf("bulk action: %v", err)
+		}
+		if !scanner.Scan() {
+			s.t.Errorf("bulk document not found")
+			break
+		}
+		if err := jsoniter.Unmarshal(scanner.Bytes(), &it.doc); err != nil {
+			s.t.Errorf("bulk document: %v", err)
+		}
+		items = append(items, it)
+	}
+
+	errors := false
+	resp := make([]map[string]map[string]interface{}, 0, len(items))
+	for _, it := range items {
+		message, _ := it.doc["message"].(string)
+		status := s.status(s.requests, message)
+		result := map[string]interface{}{"status": status}
+		if status < 300 {
+			s.indexed[message] = it.action["index"]["_index"]
+		} else {
+			errors = true
+			result["error"] = map[string]interface{}{"type": "error_" + strconv.Itoa(status)}
+		}
+		resp = append(resp, map[string]map[string]interface{}{"index": result})
+	}
+	data, _ := jsoniter.Marshal(map[string]interface{}{"errors": errors, "items": resp})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func TestElasticsearch(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	var events []*event.Event
+	for _, message := range []string{"test 1", "bad", "busy", "test 2", "unavailable"} {
+		events = append(events, &event.Event{
+			Timestamp: ts,
+			Fields:    map[string]interface{}{"app": "web", "message": message},
+		})
+	}
+
+	s := &bulkServer{
+		t: t,
+		status: func(n int, message string) int {
+			switch message {
+			case "bad":
+				return http.StatusBadRequest
+			case "busy":
+				if n == 2 {
+					return http.StatusTooManyRequests
+				}
+			case "unavailable":
+				return http.StatusServiceUnavailable
+			}
+			return http.StatusCreated
+		},
+		indexed: make(map[string]string),
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead_letter.json")
+	cfg := config.ConfigRaw{
+		"type":             "elasticsearch",
+		"hosts":            []string{srv.URL},
+		"index":            "logs-%{app}-%{+2006.01.02}",
+		"username":         "user",
+		"password":         "secret",
+		"gzip":             true,
+		"max_retries":      3,
+		"retry_backoff":    time.Millisecond,
+		"dead_letter_path": deadLetter,
+	}
+	out, err := output.New(&cfg, &config.Common{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	inChan := make(chan *event.Event, len(events))
+	outChan := make(chan *event.Event, len(events))
+	for _, e := range events {
+		inChan <- e
+	}
+	close(inChan)
+
+	if err = out.Start(inChan, outChan); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if len(outChan) != len(events) {
+		t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+	}
+
+	wantIndexed := map[string]string{
+		"test 1": "logs-web-2021.03.04",
+		"test 2": "logs-web-2021.03.04",
+		"busy":   "logs-web-2021.03.04",
+	}
+	if len(s.indexed) != len(wantIndexed) {
+		t.Errorf("indexed = %v, want %v", s.indexed, wantIndexed)
+	}
+	for message, index := range wantIndexed {
+		if s.indexed[message] != index {
+			t.Errorf("indexed[%q] = %q, want %q", message, s.indexed[message], index)
+		}
+	}
+	// first request and 3 retries (503 for request, 429 for busy, 503 for unavailable)
+	if s.requests != 4 {
+		t.Errorf("requests = %d, want 4", s.requests)
+	}
+
+	data, err := os.ReadFile(deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var failed []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		var rec struct {
+			Target   string                 `json:"target"`
+			Status   int                    `json:"status"`
+			Error    string                 `json:"error"`
+			Document map[string]interface{} `json:"document"`
+		}
+		if err = jsoniter.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("dead letter %q: %v", line, err)
+		}
+		failed = append(failed, rec.Document["message"].(string)+" "+strconv.Itoa(rec.Status)+" "+rec.Error+" "+rec.Target)
+		if rec.Document["@timestamp"] != "2021-03-04T05:06:07Z" {
+			t.Errorf("dead letter document @timestamp = %v", rec.Document["@timestamp"])
+		}
+	}
+	sort.Strings(failed)
+	want := []string{
+		`bad 400 {"type":"error_400"} logs-web-2021.03.04`,
+		`unavailable 0 max retries exceeded logs-web-2021.03.04`,
+	}
+	if strings.Join(failed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dead letter =\n%s\nwant\n%s", strings.Join(failed, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
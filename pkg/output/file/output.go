@@ -0,0 +1,397 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "file"
+
+// rotated file suffix (rotation time)
+const rotateLayout = "20060102T150405.000000000"
+
+type Config struct {
+	output.Config
+
+	Path     string        `hcl:"path" yaml:"path" json:"path"`             // path template, like "/data/%{host}/%{+2006-01-02}.log"
+	Format   output.Format `hcl:"format" yaml:"format" json:"format"`       // json (default), rubydebug, line (message field) or template
+	Template string        `hcl:"template" yaml:"template" json:"template"` // template for template format
+	Perm     string        `hcl:"perm" yaml:"perm" json:"perm"`             // created files permissions (octal, default 0644)
+	DirPerm  string        `hcl:"dir_perm" yaml:"dir_perm" json:"dir_perm"` // created dirs permissions (octal, default 0755)
+
+	BufferSize    config.Size   `hcl:"buffer_size" yaml:"buffer_size" json:"buffer_size"`          // write buffer size (per file)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // buffers flush interval
+	FsyncInterval time.Duration `hcl:"fsync_interval" yaml:"fsync_interval" json:"fsync_interval"` // fsync interval (0 - disabled)
+
+	RotateSize     config.Size   `hcl:"rotate_size" yaml:"rotate_size" json:"rotate_size"`             // rotate file, when size reached (0 - disabled)
+	RotateInterval time.Duration `hcl:"rotate_interval" yaml:"rotate_interval" json:"rotate_interval"` // rotate file, opened for interval (0 - disabled)
+	Compress       bool          `hcl:"compress" yaml:"compress" json:"compress"`                      // gzip rotated files
+
+	MaxOpenFiles int           `hcl:"max_open_files" yaml:"max_open_files" json:"max_open_files"` // max opened files (least recently written closed)
+	IdleTimeout  time.Duration `hcl:"idle_timeout" yaml:"idle_timeout" json:"idle_timeout"`       // close files, not written for timeout
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:        output.Config{Type: Name},
+		Perm:          "0644",
+		DirPerm:       "0755",
+		BufferSize:    config.Size(64 * 1024),
+		FlushInterval: time.Second,
+		MaxOpenFiles:  256,
+		IdleTimeout:   5 * time.Minute,
+	}
+}
+
+// outFile is an opened output file
+type outFile struct {
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+	last   time.Time // last write
+	synced bool
+
+	events []*event.Event // written events, hold until flush (and fsync, if enabled)
+}
+
+// File is output for write events to files (path is a template with event fields and timestamp).
+// Path separators in substituted field values and "." or ".." values are replaced with '_', so files are created under template dirs.
+//
+// Events are returned after buffer flush (and fsync, if enabled), events with failed write or flush are marked as failed.
+// Events with format error are dropped (returned as delivered).
+type File struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	path      *output.Template
+	formatter *output.Formatter
+	perm      os.FileMode
+	dirPerm   os.FileMode
+
+	files     map[string]*outFile
+	processed []*event.Event // released events, returned to out channel
+	lastSync  time.Time
+	wg        sync.WaitGroup // compress goroutines
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &File{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+		files:  make(map[string]*outFile),
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if o.cfg.Path == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': path not set")
+	}
+	if o.path, err = output.NewTemplate(o.cfg.Path); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.formatter, err = output.NewFormatter(o.cfg.Format, o.cfg.Template); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+
+	if perm, err := strconv.ParseUint(o.cfg.Perm, 8, 32); err != nil || perm > 0777 {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid perm " + o.cfg.Perm)
+	} else {
+		o.perm = os.FileMode(perm)
+	}
+	if perm, err := strconv.ParseUint(o.cfg.DirPerm, 8, 32); err != nil || perm > 0777 {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid dir_perm " + o.cfg.DirPerm)
+	} else {
+		o.dirPerm = os.FileMode(perm)
+	}
+
+	if o.cfg.BufferSize.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': buffer_size must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.FsyncInterval < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': fsync_interval must be >= 0")
+	}
+	if o.cfg.RotateSize.Value() < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': rotate_size must be >= 0")
+	}
+	if o.cfg.RotateInterval < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': rotate_interval must be >= 0")
+	}
+	if o.cfg.MaxOpenFiles < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_open_files must be > 0")
+	}
+	if o.cfg.IdleTimeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': idle_timeout must be > 0")
+	}
+
+	return o, nil
+}
+
+func (o *File) Name() string {
+	return Name
+}
+
+func (o *File) Acknowledging() {}
+
+// open return opened file (from cache or open new)
+func (o *File) open(path string, now time.Time) (*outFile, error) {
+	if f, ok := o.files[path]; ok {
+		return f, nil
+	}
+
+	if len(o.files) >= o.cfg.MaxOpenFiles {
+		// close least recently written file
+		var lru *outFile
+		for _, f := range o.files {
+			if lru == nil || f.last.Before(lru.last) {
+				lru = f
+			}
+		}
+		o.close(lru)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), o.dirPerm); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, o.perm)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	of := &outFile{
+		path:   path,
+		f:      f,
+		w:      bufio.NewWriterSize(f, int(o.cfg.BufferSize.Value())),
+		size:   fi.Size(),
+		opened: now,
+		last:   now,
+		synced: true,
+	}
+	o.files[path] = of
+
+	return of, nil
+}
+
+// close flush and close file, file removed from cache
+func (o *File) close(f *outFile) {
+	delete(o.files, f.path)
+	err := f.w.Flush()
+	if o.cfg.FsyncInterval > 0 && err == nil && !f.synced {
+		err = f.f.Sync()
+	}
+	if cerr := f.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", f.path).Err(err).Msg("close failed")
+	}
+	o.release(f, err != nil)
+}
+
+// release file written events
+func (o *File) release(f *outFile, failed bool) {
+	for i, e := range f.events {
+		if failed {
+			e.Failed = true
+		}
+		o.processed = append(o.processed, e)
+		f.events[i] = nil
+	}
+	f.events = f.events[:0]
+}
+
+// returns send released events to out channel
+func (o *File) returns(outChan chan<- *event.Event) {
+	for i, e := range o.processed {
+		outChan <- e
+		o.processed[i] = nil
+	}
+	o.processed = o.processed[:0]
+}
+
+// rotate close file and rename it (and compress in background, if enabled)
+func (o *File) rotate(f *outFile, now time.Time) {
+	o.close(f)
+	rotated := f.path + "." + now.UTC().Format(rotateLayout)
+	if err := os.Rename(f.path, rotated); err != nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", f.path).Err(err).Msg("rotate failed")
+		return
+	}
+	log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", f.path).Str("rotated", rotated).Msg("rotate")
+	if o.cfg.Compress {
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			if err := compress(rotated, o.perm); err != nil {
+				log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", rotated).Err(err).Msg("compress failed")
+			}
+		}()
+	}
+}
+
+// compress write path to path.gz and remove path
+func compress(path string, perm os.FileMode) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gzPath := path + ".gz"
+	out, err := os.OpenFile(gzPath+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err = io.Copy(zw, in); err == nil {
+		if err = zw.Close(); err == nil {
+			err = out.Sync()
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(gzPath+".tmp", gzPath)
+	}
+	if err != nil {
+		os.Remove(gzPath + ".tmp")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// escapePath replace path separators in substituted field value, "." and ".." values also replaced (no parent dir traversal)
+func escapePath(v []byte) {
+	for i, c := range v {
+		if c == '/' || c == 0 {
+			v[i] = '_'
+		}
+	}
+	if s := string(v); s == "." || s == ".." {
+		for i := range v {
+			v[i] = '_'
+		}
+	}
+}
+
+// write serialized event to file, event is hold until flush
+func (o *File) write(path string, b []byte, e *event.Event, now time.Time) error {
+	f, err := o.open(path, now)
+	if err != nil {
+		return err
+	}
+	if (o.cfg.RotateSize > 0 && f.size > 0 && f.size+int64(len(b)) > o.cfg.RotateSize.Value()) ||
+		(o.cfg.RotateInterval > 0 && now.Sub(f.opened) >= o.cfg.RotateInterval) {
+		o.rotate(f, now)
+		if f, err = o.open(path, now); err != nil {
+			return err
+		}
+	}
+	if _, err = f.w.Write(b); err != nil {
+		o.close(f)
+		return err
+	}
+	f.size += int64(len(b))
+	f.last = now
+	f.synced = false
+	f.events = append(f.events, e)
+
+	return nil
+}
+
+// maintain flush buffers, fsync, close idle and rotate expired files
+func (o *File) maintain(now time.Time) {
+	fsync := o.cfg.FsyncInterval > 0 && now.Sub(o.lastSync) >= o.cfg.FsyncInterval
+	if fsync {
+		o.lastSync = now
+	}
+	for _, f := range o.files {
+		if now.Sub(f.last) >= o.cfg.IdleTimeout {
+			o.close(f)
+			continue
+		}
+		if o.cfg.RotateInterval > 0 && now.Sub(f.opened) >= o.cfg.RotateInterval {
+			o.rotate(f, now)
+			continue
+		}
+		err := f.w.Flush()
+		if err == nil && fsync && !f.synced {
+			if err = f.f.Sync(); err == nil {
+				f.synced = true
+			}
+		}
+		if err != nil {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", f.path).Err(err).Msg("write failed")
+			o.close(f)
+		} else if o.cfg.FsyncInterval == 0 || f.synced {
+			o.release(f, false)
+		}
+	}
+}
+
+func (o *File) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	var (
+		b    []byte
+		path []byte
+		err  error
+	)
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+	o.lastSync = time.Now()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				for _, f := range o.files {
+					o.close(f)
+				}
+				o.returns(outChan)
+				o.wg.Wait()
+				return nil
+			}
+			if b, err = o.formatter.Append(b[:0], e); err == nil {
+				path, _ = o.path.AppendEscaped(path[:0], e, escapePath)
+				if err = o.write(string(path), b, e, time.Now()); err != nil {
+					log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("path", string(path)).Err(err).Msg("write failed")
+					e.Failed = true
+					o.processed = append(o.processed, e)
+				}
+			} else {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("format failed, event dropped")
+				o.processed = append(o.processed, e)
+			}
+			o.returns(outChan)
+		case now := <-ticker.C:
+			o.maintain(now)
+			o.returns(outChan)
+		}
+	}
+}
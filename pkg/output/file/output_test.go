@@ -0,0 +1,228 @@
+package file_test
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "file", "path": "/tmp/%{host}.log"}},
+		{name: "path not set", cfg: config.ConfigRaw{"type": "file"}, wantErr: true},
+		{name: "invalid path", cfg: config.ConfigRaw{"type": "file", "path": "/tmp/%{host.log"}, wantErr: true},
+		{name: "invalid perm", cfg: config.ConfigRaw{"type": "file", "path": "/tmp/test.log", "perm": "0999"}, wantErr: true},
+		{name: "invalid max_open_files", cfg: config.ConfigRaw{"type": "file", "path": "/tmp/test.log", "max_open_files": 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// readFiles return content of files in dir (gzipped files decompressed), rotated files are joined in write order
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rotated files names has a time suffix, so sort is a write order (current file is last)
+	sort.Slice(paths, func(i, j int) bool {
+		if strings.HasPrefix(paths[j], paths[i]+".") {
+			return false
+		}
+		if strings.HasPrefix(paths[i], paths[j]+".") {
+			return true
+		}
+		return paths[i] < paths[j]
+	})
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		name := strings.TrimPrefix(path, dir+"/")
+		if strings.HasSuffix(path, ".gz") {
+			if r, err = gzip.NewReader(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		data, err := io.ReadAll(r)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Index(name, ".log."); n != -1 {
+			name = name[:n+4]
+		}
+		files[name] += string(data)
+	}
+	return files
+}
+
+func TestFile(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	events := make([]*event.Event, 0, 21)
+	for i := 0; i < 20; i++ {
+		events = append(events, &event.Event{
+			Timestamp: ts,
+			Fields:    map[string]interface{}{"host": "host" + strconv.Itoa(i%2), "message": "test " + strconv.Itoa(i)},
+		})
+	}
+	// without message, dropped by line format (but not failed)
+	events = append(events, &event.Event{Timestamp: ts, Fields: map[string]interface{}{"host": "host0"}})
+	want := map[string]string{"host0/2021-03-04.log": "", "host1/2021-03-04.log": ""}
+	for i := 0; i < 20; i++ {
+		want["host"+strconv.Itoa(i%2)+"/2021-03-04.log"] += "test " + strconv.Itoa(i) + "\n"
+	}
+
+	tests := []struct {
+		name        string
+		cfg         config.ConfigRaw
+		wantRotated bool
+	}{
+		{name: "write", cfg: config.ConfigRaw{}},
+		{name: "one opened file", cfg: config.ConfigRaw{"max_open_files": 1}},
+		{name: "rotate", cfg: config.ConfigRaw{"rotate_size": "20", "fsync_interval": time.Millisecond}, wantRotated: true},
+		{name: "rotate with compress", cfg: config.ConfigRaw{"rotate_size": "20", "compress": true}, wantRotated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := config.ConfigRaw{
+				"type": "file", "path": dir + "/%{host}/%{+2006-01-02}.log", "format": "line",
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				inChan <- e
+			}
+			close(inChan)
+
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+			for len(outChan) > 0 {
+				if e := <-outChan; e.Failed {
+					t.Errorf("Start() returned failed event %s", event.String(e))
+				}
+			}
+
+			got := readFiles(t, dir)
+			for name, s := range want {
+				if got[name] != s {
+					t.Errorf("%s =\n%s\nwant\n%s", name, got[name], s)
+				}
+			}
+			if len(got) != len(want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+
+			matches, _ := filepath.Glob(dir + "/host0/2021-03-04.log.*")
+			if tt.wantRotated {
+				if len(matches) == 0 {
+					t.Errorf("rotated files not found")
+				}
+				compress := tt.cfg["compress"] == true
+				for _, path := range matches {
+					if strings.HasSuffix(path, ".gz") != compress {
+						t.Errorf("rotated file %s, compress %v", path, compress)
+					}
+				}
+			} else if len(matches) > 0 {
+				t.Errorf("unexpected rotated files: %v", matches)
+			}
+		})
+	}
+}
+
+func TestFileEscapePath(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.ConfigRaw{"type": "file", "path": dir + "/logs/%{app}/%{host}.log", "format": "line"}
+	out, err := output.New(&cfg, &config.Common{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	events := []*event.Event{
+		{Fields: map[string]interface{}{"app": "..", "host": "..", "message": "parent"}},
+		{Fields: map[string]interface{}{"app": "../..", "host": "etc/passwd", "message": "traversal"}},
+		{Fields: map[string]interface{}{"app": "nginx", "host": "web.1", "message": "valid"}},
+	}
+	inChan := make(chan *event.Event, len(events))
+	outChan := make(chan *event.Event, len(events))
+	for _, e := range events {
+		inChan <- e
+	}
+	close(inChan)
+	if err = out.Start(inChan, outChan); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	want := map[string]string{
+		"logs/__/__.log":            "parent\n",
+		"logs/.._../etc_passwd.log": "traversal\n",
+		"logs/nginx/web.1.log":      "valid\n",
+	}
+	got := readFiles(t, dir)
+	if len(got) != len(want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	for name, s := range want {
+		if got[name] != s {
+			t.Errorf("%s = %q, want %q", name, got[name], s)
+		}
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
@@ -0,0 +1,206 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/event"
+)
+
+var ErrNoMessage = errors.New("message field not found")
+
+// json with sorted map keys
+var json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+type Format int8
+
+const (
+	FormatJSON Format = iota
+	FormatRubydebug
+	FormatLine
+	FormatTemplate
+)
+
+var formatStrings []string = []string{"json", "rubydebug", "line", "template"}
+
+func (f *Format) Set(value string) error {
+	switch value {
+	case "json", "":
+		*f = FormatJSON
+	case "rubydebug":
+		*f = FormatRubydebug
+	case "line":
+		*f = FormatLine
+	case "template":
+		*f = FormatTemplate
+	default:
+		return fmt.Errorf("invalid format %s", value)
+	}
+	return nil
+}
+
+func (f *Format) String() string {
+	return formatStrings[*f]
+}
+
+func (f *Format) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+// Formatter serialize events in format:
+//
+// json - event fields (and tags list, if not empty) as json object, one per line
+//
+// rubydebug - pretty printed event fields, like logstash rubydebug codec
+//
+// line - message field, one per line
+//
+// template - executed template, one per line
+type Formatter struct {
+	format Format
+	tpl    *Template
+}
+
+func NewFormatter(format Format, template string) (*Formatter, error) {
+	f := &Formatter{format: format}
+	if format == FormatTemplate {
+		if template == "" {
+			return nil, errors.New("template not set")
+		}
+		var err error
+		if f.tpl, err = NewTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Fields return event fields with tags (as sorted list, if not empty)
+func Fields(e *event.Event) map[string]interface{} {
+	if len(e.Tags) == 0 {
+		return e.Fields
+	}
+	fields := make(map[string]interface{}, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		fields[k] = v
+	}
+	fields["tags"] = Tags(e)
+	return fields
+}
+
+// Tags return sorted event tags
+func Tags(e *event.Event) []string {
+	tags := make([]string, 0, len(e.Tags))
+	for tag := range e.Tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Document serialize event fields (with tags and @timestamp, if not set) as json document
+func Document(e *event.Event) ([]byte, error) {
+	fields := Fields(e)
+	if _, ok := fields["@timestamp"]; !ok {
+		doc := make(map[string]interface{}, len(fields)+1)
+		for k, v := range fields {
+			doc[k] = v
+		}
+		doc["@timestamp"] = e.Timestamp.UTC().Format(time.RFC3339Nano)
+		fields = doc
+	}
+	return json.Marshal(fields)
+}
+
+// Append append serialized event (with new line) to b
+func (f *Formatter) Append(b []byte, e *event.Event) ([]byte, error) {
+	switch f.format {
+	case FormatRubydebug:
+		b = appendRuby(b, Fields(e), 0)
+	case FormatLine:
+		v, ok := e.Fields["message"]
+		if !ok {
+			return b, ErrNoMessage
+		}
+		b = AppendValue(b, v)
+	case FormatTemplate:
+		b, _ = f.tpl.Append(b, e)
+	default:
+		data, err := json.Marshal(Fields(e))
+		if err != nil {
+			return b, err
+		}
+		b = append(b, data...)
+	}
+	return append(b, '\n'), nil
+}
+
+func appendIndent(b []byte, indent int) []byte {
+	for i := 0; i < indent; i++ {
+		b = append(b, ' ')
+	}
+	return b
+}
+
+// appendRuby append value in rubydebug style
+func appendRuby(b []byte, v interface{}, indent int) []byte {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(n))
+		width := 0
+		for k := range n {
+			keys = append(keys, k)
+			if len(k) > width {
+				width = len(k)
+			}
+		}
+		sort.Strings(keys)
+		b = append(b, "{\n"...)
+		for _, k := range keys {
+			b = appendIndent(b, indent+4+width-len(k))
+			b = strconv.AppendQuote(b, k)
+			b = append(b, " => "...)
+			b = appendRuby(b, n[k], indent+4)
+			b = append(b, ",\n"...)
+		}
+		b = appendIndent(b, indent)
+		b = append(b, '}')
+	case []string:
+		b = append(b, "[\n"...)
+		for i, s := range n {
+			b = appendIndent(b, indent+4)
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(i), 10)
+			b = append(b, "] "...)
+			b = strconv.AppendQuote(b, s)
+			b = append(b, ",\n"...)
+		}
+		b = appendIndent(b, indent)
+		b = append(b, ']')
+	case []interface{}:
+		b = append(b, "[\n"...)
+		for i, s := range n {
+			b = appendIndent(b, indent+4)
+			b = append(b, '[')
+			b = strconv.AppendInt(b, int64(i), 10)
+			b = append(b, "] "...)
+			b = appendRuby(b, s, indent+4)
+			b = append(b, ",\n"...)
+		}
+		b = appendIndent(b, indent)
+		b = append(b, ']')
+	case string:
+		b = strconv.AppendQuote(b, n)
+	case time.Time:
+		b = append(b, n.UTC().Format(time.RFC3339Nano)...)
+	case nil:
+		b = append(b, "nil"...)
+	default:
+		b = AppendValue(b, v)
+	}
+	return b
+}
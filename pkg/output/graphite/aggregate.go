@@ -0,0 +1,153 @@
+package graphite
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type aggKind int8
+
+const (
+	aggSum aggKind = iota
+	aggCount
+	aggMin
+	aggMax
+	aggAvg
+	aggLast
+	aggPercentile
+)
+
+// aggregation is a parsed aggregation (sum, count, min, max, avg, last or percentile, like p50, p99.9)
+type aggregation struct {
+	name       string
+	kind       aggKind
+	percentile float64
+}
+
+func parseAggregation(s string) (aggregation, error) {
+	a := aggregation{name: s}
+	switch s {
+	case "sum":
+		a.kind = aggSum
+	case "count":
+		a.kind = aggCount
+	case "min":
+		a.kind = aggMin
+	case "max":
+		a.kind = aggMax
+	case "avg":
+		a.kind = aggAvg
+	case "last":
+		a.kind = aggLast
+	default:
+		if !strings.HasPrefix(s, "p") {
+			return a, errors.New("invalid aggregation " + s)
+		}
+		p, err := strconv.ParseFloat(s[1:], 64)
+		if err != nil || p <= 0 || p > 100 {
+			return a, errors.New("invalid aggregation " + s)
+		}
+		a.kind = aggPercentile
+		a.percentile = p
+		// graphite path node can't contain dot
+		a.name = strings.ReplaceAll(s, ".", "_")
+	}
+	return a, nil
+}
+
+// seriesKey is a aggregated metric path in window
+type seriesKey struct {
+	path string
+	ts   int64 // window start (unix timestamp)
+}
+
+// series is a aggregated values in window
+type series struct {
+	metric *metric
+
+	count   int64
+	sum     float64
+	min     float64
+	max     float64
+	last    float64
+	samples []float64 // values for percentiles (reservoir sample, if max_samples reached)
+
+	sent    bool      // sended and not updated after send
+	updated time.Time // last update
+}
+
+func (s *series) add(v float64, maxSamples int) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.last = v
+	if s.metric.percentiles {
+		if len(s.samples) < maxSamples {
+			s.samples = append(s.samples, v)
+		} else if n := rand.Int63n(s.count); n < int64(maxSamples) {
+			s.samples[n] = v
+		}
+	}
+}
+
+// value return aggregated value (samples must be sorted for percentiles)
+func (s *series) value(a *aggregation) float64 {
+	switch a.kind {
+	case aggCount:
+		return float64(s.count)
+	case aggMin:
+		return s.min
+	case aggMax:
+		return s.max
+	case aggAvg:
+		return s.sum / float64(s.count)
+	case aggLast:
+		return s.last
+	case aggPercentile:
+		// nearest rank
+		n := int(math.Ceil(a.percentile/100*float64(len(s.samples)))) - 1
+		if n < 0 {
+			n = 0
+		}
+		return s.samples[n]
+	default:
+		return s.sum
+	}
+}
+
+// appendLines append carbon lines for all aggregations
+func (s *series) appendLines(b []byte, key *seriesKey) []byte {
+	if s.metric.percentiles {
+		sort.Float64s(s.samples)
+	}
+	suffix := len(s.metric.aggregations) > 1
+	for i := range s.metric.aggregations {
+		a := &s.metric.aggregations[i]
+		b = append(b, key.path...)
+		if suffix {
+			b = append(b, '.')
+			b = append(b, a.name...)
+		}
+		b = appendLine(b, s.value(a), key.ts)
+	}
+	return b
+}
+
+// appendLine append " value timestamp\n"
+func appendLine(b []byte, v float64, ts int64) []byte {
+	b = append(b, ' ')
+	b = strconv.AppendFloat(b, v, 'f', -1, 64)
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts, 10)
+	return append(b, '\n')
+}
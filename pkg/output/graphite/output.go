@@ -0,0 +1,334 @@
+package graphite
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "graphite"
+
+// max udp packet payload (without fragmentation on typical MTU)
+const udpPacketSize = 1432
+
+// Metric is a metric, derived from events
+type Metric struct {
+	Path         string   `hcl:"path" yaml:"path" json:"path"`                         // metric path template, like nginx.%{host}.status.%{status}
+	Field        string   `hcl:"field" yaml:"field" json:"field"`                      // numeric field for value (events count, if not set)
+	Aggregations []string `hcl:"aggregations" yaml:"aggregations" json:"aggregations"` // sum, count, min, max, avg, last, pN (percentile, like p99), default is count (for events count) or avg
+}
+
+type Config struct {
+	output.Config
+
+	Address  string   `hcl:"address" yaml:"address" json:"address"`    // carbon address
+	Protocol string   `hcl:"protocol" yaml:"protocol" json:"protocol"` // tcp (default) or udp
+	Prefix   string   `hcl:"prefix" yaml:"prefix" json:"prefix"`       // prefix for all metrics
+	Metrics  []Metric `hcl:"metrics" yaml:"metrics" json:"metrics"`
+
+	Window        time.Duration `hcl:"window" yaml:"window" json:"window"`                         // aggregation window (by event timestamp), 0 - send every value without aggregation
+	Lateness      time.Duration `hcl:"lateness" yaml:"lateness" json:"lateness"`                   // keep sended windows for late events (window resended with late values) until not updated for lateness
+	MaxSeries     int           `hcl:"max_series" yaml:"max_series" json:"max_series"`             // max aggregated series (paths in window), new series dropped
+	MaxSamples    int           `hcl:"max_samples" yaml:"max_samples" json:"max_samples"`          // max samples for percentiles in series (reservoir sampling)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // send interval
+	Timeout       time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                      // connect and write timeout
+	BufferSize    config.Size   `hcl:"buffer_size" yaml:"buffer_size" json:"buffer_size"`          // max buffered (not sended) size, new lines dropped on overflow
+
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial reconnect delay (doubled on every failure)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max reconnect delay
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		Address:         "127.0.0.1:2003",
+		Protocol:        "tcp",
+		Window:          time.Minute,
+		Lateness:        time.Minute,
+		MaxSeries:       100000,
+		MaxSamples:      1000,
+		FlushInterval:   time.Second,
+		Timeout:         5 * time.Second,
+		BufferSize:      config.Size(10 * 1024 * 1024),
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 30 * time.Second,
+	}
+}
+
+// metric is a parsed metric config
+type metric struct {
+	path         *output.Template
+	field        []string
+	aggregations []aggregation
+	percentiles  bool
+}
+
+// Graphite is output for send metrics, derived from events, to carbon with plaintext protocol (path value timestamp).
+//
+// Metric value is a numeric field value or 1 (for events count). Values are aggregated in window (by event timestamp),
+// series are sended after window end (with window start timestamp). Sended series are kept until lateness expired,
+// so late events update series and it resended with full aggregate (carbon keep last value for timestamp).
+// If metric has several aggregations, aggregation name is added to path (like nginx.host.upstream_time.p99).
+// Dots in substituted field values are replaced with '_' (graphite path node can't contain dot).
+type Graphite struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	metrics []metric
+	window  int64 // seconds
+	series  map[seriesKey]*series
+	sender  *output.Sender
+
+	overflowed bool
+	path       []byte
+	line       []byte
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Graphite{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+		series: make(map[seriesKey]*series),
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if o.cfg.Address == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': address not set")
+	}
+	if o.cfg.Protocol != "tcp" && o.cfg.Protocol != "udp" {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid protocol " + o.cfg.Protocol)
+	}
+	if o.cfg.Window < 0 || o.cfg.Window%time.Second != 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': window must be >= 0 and in seconds")
+	}
+	o.window = int64(o.cfg.Window / time.Second)
+	if o.cfg.Lateness < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': lateness must be >= 0")
+	}
+	if len(o.cfg.Metrics) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': metrics not set")
+	}
+	for i := range o.cfg.Metrics {
+		m, err := o.newMetric(&o.cfg.Metrics[i])
+		if err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': metric " + o.cfg.Metrics[i].Path + ": " + err.Error())
+		}
+		o.metrics = append(o.metrics, m)
+	}
+	if o.cfg.MaxSeries < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_series must be > 0")
+	}
+	if o.cfg.MaxSamples < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_samples must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.BufferSize.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': buffer_size must be > 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	o.sender = &output.Sender{
+		Network:    o.cfg.Protocol,
+		Address:    o.cfg.Address,
+		Timeout:    o.cfg.Timeout,
+		Max:        int(o.cfg.BufferSize.Value()),
+		Packet:     udpPacketSize,
+		MinBackoff: o.cfg.RetryBackoff,
+		MaxBackoff: o.cfg.MaxRetryBackoff,
+	}
+
+	return o, nil
+}
+
+func (o *Graphite) newMetric(cfg *Metric) (metric, error) {
+	var (
+		m   metric
+		err error
+	)
+	if cfg.Path == "" {
+		return m, errors.New("path not set")
+	}
+	path := cfg.Path
+	if o.cfg.Prefix != "" {
+		path = o.cfg.Prefix + "." + path
+	}
+	if m.path, err = output.NewTemplate(path); err != nil {
+		return m, err
+	}
+	if cfg.Field != "" {
+		m.field = strings.Split(cfg.Field, ".")
+	}
+	aggregations := cfg.Aggregations
+	if len(aggregations) == 0 {
+		if cfg.Field == "" {
+			aggregations = []string{"count"}
+		} else {
+			aggregations = []string{"avg"}
+		}
+	} else if o.window == 0 {
+		return m, errors.New("aggregations can't be used without window")
+	}
+	for _, s := range aggregations {
+		a, err := parseAggregation(s)
+		if err != nil {
+			return m, err
+		}
+		if a.kind == aggPercentile {
+			m.percentiles = true
+		}
+		m.aggregations = append(m.aggregations, a)
+	}
+	return m, nil
+}
+
+func (o *Graphite) Name() string {
+	return Name
+}
+
+// sanitize replace chars, not allowed in plaintext protocol path
+func sanitize(b []byte) []byte {
+	for i, c := range b {
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			b[i] = '_'
+		}
+	}
+	return b
+}
+
+// escapeNode replace dots in substituted field value (graphite path node can't contain dot)
+func escapeNode(v []byte) {
+	for i, c := range v {
+		if c == '.' {
+			v[i] = '_'
+		}
+	}
+}
+
+// add add event values to series (or sender, if window is disabled)
+func (o *Graphite) add(e *event.Event, now time.Time) {
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	for i := range o.metrics {
+		m := &o.metrics[i]
+		v := 1.0
+		if m.field != nil {
+			fv, ok := output.Lookup(e.Fields, m.field)
+			if !ok {
+				continue
+			}
+			if v, ok = output.ToFloat(fv); !ok {
+				log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Strs("field", m.field).Str("event", event.String(e)).
+					Msg("not numeric value")
+				continue
+			}
+		}
+		var found bool
+		if o.path, found = m.path.AppendEscaped(o.path[:0], e, escapeNode); !found {
+			continue
+		}
+		o.path = sanitize(o.path)
+
+		if o.window == 0 {
+			o.line = append(o.line[:0], o.path...)
+			o.line = appendLine(o.line, v, ts.Unix())
+			o.sender.Write(o.line)
+			continue
+		}
+
+		sec := ts.Unix()
+		key := seriesKey{path: string(o.path), ts: sec - sec%o.window}
+		s, ok := o.series[key]
+		if !ok {
+			if len(o.series) >= o.cfg.MaxSeries {
+				if !o.overflowed {
+					o.overflowed = true
+					log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("max_series", o.cfg.MaxSeries).
+						Msg("series limit reached, new series dropped")
+				}
+				continue
+			}
+			s = &series{metric: m}
+			o.series[key] = s
+		}
+		s.add(v, o.cfg.MaxSamples)
+		s.sent = false
+		s.updated = now
+	}
+}
+
+// aggregate write ended (or all, if force) and updated windows to sender, remove sended windows after lateness
+func (o *Graphite) aggregate(now time.Time, force bool) {
+	end := now.Unix()
+	for key, s := range o.series {
+		if !s.sent && (force || key.ts+o.window <= end) {
+			o.line = s.appendLines(o.line[:0], &key)
+			o.sender.Write(o.line)
+			s.sent = true
+		}
+		if force || (s.sent && now.Sub(s.updated) >= o.cfg.Lateness) {
+			delete(o.series, key)
+		}
+	}
+	o.overflowed = false
+}
+
+func (o *Graphite) flush(now time.Time) {
+	if _, _, err := o.sender.Flush(now); err != nil {
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("address", o.cfg.Address).Int("pending", o.sender.Pending()).
+			Err(err).Msg("send failed")
+	}
+	if n := o.sender.Dropped(); n > 0 {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("lines", n).Msg("buffer overflow, lines dropped")
+	}
+}
+
+func (o *Graphite) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				now := time.Now()
+				o.aggregate(now, true)
+				// last attempt without reconnect delay
+				o.sender.Retry()
+				o.flush(now)
+				if o.sender.Pending() > 0 {
+					log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("address", o.cfg.Address).
+						Int("pending", o.sender.Pending()).Msg("not sended on shutdown, dropped")
+				}
+				o.sender.Close()
+				return nil
+			}
+			o.add(e, time.Now())
+			outChan <- e
+		case now := <-ticker.C:
+			if o.window > 0 {
+				o.aggregate(now, false)
+			}
+			o.flush(now)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package graphite_test
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	metrics := []map[string]interface{}{{"path": "nginx.%{host}.requests"}}
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "graphite", "metrics": metrics}},
+		{name: "metrics not set", cfg: config.ConfigRaw{"type": "graphite"}, wantErr: true},
+		{name: "invalid protocol", cfg: config.ConfigRaw{"type": "graphite", "metrics": metrics, "protocol": "unix"}, wantErr: true},
+		{name: "invalid window", cfg: config.ConfigRaw{"type": "graphite", "metrics": metrics, "window": 1500 * time.Millisecond}, wantErr: true},
+		{
+			name:    "invalid aggregation",
+			cfg:     config.ConfigRaw{"type": "graphite", "metrics": []map[string]interface{}{{"path": "a", "field": "b", "aggregations": []string{"p101"}}}},
+			wantErr: true,
+		},
+		{
+			name: "aggregation without window",
+			cfg: config.ConfigRaw{
+				"type": "graphite", "window": 0,
+				"metrics": []map[string]interface{}{{"path": "a", "field": "b", "aggregations": []string{"sum"}}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// carbon is a carbon stand-in, collect received lines
+type carbon struct {
+	mu    sync.Mutex
+	lines []string
+	wg    sync.WaitGroup
+}
+
+func (c *carbon) add(line string) {
+	c.mu.Lock()
+	c.lines = append(c.lines, line)
+	c.mu.Unlock()
+}
+
+func (c *carbon) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lines := append([]string{}, c.lines...)
+	sort.Strings(lines)
+	return lines
+}
+
+func (c *carbon) listen(t *testing.T, network, address string) func() {
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			buf := make([]byte, 65536)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				for _, line := range strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n") {
+					c.add(line)
+				}
+			}
+		}()
+		return func() { conn.Close(); c.wg.Wait() }
+	}
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					c.add(scanner.Text())
+				}
+			}()
+		}
+	}()
+	return func() { ln.Close(); c.wg.Wait() }
+}
+
+func waitLines(c *carbon, n int) []string {
+	for i := 0; i < 100; i++ {
+		if lines := c.get(); len(lines) >= n {
+			return lines
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.get()
+}
+
+func TestGraphite(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC) // 1614834360
+	newEvent := func(sec int, host string, status int, upstreamTime float64) *event.Event {
+		return &event.Event{
+			Timestamp: ts.Add(time.Duration(sec) * time.Second),
+			Fields:    map[string]interface{}{"host": host, "status": status, "upstream_time": upstreamTime},
+		}
+	}
+	events := []*event.Event{
+		newEvent(1, "web 1", 200, 0.25),
+		newEvent(2, "web 1", 200, 0.5),
+		newEvent(3, "web 1", 500, 1),
+		newEvent(4, "web 1", 200, 0.25),
+		newEvent(61, "web 1", 200, 0.75),
+		{Timestamp: ts.Add(5 * time.Second), Fields: map[string]interface{}{"status": 200, "upstream_time": "-"}},
+	}
+	metrics := []map[string]interface{}{
+		{"path": "nginx.%{host}.status.%{status}"},
+		{"path": "nginx.%{host}.upstream_time", "field": "upstream_time", "aggregations": []string{"sum", "count", "min", "max", "avg", "p50", "p99.9"}},
+	}
+
+	tests := []struct {
+		name     string
+		protocol string
+		cfg      config.ConfigRaw
+		delay    time.Duration // delay before start carbon
+		want     []string
+	}{
+		{
+			name:     "aggregate",
+			protocol: "tcp",
+			cfg:      config.ConfigRaw{"metrics": metrics},
+			want: []string{
+				"test.nginx.web_1.status.200 1 1614834420",
+				"test.nginx.web_1.status.200 3 1614834360",
+				"test.nginx.web_1.status.500 1 1614834360",
+				"test.nginx.web_1.upstream_time.avg 0.5 1614834360",
+				"test.nginx.web_1.upstream_time.avg 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.count 1 1614834420",
+				"test.nginx.web_1.upstream_time.count 4 1614834360",
+				"test.nginx.web_1.upstream_time.max 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.max 1 1614834360",
+				"test.nginx.web_1.upstream_time.min 0.25 1614834360",
+				"test.nginx.web_1.upstream_time.min 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.p50 0.25 1614834360",
+				"test.nginx.web_1.upstream_time.p50 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.p99_9 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.p99_9 1 1614834360",
+				"test.nginx.web_1.upstream_time.sum 0.75 1614834420",
+				"test.nginx.web_1.upstream_time.sum 2 1614834360",
+			},
+		},
+		{
+			name:     "udp without window",
+			protocol: "udp",
+			cfg: config.ConfigRaw{
+				"window":  0,
+				"metrics": []map[string]interface{}{{"path": "nginx.%{host}.upstream_time", "field": "upstream_time"}},
+			},
+			want: []string{
+				"test.nginx.web_1.upstream_time 0.25 1614834361",
+				"test.nginx.web_1.upstream_time 0.25 1614834364",
+				"test.nginx.web_1.upstream_time 0.5 1614834362",
+				"test.nginx.web_1.upstream_time 0.75 1614834421",
+				"test.nginx.web_1.upstream_time 1 1614834363",
+			},
+		},
+		{
+			name:     "reconnect",
+			protocol: "tcp",
+			cfg: config.ConfigRaw{
+				"window":  0,
+				"metrics": []map[string]interface{}{{"path": "nginx.%{host}.status.%{status}"}},
+			},
+			delay: 100 * time.Millisecond,
+			want: []string{
+				"test.nginx.web_1.status.200 1 1614834361",
+				"test.nginx.web_1.status.200 1 1614834362",
+				"test.nginx.web_1.status.200 1 1614834364",
+				"test.nginx.web_1.status.200 1 1614834421",
+				"test.nginx.web_1.status.500 1 1614834363",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := test.FreeAddr(tt.protocol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &carbon{}
+			if tt.delay == 0 {
+				stop := c.listen(t, tt.protocol, address)
+				defer stop()
+			}
+
+			cfg := config.ConfigRaw{
+				"type":              "graphite",
+				"address":           address,
+				"protocol":          tt.protocol,
+				"prefix":            "test",
+				"flush_interval":    10 * time.Millisecond,
+				"retry_backoff":     10 * time.Millisecond,
+				"max_retry_backoff": 20 * time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				inChan <- e
+			}
+			result := make(chan error, 1)
+			go func() {
+				result <- out.Start(inChan, outChan)
+			}()
+
+			if tt.delay > 0 {
+				time.Sleep(tt.delay)
+				stop := c.listen(t, tt.protocol, address)
+				defer stop()
+				waitLines(c, len(tt.want))
+			}
+			close(inChan)
+			if err = <-result; err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+
+			lines := waitLines(c, len(tt.want))
+			if strings.Join(lines, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("lines =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
+
+func TestGraphiteLateEvents(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC) // 1614834360
+	newEvent := func(sec int) *event.Event {
+		return &event.Event{Timestamp: ts.Add(time.Duration(sec) * time.Second), Fields: map[string]interface{}{"host": "web.1"}}
+	}
+
+	tests := []struct {
+		name     string
+		lateness time.Duration
+		want     []string
+	}{
+		// window resended with full aggregate
+		{name: "lateness", lateness: time.Minute, want: []string{"test.web_1.requests 2 1614834360", "test.web_1.requests 3 1614834360"}},
+		// late event sended in new window
+		{name: "without lateness", lateness: 0, want: []string{"test.web_1.requests 1 1614834360", "test.web_1.requests 2 1614834360"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, err := test.FreeAddr("tcp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &carbon{}
+			stop := c.listen(t, "tcp", address)
+			defer stop()
+
+			cfg := config.ConfigRaw{
+				"type":           "graphite",
+				"address":        address,
+				"prefix":         "test",
+				"lateness":       tt.lateness,
+				"flush_interval": 10 * time.Millisecond,
+				"metrics":        []map[string]interface{}{{"path": "%{host}.requests"}},
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, 3)
+			outChan := make(chan *event.Event, 3)
+			inChan <- newEvent(1)
+			inChan <- newEvent(2)
+			result := make(chan error, 1)
+			go func() {
+				result <- out.Start(inChan, outChan)
+			}()
+			waitLines(c, 1)
+			// late event for sended window
+			inChan <- newEvent(3)
+			close(inChan)
+			if err = <-result; err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+
+			lines := waitLines(c, len(tt.want))
+			if strings.Join(lines, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("lines =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "http"
+
+type Format int8
+
+const (
+	FormatJSONArray Format = iota // batch as json array
+	FormatNDJSON                  // batch as json documents, one per line
+	FormatTemplate                // request per event, body is executed template
+)
+
+var formatStrings []string = []string{"json_array", "ndjson", "template"}
+
+func (f *Format) Set(value string) error {
+	switch value {
+	case "json_array", "":
+		*f = FormatJSONArray
+	case "ndjson":
+		*f = FormatNDJSON
+	case "template":
+		*f = FormatTemplate
+	default:
+		return fmt.Errorf("invalid format %s", value)
+	}
+	return nil
+}
+
+func (f *Format) String() string {
+	return formatStrings[*f]
+}
+
+func (f *Format) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+type Config struct {
+	output.Config
+
+	URL          string            `hcl:"url" yaml:"url" json:"url"`                               // url template, like "http://127.0.0.1:8080/%{app}"
+	Method       string            `hcl:"method" yaml:"method" json:"method"`                      // method template
+	Headers      map[string]string `hcl:"headers" yaml:"headers" json:"headers"`                   // header templates
+	Format       Format            `hcl:"format" yaml:"format" json:"format"`                      // json_array (default), ndjson or template
+	Template     string            `hcl:"template" yaml:"template" json:"template"`                // body template (for template format)
+	Username     string            `hcl:"username" yaml:"username" json:"username"`                // basic auth username
+	Password     string            `hcl:"password" yaml:"password" json:"password"`                // basic auth password
+	Gzip         bool              `hcl:"gzip" yaml:"gzip" json:"gzip"`                            // compress requests
+	SuccessCodes []int             `hcl:"success_codes" yaml:"success_codes" json:"success_codes"` // statuses, treated as success (2xx, if not set)
+	Concurrency  int               `hcl:"concurrency" yaml:"concurrency" json:"concurrency"`       // max parallel requests (requests order not guaranteed, if > 1)
+
+	BatchSize     int           `hcl:"batch_size" yaml:"batch_size" json:"batch_size"`             // max events in request (for json_array and ndjson)
+	BatchBytes    config.Size   `hcl:"batch_bytes" yaml:"batch_bytes" json:"batch_bytes"`          // max request size (uncompressed)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // send not full batch after interval
+	Timeout       time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                      // request timeout
+
+	MaxRetries      int           `hcl:"max_retries" yaml:"max_retries" json:"max_retries"`                   // retries for failed (network errors, 408, 429, 5xx) requests, before dead letter
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial retry delay (doubled on every retry)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max retry delay
+	DeadLetterPath  string        `hcl:"dead_letter_path" yaml:"dead_letter_path" json:"dead_letter_path"`    // file for permanently failed documents (dropped if not set)
+
+	TLS config.TLS `hcl:"tls" yaml:"tls" json:"tls"` // https with client certificate (mTLS) from local files
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		URL:             "http://127.0.0.1:8080/",
+		Method:          http.MethodPost,
+		Concurrency:     4,
+		BatchSize:       500,
+		BatchBytes:      config.Size(5 * 1024 * 1024),
+		FlushInterval:   time.Second,
+		Timeout:         30 * time.Second,
+		MaxRetries:      5,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 10 * time.Second,
+	}
+}
+
+type header struct {
+	name string
+	tpl  *output.Template
+}
+
+// batch is a documents for one request (with same method, url and headers), events are hold until request result
+type batch struct {
+	method  string
+	url     string
+	headers []string // name, value pairs
+	docs    [][]byte
+	events  []*event.Event
+	size    int64
+}
+
+// HTTP is output for send events to any http endpoint (webhook).
+//
+// Events are sended in batches as json array or NDJSON (event fields with tags and @timestamp), or as request per event
+// with body from template. Method, url and headers are templates, events with different rendered values
+// are sended in different requests. Requests are retried (with backoff) on network errors and 408/429/5xx statuses,
+// other not success statuses are permanent failures. Permanently failed documents (and documents, exceeded max_retries)
+// are written to dead letter file. Events are returned after request result, not delivered events are marked as failed.
+type HTTP struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	url        *output.Template
+	method     *output.Template
+	headers    []header
+	body       *output.Template
+	success    map[int]bool
+	client     *http.Client
+	deadLetter *output.DeadLetter
+
+	batches map[string]*batch
+	parts   [][]byte // rendered method, url and header values
+	key     []byte
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+	zw  sync.Pool
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &HTTP{
+		cfg:     defaultConfig(),
+		cfgRaw:  cfg,
+		common:  common,
+		batches: make(map[string]*batch),
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(o.cfg.URL, "http://") && !strings.HasPrefix(o.cfg.URL, "https://") {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid url " + o.cfg.URL)
+	}
+	if o.url, err = output.NewTemplate(o.cfg.URL); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.cfg.Method == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': method not set")
+	}
+	if o.method, err = output.NewTemplate(o.cfg.Method); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	names := make([]string, 0, len(o.cfg.Headers))
+	for name := range o.cfg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tpl, err := output.NewTemplate(o.cfg.Headers[name])
+		if err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': header " + name + ": " + err.Error())
+		}
+		o.headers = append(o.headers, header{name: http.CanonicalHeaderKey(name), tpl: tpl})
+	}
+	if o.cfg.Format == FormatTemplate {
+		if o.cfg.Template == "" {
+			return nil, errors.New("output '" + o.cfg.Type + "': template not set")
+		}
+		if o.body, err = output.NewTemplate(o.cfg.Template); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+		}
+	}
+	if len(o.cfg.SuccessCodes) > 0 {
+		o.success = make(map[int]bool)
+		for _, code := range o.cfg.SuccessCodes {
+			if code < 100 || code > 599 {
+				return nil, fmt.Errorf("output '%s': invalid success code %d", o.cfg.Type, code)
+			}
+			o.success[code] = true
+		}
+	}
+	if o.cfg.Concurrency < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': concurrency must be > 0")
+	}
+	if o.cfg.BatchSize < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_size must be > 0")
+	}
+	if o.cfg.BatchBytes.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_bytes must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.MaxRetries < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_retries must be >= 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	tlsConfig, err := o.cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	o.client = &http.Client{
+		Timeout: o.cfg.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: o.cfg.Concurrency,
+		},
+	}
+
+	if o.cfg.DeadLetterPath != "" {
+		if o.deadLetter, err = output.NewDeadLetter(o.cfg.DeadLetterPath); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+		}
+	}
+
+	o.parts = make([][]byte, 2+len(o.headers))
+	o.sem = make(chan struct{}, o.cfg.Concurrency)
+
+	return o, nil
+}
+
+func (o *HTTP) Name() string {
+	return Name
+}
+
+func (o *HTTP) Acknowledging() {}
+
+// contentType return default Content-Type for format
+func (o *HTTP) contentType() string {
+	switch o.cfg.Format {
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatTemplate:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/json"
+	}
+}
+
+// isSuccess check response status
+func (o *HTTP) isSuccess(status int) bool {
+	if o.success == nil {
+		return status >= 200 && status < 300
+	}
+	return o.success[status]
+}
+
+// fail write batch documents to dead letter, events are marked as not delivered
+func (o *HTTP) fail(b *batch, status int, reason string) {
+	for _, e := range b.events {
+		e.Failed = true
+	}
+	if o.deadLetter == nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("url", b.url).Int("documents", len(b.docs)).
+			Int("status", status).Str("error", reason).Msg("documents dropped")
+		return
+	}
+	for _, doc := range b.docs {
+		if err := o.deadLetter.Write(o.cfg.Type, b.url, status, reason, doc); err != nil {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("dead_letter", o.deadLetter.Path()).
+				Err(err).Msg("dead letter write failed")
+			return
+		}
+	}
+}
+
+// request build request body
+func (o *HTTP) request(b *batch, body *bytes.Buffer) error {
+	var w io.Writer = body
+	var zw *gzip.Writer
+	if o.cfg.Gzip {
+		if v := o.zw.Get(); v != nil {
+			zw = v.(*gzip.Writer)
+			zw.Reset(body)
+		} else {
+			zw = gzip.NewWriter(body)
+		}
+		defer o.zw.Put(zw)
+		w = zw
+	}
+
+	var err error
+	switch o.cfg.Format {
+	case FormatJSONArray:
+		if _, err = w.Write([]byte{'['}); err != nil {
+			return err
+		}
+		for i, doc := range b.docs {
+			if i > 0 {
+				if _, err = w.Write([]byte{','}); err != nil {
+					return err
+				}
+			}
+			if _, err = w.Write(doc); err != nil {
+				return err
+			}
+		}
+		if _, err = w.Write([]byte{']'}); err != nil {
+			return err
+		}
+	case FormatNDJSON:
+		for _, doc := range b.docs {
+			if _, err = w.Write(doc); err != nil {
+				return err
+			}
+			if _, err = w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+	default:
+		for _, doc := range b.docs {
+			if _, err = w.Write(doc); err != nil {
+				return err
+			}
+		}
+	}
+
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// retriable check client error, only network errors are retried
+// (request errors, like invalid header values from event fields, are permanent)
+func retriable(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// do send request, return true if request can be retried
+func (o *HTTP) do(b *batch, body *bytes.Buffer) (retry bool, status int, err error) {
+	body.Reset()
+	if err = o.request(b, body); err != nil {
+		return false, 0, err
+	}
+	req, err := http.NewRequest(b.method, b.url, bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return false, 0, err
+	}
+	req.Header.Set("Content-Type", o.contentType())
+	if o.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if o.cfg.Username != "" {
+		req.SetBasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+	for i := 0; i < len(b.headers); i += 2 {
+		req.Header.Set(b.headers[i], b.headers[i+1])
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return retriable(err), 0, err
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	if o.isSuccess(resp.StatusCode) {
+		return false, resp.StatusCode, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(data))
+	retry = resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, resp.StatusCode, err
+}
+
+// flush send batch, failed request retried with backoff
+func (o *HTTP) flush(b *batch) {
+	var body bytes.Buffer
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(output.Backoff(attempt-1, o.cfg.RetryBackoff, o.cfg.MaxRetryBackoff))
+		}
+		retry, status, err := o.do(b, &body)
+		if err == nil {
+			return
+		}
+		if !retry {
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("url", b.url).Int("documents", len(b.docs)).
+				Err(err).Msg("request failed")
+			o.fail(b, status, err.Error())
+			return
+		}
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("url", b.url).Int("documents", len(b.docs)).
+			Int("attempt", attempt).Err(err).Msg("request failed")
+		if attempt >= o.cfg.MaxRetries {
+			o.fail(b, 0, "max retries exceeded: "+err.Error())
+			return
+		}
+	}
+}
+
+// send send batch in background (blocked, if concurrency limit is reached), events returned to out channel after request
+func (o *HTTP) send(b *batch, outChan chan<- *event.Event) {
+	o.sem <- struct{}{}
+	o.wg.Add(1)
+	go func() {
+		defer func() {
+			<-o.sem
+			o.wg.Done()
+		}()
+		o.flush(b)
+		for _, e := range b.events {
+			outChan <- e
+		}
+	}()
+}
+
+// add add event to batch (or send request for template format)
+func (o *HTTP) add(e *event.Event, outChan chan<- *event.Event) error {
+	var doc []byte
+	if o.cfg.Format == FormatTemplate {
+		doc, _ = o.body.Append(nil, e)
+	} else {
+		var err error
+		if doc, err = output.Document(e); err != nil {
+			return err
+		}
+	}
+
+	o.parts[0], _ = o.method.Append(o.parts[0][:0], e)
+	o.parts[1], _ = o.url.Append(o.parts[1][:0], e)
+	for i := range o.headers {
+		o.parts[i+2], _ = o.headers[i].tpl.Append(o.parts[i+2][:0], e)
+	}
+	// length-prefixed parts, so values with any bytes can't collide
+	o.key = o.key[:0]
+	for _, part := range o.parts {
+		o.key = strconv.AppendInt(o.key, int64(len(part)), 10)
+		o.key = append(o.key, ':')
+		o.key = append(o.key, part...)
+	}
+
+	b, ok := o.batches[string(o.key)]
+	if !ok {
+		b = &batch{method: string(o.parts[0]), url: string(o.parts[1])}
+		for i := range o.headers {
+			b.headers = append(b.headers, o.headers[i].name, string(o.parts[i+2]))
+		}
+		if o.cfg.Format == FormatTemplate {
+			// request per event
+			b.docs = [][]byte{doc}
+			b.events = []*event.Event{e}
+			o.send(b, outChan)
+			return nil
+		}
+		o.batches[string(o.key)] = b
+	}
+	b.docs = append(b.docs, doc)
+	b.events = append(b.events, e)
+	b.size += int64(len(doc) + 1)
+	if len(b.docs) >= o.cfg.BatchSize || b.size >= o.cfg.BatchBytes.Value() {
+		delete(o.batches, string(o.key))
+		o.send(b, outChan)
+	}
+	return nil
+}
+
+// flushAll send all not empty batches
+func (o *HTTP) flushAll(outChan chan<- *event.Event) {
+	for key, b := range o.batches {
+		delete(o.batches, key)
+		o.send(b, outChan)
+	}
+}
+
+func (o *HTTP) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				o.flushAll(outChan)
+				o.wg.Wait()
+				if o.deadLetter != nil {
+					o.deadLetter.Close()
+				}
+				return nil
+			}
+			if err := o.add(e, outChan); err != nil {
+				log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("serialize")
+				outChan <- e
+			}
+		case <-ticker.C:
+			o.flushAll(outChan)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package http_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "http"}},
+		{name: "invalid url", cfg: config.ConfigRaw{"type": "http", "url": "127.0.0.1:8080"}, wantErr: true},
+		{name: "invalid url template", cfg: config.ConfigRaw{"type": "http", "url": "http://127.0.0.1:8080/%{app"}, wantErr: true},
+		{name: "invalid format", cfg: config.ConfigRaw{"type": "http", "format": "xml"}, wantErr: true},
+		{name: "template not set", cfg: config.ConfigRaw{"type": "http", "format": "template"}, wantErr: true},
+		{name: "invalid header", cfg: config.ConfigRaw{"type": "http", "headers": map[string]string{"X-App": "%{app"}}, wantErr: true},
+		{name: "invalid success code", cfg: config.ConfigRaw{"type": "http", "success_codes": []int{2000}}, wantErr: true},
+		{name: "invalid concurrency", cfg: config.ConfigRaw{"type": "http", "concurrency": 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// webhook record requests as "method path x-host: messages", response status is selected by path:
+// /retry* - 503 for first request, /fail - 400, /accepted - 202
+type webhook struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	requests []string
+	retried  map[string]bool
+}
+
+func (s *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+
+	var messages []string
+	switch r.Header.Get("Content-Type") {
+	case "application/json":
+		var docs []map[string]interface{}
+		if err := jsoniter.NewDecoder(body).Decode(&docs); err != nil {
+			s.t.Errorf("json array: %v", err)
+		}
+		for _, doc := range docs {
+			messages = append(messages, doc["message"].(string))
+		}
+	case "application/x-ndjson":
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			var doc map[string]interface{}
+			if err := jsoniter.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				s.t.Errorf("ndjson: %v", err)
+			}
+			messages = append(messages, doc["message"].(string))
+		}
+	default:
+		data, _ := io.ReadAll(body)
+		messages = append(messages, string(data))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Host")+": "+strings.Join(messages, ","))
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/retry"):
+		if !s.retried[r.URL.Path] {
+			s.retried[r.URL.Path] = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	case r.URL.Path == "/fail":
+		w.WriteHeader(http.StatusBadRequest)
+	case r.URL.Path == "/accepted":
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *webhook) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := append([]string{}, s.requests...)
+	sort.Strings(requests)
+	return requests
+}
+
+func TestHTTP(t *testing.T) {
+	testDir := t.TempDir()
+	certFile, keyFile, err := test.GenerateCert(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newEvent := func(app, host, method, message string) *event.Event {
+		return &event.Event{
+			Timestamp: ts,
+			Fields:    map[string]interface{}{"app": app, "host": host, "method": method, "message": message},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		cfg        config.ConfigRaw
+		mtls       bool
+		events     []*event.Event
+		want       []string
+		deadLetter []string // message status
+	}{
+		{
+			name: "json_array",
+			cfg: config.ConfigRaw{
+				"url": "%{url}/%{app}", "gzip": true, "batch_size": 2, "concurrency": 2,
+				"headers": map[string]string{"x-host": "%{host}"},
+			},
+			events: []*event.Event{
+				newEvent("web", "a", "", "test 1"), newEvent("web", "a", "", "test 2"), newEvent("db", "b", "", "test 3"),
+				newEvent("web", "a", "", "test 4"),
+			},
+			want: []string{"POST /db b: test 3", "POST /web a: test 1,test 2", "POST /web a: test 4"},
+		},
+		{
+			name: "ndjson",
+			cfg: config.ConfigRaw{
+				"url": "%{url}/%{app}", "format": "ndjson", "success_codes": []int{200},
+			},
+			events: []*event.Event{
+				newEvent("retry", "", "", "test 1"), newEvent("fail", "", "", "test 2"), newEvent("accepted", "", "", "test 3"),
+				newEvent("retry", "", "", "test 4"), newEvent("ok", "", "", "test 5"),
+			},
+			want: []string{
+				"POST /accepted : test 3", "POST /fail : test 2", "POST /ok : test 5",
+				"POST /retry : test 1,test 4", "POST /retry : test 1,test 4",
+			},
+			deadLetter: []string{"test 2 400", "test 3 202"},
+		},
+		{
+			name: "template",
+			cfg: config.ConfigRaw{
+				"url": "%{url}/%{app}/%{host}", "method": "%{method}", "format": "template", "template": "%{host} %{message}",
+				"concurrency": 1, "max_retries": 0,
+			},
+			events: []*event.Event{
+				newEvent("events", "a", "PUT", "test 1"), newEvent("events", "a", "PUT", "test 2"),
+				newEvent("retry", "b", "POST", "test 3"),
+			},
+			want: []string{"POST /retry/b : b test 3", "PUT /events/a : a test 1", "PUT /events/a : a test 2"},
+			// no retries
+			deadLetter: []string{"b test 3 0"},
+		},
+		{
+			name: "invalid header value",
+			cfg: config.ConfigRaw{
+				"url": "%{url}/%{app}", "headers": map[string]string{"x-host": "%{host}"},
+				// not retried, Start must return without retry delay
+				"max_retries": 1, "retry_backoff": 10 * time.Second, "max_retry_backoff": 10 * time.Second,
+			},
+			events: []*event.Event{newEvent("web", "a\nb", "", "test 1"), newEvent("web", "c", "", "test 2")},
+			want:   []string{"POST /web c: test 2"},
+			// request not sended
+			deadLetter: []string{"test 1 0"},
+		},
+		{
+			name:   "mtls",
+			cfg:    config.ConfigRaw{"url": "%{url}/%{app}", "tls": map[string]interface{}{"enabled": true, "cert_file": certFile, "key_file": keyFile, "ca_file": certFile}},
+			mtls:   true,
+			events: []*event.Event{newEvent("web", "", "", "test 1")},
+			want:   []string{"POST /web : test 1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &webhook{t: t, retried: make(map[string]bool)}
+			var srv *httptest.Server
+			if tt.mtls {
+				cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pem, err := os.ReadFile(certFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				pool := x509.NewCertPool()
+				pool.AppendCertsFromPEM(pem)
+				srv = httptest.NewUnstartedServer(s)
+				srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+				srv.StartTLS()
+			} else {
+				srv = httptest.NewServer(s)
+			}
+			defer srv.Close()
+
+			deadLetter := filepath.Join(testDir, tt.name+".json")
+			cfg := config.ConfigRaw{
+				"type":             "http",
+				"retry_backoff":    time.Millisecond,
+				"dead_letter_path": deadLetter,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			// url template must start with scheme
+			cfg["url"] = strings.Replace(cfg["url"].(string), "%{url}", srv.URL, 1)
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(tt.events))
+			outChan := make(chan *event.Event, len(tt.events))
+			for _, e := range tt.events {
+				inChan <- e
+			}
+			close(inChan)
+
+			start := time.Now()
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("Start() duration = %s, request retried", d)
+			}
+			if len(outChan) != len(tt.events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(tt.events))
+			}
+
+			if requests := s.get(); strings.Join(requests, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			data, err := os.ReadFile(deadLetter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var failed []string
+			for _, line := range strings.Split(string(data), "\n") {
+				if line == "" {
+					continue
+				}
+				var rec struct {
+					Status   int         `json:"status"`
+					Document interface{} `json:"document"`
+				}
+				if err = jsoniter.Unmarshal([]byte(line), &rec); err != nil {
+					t.Fatalf("dead letter %q: %v", line, err)
+				}
+				message := rec.Document
+				if doc, ok := rec.Document.(map[string]interface{}); ok {
+					message = doc["message"]
+				}
+				failed = append(failed, message.(string)+" "+strconv.Itoa(rec.Status))
+			}
+			sort.Strings(failed)
+			if strings.Join(failed, "\n") != strings.Join(tt.deadLetter, "\n") {
+				t.Errorf("dead letter =\n%s\nwant\n%s", strings.Join(failed, "\n"), strings.Join(tt.deadLetter, "\n"))
+			}
+		})
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
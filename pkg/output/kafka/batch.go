@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/golang/snappy"
+	"github.com/msaf1980/log-exporter/pkg/compress"
+	"github.com/msaf1980/log-exporter/pkg/event"
+)
+
+// record is a message for topic, event is hold until produce result
+type record struct {
+	key   []byte // nil for messages without key
+	value []byte
+	ts    int64 // unix ms
+	e     *event.Event
+}
+
+// appendRecords append records (record batch v2 format) to b
+func appendRecords(b []byte, records []*record) []byte {
+	var rec []byte
+	base := records[0].ts
+	for i, r := range records {
+		rec = append(rec[:0], 0) // attributes
+		rec = appendVarint(rec, r.ts-base)
+		rec = appendVarint(rec, int64(i))
+		if r.key == nil {
+			rec = appendVarint(rec, -1)
+		} else {
+			rec = appendVarint(rec, int64(len(r.key)))
+			rec = append(rec, r.key...)
+		}
+		rec = appendVarint(rec, int64(len(r.value)))
+		rec = append(rec, r.value...)
+		rec = appendVarint(rec, 0) // headers
+
+		b = appendVarint(b, int64(len(rec)))
+		b = append(b, rec...)
+	}
+	return b
+}
+
+// compressRecords append compressed records to dst
+func compressRecords(dst, src []byte, codec Compression) ([]byte, error) {
+	switch codec {
+	case CompressionGzip:
+		buf := bytes.NewBuffer(dst)
+		zw := gzip.NewWriter(buf)
+		if _, err := zw.Write(src); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionSnappy:
+		return append(dst, snappy.Encode(nil, src)...), nil
+	case CompressionLZ4:
+		return compress.LZ4Encode(dst, src), nil
+	default:
+		return append(dst, src...), nil
+	}
+}
+
+// encode build record batch (v2) for partition records
+func (pb *partitionBatch) encode(codec Compression) error {
+	first := pb.records[0].ts
+	maxTs := first
+	for _, r := range pb.records {
+		if r.ts > maxTs {
+			maxTs = r.ts
+		}
+	}
+
+	b := pb.data[:0]
+	b = appendInt64(b, 0)  // base offset
+	b = appendInt32(b, 0)  // batch length
+	b = appendInt32(b, -1) // partition leader epoch
+	b = append(b, 2)       // magic
+	b = appendInt32(b, 0)  // crc
+	crcStart := len(b)
+	b = appendInt16(b, int16(codec))
+	b = appendInt32(b, int32(len(pb.records)-1)) // last offset delta
+	b = appendInt64(b, first)
+	b = appendInt64(b, maxTs)
+	b = appendInt64(b, -1) // producer id
+	b = appendInt16(b, -1) // producer epoch
+	b = appendInt32(b, -1) // base sequence
+	b = appendInt32(b, int32(len(pb.records)))
+	if codec == CompressionNone {
+		b = appendRecords(b, pb.records)
+	} else {
+		var err error
+		if b, err = compressRecords(b, appendRecords(nil, pb.records), codec); err != nil {
+			return err
+		}
+	}
+
+	binary.BigEndian.PutUint32(b[8:], uint32(len(b)-12))
+	binary.BigEndian.PutUint32(b[crcStart-4:], crc32.Checksum(b[crcStart:], castagnoli))
+	pb.data = b
+	return nil
+}
@@ -0,0 +1,226 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// broker is a connection to broker (reconnected on next request after error), not safe for concurrent use
+type broker struct {
+	id   int32
+	addr string
+	conn net.Conn
+}
+
+func (b *broker) close() {
+	if b.conn != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+}
+
+// client is a kafka cluster metadata cache and brokers connections
+type client struct {
+	bootstrap []string
+	clientID  string
+	timeout   time.Duration
+	tls       *tls.Config
+
+	correlationID int32
+
+	meta    *broker // connection for metadata requests
+	brokers map[int32]*broker
+	topics  map[string]*topicMeta
+}
+
+func newClient(bootstrap []string, clientID string, timeout time.Duration, tlsConfig *tls.Config) *client {
+	return &client{
+		bootstrap: bootstrap,
+		clientID:  clientID,
+		timeout:   timeout,
+		tls:       tlsConfig,
+		brokers:   make(map[int32]*broker),
+		topics:    make(map[string]*topicMeta),
+	}
+}
+
+func (c *client) dial(addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+	if c.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", addr, c.tls)
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// request send request to broker and read response body (if wait is set), connection is closed on error
+func (c *client) request(b *broker, apiKey, version int16, body []byte, wait bool) ([]byte, error) {
+	if b.conn == nil {
+		conn, err := c.dial(b.addr)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = conn
+	}
+	correlationID := atomic.AddInt32(&c.correlationID, 1)
+	req := appendRequestHeader(make([]byte, 0, len(body)+64), apiKey, version, correlationID, c.clientID)
+	req = append(req, body...)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+
+	b.conn.SetDeadline(time.Now().Add(c.timeout))
+	if _, err := b.conn.Write(req); err != nil {
+		b.close()
+		return nil, err
+	}
+	if !wait {
+		return nil, nil
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(b.conn, header[:]); err != nil {
+		b.close()
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header[:]))
+	if size < 4 {
+		b.close()
+		return nil, errShortBuffer
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(b.conn, resp); err != nil {
+		b.close()
+		return nil, err
+	}
+	if int32(binary.BigEndian.Uint32(header[4:])) != correlationID {
+		b.close()
+		return nil, errors.New("kafka: correlation id mismatch")
+	}
+	return resp, nil
+}
+
+// refresh update metadata for known and requested topics
+func (c *client) refresh(topics ...string) error {
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	n := 0
+	for i := range topics {
+		if i == 0 || topics[i] != topics[i-1] {
+			topics[n] = topics[i]
+			n++
+		}
+	}
+	topics = topics[:n]
+	body := metadataRequest(nil, topics)
+
+	// try last used connection, known brokers and bootstrap brokers
+	var addrs []string
+	if c.meta == nil || c.meta.conn == nil {
+		ids := make([]int, 0, len(c.brokers))
+		for id := range c.brokers {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			addrs = append(addrs, c.brokers[int32(id)].addr)
+		}
+		addrs = append(addrs, c.bootstrap...)
+		c.meta = nil
+	}
+	var (
+		resp []byte
+		err  error
+	)
+	if c.meta != nil {
+		if resp, err = c.request(c.meta, apiMetadata, metadataVersion, body, true); err != nil {
+			c.meta = nil
+			return err
+		}
+	} else {
+		err = errors.New("kafka: brokers not set")
+		for _, addr := range addrs {
+			b := &broker{id: -1, addr: addr}
+			if resp, err = c.request(b, apiMetadata, metadataVersion, body, true); err == nil {
+				c.meta = b
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	brokers, topicsMeta, err := metadataResponse(resp)
+	if err != nil {
+		c.meta.close()
+		c.meta = nil
+		return err
+	}
+	found := make(map[int32]bool, len(brokers))
+	for _, bm := range brokers {
+		found[bm.id] = true
+		addr := bm.addr()
+		if b, ok := c.brokers[bm.id]; ok {
+			if b.addr == addr {
+				continue
+			}
+			b.close()
+		}
+		c.brokers[bm.id] = &broker{id: bm.id, addr: addr}
+	}
+	for id, b := range c.brokers {
+		if !found[id] {
+			b.close()
+			delete(c.brokers, id)
+		}
+	}
+	for i := range topicsMeta {
+		c.topics[topicsMeta[i].name] = &topicsMeta[i]
+	}
+	return nil
+}
+
+// partitions return topic partitions count (0 if topic metadata not available)
+func (c *client) partitions(topic string) (int, error) {
+	if t, ok := c.topics[topic]; ok && t.err == ErrNone && len(t.leaders) > 0 {
+		return len(t.leaders), nil
+	}
+	if err := c.refresh(topic); err != nil {
+		return 0, err
+	}
+	t, ok := c.topics[topic]
+	if !ok {
+		return 0, ErrUnknownTopicOrPartition
+	}
+	if t.err != ErrNone {
+		return 0, t.err
+	}
+	if len(t.leaders) == 0 {
+		return 0, ErrLeaderNotAvailable
+	}
+	return len(t.leaders), nil
+}
+
+// leader return partition leader (nil if not available)
+func (c *client) leader(topic string, partition int32) *broker {
+	t, ok := c.topics[topic]
+	if !ok || int(partition) >= len(t.leaders) {
+		return nil
+	}
+	return c.brokers[t.leaders[partition]]
+}
+
+func (c *client) close() {
+	if c.meta != nil {
+		c.meta.close()
+	}
+	for _, b := range c.brokers {
+		b.close()
+	}
+}
@@ -0,0 +1,468 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "kafka"
+
+// Compression is a record batch compression codec (value is a record batch attributes codec)
+type Compression int8
+
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionSnappy
+	CompressionLZ4
+)
+
+var compressionStrings []string = []string{"none", "gzip", "snappy", "lz4"}
+
+func (c *Compression) Set(value string) error {
+	switch value {
+	case "none", "":
+		*c = CompressionNone
+	case "gzip":
+		*c = CompressionGzip
+	case "snappy":
+		*c = CompressionSnappy
+	case "lz4":
+		*c = CompressionLZ4
+	default:
+		return fmt.Errorf("invalid compression %s", value)
+	}
+	return nil
+}
+
+func (c *Compression) String() string {
+	return compressionStrings[*c]
+}
+
+func (c *Compression) UnmarshalText(text []byte) error {
+	return c.Set(string(text))
+}
+
+// Acks is a required acknowledges for produce request
+type Acks int8
+
+const (
+	AcksAll    Acks = iota // all in-sync replicas (-1)
+	AcksLeader             // leader only (1)
+	AcksNone               // no response (0), messages are treated as delivered after write to socket
+)
+
+var acksStrings []string = []string{"all", "leader", "none"}
+
+func (a *Acks) Set(value string) error {
+	switch value {
+	case "all", "-1", "":
+		*a = AcksAll
+	case "leader", "1":
+		*a = AcksLeader
+	case "none", "0":
+		*a = AcksNone
+	default:
+		return fmt.Errorf("invalid acks %s", value)
+	}
+	return nil
+}
+
+func (a *Acks) String() string {
+	return acksStrings[*a]
+}
+
+func (a *Acks) UnmarshalText(text []byte) error {
+	return a.Set(string(text))
+}
+
+// value return acks for produce request
+func (a Acks) value() int16 {
+	switch a {
+	case AcksLeader:
+		return 1
+	case AcksNone:
+		return 0
+	default:
+		return -1
+	}
+}
+
+type Config struct {
+	output.Config
+
+	Brokers     []string      `hcl:"brokers" yaml:"brokers" json:"brokers"`             // bootstrap brokers (host:port)
+	Topic       string        `hcl:"topic" yaml:"topic" json:"topic"`                   // topic template, like "logs-%{app}"
+	Key         string        `hcl:"key" yaml:"key" json:"key"`                         // partition key field (nested fields like a.b), messages without key are sended to one partition per flush (changed round robin)
+	ClientID    string        `hcl:"client_id" yaml:"client_id" json:"client_id"`       // client id in requests
+	Acks        Acks          `hcl:"acks" yaml:"acks" json:"acks"`                      // all (default), leader or none
+	Compression Compression   `hcl:"compression" yaml:"compression" json:"compression"` // none (default), gzip, snappy or lz4
+	Format      output.Format `hcl:"format" yaml:"format" json:"format"`                // message format: json (default), rubydebug, line (message field) or template
+	Template    string        `hcl:"template" yaml:"template" json:"template"`          // message template (for template format)
+
+	BatchSize     int           `hcl:"batch_size" yaml:"batch_size" json:"batch_size"`             // max pending messages, flushed in one produce request per broker
+	BatchBytes    config.Size   `hcl:"batch_bytes" yaml:"batch_bytes" json:"batch_bytes"`          // max pending messages size (uncompressed)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // flush not full batch after interval
+	Timeout       time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                      // network and produce request timeout
+
+	MaxRetries      int           `hcl:"max_retries" yaml:"max_retries" json:"max_retries"`                   // retries for retriable errors (network, leader change, etc), before messages delivery failed
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial retry delay (doubled on every retry)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max retry delay
+
+	TLS config.TLS `hcl:"tls" yaml:"tls" json:"tls"` // tls (with client certificate) from local files
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		Brokers:         []string{"127.0.0.1:9092"},
+		Topic:           "logs",
+		ClientID:        "log-exporter",
+		BatchSize:       1000,
+		BatchBytes:      config.Size(1024 * 1024),
+		FlushInterval:   time.Second,
+		Timeout:         30 * time.Second,
+		MaxRetries:      5,
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 10 * time.Second,
+	}
+}
+
+// Kafka is output for produce events to kafka topics.
+//
+// Events are buffered and flushed (on batch_size, batch_bytes or flush_interval) as record batches (v2) in one produce
+// request per partition leader. Events are holded until produce result, so input acknowledges (like file offsets
+// with wait_ack) are advanced only for delivered messages. Retriable errors (network, leader change, not enough replicas)
+// are retried with backoff after metadata refresh, after max_retries or on permanent error (like too large message)
+// events are marked as failed. Events with format error are dropped (returned as delivered).
+type Kafka struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	topic     *output.Template
+	key       []string
+	formatter *output.Formatter
+	client    *client
+
+	records map[string][]*record // pending records by topic
+	count   int
+	size    int64
+	next    map[string]int32 // partition for messages without key
+	buf     []byte
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Kafka{
+		cfg:     defaultConfig(),
+		cfgRaw:  cfg,
+		common:  common,
+		records: make(map[string][]*record),
+		next:    make(map[string]int32),
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if len(o.cfg.Brokers) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': brokers not set")
+	}
+	for _, addr := range o.cfg.Brokers {
+		if !strings.Contains(addr, ":") {
+			return nil, errors.New("output '" + o.cfg.Type + "': invalid broker " + addr)
+		}
+	}
+	if o.cfg.Topic == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': topic not set")
+	}
+	if o.topic, err = output.NewTemplate(o.cfg.Topic); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.cfg.Key != "" {
+		o.key = strings.Split(o.cfg.Key, ".")
+	}
+	if o.formatter, err = output.NewFormatter(o.cfg.Format, o.cfg.Template); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.cfg.BatchSize < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_size must be > 0")
+	}
+	if o.cfg.BatchBytes.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_bytes must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.MaxRetries < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_retries must be >= 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	tlsConfig, err := o.cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	o.client = newClient(o.cfg.Brokers, o.cfg.ClientID, o.cfg.Timeout, tlsConfig)
+
+	return o, nil
+}
+
+func (o *Kafka) Name() string {
+	return Name
+}
+
+func (o *Kafka) Acknowledging() {}
+
+// release return records events to out channel (marked as failed, if not delivered)
+func (o *Kafka) release(records []*record, failed bool, outChan chan<- *event.Event) {
+	for _, r := range records {
+		if failed {
+			r.e.Failed = true
+		}
+		outChan <- r.e
+	}
+}
+
+// assign split topic records to partitions batches
+func (o *Kafka) assign(topic string, records []*record, partitions int) []*partitionBatch {
+	sticky := o.next[topic] % int32(partitions)
+	byPartition := make(map[int32]*partitionBatch)
+	for _, r := range records {
+		partition := sticky
+		if r.key != nil {
+			partition = keyPartition(r.key, partitions)
+		}
+		pb, ok := byPartition[partition]
+		if !ok {
+			pb = &partitionBatch{topic: topic, partition: partition}
+			byPartition[partition] = pb
+		}
+		pb.records = append(pb.records, r)
+	}
+	o.next[topic] = (sticky + 1) % int32(partitions)
+
+	batches := make([]*partitionBatch, 0, len(byPartition))
+	for _, pb := range byPartition {
+		batches = append(batches, pb)
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i].partition < batches[j].partition })
+	return batches
+}
+
+// send send produce request with partitions batches to leader, set batches errors
+func (o *Kafka) send(b *broker, batches []*partitionBatch) {
+	setErr := func(err error) {
+		for _, pb := range batches {
+			pb.err = err
+		}
+	}
+	for _, pb := range batches {
+		if pb.data == nil {
+			if err := pb.encode(o.cfg.Compression); err != nil {
+				setErr(err)
+				return
+			}
+		}
+	}
+
+	acks := o.cfg.Acks.value()
+	body := produceRequest(nil, acks, int32(o.cfg.Timeout/time.Millisecond), batches)
+	resp, err := o.client.request(b, apiProduce, produceVersion, body, acks != 0)
+	if err != nil {
+		setErr(err)
+		return
+	}
+	setErr(nil)
+	if acks == 0 {
+		return
+	}
+	results, err := produceResponse(resp, produceVersion)
+	if err != nil {
+		b.close()
+		setErr(err)
+		return
+	}
+	found := 0
+	for _, r := range results {
+		for _, pb := range batches {
+			if pb.topic == r.topic && pb.partition == r.partition {
+				found++
+				if r.err != ErrNone {
+					pb.err = r.err
+				}
+				break
+			}
+		}
+	}
+	if found < len(batches) {
+		setErr(errors.New("kafka: partitions results not found in produce response"))
+	}
+}
+
+// produce send batches to partitions leaders and return batches for retry, delivered and permanently failed batches are released
+func (o *Kafka) produce(batches []*partitionBatch, outChan chan<- *event.Event) []*partitionBatch {
+	byLeader := make(map[*broker][]*partitionBatch)
+	for _, pb := range batches {
+		b := o.client.leader(pb.topic, pb.partition)
+		if b == nil {
+			pb.err = ErrLeaderNotAvailable
+			continue
+		}
+		byLeader[b] = append(byLeader[b], pb)
+	}
+
+	var wg sync.WaitGroup
+	for b, leaderBatches := range byLeader {
+		wg.Add(1)
+		go func(b *broker, batches []*partitionBatch) {
+			defer wg.Done()
+			o.send(b, batches)
+		}(b, leaderBatches)
+	}
+	wg.Wait()
+
+	retry := batches[:0]
+	for _, pb := range batches {
+		if pb.err == nil {
+			o.release(pb.records, false, outChan)
+			continue
+		}
+		var kerr Error
+		if errors.As(pb.err, &kerr) && !kerr.Retriable() {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("topic", pb.topic).Int32("partition", pb.partition).
+				Int("messages", len(pb.records)).Err(pb.err).Msg("produce failed")
+			o.release(pb.records, true, outChan)
+			continue
+		}
+		retry = append(retry, pb)
+	}
+	return retry
+}
+
+// flush produce pending records, events are returned to out channel after produce result
+func (o *Kafka) flush(outChan chan<- *event.Event) {
+	if o.count == 0 {
+		return
+	}
+	unassigned := o.records
+	o.records = make(map[string][]*record)
+	o.count = 0
+	o.size = 0
+
+	topics := make([]string, 0, len(unassigned))
+	for topic := range unassigned {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var batches []*partitionBatch
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(output.Backoff(attempt-1, o.cfg.RetryBackoff, o.cfg.MaxRetryBackoff))
+			if err := o.client.refresh(); err != nil {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Err(err).Msg("metadata refresh failed")
+			}
+		}
+		n := 0
+		for _, topic := range topics {
+			partitions, err := o.client.partitions(topic)
+			if err != nil {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("topic", topic).
+					Int("attempt", attempt).Err(err).Msg("topic metadata not available")
+				topics[n] = topic
+				n++
+				continue
+			}
+			batches = append(batches, o.assign(topic, unassigned[topic], partitions)...)
+			delete(unassigned, topic)
+		}
+		topics = topics[:n]
+
+		batches = o.produce(batches, outChan)
+		if len(batches) == 0 && len(topics) == 0 {
+			return
+		}
+		for _, pb := range batches {
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("topic", pb.topic).Int32("partition", pb.partition).
+				Int("messages", len(pb.records)).Int("attempt", attempt).Err(pb.err).Msg("produce failed")
+		}
+		if attempt >= o.cfg.MaxRetries {
+			messages := 0
+			for _, pb := range batches {
+				messages += len(pb.records)
+				o.release(pb.records, true, outChan)
+			}
+			for _, topic := range topics {
+				messages += len(unassigned[topic])
+				o.release(unassigned[topic], true, outChan)
+			}
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("messages", messages).
+				Msg("max retries exceeded, messages not delivered")
+			return
+		}
+	}
+}
+
+// add add event to pending records
+func (o *Kafka) add(e *event.Event, outChan chan<- *event.Event) {
+	var err error
+	if o.buf, err = o.formatter.Append(o.buf[:0], e); err != nil {
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("format failed, event dropped")
+		outChan <- e
+		return
+	}
+	r := &record{
+		value: append([]byte(nil), o.buf[:len(o.buf)-1]...), // without new line
+		ts:    e.Timestamp.UnixNano() / int64(time.Millisecond),
+		e:     e,
+	}
+	if o.key != nil {
+		if v, ok := output.Lookup(e.Fields, o.key); ok {
+			r.key = output.AppendValue([]byte{}, v)
+		}
+	}
+	o.buf, _ = o.topic.Append(o.buf[:0], e)
+	topic := string(o.buf)
+
+	o.records[topic] = append(o.records[topic], r)
+	o.count++
+	o.size += int64(len(r.key) + len(r.value))
+	if o.count >= o.cfg.BatchSize || o.size >= o.cfg.BatchBytes.Value() {
+		o.flush(outChan)
+	}
+}
+
+func (o *Kafka) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				o.flush(outChan)
+				o.client.close()
+				return nil
+			}
+			o.add(e, outChan)
+		case <-ticker.C:
+			o.flush(outChan)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/msaf1980/log-exporter/pkg/compress"
+)
+
+// Message is a decoded produced message
+type Message struct {
+	Topic     string
+	Partition int32
+	Key       *string
+	Value     string
+	Timestamp time.Time
+}
+
+// decodeRecords decode record batch (v2), return compression codec and messages
+func decodeRecords(data []byte) (Compression, []Message, error) {
+	d := decoder{b: data}
+	d.int64() // base offset
+	length := d.int32()
+	d.int32() // partition leader epoch
+	magic := d.int8()
+	crc := uint32(d.int32())
+	if d.err != nil {
+		return 0, nil, d.err
+	}
+	if magic != 2 {
+		return 0, nil, errors.New("invalid magic " + strconv.Itoa(int(magic)))
+	}
+	if int(length) != len(data)-12 {
+		return 0, nil, errors.New("invalid batch length")
+	}
+	if crc32.Checksum(d.b, castagnoli) != crc {
+		return 0, nil, errors.New("crc mismatch")
+	}
+	codec := Compression(d.int16() & 0x07)
+	d.int32() // last offset delta
+	first := d.int64()
+	d.int64() // max timestamp
+	d.int64() // producer id
+	d.int16() // producer epoch
+	d.int32() // base sequence
+	count := int(d.int32())
+	if d.err != nil {
+		return 0, nil, d.err
+	}
+
+	var (
+		records []byte
+		err     error
+	)
+	switch codec {
+	case CompressionNone:
+		records = d.b
+	case CompressionGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(d.b)); err == nil {
+			records, err = io.ReadAll(zr)
+		}
+	case CompressionSnappy:
+		records, err = snappy.Decode(nil, d.b)
+	case CompressionLZ4:
+		records, err = compress.LZ4Decode(d.b)
+	default:
+		err = errors.New("invalid compression " + strconv.Itoa(int(codec)))
+	}
+	if err != nil {
+		return codec, nil, err
+	}
+
+	d = decoder{b: records}
+	messages := make([]Message, 0, count)
+	for i := 0; i < count; i++ {
+		size := d.varint()
+		r := decoder{b: d.next(int(size))}
+		r.int8() // attributes
+		ts := first + r.varint()
+		if delta := r.varint(); delta != int64(i) {
+			return codec, nil, errors.New("invalid offset delta")
+		}
+		m := Message{Timestamp: time.Unix(0, ts*int64(time.Millisecond)).UTC()}
+		if n := r.varint(); n >= 0 {
+			key := string(r.next(int(n)))
+			m.Key = &key
+		}
+		m.Value = string(r.next(int(r.varint())))
+		r.varint() // headers
+		if r.err != nil {
+			return codec, nil, r.err
+		}
+		if len(r.b) > 0 {
+			return codec, nil, errors.New("record not fully readed")
+		}
+		messages = append(messages, m)
+	}
+	if d.err != nil {
+		return codec, nil, d.err
+	}
+	if len(d.b) > 0 {
+		return codec, nil, errors.New("records not fully readed")
+	}
+	return codec, messages, nil
+}
+
+// FakeBroker is an in-process single node kafka cluster, all topics are exist with same partitions count
+type FakeBroker struct {
+	ln         net.Listener
+	partitions int
+
+	mu        sync.Mutex
+	messages  []Message
+	codecs    map[Compression]int // record batches by compression
+	fails     []Error             // errors for next produced partitions batches (not stored)
+	conns     map[net.Conn]bool
+	produces  int
+	closed    bool
+	decodeErr error
+
+	wg sync.WaitGroup
+}
+
+func NewFakeBroker(partitions int) (*FakeBroker, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	b := &FakeBroker{ln: ln, partitions: partitions, codecs: make(map[Compression]int), conns: make(map[net.Conn]bool)}
+	b.wg.Add(1)
+	go b.serve()
+	return b, nil
+}
+
+func (b *FakeBroker) Addr() string {
+	return b.ln.Addr().String()
+}
+
+// Fail set errors for next produced partitions batches
+func (b *FakeBroker) Fail(errs ...Error) {
+	b.mu.Lock()
+	b.fails = append(b.fails, errs...)
+	b.mu.Unlock()
+}
+
+// Messages return stored messages, produce requests count and batches count by compression
+func (b *FakeBroker) Messages() ([]Message, int, map[Compression]int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	codecs := make(map[Compression]int, len(b.codecs))
+	for k, v := range b.codecs {
+		codecs[k] = v
+	}
+	return append([]Message(nil), b.messages...), b.produces, codecs, b.decodeErr
+}
+
+func (b *FakeBroker) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.ln.Close()
+	for conn := range b.conns {
+		conn.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func (b *FakeBroker) serve() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go b.handle(conn)
+	}
+}
+
+func (b *FakeBroker) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		b.wg.Done()
+	}()
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := decoder{b: req}
+		apiKey := d.int16()
+		version := d.int16()
+		correlationID := d.int32()
+		d.string() // client id
+		if d.err != nil {
+			return
+		}
+
+		var body []byte
+		switch apiKey {
+		case apiMetadata:
+			body = b.metadata(&d)
+		case apiProduce:
+			var wait bool
+			body, wait = b.produce(&d, version)
+			if !wait {
+				continue
+			}
+		default:
+			return
+		}
+		if body == nil {
+			return
+		}
+		resp := appendInt32(nil, int32(len(body)+4))
+		resp = appendInt32(resp, correlationID)
+		resp = append(resp, body...)
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (b *FakeBroker) metadata(d *decoder) []byte {
+	topics := make([]string, d.array())
+	for i := range topics {
+		topics[i] = d.string()
+	}
+	if d.err != nil {
+		return nil
+	}
+	host, port, _ := net.SplitHostPort(b.Addr())
+	portN, _ := strconv.Atoi(port)
+
+	resp := appendInt32(nil, 1) // brokers
+	resp = appendInt32(resp, 1)
+	resp = appendString(resp, host)
+	resp = appendInt32(resp, int32(portN))
+	resp = appendInt16(resp, -1) // rack
+	resp = appendInt32(resp, 1)  // controller id
+	resp = appendInt32(resp, int32(len(topics)))
+	for _, topic := range topics {
+		resp = appendInt16(resp, 0)
+		resp = appendString(resp, topic)
+		resp = append(resp, 0) // is internal
+		resp = appendInt32(resp, int32(b.partitions))
+		for p := 0; p < b.partitions; p++ {
+			resp = appendInt16(resp, 0)
+			resp = appendInt32(resp, int32(p))
+			resp = appendInt32(resp, 1) // leader
+			resp = appendInt32(resp, 1) // replicas
+			resp = appendInt32(resp, 1)
+			resp = appendInt32(resp, 1) // isr
+			resp = appendInt32(resp, 1)
+		}
+	}
+	return resp
+}
+
+func (b *FakeBroker) produce(d *decoder, version int16) ([]byte, bool) {
+	d.string() // transactional id
+	acks := d.int16()
+	d.int32() // timeout
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.produces++
+
+	topics := d.array()
+	resp := appendInt32(nil, int32(topics))
+	for ; topics > 0; topics-- {
+		topic := d.string()
+		resp = appendString(resp, topic)
+		n := d.array()
+		resp = appendInt32(resp, int32(n))
+		for ; n > 0; n-- {
+			partition := d.int32()
+			data := d.bytes()
+			if d.err != nil {
+				b.decodeErr = d.err
+				return nil, true
+			}
+			perr := ErrNone
+			if len(b.fails) > 0 {
+				perr = b.fails[0]
+				b.fails = b.fails[1:]
+			} else {
+				codec, messages, err := decodeRecords(data)
+				if err != nil {
+					b.decodeErr = err
+					perr = 2 // CORRUPT_MESSAGE
+				} else {
+					b.codecs[codec]++
+					for _, m := range messages {
+						m.Topic = topic
+						m.Partition = partition
+						b.messages = append(b.messages, m)
+					}
+				}
+			}
+			resp = appendInt32(resp, partition)
+			resp = appendInt16(resp, int16(perr))
+			resp = appendInt64(resp, 0)  // base offset
+			resp = appendInt64(resp, -1) // log append time
+			if version >= 5 {
+				resp = appendInt64(resp, 0) // log start offset
+			}
+		}
+	}
+	resp = appendInt32(resp, 0) // throttle time
+	return resp, acks != 0
+}
@@ -0,0 +1,295 @@
+package kafka_test
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/msaf1980/log-exporter/pkg/output/kafka"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "kafka"}},
+		{name: "zstd not supported", cfg: config.ConfigRaw{"type": "kafka", "compression": "zstd"}, wantErr: true},
+		{name: "brokers not set", cfg: config.ConfigRaw{"type": "kafka", "brokers": []string{}}, wantErr: true},
+		{name: "invalid broker", cfg: config.ConfigRaw{"type": "kafka", "brokers": []string{"127.0.0.1"}}, wantErr: true},
+		{name: "invalid topic", cfg: config.ConfigRaw{"type": "kafka", "topic": "logs-%{app"}, wantErr: true},
+		{name: "invalid compression", cfg: config.ConfigRaw{"type": "kafka", "compression": "brotli"}, wantErr: true},
+		{name: "invalid acks", cfg: config.ConfigRaw{"type": "kafka", "acks": "2"}, wantErr: true},
+		{name: "template not set", cfg: config.ConfigRaw{"type": "kafka", "format": "template"}, wantErr: true},
+		{name: "invalid batch_size", cfg: config.ConfigRaw{"type": "kafka", "batch_size": 0}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// acker count event acknowledges
+type acker struct {
+	mu        sync.Mutex
+	delivered int
+	failed    int
+}
+
+func (a *acker) Ack(delivered bool) {
+	a.mu.Lock()
+	if delivered {
+		a.delivered++
+	} else {
+		a.failed++
+	}
+	a.mu.Unlock()
+}
+
+func (a *acker) get() (delivered, failed int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.delivered, a.failed
+}
+
+// run send events to output and return events from out channel
+func run(t *testing.T, cfg config.ConfigRaw, events []*event.Event) []*event.Event {
+	o, err := output.New(&cfg, &config.Common{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	inChan := make(chan *event.Event, len(events))
+	outChan := make(chan *event.Event, len(events))
+	for _, e := range events {
+		inChan <- e
+	}
+	close(inChan)
+	if err = o.Start(inChan, outChan); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	close(outChan)
+	out := make([]*event.Event, 0, len(events))
+	for e := range outChan {
+		out = append(out, e)
+	}
+	if len(out) != len(events) {
+		t.Errorf("out events = %d, want %d", len(out), len(events))
+	}
+	return out
+}
+
+func TestKafka(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newEvents := func(a *acker) []*event.Event {
+		var events []*event.Event
+		for i := 0; i < 30; i++ {
+			fields := map[string]interface{}{"app": "app" + strconv.Itoa(i%2), "message": "message " + strconv.Itoa(i)}
+			if i%5 != 0 {
+				fields["host"] = "host" + strconv.Itoa(i%3)
+			}
+			events = append(events, &event.Event{Timestamp: ts.Add(time.Duration(i) * time.Millisecond), Fields: fields, Acker: a})
+		}
+		return events
+	}
+
+	tests := []struct {
+		name     string
+		cfg      config.ConfigRaw
+		fail     []kafka.Error
+		produces int // min produce requests
+	}{
+		{name: "none", cfg: config.ConfigRaw{}, produces: 1},
+		{name: "gzip", cfg: config.ConfigRaw{"compression": "gzip"}, produces: 1},
+		{name: "snappy", cfg: config.ConfigRaw{"compression": "snappy", "batch_size": 10}, produces: 3},
+		{name: "lz4", cfg: config.ConfigRaw{"compression": "lz4", "acks": "leader"}, produces: 1},
+		{name: "acks none", cfg: config.ConfigRaw{"acks": "none"}, produces: 1},
+		{
+			name:     "retry",
+			cfg:      config.ConfigRaw{},
+			fail:     []kafka.Error{kafka.ErrNotLeaderForPartition, kafka.ErrRequestTimedOut},
+			produces: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker, err := kafka.NewFakeBroker(4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer broker.Close()
+			broker.Fail(tt.fail...)
+
+			cfg := config.ConfigRaw{
+				"type":          "kafka",
+				"brokers":       []string{broker.Addr()},
+				"topic":         "logs-%{app}",
+				"key":           "host",
+				"format":        "line",
+				"retry_backoff": time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			a := &acker{}
+			events := newEvents(a)
+			for _, e := range run(t, cfg, events) {
+				if e.Failed {
+					t.Errorf("event %s failed", event.String(e))
+				}
+				event.Put(e)
+			}
+			if delivered, failed := a.get(); delivered != len(events) || failed != 0 {
+				t.Errorf("acks delivered = %d, failed = %d, want %d delivered", delivered, failed, len(events))
+			}
+
+			var (
+				messages []kafka.Message
+				produces int
+				codecs   map[kafka.Compression]int
+			)
+			// with acks none messages can be not yet readed by broker
+			for i := 0; i < 100; i++ {
+				if messages, produces, codecs, err = broker.Messages(); err != nil || len(messages) >= len(events) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatalf("broker decode error = %v", err)
+			}
+			if produces < tt.produces {
+				t.Errorf("produce requests = %d, want >= %d", produces, tt.produces)
+			}
+			var codec kafka.Compression
+			if c, ok := tt.cfg["compression"]; ok {
+				if err = codec.Set(c.(string)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(codecs) != 1 || codecs[codec] == 0 {
+				t.Errorf("batches compression = %v, want %s", codecs, codec.String())
+			}
+
+			got := make([]string, 0, len(messages))
+			keyPartitions := make(map[string]int32)
+			for _, m := range messages {
+				if m.Key != nil {
+					if p, ok := keyPartitions[*m.Key]; ok && p != m.Partition {
+						t.Errorf("key %s produced to partitions %d and %d", *m.Key, p, m.Partition)
+					}
+					keyPartitions[*m.Key] = m.Partition
+				}
+				got = append(got, m.Topic+": "+m.Value)
+			}
+			sort.Strings(got)
+			want := make([]string, 0, len(events))
+			for _, e := range newEvents(nil) {
+				want = append(want, "logs-"+e.Fields["app"].(string)+": "+e.Fields["message"].(string))
+			}
+			sort.Strings(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("messages = %q, want %q", got, want)
+			}
+			if len(keyPartitions) != 3 {
+				t.Errorf("keys = %v, want 3 keys", keyPartitions)
+			}
+		})
+	}
+}
+
+func TestKafkaDeliveryFailure(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newEvents := func(a *acker, n int) []*event.Event {
+		events := make([]*event.Event, 0, n)
+		for i := 0; i < n; i++ {
+			events = append(events, &event.Event{
+				Timestamp: ts,
+				Fields:    map[string]interface{}{"host": "host" + strconv.Itoa(i), "message": "message " + strconv.Itoa(i)},
+				Acker:     a,
+			})
+		}
+		return events
+	}
+
+	t.Run("permanent error", func(t *testing.T) {
+		broker, err := kafka.NewFakeBroker(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer broker.Close()
+		// first batch rejected, second delivered
+		broker.Fail(kafka.ErrMessageTooLarge)
+
+		a := &acker{}
+		cfg := config.ConfigRaw{"type": "kafka", "brokers": []string{broker.Addr()}, "batch_size": 2, "retry_backoff": time.Millisecond}
+		failed := 0
+		for _, e := range run(t, cfg, newEvents(a, 4)) {
+			if e.Failed {
+				failed++
+			}
+			event.Put(e)
+		}
+		if failed != 2 {
+			t.Errorf("failed events = %d, want 2", failed)
+		}
+		if delivered, failed := a.get(); delivered != 2 || failed != 2 {
+			t.Errorf("acks delivered = %d, failed = %d, want 2 and 2", delivered, failed)
+		}
+		if messages, produces, _, _ := broker.Messages(); len(messages) != 2 || produces != 2 {
+			t.Errorf("broker messages = %d, produces = %d, want 2 and 2", len(messages), produces)
+		}
+	})
+
+	t.Run("max retries", func(t *testing.T) {
+		// broker not available
+		addr, err := test.FreeAddr("tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := &acker{}
+		cfg := config.ConfigRaw{
+			"type":          "kafka",
+			"brokers":       []string{addr},
+			"timeout":       100 * time.Millisecond,
+			"max_retries":   2,
+			"retry_backoff": time.Millisecond,
+		}
+		for _, e := range run(t, cfg, newEvents(a, 3)) {
+			if !e.Failed {
+				t.Errorf("event %s not failed", event.String(e))
+			}
+			event.Put(e)
+		}
+		if delivered, failed := a.get(); delivered != 0 || failed != 3 {
+			t.Errorf("acks delivered = %d, failed = %d, want 0 and 3", delivered, failed)
+		}
+	})
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
@@ -0,0 +1,371 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+)
+
+// kafka protocol (https://kafka.apache.org/protocol), only requests used by producer
+
+const (
+	apiProduce  int16 = 0
+	apiMetadata int16 = 3
+
+	metadataVersion int16 = 1
+	produceVersion  int16 = 3 // record batch v2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var errShortBuffer = errors.New("kafka: short response")
+
+// Error is a kafka protocol error code
+type Error int16
+
+const (
+	ErrNone                    Error = 0
+	ErrUnknownTopicOrPartition Error = 3
+	ErrLeaderNotAvailable      Error = 5
+	ErrNotLeaderForPartition   Error = 6
+	ErrRequestTimedOut         Error = 7
+	ErrMessageTooLarge         Error = 10
+	ErrNetworkException        Error = 13
+	ErrNotEnoughReplicas       Error = 19
+	ErrNotEnoughReplicasAfter  Error = 20
+	ErrKafkaStorageError       Error = 56
+)
+
+var errorStrings = map[Error]string{
+	-1:                         "UNKNOWN_SERVER_ERROR",
+	ErrUnknownTopicOrPartition: "UNKNOWN_TOPIC_OR_PARTITION",
+	ErrLeaderNotAvailable:      "LEADER_NOT_AVAILABLE",
+	ErrNotLeaderForPartition:   "NOT_LEADER_OR_FOLLOWER",
+	ErrRequestTimedOut:         "REQUEST_TIMED_OUT",
+	ErrMessageTooLarge:         "MESSAGE_TOO_LARGE",
+	ErrNetworkException:        "NETWORK_EXCEPTION",
+	ErrNotEnoughReplicas:       "NOT_ENOUGH_REPLICAS",
+	ErrNotEnoughReplicasAfter:  "NOT_ENOUGH_REPLICAS_AFTER_APPEND",
+	ErrKafkaStorageError:       "KAFKA_STORAGE_ERROR",
+	2:                          "CORRUPT_MESSAGE",
+	18:                         "RECORD_LIST_TOO_LARGE",
+	29:                         "TOPIC_AUTHORIZATION_FAILED",
+	76:                         "UNSUPPORTED_COMPRESSION_TYPE",
+	87:                         "INVALID_RECORD",
+}
+
+func (e Error) Error() string {
+	if s, ok := errorStrings[e]; ok {
+		return "kafka: " + s
+	}
+	return "kafka: error code " + strconv.Itoa(int(e))
+}
+
+// Retriable return true, if request can be retried (may be after metadata refresh)
+func (e Error) Retriable() bool {
+	switch e {
+	case ErrUnknownTopicOrPartition, ErrLeaderNotAvailable, ErrNotLeaderForPartition, ErrRequestTimedOut,
+		ErrNetworkException, ErrNotEnoughReplicas, ErrNotEnoughReplicasAfter, ErrKafkaStorageError:
+		return true
+	default:
+		return false
+	}
+}
+
+func appendInt16(b []byte, v int16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendInt32(b []byte, v int32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendInt16(b, int16(len(s)))
+	return append(b, s...)
+}
+
+// appendVarint append zigzag encoded varint (for record fields)
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendRequestHeader append request header v1 (after size, which is set by request)
+func appendRequestHeader(b []byte, apiKey, version int16, correlationID int32, clientID string) []byte {
+	b = appendInt32(b, 0) // size
+	b = appendInt16(b, apiKey)
+	b = appendInt16(b, version)
+	b = appendInt32(b, correlationID)
+	return appendString(b, clientID)
+}
+
+// decoder read big-endian protocol fields, first error is saved and later reads return zero values
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.b) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) int8() int8 {
+	if v := d.next(1); v != nil {
+		return int8(v[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if v := d.next(2); v != nil {
+		return int16(binary.BigEndian.Uint16(v))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if v := d.next(4); v != nil {
+		return int32(binary.BigEndian.Uint32(v))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if v := d.next(8); v != nil {
+		return int64(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+// string read string or nullable string (null is returned as empty)
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+// bytes read nullable bytes
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.next(int(n))
+}
+
+// array read array length (null is returned as 0)
+func (d *decoder) array() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.b) {
+		// every element is at least 1 byte
+		d.err = errShortBuffer
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// brokerMeta is a broker from metadata response
+type brokerMeta struct {
+	id   int32
+	host string
+	port int32
+}
+
+func (b brokerMeta) addr() string {
+	return b.host + ":" + strconv.Itoa(int(b.port))
+}
+
+// topicMeta is a topic from metadata response, leaders indexed by partition (-1 if no leader)
+type topicMeta struct {
+	name    string
+	err     Error
+	leaders []int32
+}
+
+// metadataRequest encode metadata request v1 body for topics
+func metadataRequest(b []byte, topics []string) []byte {
+	b = appendInt32(b, int32(len(topics)))
+	for _, topic := range topics {
+		b = appendString(b, topic)
+	}
+	return b
+}
+
+// metadataResponse decode metadata response v1 body
+func metadataResponse(b []byte) ([]brokerMeta, []topicMeta, error) {
+	d := decoder{b: b}
+	brokers := make([]brokerMeta, d.array())
+	for i := range brokers {
+		brokers[i].id = d.int32()
+		brokers[i].host = d.string()
+		brokers[i].port = d.int32()
+		d.string() // rack
+	}
+	d.int32() // controller id
+	topics := make([]topicMeta, d.array())
+	for i := range topics {
+		topics[i].err = Error(d.int16())
+		topics[i].name = d.string()
+		d.int8() // is internal
+		n := d.array()
+		for j := 0; j < n && d.err == nil; j++ {
+			d.int16() // partition error, leader is -1 if not available
+			partition := d.int32()
+			leader := d.int32()
+			for k := d.array(); k > 0; k-- {
+				d.int32() // replica
+			}
+			for k := d.array(); k > 0; k-- {
+				d.int32() // isr
+			}
+			if partition < 0 || int(partition) >= n {
+				d.err = errors.New("kafka: invalid partition " + strconv.Itoa(int(partition)))
+				break
+			}
+			if topics[i].leaders == nil {
+				topics[i].leaders = make([]int32, n)
+			}
+			topics[i].leaders[partition] = leader
+		}
+	}
+	return brokers, topics, d.err
+}
+
+// partitionBatch is a record batch for topic partition
+type partitionBatch struct {
+	topic     string
+	partition int32
+	records   []*record
+	data      []byte // encoded record batch
+	err       error  // last produce error
+}
+
+// produceRequest encode produce request body (v3 and later) for batches
+func produceRequest(b []byte, acks int16, timeoutMs int32, batches []*partitionBatch) []byte {
+	b = appendInt16(b, -1) // transactional id
+	b = appendInt16(b, acks)
+	b = appendInt32(b, timeoutMs)
+
+	// batches are grouped by topic
+	var topics []string
+	partitions := make(map[string][]*partitionBatch)
+	for _, pb := range batches {
+		if _, ok := partitions[pb.topic]; !ok {
+			topics = append(topics, pb.topic)
+		}
+		partitions[pb.topic] = append(partitions[pb.topic], pb)
+	}
+	b = appendInt32(b, int32(len(topics)))
+	for _, topic := range topics {
+		b = appendString(b, topic)
+		b = appendInt32(b, int32(len(partitions[topic])))
+		for _, pb := range partitions[topic] {
+			b = appendInt32(b, pb.partition)
+			b = appendInt32(b, int32(len(pb.data)))
+			b = append(b, pb.data...)
+		}
+	}
+	return b
+}
+
+// produceResult is a partition result from produce response
+type produceResult struct {
+	topic     string
+	partition int32
+	err       Error
+}
+
+// produceResponse decode produce response body
+func produceResponse(b []byte, version int16) ([]produceResult, error) {
+	d := decoder{b: b}
+	var results []produceResult
+	for i := d.array(); i > 0; i-- {
+		topic := d.string()
+		for j := d.array(); j > 0; j-- {
+			r := produceResult{topic: topic}
+			r.partition = d.int32()
+			r.err = Error(d.int16())
+			d.int64() // base offset
+			d.int64() // log append time
+			if version >= 5 {
+				d.int64() // log start offset
+			}
+			results = append(results, r)
+		}
+	}
+	return results, d.err
+}
+
+// murmur2 is a kafka default partitioner hash
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// keyPartition return partition for key (like kafka default partitioner)
+func keyPartition(key []byte, partitions int) int32 {
+	return int32((murmur2(key) & 0x7fffffff) % int32(partitions))
+}
@@ -0,0 +1,65 @@
+package kafka
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMurmur2(t *testing.T) {
+	// values from kafka java client
+	tests := []struct {
+		key  string
+		want int32
+	}{
+		{key: "21", want: -973932308},
+		{key: "foobar", want: -790332482},
+		{key: "a-little-bit-long-string", want: -985981536},
+		{key: "a-little-bit-longer-string", want: -1486304829},
+		{key: "lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", want: -58897971},
+		{key: "abc", want: 479470107},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := murmur2([]byte(tt.key)); got != tt.want {
+				t.Errorf("murmur2() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordBatch(t *testing.T) {
+	ts := time.Date(2021, 1, 2, 10, 0, 0, 0, time.UTC)
+	key := "host1"
+	want := []Message{
+		{Key: &key, Value: "first", Timestamp: ts},
+		{Value: strings.Repeat("second ", 1000), Timestamp: ts.Add(-time.Second)},
+		{Key: &key, Value: "", Timestamp: ts.Add(time.Minute)},
+	}
+	for _, codec := range []Compression{CompressionNone, CompressionGzip, CompressionSnappy, CompressionLZ4} {
+		t.Run(codec.String(), func(t *testing.T) {
+			pb := &partitionBatch{topic: "logs"}
+			for _, m := range want {
+				r := &record{value: []byte(m.Value), ts: m.Timestamp.UnixNano() / int64(time.Millisecond)}
+				if m.Key != nil {
+					r.key = []byte(*m.Key)
+				}
+				pb.records = append(pb.records, r)
+			}
+			if err := pb.encode(codec); err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			gotCodec, got, err := decodeRecords(pb.data)
+			if err != nil {
+				t.Fatalf("decodeRecords() error = %v", err)
+			}
+			if gotCodec != codec {
+				t.Errorf("compression = %s, want %s", gotCodec.String(), codec.String())
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("messages = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
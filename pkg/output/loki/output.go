@@ -0,0 +1,597 @@
+package loki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "loki"
+
+type Encoding int8
+
+const (
+	EncodingProtobuf Encoding = iota
+	EncodingJSON
+)
+
+var encodingStrings []string = []string{"protobuf", "json"}
+
+func (e *Encoding) Set(value string) error {
+	switch value {
+	case "protobuf", "":
+		*e = EncodingProtobuf
+	case "json":
+		*e = EncodingJSON
+	default:
+		return fmt.Errorf("invalid encoding %s", value)
+	}
+	return nil
+}
+
+func (e *Encoding) String() string {
+	return encodingStrings[*e]
+}
+
+func (e *Encoding) UnmarshalText(text []byte) error {
+	return e.Set(string(text))
+}
+
+type OutOfOrder int8
+
+const (
+	OutOfOrderFix  OutOfOrder = iota // set entry timestamp to last sended stream timestamp
+	OutOfOrderDrop                   // drop entry
+	OutOfOrderSend                   // send as is (for Loki with unordered writes)
+)
+
+var outOfOrderStrings []string = []string{"fix", "drop", "send"}
+
+func (o *OutOfOrder) Set(value string) error {
+	switch value {
+	case "fix", "":
+		*o = OutOfOrderFix
+	case "drop":
+		*o = OutOfOrderDrop
+	case "send":
+		*o = OutOfOrderSend
+	default:
+		return fmt.Errorf("invalid out_of_order %s", value)
+	}
+	return nil
+}
+
+func (o *OutOfOrder) String() string {
+	return outOfOrderStrings[*o]
+}
+
+func (o *OutOfOrder) UnmarshalText(text []byte) error {
+	return o.Set(string(text))
+}
+
+type Config struct {
+	output.Config
+
+	URL          string            `hcl:"url" yaml:"url" json:"url"`                               // push url, like http://127.0.0.1:3100/loki/api/v1/push
+	Encoding     Encoding          `hcl:"encoding" yaml:"encoding" json:"encoding"`                // protobuf (snappy compressed, default) or json
+	Labels       []string          `hcl:"labels" yaml:"labels" json:"labels"`                      // fields for stream labels (label name is a field name with '.' replaced to '_')
+	StaticLabels map[string]string `hcl:"static_labels" yaml:"static_labels" json:"static_labels"` // labels, added to all streams
+	Format       output.Format     `hcl:"format" yaml:"format" json:"format"`                      // line format: line (message field, default), json, rubydebug or template
+	Template     string            `hcl:"template" yaml:"template" json:"template"`                // template for template format
+	TenantID     string            `hcl:"tenant_id" yaml:"tenant_id" json:"tenant_id"`             // X-Scope-OrgID header
+	Username     string            `hcl:"username" yaml:"username" json:"username"`                // basic auth username
+	Password     string            `hcl:"password" yaml:"password" json:"password"`                // basic auth password
+
+	OutOfOrder        OutOfOrder    `hcl:"out_of_order" yaml:"out_of_order" json:"out_of_order"`                   // entries, older than last sended in stream: fix (default), drop or send
+	MaxLabelValues    int           `hcl:"max_label_values" yaml:"max_label_values" json:"max_label_values"`       // max values for label in cardinality_window (0 - unlimited)
+	CardinalityWindow time.Duration `hcl:"cardinality_window" yaml:"cardinality_window" json:"cardinality_window"` // reset label values (and streams state) interval
+	OverflowValue     string        `hcl:"overflow_value" yaml:"overflow_value" json:"overflow_value"`             // label value, when max_label_values reached
+	BatchSize         int           `hcl:"batch_size" yaml:"batch_size" json:"batch_size"`                         // max entries in push request
+	BatchBytes        config.Size   `hcl:"batch_bytes" yaml:"batch_bytes" json:"batch_bytes"`                      // max lines size in push request
+	FlushInterval     time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"`             // send not full batch after interval
+	Timeout           time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                                  // request timeout
+	MaxRetries        int           `hcl:"max_retries" yaml:"max_retries" json:"max_retries"`                      // retries on network errors and 429/5xx statuses
+	RetryBackoff      time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`                // initial retry delay (doubled on every retry)
+	MaxRetryBackoff   time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"`    // max retry delay
+	TLS               config.TLS    `hcl:"tls" yaml:"tls" json:"tls"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:            output.Config{Type: Name},
+		URL:               "http://127.0.0.1:3100/loki/api/v1/push",
+		Labels:            []string{"host", "path", "type"},
+		Format:            output.FormatLine,
+		MaxLabelValues:    1000,
+		CardinalityWindow: time.Hour,
+		OverflowValue:     "__overflow__",
+		BatchSize:         1000,
+		BatchBytes:        config.Size(1024 * 1024),
+		FlushInterval:     time.Second,
+		Timeout:           30 * time.Second,
+		MaxRetries:        5,
+		RetryBackoff:      100 * time.Millisecond,
+		MaxRetryBackoff:   10 * time.Second,
+	}
+}
+
+// labelField is a label from event field
+type labelField struct {
+	name string
+	path []string
+}
+
+// Loki is output for send events to Grafana Loki with push API.
+//
+// Events are grouped to streams by labels (from event fields), entries sorted by event timestamp in stream.
+// Loki (without unordered writes) reject entries, older than last in stream, so these entries are fixed
+// (timestamp set to last sended), dropped or sended as is (see out_of_order).
+// Entries, rejected by Loki as out of order (sended by other writer or before restart), are fixed or dropped and resended once.
+// Label values are limited (per label) with max_label_values, new values replaced with overflow_value.
+// Events are returned after push result, not delivered events are marked as failed.
+type Loki struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	formatter *output.Formatter
+	fields    []labelField
+	static    []label
+	client    *http.Client
+
+	values     map[string]map[string]struct{} // label values (for cardinality limit)
+	overflowed map[string]bool
+	last       map[string]time.Time // last sended timestamp for stream
+	resetAt    time.Time
+
+	streams map[string]*stream
+	order   []*stream // streams in batch
+	entries int
+	size    int64
+	events  []*event.Event // batch events, hold until push result
+
+	body []byte
+	buf  []byte
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Loki{
+		cfg:        defaultConfig(),
+		cfgRaw:     cfg,
+		common:     common,
+		values:     make(map[string]map[string]struct{}),
+		overflowed: make(map[string]bool),
+		last:       make(map[string]time.Time),
+		streams:    make(map[string]*stream),
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(o.cfg.URL, "http://") && !strings.HasPrefix(o.cfg.URL, "https://") {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid url " + o.cfg.URL)
+	}
+	if len(o.cfg.Labels) == 0 && len(o.cfg.StaticLabels) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': labels not set")
+	}
+	names := make(map[string]bool)
+	for _, field := range o.cfg.Labels {
+		name := strings.ReplaceAll(field, ".", "_")
+		if !validLabel(name) {
+			return nil, errors.New("output '" + o.cfg.Type + "': invalid label " + name)
+		}
+		if names[name] {
+			return nil, errors.New("output '" + o.cfg.Type + "': duplicate label " + name)
+		}
+		names[name] = true
+		o.fields = append(o.fields, labelField{name: name, path: strings.Split(field, ".")})
+	}
+	for name, value := range o.cfg.StaticLabels {
+		if !validLabel(name) {
+			return nil, errors.New("output '" + o.cfg.Type + "': invalid label " + name)
+		}
+		if names[name] {
+			return nil, errors.New("output '" + o.cfg.Type + "': duplicate label " + name)
+		}
+		names[name] = true
+		o.static = append(o.static, label{name: name, value: value})
+	}
+	if o.formatter, err = output.NewFormatter(o.cfg.Format, o.cfg.Template); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	if o.cfg.MaxLabelValues < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_label_values must be >= 0")
+	}
+	if o.cfg.CardinalityWindow <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': cardinality_window must be > 0")
+	}
+	if o.cfg.BatchSize < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_size must be > 0")
+	}
+	if o.cfg.BatchBytes.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': batch_bytes must be > 0")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.MaxRetries < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_retries must be >= 0")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	tlsConfig, err := o.cfg.TLS.ClientConfig()
+	if err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+	}
+	o.client = &http.Client{
+		Timeout:   o.cfg.Timeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+	}
+
+	return o, nil
+}
+
+func (o *Loki) Name() string {
+	return Name
+}
+
+func (o *Loki) Acknowledging() {}
+
+// validLabel check label name ([a-zA-Z_][a-zA-Z0-9_]*)
+func validLabel(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// limit return label value (or overflow_value, if max_label_values reached)
+func (o *Loki) limit(name, value string) string {
+	if o.cfg.MaxLabelValues == 0 {
+		return value
+	}
+	values, ok := o.values[name]
+	if !ok {
+		values = make(map[string]struct{})
+		o.values[name] = values
+	}
+	if _, ok = values[value]; ok {
+		return value
+	}
+	if len(values) >= o.cfg.MaxLabelValues {
+		if !o.overflowed[name] {
+			o.overflowed[name] = true
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("label", name).Int("max_label_values", o.cfg.MaxLabelValues).
+				Msg("label values limit reached, new values replaced")
+		}
+		return o.cfg.OverflowValue
+	}
+	values[value] = struct{}{}
+	return value
+}
+
+// labels return stream labels for event (sorted by name)
+func (o *Loki) labels(e *event.Event) []label {
+	labels := make([]label, 0, len(o.fields)+len(o.static))
+	for i := range o.fields {
+		v, ok := output.Lookup(e.Fields, o.fields[i].path)
+		if !ok || v == nil {
+			continue
+		}
+		o.buf = output.AppendValue(o.buf[:0], v)
+		if len(o.buf) == 0 {
+			continue
+		}
+		labels = append(labels, label{name: o.fields[i].name, value: o.limit(o.fields[i].name, string(o.buf))})
+	}
+	labels = append(labels, o.static...)
+	sortLabels(labels)
+	return labels
+}
+
+// add add event to batch, return false if event skipped
+func (o *Loki) add(e *event.Event) bool {
+	var err error
+	if o.buf, err = o.formatter.Append(o.buf[:0], e); err != nil {
+		log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("format")
+		return false
+	}
+	line := string(o.buf[:len(o.buf)-1])
+
+	labels := o.labels(e)
+	if len(labels) == 0 {
+		log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Msg("no labels")
+		return false
+	}
+	key := labelsString(labels)
+	s, ok := o.streams[key]
+	if !ok {
+		s = &stream{key: key, labels: labels}
+		o.streams[key] = s
+		o.order = append(o.order, s)
+	}
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	s.entries = append(s.entries, entry{ts: ts, line: line})
+	o.entries++
+	o.size += int64(len(line))
+	o.events = append(o.events, e)
+
+	return true
+}
+
+// prepare sort stream entries and handle out of order entries
+func (o *Loki) prepare() []*stream {
+	streams := o.order[:0]
+	for _, s := range o.order {
+		sort.SliceStable(s.entries, func(i, j int) bool { return s.entries[i].ts.Before(s.entries[j].ts) })
+		if last, ok := o.last[s.key]; ok && o.cfg.OutOfOrder != OutOfOrderSend {
+			n := 0
+			for i := range s.entries {
+				if !s.entries[i].ts.Before(last) {
+					break
+				}
+				if o.cfg.OutOfOrder == OutOfOrderFix {
+					s.entries[i].ts = last
+				}
+				n++
+			}
+			if n > 0 {
+				if o.cfg.OutOfOrder == OutOfOrderDrop {
+					s.entries = s.entries[n:]
+				}
+				log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("stream", s.key).Int("entries", n).
+					Str("out_of_order", o.cfg.OutOfOrder.String()).Msg("out of order entries")
+			}
+		}
+		if len(s.entries) > 0 {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// sended update last sended timestamp for streams (only after successful push)
+func (o *Loki) sended(streams []*stream) {
+	for _, s := range streams {
+		o.last[s.key] = s.entries[len(s.entries)-1].ts
+	}
+}
+
+// rejectedRe match entry, rejected by Loki, like
+//
+// entry with timestamp 2021-03-04 05:06:07 +0000 UTC ignored, reason: 'entry out of order' for stream: {host="localhost"},
+var rejectedRe = regexp.MustCompile(`entry with timestamp (.+?) ignored, reason: '[^']*' for stream: (\{.*?\}),\n`)
+
+// parseRejected return max rejected timestamp by stream key from Loki out of order error
+func parseRejected(msg string) map[string]time.Time {
+	var rejected map[string]time.Time
+	for _, m := range rejectedRe.FindAllStringSubmatch(msg, -1) {
+		ts, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", m[1])
+		if err != nil {
+			continue
+		}
+		if rejected == nil {
+			rejected = make(map[string]time.Time)
+		}
+		if last, ok := rejected[m[2]]; !ok || ts.After(last) {
+			rejected[m[2]] = ts
+		}
+	}
+	return rejected
+}
+
+// rejected apply out_of_order to entries, rejected by Loki as out of order, and return streams for resend.
+// Other entries are stored by Loki. Loki return limited count of rejected entries,
+// so entries after last returned are considered as stored.
+func (o *Loki) rejected(streams []*stream, err error) ([]*stream, bool) {
+	rejected := parseRejected(err.Error())
+	if len(rejected) == 0 {
+		return nil, false
+	}
+	var resend []*stream
+	now := time.Now()
+	for _, s := range streams {
+		ts, ok := rejected[s.key]
+		if !ok {
+			o.last[s.key] = s.entries[len(s.entries)-1].ts
+			continue
+		}
+		// entries are sorted, so rejected entries are before stored
+		n := sort.Search(len(s.entries), func(i int) bool { return s.entries[i].ts.After(ts) })
+		if n == 0 {
+			continue
+		}
+		// last timestamp in Loki stream is unknown, if all entries rejected
+		last := now
+		if n < len(s.entries) {
+			last = s.entries[len(s.entries)-1].ts
+			o.last[s.key] = last
+		}
+		log.Debug().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("stream", s.key).Int("entries", n).
+			Str("out_of_order", o.cfg.OutOfOrder.String()).Msg("out of order entries rejected")
+		if o.cfg.OutOfOrder == OutOfOrderDrop {
+			continue
+		}
+		s.entries = s.entries[:n]
+		for i := range s.entries {
+			s.entries[i].ts = last
+		}
+		resend = append(resend, s)
+	}
+	return resend, true
+}
+
+// push send push request, return true for retry
+func (o *Loki) push(streams []*stream) (bool, error) {
+	contentType := "application/x-protobuf"
+	if o.cfg.Encoding == EncodingJSON {
+		contentType = "application/json"
+		o.body = appendJSON(o.body[:0], streams)
+	} else {
+		o.buf = appendProto(o.buf[:0], streams)
+		o.body = snappy.Encode(o.body[:cap(o.body)], o.buf)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, o.cfg.URL, bytes.NewReader(o.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if o.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", o.cfg.TenantID)
+	}
+	if o.cfg.Username != "" {
+		req.SetBasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// flush send batch (with retries), batch events returned to out channel
+func (o *Loki) flush(outChan chan<- *event.Event) {
+	if o.entries == 0 {
+		return
+	}
+	streams := o.prepare()
+	entries := o.entries
+	failed := false
+	resended := false
+
+	for attempt := 0; len(streams) > 0; attempt++ {
+		if attempt > 0 {
+			time.Sleep(output.Backoff(attempt-1, o.cfg.RetryBackoff, o.cfg.MaxRetryBackoff))
+		}
+		retry, err := o.push(streams)
+		if err == nil {
+			o.sended(streams)
+			break
+		}
+		if !retry {
+			if isOutOfOrder(err) && !resended && o.cfg.OutOfOrder != OutOfOrderSend {
+				var ok bool
+				if streams, ok = o.rejected(streams, err); ok {
+					// resend once fixed entries (without backoff)
+					resended = true
+					attempt = -1
+					continue
+				}
+			}
+			if isOutOfOrder(err) {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("entries", entries).Err(err).Msg("out of order entries rejected")
+			} else {
+				log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("entries", entries).Err(err).Msg("push rejected, entries dropped")
+			}
+			failed = true
+			break
+		}
+		if attempt >= o.cfg.MaxRetries {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("entries", entries).Err(err).Msg("push failed, entries dropped")
+			failed = true
+			break
+		}
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("entries", entries).Int("attempt", attempt).Err(err).Msg("push failed")
+	}
+
+	for _, s := range o.order {
+		delete(o.streams, s.key)
+	}
+	o.order = o.order[:0]
+	o.entries = 0
+	o.size = 0
+	for i, e := range o.events {
+		if failed {
+			e.Failed = true
+		}
+		outChan <- e
+		o.events[i] = nil
+	}
+	o.events = o.events[:0]
+}
+
+// isOutOfOrder check for Loki out of order rejection (400 with out of order or too far behind entries)
+func isOutOfOrder(err error) bool {
+	s := err.Error()
+	return strings.HasPrefix(s, "400 ") && (strings.Contains(s, "out of order") || strings.Contains(s, "too far behind"))
+}
+
+// reset cardinality state on window end
+func (o *Loki) reset(now time.Time) {
+	if now.Before(o.resetAt) {
+		return
+	}
+	o.resetAt = now.Add(o.cfg.CardinalityWindow)
+	o.values = make(map[string]map[string]struct{})
+	o.overflowed = make(map[string]bool)
+	// streams without entries in window also forgotten
+	for key, ts := range o.last {
+		if now.Sub(ts) > o.cfg.CardinalityWindow {
+			delete(o.last, key)
+		}
+	}
+}
+
+func (o *Loki) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+	o.resetAt = time.Now().Add(o.cfg.CardinalityWindow)
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				o.flush(outChan)
+				return nil
+			}
+			if !o.add(e) {
+				// skipped
+				outChan <- e
+				continue
+			}
+			if o.entries >= o.cfg.BatchSize || o.size >= o.cfg.BatchBytes.Value() {
+				o.flush(outChan)
+			}
+		case now := <-ticker.C:
+			o.flush(outChan)
+			o.reset(now)
+		}
+	}
+}
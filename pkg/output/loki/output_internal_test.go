@@ -0,0 +1,62 @@
+package loki
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Entry is a decoded push request entry
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+}
+
+// DecodePush decode push request body to entries by stream labels (in prometheus format)
+func DecodePush(body []byte, contentType string) (map[string][]Entry, error) {
+	streams := make(map[string][]Entry)
+	if contentType == "application/json" {
+		var req struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := jsoniter.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		for _, s := range req.Streams {
+			labels := make([]label, 0, len(s.Stream))
+			for name, value := range s.Stream {
+				labels = append(labels, label{name: name, value: value})
+			}
+			sortLabels(labels)
+			key := labelsString(labels)
+			for _, v := range s.Values {
+				ns, err := strconv.ParseInt(v[0], 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				streams[key] = append(streams[key], Entry{Timestamp: time.Unix(0, ns), Line: v[1]})
+			}
+		}
+		return streams, nil
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range decoded {
+		for _, e := range s.entries {
+			streams[s.key] = append(streams[s.key], Entry{Timestamp: e.ts, Line: e.line})
+		}
+	}
+	return streams, nil
+}
@@ -0,0 +1,397 @@
+package loki_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/msaf1980/log-exporter/pkg/output/loki"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "loki"}},
+		{name: "json", cfg: config.ConfigRaw{"type": "loki", "encoding": "json", "labels": []string{"host", "kubernetes.pod"}}},
+		{name: "invalid url", cfg: config.ConfigRaw{"type": "loki", "url": "127.0.0.1:3100"}, wantErr: true},
+		{name: "invalid encoding", cfg: config.ConfigRaw{"type": "loki", "encoding": "xml"}, wantErr: true},
+		{name: "invalid label", cfg: config.ConfigRaw{"type": "loki", "labels": []string{"host-name"}}, wantErr: true},
+		{name: "duplicate label", cfg: config.ConfigRaw{"type": "loki", "labels": []string{"a.b", "a_b"}}, wantErr: true},
+		{name: "labels not set", cfg: config.ConfigRaw{"type": "loki", "labels": []string{}}, wantErr: true},
+		{name: "invalid out_of_order", cfg: config.ConfigRaw{"type": "loki", "out_of_order": "skip"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// pushServer is a fake Loki push endpoint (first request failed with 503)
+type pushServer struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	requests int
+	streams  map[string][]loki.Entry
+	fail     int                  // request, failed with 400
+	last     map[string]time.Time // reject out of order entries (if not nil), like Loki without unordered writes
+}
+
+func (s *pushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.requests == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if s.requests == s.fail {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	streams, err := loki.DecodePush(body, r.Header.Get("Content-Type"))
+	if err != nil {
+		s.t.Errorf("DecodePush() error = %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var rejected strings.Builder
+	for key, entries := range streams {
+		for _, e := range entries {
+			if s.last != nil {
+				if e.Timestamp.Before(s.last[key]) {
+					fmt.Fprintf(&rejected, "entry with timestamp %s ignored, reason: 'entry out of order' for stream: %s,\n", e.Timestamp.UTC().String(), key)
+					continue
+				}
+				s.last[key] = e.Timestamp
+			}
+			s.streams[key] = append(s.streams[key], e)
+		}
+	}
+	if rejected.Len() > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		rejected.WriteString("total ignored")
+		w.Write([]byte(rejected.String()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLoki(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newEvent := func(host, app string, sec int, message string) *event.Event {
+		return &event.Event{
+			Timestamp: ts.Add(time.Duration(sec) * time.Second),
+			Fields:    map[string]interface{}{"host": host, "app": app, "message": message},
+		}
+	}
+	// batch_size is 4, so last event is out of order for second batch
+	events := []*event.Event{
+		newEvent("a", "web", 10, "a 10"),
+		newEvent("b", "web", 1, "b 1"),
+		newEvent("a", "web", 5, "a 5"),
+		newEvent("a", "db", 8, "a 8"),
+		newEvent("a", "web", 7, "a 7"),
+		newEvent("b", "web", 2, "b 2"),
+		newEvent("b", "cache", 3, "b 3"),
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.ConfigRaw
+		want map[string][]loki.Entry
+	}{
+		{
+			name: "protobuf",
+			cfg:  config.ConfigRaw{},
+			want: map[string][]loki.Entry{
+				`{app="web", env="test", host="a"}`: {
+					{Timestamp: ts.Add(5 * time.Second), Line: "a 5"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 10"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 7"},
+				},
+				`{app="db", env="test", host="a"}`: {
+					{Timestamp: ts.Add(8 * time.Second), Line: "a 8"},
+				},
+				`{app="__overflow__", env="test", host="b"}`: {
+					{Timestamp: ts.Add(3 * time.Second), Line: "b 3"},
+				},
+				`{app="web", env="test", host="b"}`: {
+					{Timestamp: ts.Add(1 * time.Second), Line: "b 1"},
+					{Timestamp: ts.Add(2 * time.Second), Line: "b 2"},
+				},
+			},
+		},
+		{
+			name: "json with drop",
+			cfg:  config.ConfigRaw{"encoding": "json", "out_of_order": "drop"},
+			want: map[string][]loki.Entry{
+				`{app="web", env="test", host="a"}`: {
+					{Timestamp: ts.Add(5 * time.Second), Line: "a 5"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 10"},
+				},
+				`{app="db", env="test", host="a"}`: {
+					{Timestamp: ts.Add(8 * time.Second), Line: "a 8"},
+				},
+				`{app="__overflow__", env="test", host="b"}`: {
+					{Timestamp: ts.Add(3 * time.Second), Line: "b 3"},
+				},
+				`{app="web", env="test", host="b"}`: {
+					{Timestamp: ts.Add(1 * time.Second), Line: "b 1"},
+					{Timestamp: ts.Add(2 * time.Second), Line: "b 2"},
+				},
+			},
+		},
+		{
+			name: "send",
+			cfg:  config.ConfigRaw{"out_of_order": "send"},
+			want: map[string][]loki.Entry{
+				`{app="web", env="test", host="a"}`: {
+					{Timestamp: ts.Add(5 * time.Second), Line: "a 5"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 10"},
+					{Timestamp: ts.Add(7 * time.Second), Line: "a 7"},
+				},
+				`{app="db", env="test", host="a"}`: {
+					{Timestamp: ts.Add(8 * time.Second), Line: "a 8"},
+				},
+				`{app="__overflow__", env="test", host="b"}`: {
+					{Timestamp: ts.Add(3 * time.Second), Line: "b 3"},
+				},
+				`{app="web", env="test", host="b"}`: {
+					{Timestamp: ts.Add(1 * time.Second), Line: "b 1"},
+					{Timestamp: ts.Add(2 * time.Second), Line: "b 2"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &pushServer{t: t, streams: make(map[string][]loki.Entry)}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			cfg := config.ConfigRaw{
+				"type":             "loki",
+				"url":              srv.URL + "/loki/api/v1/push",
+				"labels":           []string{"host", "app"},
+				"static_labels":    map[string]string{"env": "test"},
+				"tenant_id":        "tenant",
+				"max_label_values": 2,
+				"batch_size":       4,
+				"retry_backoff":    time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				inChan <- e
+			}
+			close(inChan)
+
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+			// first request retried
+			if s.requests != 3 {
+				t.Errorf("requests = %d, want 3", s.requests)
+			}
+			for key, entries := range s.streams {
+				for i := range entries {
+					entries[i].Timestamp = entries[i].Timestamp.UTC()
+				}
+				if !reflect.DeepEqual(entries, tt.want[key]) {
+					t.Errorf("stream %s =\n%+v\nwant\n%+v", key, entries, tt.want[key])
+				}
+			}
+			if len(s.streams) != len(tt.want) {
+				t.Errorf("streams = %+v, want %+v", s.streams, tt.want)
+			}
+		})
+	}
+}
+
+func TestLokiRejected(t *testing.T) {
+	start := time.Now()
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	newEvent := func(host string, sec int, message string) *event.Event {
+		return &event.Event{
+			Timestamp: ts.Add(time.Duration(sec) * time.Second),
+			Fields:    map[string]interface{}{"host": host, "message": message},
+		}
+	}
+	// batch_size is 4, second batch is a 9
+	events := []*event.Event{
+		newEvent("a", 5, "a 5"),
+		newEvent("a", 7, "a 7"),
+		newEvent("a", 10, "a 10"),
+		newEvent("b", 1, "b 1"),
+		newEvent("a", 9, "a 9"),
+	}
+
+	tests := []struct {
+		name         string
+		cfg          config.ConfigRaw
+		fail         int
+		last         map[string]time.Time // last timestamp in Loki streams
+		wantRequests int
+		wantFailed   int
+		want         map[string][]loki.Entry
+	}{
+		{
+			name: "fix",
+			last: map[string]time.Time{`{host="a"}`: ts.Add(8 * time.Second), `{host="b"}`: ts.Add(100 * time.Second)},
+			// rejected entries resended
+			wantRequests: 4,
+			want: map[string][]loki.Entry{
+				`{host="a"}`: {
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 10"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 5"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 7"},
+					{Timestamp: ts.Add(10 * time.Second), Line: "a 9"},
+				},
+				// last Loki timestamp is unknown, fixed to send time
+				`{host="b"}`: {{Line: "b 1"}},
+			},
+		},
+		{
+			name:         "drop",
+			cfg:          config.ConfigRaw{"out_of_order": "drop"},
+			last:         map[string]time.Time{`{host="a"}`: ts.Add(8 * time.Second), `{host="b"}`: ts.Add(100 * time.Second)},
+			wantRequests: 2,
+			want: map[string][]loki.Entry{
+				`{host="a"}`: {{Timestamp: ts.Add(10 * time.Second), Line: "a 10"}},
+			},
+		},
+		{
+			name: "failed batch",
+			fail: 2,
+			// last timestamp not updated by failed batch, so a 9 is not fixed
+			wantRequests: 3,
+			wantFailed:   4,
+			want: map[string][]loki.Entry{
+				`{host="a"}`: {{Timestamp: ts.Add(9 * time.Second), Line: "a 9"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &pushServer{t: t, streams: make(map[string][]loki.Entry), fail: tt.fail, last: tt.last}
+			srv := httptest.NewServer(s)
+			defer srv.Close()
+
+			cfg := config.ConfigRaw{
+				"type":          "loki",
+				"url":           srv.URL + "/loki/api/v1/push",
+				"labels":        []string{"host"},
+				"tenant_id":     "tenant",
+				"batch_size":    4,
+				"retry_backoff": time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			out, err := output.New(&cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				e.Failed = false
+				inChan <- e
+			}
+			close(inChan)
+
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+			close(outChan)
+			failed := 0
+			for e := range outChan {
+				if e.Failed {
+					failed++
+				}
+			}
+			if failed != tt.wantFailed {
+				t.Errorf("failed events = %d, want %d", failed, tt.wantFailed)
+			}
+			if s.requests != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", s.requests, tt.wantRequests)
+			}
+			for key, entries := range s.streams {
+				for i := range entries {
+					if tt.want[key] != nil && i < len(tt.want[key]) && tt.want[key][i].Timestamp.IsZero() {
+						if entries[i].Timestamp.Before(start) {
+							t.Errorf("stream %s entry %d timestamp = %s, want send time", key, i, entries[i].Timestamp)
+						}
+						entries[i].Timestamp = time.Time{}
+					} else {
+						entries[i].Timestamp = entries[i].Timestamp.UTC()
+					}
+				}
+				if !reflect.DeepEqual(entries, tt.want[key]) {
+					t.Errorf("stream %s =\n%+v\nwant\n%+v", key, entries, tt.want[key])
+				}
+			}
+			if len(s.streams) != len(tt.want) {
+				t.Errorf("streams = %+v, want %+v", s.streams, tt.want)
+			}
+		})
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
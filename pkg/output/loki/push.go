@@ -0,0 +1,166 @@
+package loki
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// entry is a log line in stream
+type entry struct {
+	ts   time.Time
+	line string
+}
+
+// label is a stream label
+type label struct {
+	name  string
+	value string
+}
+
+// stream is a entries with same labels
+type stream struct {
+	key     string // labels in prometheus format, like {host="localhost", path="/var/log/messages"}
+	labels  []label
+	entries []entry
+}
+
+func sortLabels(labels []label) {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+}
+
+// labelsString return labels in prometheus format (labels must be sorted by name)
+func labelsString(labels []label) string {
+	b := make([]byte, 0, 64)
+	b = append(b, '{')
+	for i := range labels {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = append(b, labels[i].name...)
+		b = append(b, '=')
+		b = strconv.AppendQuote(b, labels[i].value)
+	}
+	b = append(b, '}')
+	return string(b)
+}
+
+// appendJSON append push request in json format:
+//
+// {"streams":[{"stream":{"label":"value"},"values":[["<unix epoch in nanoseconds>","<log line>"]]}]}
+func appendJSON(b []byte, streams []*stream) []byte {
+	b = append(b, `{"streams":[`...)
+	for i, s := range streams {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, `{"stream":{`...)
+		for j := range s.labels {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, s.labels[j].name)
+			b = append(b, ':')
+			b = appendJSONString(b, s.labels[j].value)
+		}
+		b = append(b, `},"values":[`...)
+		for j := range s.entries {
+			if j > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, `["`...)
+			b = strconv.AppendInt(b, s.entries[j].ts.UnixNano(), 10)
+			b = append(b, `",`...)
+			b = appendJSONString(b, s.entries[j].line)
+			b = append(b, ']')
+		}
+		b = append(b, "]}"...)
+	}
+	return append(b, "]}"...)
+}
+
+const hex = "0123456789abcdef"
+
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b = append(b, '\\', c)
+		case c == '\n':
+			b = append(b, '\\', 'n')
+		case c == '\r':
+			b = append(b, '\\', 'r')
+		case c == '\t':
+			b = append(b, '\\', 't')
+		case c < 0x20:
+			b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			b = append(b, c)
+		}
+	}
+	return append(b, '"')
+}
+
+// protobuf wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendKey(b []byte, field int, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProto append push request in protobuf format:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func appendProto(b []byte, streams []*stream) []byte {
+	var (
+		sb []byte // stream
+		eb []byte // entry
+		tb []byte // timestamp
+	)
+	for _, s := range streams {
+		sb = appendString(sb[:0], 1, s.key)
+		for i := range s.entries {
+			tb = tb[:0]
+			if sec := s.entries[i].ts.Unix(); sec != 0 {
+				tb = appendKey(tb, 1, wireVarint)
+				tb = appendVarint(tb, uint64(sec))
+			}
+			if nsec := s.entries[i].ts.Nanosecond(); nsec != 0 {
+				tb = appendKey(tb, 2, wireVarint)
+				tb = appendVarint(tb, uint64(nsec))
+			}
+			eb = appendBytes(eb[:0], 1, tb)
+			eb = appendString(eb, 2, s.entries[i].line)
+			sb = appendBytes(sb, 2, eb)
+		}
+		b = appendBytes(b, 1, sb)
+	}
+	return b
+}
@@ -0,0 +1,123 @@
+package loki
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+var errCorrupt = errors.New("corrupt input")
+
+// protoField is a decoded protobuf field (varint or bytes)
+type protoField struct {
+	num   int
+	value uint64
+	data  []byte
+}
+
+func protoDecode(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errCorrupt
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(b); n <= 0 {
+				return nil, errCorrupt
+			}
+			b = b[n:]
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, errCorrupt
+			}
+			f.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+		default:
+			return nil, errCorrupt
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// decodeProto decode protobuf push request to streams (labels in prometheus format, without parsed labels)
+func decodeProto(b []byte) ([]*stream, error) {
+	fields, err := protoDecode(b)
+	if err != nil {
+		return nil, err
+	}
+	var streams []*stream
+	for _, f := range fields {
+		sf, err := protoDecode(f.data)
+		if err != nil {
+			return nil, err
+		}
+		s := &stream{}
+		for _, f := range sf {
+			switch f.num {
+			case 1:
+				s.key = string(f.data)
+			case 2:
+				ef, err := protoDecode(f.data)
+				if err != nil {
+					return nil, err
+				}
+				var e entry
+				for _, f := range ef {
+					switch f.num {
+					case 1:
+						tf, err := protoDecode(f.data)
+						if err != nil {
+							return nil, err
+						}
+						var sec, nsec int64
+						for _, f := range tf {
+							if f.num == 1 {
+								sec = int64(f.value)
+							} else if f.num == 2 {
+								nsec = int64(f.value)
+							}
+						}
+						e.ts = time.Unix(sec, nsec)
+					case 2:
+						e.line = string(f.data)
+					}
+				}
+				s.entries = append(s.entries, e)
+			}
+		}
+		streams = append(streams, s)
+	}
+	return streams, nil
+}
+
+func TestProto(t *testing.T) {
+	ts := time.Unix(1614834367, 123)
+	streams := []*stream{
+		{key: `{host="localhost"}`, entries: []entry{{ts: ts, line: "test 1"}, {ts: ts.Add(time.Second), line: "test 2"}}},
+		{key: `{host="remote"}`, entries: []entry{{ts: time.Unix(0, 0), line: ""}}},
+	}
+	got, err := decodeProto(appendProto(nil, streams))
+	if err != nil {
+		t.Fatalf("decodeProto() error = %v", err)
+	}
+	if len(got) != len(streams) {
+		t.Fatalf("decodeProto() streams = %d, want %d", len(got), len(streams))
+	}
+	for i := range streams {
+		if got[i].key != streams[i].key || len(got[i].entries) != len(streams[i].entries) {
+			t.Fatalf("decodeProto()[%d] = %+v, want %+v", i, got[i], streams[i])
+		}
+		for j := range streams[i].entries {
+			if !got[i].entries[j].ts.Equal(streams[i].entries[j].ts) || got[i].entries[j].line != streams[i].entries[j].line {
+				t.Errorf("decodeProto()[%d][%d] = %+v, want %+v", i, j, got[i].entries[j], streams[i].entries[j])
+			}
+		}
+	}
+}
@@ -12,6 +12,14 @@ type Output interface {
 	Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error
 }
 
+// Acknowledging is an output, which return events after delivery result (not delivered events are marked as failed).
+// Inputs, which wait for acknowledges (like file with wait_ack), can be used only with acknowledging outputs.
+// Events, which can't be formatted, are dropped, but returned as delivered (retry can't deliver them, so input acknowledges
+// must not be blocked).
+type Acknowledging interface {
+	Acknowledging()
+}
+
 type Config struct {
 	Type string `hcl:"type" yaml:"type"` // output type (from outputs map)
 }
@@ -0,0 +1,126 @@
+package prometheus
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+)
+
+// Condition is a event field check
+type Condition struct {
+	Field string `hcl:"field" yaml:"field" json:"field"` // field (nested fields like a.b)
+	Op    string `hcl:"op" yaml:"op" json:"op"`          // ==, !=, =~ (regexp match), !~, <, <=, >, >= (numeric compare), exists, not_exists
+	Value string `hcl:"value" yaml:"value" json:"value"` // value for compare
+}
+
+type condOp int8
+
+const (
+	opEq condOp = iota
+	opNe
+	opMatch
+	opNotMatch
+	opLt
+	opLe
+	opGt
+	opGe
+	opExists
+	opNotExists
+)
+
+type condition struct {
+	path  []string
+	op    condOp
+	value string
+	num   float64
+	re    *regexp.Regexp
+}
+
+func newCondition(c *Condition) (*condition, error) {
+	if c.Field == "" {
+		return nil, errors.New("condition field not set")
+	}
+	cond := &condition{path: strings.Split(c.Field, "."), value: c.Value}
+	var err error
+	switch c.Op {
+	case "==", "":
+		cond.op = opEq
+	case "!=":
+		cond.op = opNe
+	case "=~", "!~":
+		cond.op = opMatch
+		if c.Op == "!~" {
+			cond.op = opNotMatch
+		}
+		if cond.re, err = regexp.Compile(c.Value); err != nil {
+			return nil, err
+		}
+	case "<", "<=", ">", ">=":
+		switch c.Op {
+		case "<":
+			cond.op = opLt
+		case "<=":
+			cond.op = opLe
+		case ">":
+			cond.op = opGt
+		default:
+			cond.op = opGe
+		}
+		if cond.num, err = strconv.ParseFloat(c.Value, 64); err != nil {
+			return nil, errors.New("condition " + c.Field + ": value must be a number for " + c.Op)
+		}
+	case "exists":
+		cond.op = opExists
+	case "not_exists":
+		cond.op = opNotExists
+	default:
+		return nil, errors.New("condition " + c.Field + ": invalid op " + c.Op)
+	}
+	return cond, nil
+}
+
+func (c *condition) match(e *event.Event, buf []byte) bool {
+	v, ok := output.Lookup(e.Fields, c.path)
+	switch c.op {
+	case opExists:
+		return ok
+	case opNotExists:
+		return !ok
+	case opLt, opLe, opGt, opGe:
+		if !ok {
+			return false
+		}
+		f, ok := output.ToFloat(v)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case opLt:
+			return f < c.num
+		case opLe:
+			return f <= c.num
+		case opGt:
+			return f > c.num
+		default:
+			return f >= c.num
+		}
+	}
+	var s string
+	if ok {
+		s = string(output.AppendValue(buf[:0], v))
+	}
+	switch c.op {
+	case opEq:
+		return ok && s == c.value
+	case opNe:
+		return !ok || s != c.value
+	case opMatch:
+		return ok && c.re.MatchString(s)
+	default:
+		return !ok || !c.re.MatchString(s)
+	}
+}
@@ -0,0 +1,318 @@
+package prometheus
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+)
+
+// DefaultBuckets is a default histogram buckets (like in Prometheus client)
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metric is a metric, derived from events
+type Metric struct {
+	Name       string            `hcl:"name" yaml:"name" json:"name"`                   // metric name
+	Type       string            `hcl:"type" yaml:"type" json:"type"`                   // counter, gauge or histogram
+	Help       string            `hcl:"help" yaml:"help" json:"help"`                   // help text
+	Field      string            `hcl:"field" yaml:"field" json:"field"`                // numeric field for value (for counter is optional, counter incremented by 1 if not set)
+	Labels     map[string]string `hcl:"labels" yaml:"labels" json:"labels"`             // label name -> event field
+	Buckets    []float64         `hcl:"buckets" yaml:"buckets" json:"buckets"`          // histogram buckets
+	Conditions []Condition       `hcl:"conditions" yaml:"conditions" json:"conditions"` // all conditions must match for update metric
+}
+
+type metricType int8
+
+const (
+	typeCounter metricType = iota
+	typeGauge
+	typeHistogram
+)
+
+var metricTypeStrings = []string{"counter", "gauge", "histogram"}
+
+// series is a metric value with labels
+type series struct {
+	labels  []string // label values
+	value   float64  // counter or gauge value, histogram sum
+	count   uint64   // histogram count
+	buckets []uint64 // histogram buckets (not cumulative)
+	updated time.Time
+}
+
+type metric struct {
+	name       string
+	help       string
+	typ        metricType
+	field      []string
+	labelNames []string
+	labelPaths [][]string
+	buckets    []float64
+	conditions []*condition
+
+	series  map[string]*series
+	dropped uint64 // new series, dropped on max_series limit
+	key     []byte
+}
+
+func validName(name string, colon bool) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') || (colon && c == ':')) {
+			return false
+		}
+	}
+	return true
+}
+
+func newMetric(cfg *Metric, namespace string) (*metric, error) {
+	m := &metric{
+		name:   cfg.Name,
+		help:   cfg.Help,
+		series: make(map[string]*series),
+	}
+	if namespace != "" {
+		m.name = namespace + "_" + m.name
+	}
+	if !validName(m.name, true) {
+		return nil, errors.New("invalid metric name " + m.name)
+	}
+	switch cfg.Type {
+	case "counter":
+		m.typ = typeCounter
+	case "gauge":
+		m.typ = typeGauge
+	case "histogram":
+		m.typ = typeHistogram
+	default:
+		return nil, errors.New("invalid metric type " + cfg.Type)
+	}
+	if cfg.Field != "" {
+		m.field = strings.Split(cfg.Field, ".")
+	} else if m.typ != typeCounter {
+		return nil, errors.New("field not set")
+	}
+
+	for name := range cfg.Labels {
+		if !validName(name, false) || strings.HasPrefix(name, "__") || name == "le" {
+			return nil, errors.New("invalid label " + name)
+		}
+		m.labelNames = append(m.labelNames, name)
+	}
+	sort.Strings(m.labelNames)
+	for _, name := range m.labelNames {
+		m.labelPaths = append(m.labelPaths, strings.Split(cfg.Labels[name], "."))
+	}
+
+	if m.typ == typeHistogram {
+		m.buckets = cfg.Buckets
+		if len(m.buckets) == 0 {
+			m.buckets = DefaultBuckets
+		}
+		for i := range m.buckets {
+			if i > 0 && m.buckets[i] <= m.buckets[i-1] {
+				return nil, errors.New("histogram buckets must be sorted")
+			}
+		}
+	} else if len(cfg.Buckets) > 0 {
+		return nil, errors.New("buckets can be set only for histogram")
+	}
+
+	for i := range cfg.Conditions {
+		c, err := newCondition(&cfg.Conditions[i])
+		if err != nil {
+			return nil, err
+		}
+		m.conditions = append(m.conditions, c)
+	}
+
+	return m, nil
+}
+
+// update update metric with event, return false if new series dropped (by maxSeries limit)
+func (m *metric) update(e *event.Event, now time.Time, maxSeries int) bool {
+	for _, c := range m.conditions {
+		if !c.match(e, m.key) {
+			return true
+		}
+	}
+
+	v := 1.0
+	if m.field != nil {
+		fv, ok := output.Lookup(e.Fields, m.field)
+		if !ok {
+			return true
+		}
+		if v, ok = output.ToFloat(fv); !ok || math.IsNaN(v) {
+			return true
+		}
+		if m.typ == typeCounter && v < 0 {
+			return true
+		}
+	}
+
+	m.key = m.key[:0]
+	labels := make([]string, len(m.labelPaths))
+	for i, path := range m.labelPaths {
+		if lv, ok := output.Lookup(e.Fields, path); ok {
+			labels[i] = string(output.AppendValue(nil, lv))
+		}
+		m.key = append(m.key, labels[i]...)
+		m.key = append(m.key, 0xff)
+	}
+	s, ok := m.series[string(m.key)]
+	if !ok {
+		if len(m.series) >= maxSeries {
+			m.dropped++
+			return false
+		}
+		s = &series{labels: labels}
+		if m.typ == typeHistogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[string(m.key)] = s
+	}
+	s.updated = now
+
+	switch m.typ {
+	case typeCounter:
+		s.value += v
+	case typeGauge:
+		s.value = v
+	default:
+		s.value += v
+		s.count++
+		// bucket with le >= v
+		if i := sort.SearchFloat64s(m.buckets, v); i < len(m.buckets) {
+			s.buckets[i]++
+		}
+	}
+
+	return true
+}
+
+// expire delete series, not updated before deadline
+func (m *metric) expire(deadline time.Time) {
+	for key, s := range m.series {
+		if s.updated.Before(deadline) {
+			delete(m.series, key)
+		}
+	}
+}
+
+func appendFloat(b []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, 1):
+		return append(b, "+Inf"...)
+	case math.IsInf(v, -1):
+		return append(b, "-Inf"...)
+	default:
+		return strconv.AppendFloat(b, v, 'g', -1, 64)
+	}
+}
+
+func appendLabelValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			b = append(b, '\\', '\\')
+		case '"':
+			b = append(b, '\\', '"')
+		case '\n':
+			b = append(b, '\\', 'n')
+		default:
+			b = append(b, s[i])
+		}
+	}
+	return b
+}
+
+// appendSeries append series line (name{labels[,le="bucket"]} value)
+func (m *metric) appendSeries(b []byte, suffix string, s *series, le string, v float64) []byte {
+	b = append(b, m.name...)
+	b = append(b, suffix...)
+	if len(m.labelNames) > 0 || le != "" {
+		b = append(b, '{')
+		n := 0
+		for i, name := range m.labelNames {
+			if s.labels[i] == "" {
+				continue
+			}
+			if n > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, name...)
+			b = append(b, `="`...)
+			b = appendLabelValue(b, s.labels[i])
+			b = append(b, '"')
+			n++
+		}
+		if le != "" {
+			if n > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, `le="`...)
+			b = append(b, le...)
+			b = append(b, '"')
+		}
+		if b[len(b)-1] == '{' {
+			b = b[:len(b)-1]
+		} else {
+			b = append(b, '}')
+		}
+	}
+	b = append(b, ' ')
+	b = appendFloat(b, v)
+	return append(b, '\n')
+}
+
+// appendText append metric in prometheus text exposition format (series sorted by labels)
+func (m *metric) appendText(b []byte) []byte {
+	if len(m.series) == 0 {
+		return b
+	}
+	if m.help != "" {
+		b = append(b, "# HELP "...)
+		b = append(b, m.name...)
+		b = append(b, ' ')
+		b = append(b, strings.ReplaceAll(strings.ReplaceAll(m.help, `\`, `\\`), "\n", `\n`)...)
+		b = append(b, '\n')
+	}
+	b = append(b, "# TYPE "...)
+	b = append(b, m.name...)
+	b = append(b, ' ')
+	b = append(b, metricTypeStrings[m.typ]...)
+	b = append(b, '\n')
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var le []byte
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != typeHistogram {
+			b = m.appendSeries(b, "", s, "", s.value)
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.buckets[i]
+			le = appendFloat(le[:0], bound)
+			b = m.appendSeries(b, "_bucket", s, string(le), float64(cumulative))
+		}
+		b = m.appendSeries(b, "_bucket", s, "+Inf", float64(s.count))
+		b = m.appendSeries(b, "_sum", s, "", s.value)
+		b = m.appendSeries(b, "_count", s, "", float64(s.count))
+	}
+	return b
+}
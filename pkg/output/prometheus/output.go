@@ -0,0 +1,216 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "prometheus"
+
+// droppedName is a counter of series, dropped on max_series limit
+const droppedName = "log_exporter_prometheus_series_dropped_total"
+
+type Config struct {
+	output.Config
+
+	Listen    string   `hcl:"listen" yaml:"listen" json:"listen"`          // listen address
+	Path      string   `hcl:"path" yaml:"path" json:"path"`                // metrics url path
+	Namespace string   `hcl:"namespace" yaml:"namespace" json:"namespace"` // prefix for metric names (namespace_name)
+	Metrics   []Metric `hcl:"metrics" yaml:"metrics" json:"metrics"`
+
+	MaxSeries int           `hcl:"max_series" yaml:"max_series" json:"max_series"` // max series (label sets) per metric, new series dropped
+	Expire    time.Duration `hcl:"expire" yaml:"expire" json:"expire"`             // delete series, not updated for duration (0 - disabled)
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:    output.Config{Type: Name},
+		Listen:    ":9117",
+		Path:      "/metrics",
+		MaxSeries: 10000,
+		Expire:    10 * time.Minute,
+	}
+}
+
+// Prometheus is output for expose metrics, derived from events, for Prometheus scrape.
+//
+// Counters are incremented by 1 (or by numeric field value), gauges set to field value,
+// histograms observe field value. Labels are mapped from event fields, metric updated only if all conditions are matched.
+// Series are limited by max_series per metric and deleted, if not updated for expire duration.
+type Prometheus struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Prometheus{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if o.cfg.Listen == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': listen not set")
+	}
+	if o.cfg.Path == "" || o.cfg.Path[0] != '/' {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid path " + o.cfg.Path)
+	}
+	if len(o.cfg.Metrics) == 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': metrics not set")
+	}
+	names := make(map[string]bool)
+	for i := range o.cfg.Metrics {
+		m, err := newMetric(&o.cfg.Metrics[i], o.cfg.Namespace)
+		if err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': metric " + o.cfg.Metrics[i].Name + ": " + err.Error())
+		}
+		if names[m.name] {
+			return nil, errors.New("output '" + o.cfg.Type + "': duplicate metric " + m.name)
+		}
+		names[m.name] = true
+		o.metrics = append(o.metrics, m)
+	}
+	if o.cfg.MaxSeries < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_series must be > 0")
+	}
+	if o.cfg.Expire < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': expire must be >= 0")
+	}
+
+	return o, nil
+}
+
+func (o *Prometheus) Name() string {
+	return Name
+}
+
+// update update metrics with event
+func (o *Prometheus) update(e *event.Event, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, m := range o.metrics {
+		if !m.update(e, now, o.cfg.MaxSeries) && m.dropped == 1 {
+			log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("metric", m.name).Int("max_series", o.cfg.MaxSeries).
+				Msg("series limit reached, new series dropped")
+		}
+	}
+}
+
+func (o *Prometheus) expire(now time.Time) {
+	if o.cfg.Expire == 0 {
+		return
+	}
+	deadline := now.Add(-o.cfg.Expire)
+	o.mu.Lock()
+	for _, m := range o.metrics {
+		m.expire(deadline)
+	}
+	o.mu.Unlock()
+}
+
+// appendText append metrics in prometheus text exposition format
+func (o *Prometheus) appendText(b []byte) []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	dropped := false
+	for _, m := range o.metrics {
+		b = m.appendText(b)
+		if m.dropped > 0 {
+			dropped = true
+		}
+	}
+	if dropped {
+		b = append(b, "# HELP "+droppedName+" Series, dropped on max_series limit.\n# TYPE "+droppedName+" counter\n"...)
+		for _, m := range o.metrics {
+			if m.dropped > 0 {
+				b = append(b, droppedName+`{metric="`...)
+				b = append(b, m.name...)
+				b = append(b, `"} `...)
+				b = strconv.AppendUint(b, m.dropped, 10)
+				b = append(b, '\n')
+			}
+		}
+	}
+	return b
+}
+
+func (o *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	o.expire(time.Now())
+	b := o.appendText(make([]byte, 0, 4096))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(b)
+	}
+}
+
+func (o *Prometheus) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ln, err := net.Listen("tcp", o.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(o.cfg.Path, o)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("listen", o.cfg.Listen).Err(err).Msg("serve")
+		}
+	}()
+
+	var tick <-chan time.Time
+	if o.cfg.Expire > 0 {
+		interval := o.cfg.Expire / 2
+		if interval > time.Minute {
+			interval = time.Minute
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err = srv.Shutdown(ctx)
+				cancel()
+				wg.Wait()
+				return err
+			}
+			o.update(e, time.Now())
+			outChan <- e
+		case now := <-tick:
+			o.expire(now)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package prometheus_test
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	metrics := []map[string]interface{}{{"name": "requests_total", "type": "counter"}}
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "prometheus", "metrics": metrics}},
+		{name: "metrics not set", cfg: config.ConfigRaw{"type": "prometheus"}, wantErr: true},
+		{name: "invalid path", cfg: config.ConfigRaw{"type": "prometheus", "metrics": metrics, "path": "metrics"}, wantErr: true},
+		{
+			name:    "invalid name",
+			cfg:     config.ConfigRaw{"type": "prometheus", "metrics": []map[string]interface{}{{"name": "requests-total", "type": "counter"}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid type",
+			cfg:     config.ConfigRaw{"type": "prometheus", "metrics": []map[string]interface{}{{"name": "requests", "type": "summary", "field": "a"}}},
+			wantErr: true,
+		},
+		{
+			name:    "gauge without field",
+			cfg:     config.ConfigRaw{"type": "prometheus", "metrics": []map[string]interface{}{{"name": "bytes", "type": "gauge"}}},
+			wantErr: true,
+		},
+		{
+			name: "duplicate",
+			cfg: config.ConfigRaw{
+				"type":    "prometheus",
+				"metrics": []map[string]interface{}{{"name": "requests", "type": "counter"}, {"name": "requests", "type": "counter"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid label",
+			cfg: config.ConfigRaw{
+				"type":    "prometheus",
+				"metrics": []map[string]interface{}{{"name": "requests", "type": "counter", "labels": map[string]string{"le": "status"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsorted buckets",
+			cfg: config.ConfigRaw{
+				"type":    "prometheus",
+				"metrics": []map[string]interface{}{{"name": "time", "type": "histogram", "field": "time", "buckets": []float64{1, 0.5}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid condition",
+			cfg: config.ConfigRaw{
+				"type": "prometheus",
+				"metrics": []map[string]interface{}{
+					{"name": "errors", "type": "counter", "conditions": []map[string]interface{}{{"field": "status", "op": ">=", "value": "5xx"}}},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s status = %d", url, resp.StatusCode)
+	}
+	return string(body)
+}
+
+func TestPrometheus(t *testing.T) {
+	newEvent := func(host string, status int, requestTime, bytes interface{}) *event.Event {
+		e := &event.Event{Timestamp: time.Now(), Fields: map[string]interface{}{"host": host, "status": status, "request_time": requestTime}}
+		if bytes != nil {
+			e.Fields["bytes"] = bytes
+		}
+		return e
+	}
+	events := []*event.Event{
+		newEvent("web1", 200, 0.25, 100),
+		newEvent("web1", 200, 0.5, 200),
+		newEvent("web1", 500, 1.5, 50),
+		newEvent("web2", 404, 0.01, 10),
+		newEvent("web1", 200, "-", nil),
+	}
+
+	address, err := test.FreeAddr("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.ConfigRaw{
+		"type":       "prometheus",
+		"listen":     address,
+		"namespace":  "nginx",
+		"max_series": 2,
+		"expire":     500 * time.Millisecond,
+		"metrics": []map[string]interface{}{
+			{"name": "requests_total", "type": "counter", "help": "Requests.", "labels": map[string]string{"status": "status"}},
+			{
+				"name": "errors_total", "type": "counter", "labels": map[string]string{"host": "host"},
+				"conditions": []map[string]interface{}{{"field": "status", "op": ">=", "value": "500"}},
+			},
+			{
+				"name": "request_time_seconds", "type": "histogram", "field": "request_time",
+				"labels": map[string]string{"status": "status"}, "buckets": []float64{0.3, 1},
+			},
+			{"name": "bytes_sent", "type": "gauge", "field": "bytes", "labels": map[string]string{"host": "host"}},
+		},
+	}
+	out, err := output.New(&cfg, &config.Common{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	inChan := make(chan *event.Event, len(events))
+	outChan := make(chan *event.Event, len(events))
+	for _, e := range events {
+		inChan <- e
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- out.Start(inChan, outChan)
+	}()
+	for i := 0; i < len(events); i++ {
+		<-outChan
+	}
+
+	dropped := "# HELP log_exporter_prometheus_series_dropped_total Series, dropped on max_series limit.\n" +
+		"# TYPE log_exporter_prometheus_series_dropped_total counter\n" +
+		`log_exporter_prometheus_series_dropped_total{metric="nginx_requests_total"} 1` + "\n" +
+		`log_exporter_prometheus_series_dropped_total{metric="nginx_request_time_seconds"} 1` + "\n"
+	want := "# HELP nginx_requests_total Requests.\n" +
+		"# TYPE nginx_requests_total counter\n" +
+		`nginx_requests_total{status="200"} 3` + "\n" +
+		`nginx_requests_total{status="500"} 1` + "\n" +
+		"# TYPE nginx_errors_total counter\n" +
+		`nginx_errors_total{host="web1"} 1` + "\n" +
+		"# TYPE nginx_request_time_seconds histogram\n" +
+		`nginx_request_time_seconds_bucket{status="200",le="0.3"} 1` + "\n" +
+		`nginx_request_time_seconds_bucket{status="200",le="1"} 2` + "\n" +
+		`nginx_request_time_seconds_bucket{status="200",le="+Inf"} 2` + "\n" +
+		`nginx_request_time_seconds_sum{status="200"} 0.75` + "\n" +
+		`nginx_request_time_seconds_count{status="200"} 2` + "\n" +
+		`nginx_request_time_seconds_bucket{status="500",le="0.3"} 0` + "\n" +
+		`nginx_request_time_seconds_bucket{status="500",le="1"} 0` + "\n" +
+		`nginx_request_time_seconds_bucket{status="500",le="+Inf"} 1` + "\n" +
+		`nginx_request_time_seconds_sum{status="500"} 1.5` + "\n" +
+		`nginx_request_time_seconds_count{status="500"} 1` + "\n" +
+		"# TYPE nginx_bytes_sent gauge\n" +
+		`nginx_bytes_sent{host="web1"} 50` + "\n" +
+		`nginx_bytes_sent{host="web2"} 10` + "\n" +
+		dropped
+	if got := scrape(t, "http://"+address+"/metrics"); got != want {
+		t.Errorf("metrics =\n%s\nwant\n%s", got, want)
+	}
+
+	// stale series expired
+	time.Sleep(600 * time.Millisecond)
+	if got := scrape(t, "http://"+address+"/metrics"); got != dropped {
+		t.Errorf("metrics after expire =\n%s\nwant\n%s", got, dropped)
+	}
+
+	close(inChan)
+	if err = <-result; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
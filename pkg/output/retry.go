@@ -0,0 +1,83 @@
+package output
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// Backoff return retry delay for attempt (from 0), doubled on every attempt and limited by max
+func Backoff(attempt int, min, max time.Duration) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// DeadLetter is a sink for permanently failed documents (NDJSON file with failure reason and document).
+type DeadLetter struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+	b  []byte
+}
+
+type deadLetterRecord struct {
+	Timestamp string              `json:"@timestamp"`
+	Output    string              `json:"output"`
+	Target    string              `json:"target,omitempty"`
+	Status    int                 `json:"status,omitempty"`
+	Error     string              `json:"error"`
+	Document  jsoniter.RawMessage `json:"document"`
+}
+
+// NewDeadLetter open dead letter file for append
+func NewDeadLetter(path string) (*DeadLetter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetter{path: path, f: f}, nil
+}
+
+// Path return dead letter file path
+func (d *DeadLetter) Path() string {
+	return d.path
+}
+
+// Write append failed document (must be a valid json, otherwise stored as json string)
+func (d *DeadLetter) Write(output, target string, status int, reason string, doc []byte) error {
+	rec := deadLetterRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Output:    output,
+		Target:    target,
+		Status:    status,
+		Error:     reason,
+		Document:  doc,
+	}
+	if !json.Valid(doc) {
+		rec.Document, _ = json.Marshal(string(doc))
+	}
+	data, err := json.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.b = append(append(d.b[:0], data...), '\n')
+	_, err = d.f.Write(d.b)
+	return err
+}
+
+// Close close dead letter file
+func (d *DeadLetter) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,160 @@
+package output
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// Sender write framed messages to udp, tcp or tls with reconnect (with backoff), messages are buffered
+// (up to Max bytes) while disconnected. Framing (like line ends or octet counting) is done by caller.
+//
+// For udp messages are packed into datagrams up to Packet size (datagram per message, if Packet is 0),
+// for tcp and tls partially writed message is dropped on write error (it can't be continued in new connection).
+type Sender struct {
+	Network string // udp, tcp or tls
+	Address string
+	Timeout time.Duration // connect and write timeout
+	TLS     *tls.Config
+	Max     int // max buffered bytes
+	Packet  int // max udp datagram size
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	attempt int
+	retryAt time.Time
+
+	conn    net.Conn
+	buf     []byte
+	ends    []int // messages ends in buf
+	dropped int   // messages, dropped on buffer overflow
+}
+
+// Write buffer message, return false if message dropped (buffer is full)
+func (s *Sender) Write(msg []byte) bool {
+	if len(s.buf)+len(msg) > s.Max {
+		s.dropped++
+		return false
+	}
+	s.buf = append(s.buf, msg...)
+	s.ends = append(s.ends, len(s.buf))
+	return true
+}
+
+// Pending return buffered messages
+func (s *Sender) Pending() int {
+	return len(s.ends)
+}
+
+// Dropped return messages, dropped on buffer overflow after last call
+func (s *Sender) Dropped() int {
+	n := s.dropped
+	s.dropped = 0
+	return n
+}
+
+// Retry reset reconnect delay (for last attempt on shutdown)
+func (s *Sender) Retry() {
+	s.retryAt = time.Time{}
+}
+
+// consume remove first n messages from buffer
+func (s *Sender) consume(n int) {
+	if n == 0 {
+		return
+	}
+	cut := s.ends[n-1]
+	s.buf = s.buf[:copy(s.buf, s.buf[cut:])]
+	s.ends = s.ends[:copy(s.ends, s.ends[n:])]
+	for i := range s.ends {
+		s.ends[i] -= cut
+	}
+}
+
+func (s *Sender) connect(now time.Time) error {
+	if now.Before(s.retryAt) {
+		return nil
+	}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.Network == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: s.Timeout}, "tcp", s.Address, s.TLS)
+	} else {
+		conn, err = net.DialTimeout(s.Network, s.Address, s.Timeout)
+	}
+	if err != nil {
+		s.retryAt = now.Add(Backoff(s.attempt, s.MinBackoff, s.MaxBackoff))
+		s.attempt++
+		return err
+	}
+	s.conn = conn
+	s.attempt = 0
+	return nil
+}
+
+func (s *Sender) disconnect(now time.Time) {
+	s.conn.Close()
+	s.conn = nil
+	s.retryAt = now.Add(Backoff(s.attempt, s.MinBackoff, s.MaxBackoff))
+	s.attempt++
+}
+
+// Flush send buffered messages, return count of sended and lost (partially writed) messages from buffer start
+func (s *Sender) Flush(now time.Time) (sent, lost int, err error) {
+	if len(s.ends) == 0 {
+		return 0, 0, nil
+	}
+	if s.conn == nil {
+		if err = s.connect(now); err != nil || s.conn == nil {
+			return 0, 0, err
+		}
+	}
+
+	s.conn.SetWriteDeadline(now.Add(s.Timeout))
+	if s.Network == "udp" {
+		start := 0
+		for sent < len(s.ends) {
+			// pack messages into datagram
+			n := sent + 1
+			for n < len(s.ends) && s.ends[n]-start <= s.Packet {
+				n++
+			}
+			if _, err = s.conn.Write(s.buf[start:s.ends[n-1]]); err != nil {
+				s.consume(sent)
+				s.disconnect(now)
+				return sent, 0, err
+			}
+			start = s.ends[n-1]
+			sent = n
+		}
+		s.consume(sent)
+		return sent, 0, nil
+	}
+
+	n, err := s.conn.Write(s.buf)
+	if err != nil {
+		for sent < len(s.ends) && s.ends[sent] <= n {
+			sent++
+		}
+		if n > 0 && sent < len(s.ends) && (sent == 0 || s.ends[sent-1] < n) {
+			lost = 1
+		}
+		s.consume(sent + lost)
+		s.disconnect(now)
+		return sent, lost, err
+	}
+	sent = len(s.ends)
+	s.consume(sent)
+	return sent, 0, nil
+}
+
+// Close close connection (buffered messages are not sended)
+func (s *Sender) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -1,70 +1,141 @@
 package stdout
 
 import (
+	"bufio"
 	"errors"
-	"fmt"
+	"io"
+	"os"
+	"time"
 
-	"github.com/msaf1980/go-stringutils"
 	"github.com/msaf1980/log-exporter/pkg/config"
 	"github.com/msaf1980/log-exporter/pkg/event"
-	"github.com/msaf1980/log-exporter/pkg/filter"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
 )
 
-const Name = "remove_field"
-
-type ft struct {
-	value     string
-	templated bool
-	tpl       stringutils.Template
-}
+const Name = "stdout"
 
 type Config struct {
-	filter.Config
+	output.Config
 
-	Fields []string `hcl:"fields" yaml:"fields" json:"fields"`
+	Format        output.Format `hcl:"format" yaml:"format" json:"format"`                         // json (default), rubydebug, line (message field) or template
+	Template      string        `hcl:"template" yaml:"template" json:"template"`                   // template for template format, like "%{timestamp} %{message}"
+	BufferSize    config.Size   `hcl:"buffer_size" yaml:"buffer_size" json:"buffer_size"`          // write buffer size
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // flush interval (0 - flush when no pending events)
 }
 
 func defaultConfig() Config {
 	return Config{
-		Config: filter.Config{Type: Name},
+		Config:        output.Config{Type: Name},
+		BufferSize:    config.Size(64 * 1024),
+		FlushInterval: time.Second,
 	}
 }
 
-// RemoveField is filter for append fields.
-//
-// Support template: like "%{host} %{timestamp}""
-type RemoveField struct {
+// Stdout is output for print events to stdout (for debug pipelines).
+// Events are returned after buffer flush, events with failed write are marked as failed.
+// Events with format error are dropped (returned as delivered).
+type Stdout struct {
 	cfg    Config
 	cfgRaw *config.ConfigRaw
 	common *config.Common
+
+	formatter *output.Formatter
+	w         io.Writer
+	events    []*event.Event // buffered events, hold until flush
 }
 
-func New(cfg *config.ConfigRaw, common *config.Common) (filter.Filter, error) {
-	fi := &RemoveField{
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Stdout{
 		cfg:    defaultConfig(),
 		cfgRaw: cfg,
 		common: common,
+		w:      os.Stdout,
 	}
 
 	var err error
-	if err = cfg.Decode(&fi.cfg); err != nil {
+	if err = cfg.Decode(&o.cfg); err != nil {
 		return nil, err
 	}
 
-	if len(fi.cfg.Fields) == 0 {
-		return nil, errors.New("filter '" + fi.cfg.Type + "': fields not set")
+	if o.cfg.BufferSize.Value() < 1 {
+		return nil, errors.New("output '" + o.cfg.Type + "': buffer_size must be > 0")
+	}
+
+	if o.cfg.FlushInterval < 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be >= 0")
+	}
+
+	if o.formatter, err = output.NewFormatter(o.cfg.Format, o.cfg.Template); err != nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
 	}
 
-	return fi, nil
+	return o, nil
 }
 
-func (fi *RemoveField) Name() string {
+func (o *Stdout) Name() string {
 	return Name
 }
 
-func (fi *RemoveField) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
-	for e := range inChan {
-		fmt.Printf("%#v\n", *e)
+func (o *Stdout) Acknowledging() {}
+
+// flush write buffer, buffered events returned to out channel
+func (o *Stdout) flush(w *bufio.Writer, outChan chan<- *event.Event) {
+	err := w.Flush()
+	if err != nil {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Err(err).Msg("write failed")
+		w.Reset(o.w)
+	}
+	for i, e := range o.events {
+		if err != nil {
+			e.Failed = true
+		}
+		outChan <- e
+		o.events[i] = nil
+	}
+	o.events = o.events[:0]
+}
+
+func (o *Stdout) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	var (
+		b    []byte
+		err  error
+		tick <-chan time.Time
+	)
+	w := bufio.NewWriterSize(o.w, int(o.cfg.BufferSize.Value()))
+	if o.cfg.FlushInterval > 0 {
+		ticker := time.NewTicker(o.cfg.FlushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				o.flush(w, outChan)
+				return nil
+			}
+			if b, err = o.formatter.Append(b[:0], e); err != nil {
+				log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("event", event.String(e)).Err(err).Msg("format failed, event dropped")
+				outChan <- e
+			} else if _, err = w.Write(b); err != nil {
+				log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Err(err).Msg("write failed")
+				w.Reset(o.w)
+				// buffered events are lost
+				o.events = append(o.events, e)
+				for _, e := range o.events {
+					e.Failed = true
+				}
+				o.flush(w, outChan)
+			} else {
+				o.events = append(o.events, e)
+			}
+			if tick == nil && len(inChan) == 0 {
+				o.flush(w, outChan)
+			}
+		case <-tick:
+			o.flush(w, outChan)
+		}
 	}
-	return nil
 }
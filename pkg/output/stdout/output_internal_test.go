@@ -0,0 +1,13 @@
+package stdout
+
+import (
+	"io"
+)
+
+func (o *Stdout) Cfg() *Config {
+	return &o.cfg
+}
+
+func (o *Stdout) SetWriter(w io.Writer) {
+	o.w = w
+}
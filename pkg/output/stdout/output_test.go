@@ -0,0 +1,137 @@
+package stdout_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/msaf1980/log-exporter/pkg/output/stdout"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "stdout"}},
+		{name: "rubydebug", cfg: config.ConfigRaw{"type": "stdout", "format": "rubydebug"}},
+		{name: "invalid format", cfg: config.ConfigRaw{"type": "stdout", "format": "xml"}, wantErr: true},
+		{name: "template not set", cfg: config.ConfigRaw{"type": "stdout", "format": "template"}, wantErr: true},
+		{name: "invalid template", cfg: config.ConfigRaw{"type": "stdout", "format": "template", "template": "%{host"}, wantErr: true},
+		{name: "invalid buffer_size", cfg: config.ConfigRaw{"type": "stdout", "buffer_size": "0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStdout(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	events := []*event.Event{
+		{
+			Timestamp: ts,
+			Fields:    map[string]interface{}{"host": "localhost", "message": "test1", "count": 2},
+			Tags:      map[string]int{"b": 1, "a": 1},
+		},
+		{
+			Timestamp: ts,
+			Fields:    map[string]interface{}{"host": "localhost"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.ConfigRaw
+		want string
+	}{
+		{
+			name: "json",
+			cfg:  config.ConfigRaw{"type": "stdout"},
+			want: `{"count":2,"host":"localhost","message":"test1","tags":["a","b"]}` + "\n" +
+				`{"host":"localhost"}` + "\n",
+		},
+		{
+			name: "line",
+			cfg:  config.ConfigRaw{"type": "stdout", "format": "line", "flush_interval": 0},
+			want: "test1\n",
+		},
+		{
+			name: "template",
+			cfg:  config.ConfigRaw{"type": "stdout", "format": "template", "template": "%{+2006-01-02} %{host}: %{message}"},
+			want: "2021-03-04 localhost: test1\n2021-03-04 localhost: %{message}\n",
+		},
+		{
+			name: "rubydebug",
+			cfg:  config.ConfigRaw{"type": "stdout", "format": "rubydebug"},
+			want: "{\n" +
+				`      "count" => 2,` + "\n" +
+				`       "host" => "localhost",` + "\n" +
+				`    "message" => "test1",` + "\n" +
+				`       "tags" => [` + "\n" +
+				`        [0] "a",` + "\n" +
+				`        [1] "b",` + "\n" +
+				"    ],\n" +
+				"}\n" +
+				"{\n" +
+				`    "host" => "localhost",` + "\n" +
+				"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := output.New(&tt.cfg, &config.Common{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			var buf bytes.Buffer
+			out.(*stdout.Stdout).SetWriter(&buf)
+
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			for _, e := range events {
+				inChan <- e
+			}
+			close(inChan)
+
+			if err = out.Start(inChan, outChan); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("Start() returned events = %d, want %d", len(outChan), len(events))
+			}
+			// events with format error (like line without message) are dropped, but not failed
+			for len(outChan) > 0 {
+				if e := <-outChan; e.Failed {
+					t.Errorf("Start() returned failed event %s", event.String(e))
+				}
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Start() =\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func init() {
+	level := os.Getenv("GO_TESTS_LEVEL")
+	if level == "" {
+		level = "error"
+	}
+	l, err := zerolog.ParseLevel(level)
+	if err != nil {
+		panic(err)
+	}
+	zerolog.SetGlobalLevel(l)
+}
@@ -0,0 +1,195 @@
+package syslog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is a syslog message format
+type Format int8
+
+const (
+	FormatRFC5424 Format = iota // <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+	FormatRFC3164               // <PRI>Mmm dd hh:mm:ss HOSTNAME APP-NAME[PROCID]: MSG
+)
+
+var formatStrings []string = []string{"rfc5424", "rfc3164"}
+
+func (f *Format) Set(value string) error {
+	switch value {
+	case "rfc5424", "":
+		*f = FormatRFC5424
+	case "rfc3164":
+		*f = FormatRFC3164
+	default:
+		return fmt.Errorf("invalid format %s", value)
+	}
+	return nil
+}
+
+func (f *Format) String() string {
+	return formatStrings[*f]
+}
+
+func (f *Format) UnmarshalText(text []byte) error {
+	return f.Set(string(text))
+}
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"ntp":      12,
+	"security": 13,
+	"console":  14,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// severities with common log levels names
+var severities = map[string]int{
+	"emerg":         0,
+	"emergency":     0,
+	"panic":         0,
+	"alert":         1,
+	"crit":          2,
+	"critical":      2,
+	"fatal":         2,
+	"err":           3,
+	"error":         3,
+	"warn":          4,
+	"warning":       4,
+	"notice":        5,
+	"info":          6,
+	"informational": 6,
+	"debug":         7,
+	"trace":         7,
+}
+
+// code return facility or severity code by name (case insensitive) or number (from 0 to max)
+func code(v interface{}, names map[string]int, max int) (int, bool) {
+	var n int
+	switch v := v.(type) {
+	case string:
+		if c, ok := names[strings.ToLower(v)]; ok {
+			return c, true
+		}
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			return 0, false
+		}
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		n = int(v)
+		if float64(n) != v {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if n < 0 || n > max {
+		return 0, false
+	}
+	return n, true
+}
+
+func facilityCode(v interface{}) (int, bool) {
+	return code(v, facilities, 23)
+}
+
+func severityCode(v interface{}) (int, bool) {
+	return code(v, severities, 7)
+}
+
+// appendHeaderField append header field (printable ASCII without spaces, truncated to max), "-" for empty value
+func appendHeaderField(b []byte, v []byte, max int) []byte {
+	if len(v) == 0 {
+		return append(b, '-')
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+	for _, c := range v {
+		if c < 33 || c > 126 {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// message is a syslog message parts
+type message struct {
+	pri      int
+	ts       time.Time
+	hostname []byte
+	appName  []byte
+	procID   []byte
+	msgID    []byte
+	msg      []byte
+}
+
+// appendRFC5424 append RFC5424 message (without structured data)
+func appendRFC5424(b []byte, m *message) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(m.pri), 10)
+	b = append(b, ">1 "...)
+	b = m.ts.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.hostname, 255)
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.appName, 48)
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.procID, 128)
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.msgID, 32)
+	b = append(b, " -"...)
+	if len(m.msg) > 0 {
+		b = append(b, ' ')
+		b = append(b, m.msg...)
+	}
+	return b
+}
+
+// appendRFC3164 append RFC3164 (BSD) message, timestamp is in UTC
+func appendRFC3164(b []byte, m *message) []byte {
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(m.pri), 10)
+	b = append(b, '>')
+	b = m.ts.UTC().AppendFormat(b, time.Stamp)
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.hostname, 255)
+	b = append(b, ' ')
+	b = appendHeaderField(b, m.appName, 32)
+	if len(m.procID) > 0 {
+		b = append(b, '[')
+		b = appendHeaderField(b, m.procID, 128)
+		b = append(b, ']')
+	}
+	b = append(b, ':')
+	if len(m.msg) > 0 {
+		b = append(b, ' ')
+		b = append(b, m.msg...)
+	}
+	return b
+}
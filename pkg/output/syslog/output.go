@@ -0,0 +1,310 @@
+package syslog
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/rs/zerolog/log"
+)
+
+const Name = "syslog"
+
+type Config struct {
+	output.Config
+
+	Address  string `hcl:"address" yaml:"address" json:"address"`    // syslog server address
+	Protocol string `hcl:"protocol" yaml:"protocol" json:"protocol"` // udp (default), tcp or tls (octet-counted framing for tcp and tls)
+	Format   Format `hcl:"format" yaml:"format" json:"format"`       // rfc5424 (default) or rfc3164
+
+	Facility      string `hcl:"facility" yaml:"facility" json:"facility"`                   // default facility (name like local0 or code)
+	FacilityField string `hcl:"facility_field" yaml:"facility_field" json:"facility_field"` // field with facility (nested fields like a.b)
+	Severity      string `hcl:"severity" yaml:"severity" json:"severity"`                   // default severity (name like warning or code)
+	SeverityField string `hcl:"severity_field" yaml:"severity_field" json:"severity_field"` // field with severity (syslog or log levels names, like error, warn, info, debug, or code)
+	Hostname      string `hcl:"hostname" yaml:"hostname" json:"hostname"`                   // hostname template (local hostname, if fields not found)
+	AppName       string `hcl:"app_name" yaml:"app_name" json:"app_name"`                   // app-name (tag for rfc3164) template
+	ProcID        string `hcl:"proc_id" yaml:"proc_id" json:"proc_id"`                      // procid template
+	MsgID         string `hcl:"msg_id" yaml:"msg_id" json:"msg_id"`                         // msgid template (only for rfc5424)
+	Message       string `hcl:"message" yaml:"message" json:"message"`                      // message template
+
+	MaxSize       config.Size   `hcl:"max_size" yaml:"max_size" json:"max_size"`                   // max message size (longer messages are truncated)
+	FlushInterval time.Duration `hcl:"flush_interval" yaml:"flush_interval" json:"flush_interval"` // send interval
+	Timeout       time.Duration `hcl:"timeout" yaml:"timeout" json:"timeout"`                      // connect and write timeout
+	BufferSize    config.Size   `hcl:"buffer_size" yaml:"buffer_size" json:"buffer_size"`          // max buffered (not sended) size, new messages dropped on overflow
+
+	RetryBackoff    time.Duration `hcl:"retry_backoff" yaml:"retry_backoff" json:"retry_backoff"`             // initial reconnect delay (doubled on every failure)
+	MaxRetryBackoff time.Duration `hcl:"max_retry_backoff" yaml:"max_retry_backoff" json:"max_retry_backoff"` // max reconnect delay
+
+	TLS config.TLS `hcl:"tls" yaml:"tls" json:"tls"` // tls (with client certificate) from local files, for tls protocol
+}
+
+func defaultConfig() Config {
+	return Config{
+		Config:          output.Config{Type: Name},
+		Address:         "127.0.0.1:514",
+		Protocol:        "udp",
+		Facility:        "user",
+		SeverityField:   "level",
+		Severity:        "info",
+		Hostname:        "%{host}",
+		AppName:         "%{app}",
+		Message:         "%{message}",
+		MaxSize:         config.Size(8 * 1024),
+		FlushInterval:   time.Second,
+		Timeout:         5 * time.Second,
+		BufferSize:      config.Size(10 * 1024 * 1024),
+		RetryBackoff:    100 * time.Millisecond,
+		MaxRetryBackoff: 30 * time.Second,
+	}
+}
+
+// Syslog is output for forward events to syslog server as RFC5424 or RFC3164 messages.
+//
+// Facility and severity are taken from event fields (by name or code) with defaults for events without valid values,
+// hostname, app-name, procid, msgid and message are templates (header fields with not found event fields are empty).
+// Messages are buffered and sended on flush_interval, on connection failure they stay in buffer (up to buffer_size)
+// until reconnect (with backoff). Events are returned after send, dropped events are marked as failed.
+type Syslog struct {
+	cfg    Config
+	cfgRaw *config.ConfigRaw
+	common *config.Common
+
+	facility      int
+	facilityField []string
+	severity      int
+	severityField []string
+	hostname      *output.Template
+	appName       *output.Template
+	procID        *output.Template
+	msgID         *output.Template
+	message       *output.Template
+	sender        *output.Sender
+
+	m      message
+	line   []byte
+	frame  []byte
+	events []*event.Event // buffered messages events, hold until send result
+}
+
+// newField parse header field template (empty template is nil)
+func newField(format string) (*output.Template, error) {
+	if format == "" {
+		return nil, nil
+	}
+	return output.NewTemplate(format)
+}
+
+// appendField append executed header field template, nothing appended if fields not found
+func appendField(b []byte, tpl *output.Template, e *event.Event) []byte {
+	if tpl == nil {
+		return b
+	}
+	start := len(b)
+	b, found := tpl.Append(b, e)
+	if !found {
+		return b[:start]
+	}
+	return b
+}
+
+func New(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+	o := &Syslog{
+		cfg:    defaultConfig(),
+		cfgRaw: cfg,
+		common: common,
+	}
+
+	var err error
+	if err = cfg.Decode(&o.cfg); err != nil {
+		return nil, err
+	}
+
+	if o.cfg.Address == "" {
+		return nil, errors.New("output '" + o.cfg.Type + "': address not set")
+	}
+	if o.cfg.Protocol != "udp" && o.cfg.Protocol != "tcp" && o.cfg.Protocol != "tls" {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid protocol " + o.cfg.Protocol)
+	}
+	var ok bool
+	if o.facility, ok = facilityCode(o.cfg.Facility); !ok {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid facility " + o.cfg.Facility)
+	}
+	if o.severity, ok = severityCode(o.cfg.Severity); !ok {
+		return nil, errors.New("output '" + o.cfg.Type + "': invalid severity " + o.cfg.Severity)
+	}
+	if o.cfg.FacilityField != "" {
+		o.facilityField = strings.Split(o.cfg.FacilityField, ".")
+	}
+	if o.cfg.SeverityField != "" {
+		o.severityField = strings.Split(o.cfg.SeverityField, ".")
+	}
+	for _, f := range []struct {
+		tpl    **output.Template
+		format string
+	}{
+		{&o.hostname, o.cfg.Hostname},
+		{&o.appName, o.cfg.AppName},
+		{&o.procID, o.cfg.ProcID},
+		{&o.msgID, o.cfg.MsgID},
+		{&o.message, o.cfg.Message},
+	} {
+		if *f.tpl, err = newField(f.format); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+		}
+	}
+	if o.message == nil {
+		return nil, errors.New("output '" + o.cfg.Type + "': message not set")
+	}
+	if o.cfg.MaxSize.Value() < 64 {
+		return nil, errors.New("output '" + o.cfg.Type + "': max_size must be >= 64")
+	}
+	if o.cfg.FlushInterval <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': flush_interval must be > 0")
+	}
+	if o.cfg.Timeout <= 0 {
+		return nil, errors.New("output '" + o.cfg.Type + "': timeout must be > 0")
+	}
+	if o.cfg.BufferSize.Value() < o.cfg.MaxSize.Value() {
+		return nil, errors.New("output '" + o.cfg.Type + "': buffer_size must be >= max_size")
+	}
+	if o.cfg.RetryBackoff <= 0 || o.cfg.MaxRetryBackoff < o.cfg.RetryBackoff {
+		return nil, errors.New("output '" + o.cfg.Type + "': retry_backoff must be > 0 and <= max_retry_backoff")
+	}
+
+	o.sender = &output.Sender{
+		Network:    o.cfg.Protocol,
+		Address:    o.cfg.Address,
+		Timeout:    o.cfg.Timeout,
+		Max:        int(o.cfg.BufferSize.Value()),
+		MinBackoff: o.cfg.RetryBackoff,
+		MaxBackoff: o.cfg.MaxRetryBackoff,
+	}
+	if o.cfg.Protocol == "tls" {
+		o.cfg.TLS.Enabled = true
+		if o.sender.TLS, err = o.cfg.TLS.ClientConfig(); err != nil {
+			return nil, errors.New("output '" + o.cfg.Type + "': " + err.Error())
+		}
+	}
+
+	return o, nil
+}
+
+func (o *Syslog) Name() string {
+	return Name
+}
+
+func (o *Syslog) Acknowledging() {}
+
+// add format event as syslog message and write it to sender, return false if message dropped
+func (o *Syslog) add(e *event.Event) bool {
+	m := &o.m
+	facility := o.facility
+	if o.facilityField != nil {
+		if v, ok := output.Lookup(e.Fields, o.facilityField); ok {
+			if c, ok := facilityCode(v); ok {
+				facility = c
+			}
+		}
+	}
+	severity := o.severity
+	if o.severityField != nil {
+		if v, ok := output.Lookup(e.Fields, o.severityField); ok {
+			if c, ok := severityCode(v); ok {
+				severity = c
+			}
+		}
+	}
+	m.pri = facility*8 + severity
+	m.ts = e.Timestamp
+	m.hostname = appendField(m.hostname[:0], o.hostname, e)
+	if len(m.hostname) == 0 {
+		m.hostname = append(m.hostname, o.common.Hostname...)
+	}
+	m.appName = appendField(m.appName[:0], o.appName, e)
+	m.procID = appendField(m.procID[:0], o.procID, e)
+	m.msgID = appendField(m.msgID[:0], o.msgID, e)
+	m.msg, _ = o.message.Append(m.msg[:0], e)
+
+	if o.cfg.Format == FormatRFC3164 {
+		o.line = appendRFC3164(o.line[:0], m)
+	} else {
+		o.line = appendRFC5424(o.line[:0], m)
+	}
+	if max := int(o.cfg.MaxSize.Value()); len(o.line) > max {
+		// truncate on rune boundary
+		for max > 0 && !utf8.RuneStart(o.line[max]) {
+			max--
+		}
+		o.line = o.line[:max]
+	}
+	if o.cfg.Protocol == "udp" {
+		return o.sender.Write(o.line)
+	}
+	// octet-counted framing (RFC6587)
+	o.frame = strconv.AppendInt(o.frame[:0], int64(len(o.line)), 10)
+	o.frame = append(o.frame, ' ')
+	o.frame = append(o.frame, o.line...)
+	return o.sender.Write(o.frame)
+}
+
+// release return first n buffered events
+func (o *Syslog) release(n int, failed bool, outChan chan<- *event.Event) {
+	for i, e := range o.events[:n] {
+		if failed {
+			e.Failed = true
+		}
+		outChan <- e
+		o.events[i] = nil
+	}
+	o.events = o.events[:copy(o.events, o.events[n:])]
+}
+
+func (o *Syslog) flush(now time.Time, outChan chan<- *event.Event) {
+	sent, lost, err := o.sender.Flush(now)
+	o.release(sent, false, outChan)
+	o.release(lost, true, outChan)
+	if err != nil {
+		log.Warn().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("address", o.cfg.Address).Int("pending", o.sender.Pending()).
+			Err(err).Msg("send failed")
+	}
+	if n := o.sender.Dropped(); n > 0 {
+		log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Int("messages", n).Msg("buffer overflow, messages dropped")
+	}
+}
+
+func (o *Syslog) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	ticker := time.NewTicker(o.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-inChan:
+			if !ok {
+				now := time.Now()
+				// last attempt without reconnect delay
+				o.sender.Retry()
+				o.flush(now, outChan)
+				if o.sender.Pending() > 0 {
+					log.Error().Str("config", o.common.Config).Str("output", o.cfg.Type).Str("address", o.cfg.Address).
+						Int("pending", o.sender.Pending()).Msg("not sended on shutdown, dropped")
+				}
+				o.release(len(o.events), true, outChan)
+				o.sender.Close()
+				return nil
+			}
+			if o.add(e) {
+				o.events = append(o.events, e)
+			} else {
+				e.Failed = true
+				outChan <- e
+			}
+		case now := <-ticker.C:
+			o.flush(now, outChan)
+		}
+	}
+}
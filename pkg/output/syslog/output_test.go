@@ -0,0 +1,340 @@
+package syslog_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.ConfigRaw
+		wantErr bool
+	}{
+		{name: "default", cfg: config.ConfigRaw{"type": "syslog"}},
+		{name: "rfc3164", cfg: config.ConfigRaw{"type": "syslog", "format": "rfc3164", "protocol": "tcp", "facility": "local7"}},
+		{name: "invalid format", cfg: config.ConfigRaw{"type": "syslog", "format": "rfc5425"}, wantErr: true},
+		{name: "invalid protocol", cfg: config.ConfigRaw{"type": "syslog", "protocol": "http"}, wantErr: true},
+		{name: "invalid facility", cfg: config.ConfigRaw{"type": "syslog", "facility": "local8"}, wantErr: true},
+		{name: "invalid severity", cfg: config.ConfigRaw{"type": "syslog", "severity": "8"}, wantErr: true},
+		{name: "invalid app_name", cfg: config.ConfigRaw{"type": "syslog", "app_name": "%{app"}, wantErr: true},
+		{name: "message not set", cfg: config.ConfigRaw{"type": "syslog", "message": ""}, wantErr: true},
+		{name: "invalid buffer_size", cfg: config.ConfigRaw{"type": "syslog", "max_size": "64k", "buffer_size": "32k"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := output.New(&tt.cfg, &config.Common{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// server is a syslog server (udp, or tcp and tls with octet-counted framing)
+type server struct {
+	t *testing.T
+
+	ln net.Listener
+	pc net.PacketConn
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    []net.Conn
+	messages []string
+}
+
+func listen(t *testing.T, protocol, addr string, tlsConfig *tls.Config) *server {
+	s := &server{t: t}
+	var err error
+	switch protocol {
+	case "udp":
+		if s.pc, err = net.ListenPacket("udp", addr); err != nil {
+			t.Fatal(err)
+		}
+		s.wg.Add(1)
+		go s.readPackets()
+		return s
+	case "tls":
+		s.ln, err = tls.Listen("tcp", addr, tlsConfig)
+	default:
+		s.ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+func (s *server) readPackets() {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, string(buf[:n]))
+		s.mu.Unlock()
+	}
+}
+
+func (s *server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.read(conn)
+	}
+}
+
+// read octet-counted messages
+func (s *server) read(conn net.Conn) {
+	defer s.wg.Done()
+	r := bufio.NewReader(conn)
+	for {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+		n, err := strconv.Atoi(length[:len(length)-1])
+		if err != nil {
+			s.t.Errorf("invalid frame length %q", length)
+			return
+		}
+		msg := make([]byte, n)
+		if _, err = io.ReadFull(r, msg); err != nil {
+			s.t.Errorf("read frame: %v", err)
+			return
+		}
+		s.mu.Lock()
+		s.messages = append(s.messages, string(msg))
+		s.mu.Unlock()
+	}
+}
+
+// wait return received messages (wait until n messages received or timeout)
+func (s *server) wait(n int, timeout time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		s.mu.Lock()
+		messages := append([]string(nil), s.messages...)
+		s.mu.Unlock()
+		if len(messages) >= n || time.Now().After(deadline) {
+			return messages
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *server) close() {
+	if s.pc != nil {
+		s.pc.Close()
+	} else {
+		s.ln.Close()
+		s.mu.Lock()
+		for _, conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+	}
+	s.wg.Wait()
+}
+
+func TestSyslog(t *testing.T) {
+	testDir := t.TempDir()
+	certFile, keyFile, err := test.GenerateCert(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 123456789, time.UTC)
+	newEvents := func() []*event.Event {
+		return []*event.Event{
+			{
+				Timestamp: ts,
+				Fields: map[string]interface{}{
+					"host": "web1", "app": "nginx", "pid": 42, "level": "ERROR", "facility": "local3", "message": "upstream timed out",
+				},
+			},
+			{Timestamp: ts, Fields: map[string]interface{}{"app": "my app", "facility": 100, "message": "started"}},
+			{Timestamp: ts, Fields: map[string]interface{}{"host": "web2", "level": 7, "message": ""}},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		protocol string
+		cfg      config.ConfigRaw
+		late     bool // server started after events
+		want     []string
+	}{
+		{
+			name:     "udp rfc5424",
+			protocol: "udp",
+			want: []string{
+				"<11>1 2021-03-04T05:06:07.123456Z web1 nginx - - - upstream timed out",
+				"<14>1 2021-03-04T05:06:07.123456Z localhost my_app - - - started",
+				"<15>1 2021-03-04T05:06:07.123456Z web2 - - - -",
+			},
+		},
+		{
+			name:     "tcp rfc3164",
+			protocol: "tcp",
+			cfg:      config.ConfigRaw{"format": "rfc3164", "facility": "local0", "facility_field": "facility", "proc_id": "%{pid}"},
+			want: []string{
+				"<155>Mar  4 05:06:07 web1 nginx[42]: upstream timed out",
+				"<134>Mar  4 05:06:07 localhost my_app: started",
+				"<135>Mar  4 05:06:07 web2 -:",
+			},
+		},
+		{
+			name:     "tls rfc5424",
+			protocol: "tls",
+			cfg: config.ConfigRaw{
+				"severity_field": "", "severity": "notice", "msg_id": "%{app}-%{host}", "message": "%{host}: %{message}",
+				"tls": map[string]interface{}{"ca_file": certFile},
+			},
+			want: []string{
+				"<13>1 2021-03-04T05:06:07.123456Z web1 nginx - nginx-web1 - web1: upstream timed out",
+				"<13>1 2021-03-04T05:06:07.123456Z localhost my_app - - - %{host}: started",
+				"<13>1 2021-03-04T05:06:07.123456Z web2 - - - - web2: ",
+			},
+		},
+		{
+			name:     "reconnect",
+			protocol: "tcp",
+			late:     true,
+			want: []string{
+				"<11>1 2021-03-04T05:06:07.123456Z web1 nginx - - - upstream timed out",
+				"<14>1 2021-03-04T05:06:07.123456Z localhost my_app - - - started",
+				"<15>1 2021-03-04T05:06:07.123456Z web2 - - - -",
+			},
+		},
+		{
+			name:     "truncate on rune boundary",
+			protocol: "udp",
+			cfg:      config.ConfigRaw{"max_size": "64", "message": "éééééééééééééééééééé"},
+			want: []string{
+				"<11>1 2021-03-04T05:06:07.123456Z web1 nginx - - - éééééé",
+				"<14>1 2021-03-04T05:06:07.123456Z localhost my_app - - - ééé",
+				"<15>1 2021-03-04T05:06:07.123456Z web2 - - - - éééééééé",
+			},
+		},
+		{
+			name:     "buffer overflow",
+			protocol: "tcp",
+			cfg:      config.ConfigRaw{"max_size": "64", "buffer_size": "100"},
+			late:     true,
+			want: []string{
+				// truncated to max_size
+				"<11>1 2021-03-04T05:06:07.123456Z web1 nginx - - - upstream time",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := test.FreeAddr(tt.protocol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			startServer := func() *server {
+				return listen(t, tt.protocol, addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+			}
+			var srv *server
+			if !tt.late {
+				srv = startServer()
+				defer srv.close()
+			}
+
+			cfg := config.ConfigRaw{
+				"type":           "syslog",
+				"address":        addr,
+				"protocol":       tt.protocol,
+				"flush_interval": 10 * time.Millisecond,
+				"retry_backoff":  10 * time.Millisecond,
+			}
+			for k, v := range tt.cfg {
+				cfg[k] = v
+			}
+			o, err := output.New(&cfg, &config.Common{Hostname: "localhost"})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			events := newEvents()
+			inChan := make(chan *event.Event, len(events))
+			outChan := make(chan *event.Event, len(events))
+			done := make(chan error)
+			go func() {
+				done <- o.Start(inChan, outChan)
+			}()
+			for _, e := range events {
+				inChan <- e
+			}
+			if tt.late {
+				// messages are buffered until connect
+				time.Sleep(50 * time.Millisecond)
+				srv = startServer()
+				defer srv.close()
+			}
+			// delivered by flush (before shutdown)
+			got := srv.wait(len(tt.want), 2*time.Second)
+			close(inChan)
+			if err = <-done; err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			if len(outChan) != len(events) {
+				t.Errorf("out events = %d, want %d", len(outChan), len(events))
+			}
+			if tt.late {
+				// no more messages after shutdown
+				got = srv.wait(len(tt.want)+1, 100*time.Millisecond)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	}
+}
@@ -0,0 +1,204 @@
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/event"
+)
+
+type nodeKind int8
+
+const (
+	nodeText nodeKind = iota
+	nodeField
+	nodeTime
+)
+
+type templateNode struct {
+	kind  nodeKind
+	value string   // text, field name or time layout
+	path  []string // field path (for nested fields)
+}
+
+// Template is a format string with event fields and timestamp, like "/data/%{host}/%{+2006-01-02}.log".
+//
+// %{field} (or %{field.subfield} for nested maps) replaced with event field value,
+// if field not found, it stay as is (like "%{field}").
+// %{+layout} replaced with event timestamp (in UTC), formatted with Go time layout.
+type Template struct {
+	format string
+	nodes  []templateNode
+}
+
+// NewTemplate parse template format string
+func NewTemplate(format string) (*Template, error) {
+	t := &Template{format: format}
+	f := format
+	for len(f) > 0 {
+		start := strings.Index(f, "%{")
+		if start == -1 {
+			t.nodes = append(t.nodes, templateNode{kind: nodeText, value: f})
+			break
+		}
+		if start > 0 {
+			t.nodes = append(t.nodes, templateNode{kind: nodeText, value: f[:start]})
+		}
+		f = f[start+2:]
+		end := strings.IndexByte(f, '}')
+		if end == -1 {
+			return nil, fmt.Errorf("parse template '%s': expect }", format)
+		}
+		name := f[:end]
+		f = f[end+1:]
+		if name == "" || name == "+" {
+			return nil, fmt.Errorf("parse template '%s': empty parameter", format)
+		}
+		if name[0] == '+' {
+			t.nodes = append(t.nodes, templateNode{kind: nodeTime, value: name[1:]})
+		} else {
+			t.nodes = append(t.nodes, templateNode{kind: nodeField, value: name, path: strings.Split(name, ".")})
+		}
+	}
+	return t, nil
+}
+
+// String return template format string
+func (t *Template) String() string {
+	return t.format
+}
+
+// Static return true, if template has no parameters
+func (t *Template) Static() bool {
+	for i := range t.nodes {
+		if t.nodes[i].kind != nodeText {
+			return false
+		}
+	}
+	return true
+}
+
+// Append append executed template to b, found is false if some fields not found
+func (t *Template) Append(b []byte, e *event.Event) (_ []byte, found bool) {
+	return t.AppendEscaped(b, e, nil)
+}
+
+// AppendEscaped append executed template to b, substituted field values are escaped in place (if escape is not nil),
+// found is false if some fields not found
+func (t *Template) AppendEscaped(b []byte, e *event.Event, escape func(v []byte)) (_ []byte, found bool) {
+	found = true
+	for i := range t.nodes {
+		n := &t.nodes[i]
+		switch n.kind {
+		case nodeText:
+			b = append(b, n.value...)
+		case nodeTime:
+			b = e.Timestamp.UTC().AppendFormat(b, n.value)
+		case nodeField:
+			if v, ok := Lookup(e.Fields, n.path); ok {
+				start := len(b)
+				b = AppendValue(b, v)
+				if escape != nil {
+					escape(b[start:])
+				}
+			} else {
+				found = false
+				b = append(b, "%{"...)
+				b = append(b, n.value...)
+				b = append(b, '}')
+			}
+		}
+	}
+	return b, found
+}
+
+// Execute return executed template, found is false if some fields not found
+func (t *Template) Execute(e *event.Event) (string, bool) {
+	if len(t.nodes) == 1 && t.nodes[0].kind == nodeText {
+		return t.nodes[0].value, true
+	}
+	b, found := t.Append(make([]byte, 0, 2*len(t.format)), e)
+	return string(b), found
+}
+
+// Lookup return field value by path (for nested maps)
+func Lookup(fields map[string]interface{}, path []string) (interface{}, bool) {
+	var (
+		v  interface{}
+		ok bool
+	)
+	for i, name := range path {
+		if v, ok = fields[name]; !ok {
+			return nil, false
+		}
+		if i < len(path)-1 {
+			if fields, ok = v.(map[string]interface{}); !ok {
+				return nil, false
+			}
+		}
+	}
+	return v, true
+}
+
+// AppendValue append field value as text
+func AppendValue(b []byte, v interface{}) []byte {
+	switch n := v.(type) {
+	case string:
+		return append(b, n...)
+	case []byte:
+		return append(b, n...)
+	case int:
+		return strconv.AppendInt(b, int64(n), 10)
+	case int64:
+		return strconv.AppendInt(b, n, 10)
+	case int32:
+		return strconv.AppendInt(b, int64(n), 10)
+	case uint:
+		return strconv.AppendUint(b, uint64(n), 10)
+	case uint64:
+		return strconv.AppendUint(b, n, 10)
+	case uint32:
+		return strconv.AppendUint(b, uint64(n), 10)
+	case float64:
+		return strconv.AppendFloat(b, n, 'f', -1, 64)
+	case float32:
+		return strconv.AppendFloat(b, float64(n), 'f', -1, 32)
+	case bool:
+		return strconv.AppendBool(b, n)
+	case time.Time:
+		return n.AppendFormat(b, time.RFC3339Nano)
+	case nil:
+		return b
+	default:
+		return append(b, fmt.Sprint(v)...)
+	}
+}
+
+// ToFloat convert numeric (or numeric string) field value
+func ToFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,51 @@
+package output_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/output"
+)
+
+func TestTemplate(t *testing.T) {
+	e := &event.Event{
+		Timestamp: time.Date(2021, 3, 4, 5, 6, 7, 0, time.FixedZone("MSK", 3*3600)),
+		Fields: map[string]interface{}{
+			"host":  "localhost",
+			"count": int64(2),
+			"nested": map[string]interface{}{
+				"app": "web",
+			},
+		},
+	}
+	tests := []struct {
+		format    string
+		want      string
+		wantFound bool
+		wantErr   bool
+	}{
+		{format: "/data/static.log", want: "/data/static.log", wantFound: true},
+		{format: "/data/%{host}/%{+2006-01-02T15}.log", want: "/data/localhost/2021-03-04T02.log", wantFound: true},
+		{format: "%{nested.app}-%{count}", want: "web-2", wantFound: true},
+		{format: "%{host}-%{missed}-%{nested.missed}", want: "localhost-%{missed}-%{nested.missed}", wantFound: false},
+		{format: "%{host", wantErr: true},
+		{format: "%{}", wantErr: true},
+		{format: "%{+}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			tpl, err := output.NewTemplate(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, found := tpl.Execute(e)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("Execute() = (%q, %v), want (%q, %v)", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
@@ -1,10 +1,28 @@
 package output_init
 
 import (
-	"github.com/msaf1980/log-exporter/pkg/filter"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"github.com/msaf1980/log-exporter/pkg/output/clickhouse"
+	"github.com/msaf1980/log-exporter/pkg/output/elasticsearch"
+	"github.com/msaf1980/log-exporter/pkg/output/file"
+	"github.com/msaf1980/log-exporter/pkg/output/graphite"
+	httpoutput "github.com/msaf1980/log-exporter/pkg/output/http"
+	"github.com/msaf1980/log-exporter/pkg/output/kafka"
+	"github.com/msaf1980/log-exporter/pkg/output/loki"
+	"github.com/msaf1980/log-exporter/pkg/output/prometheus"
 	"github.com/msaf1980/log-exporter/pkg/output/stdout"
+	"github.com/msaf1980/log-exporter/pkg/output/syslog"
 )
 
 func init() {
-	filter.Set(stdout.Name, stdout.New)
+	output.Set(clickhouse.Name, clickhouse.New)
+	output.Set(elasticsearch.Name, elasticsearch.New)
+	output.Set(file.Name, file.New)
+	output.Set(graphite.Name, graphite.New)
+	output.Set(httpoutput.Name, httpoutput.New)
+	output.Set(kafka.Name, kafka.New)
+	output.Set(loki.Name, loki.New)
+	output.Set(prometheus.Name, prometheus.New)
+	output.Set(stdout.Name, stdout.New)
+	output.Set(syslog.Name, syslog.New)
 }
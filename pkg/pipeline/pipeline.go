@@ -2,14 +2,28 @@ package pipeline
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"sync"
 
 	"github.com/msaf1980/log-exporter/pkg/config"
 	"github.com/msaf1980/log-exporter/pkg/event"
+	"github.com/msaf1980/log-exporter/pkg/filter"
+	"github.com/msaf1980/log-exporter/pkg/fstatdb"
 	"github.com/msaf1980/log-exporter/pkg/input"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	"golang.org/x/sync/errgroup"
 )
 
+// Pipeline is inputs -> filters (chained) -> outputs.
+//
+// Pipeline is completed, when all inputs are completed (like stdin on EOF or file in read mode) and all events are drained by filters and outputs.
+// On shutdown (ctx cancel) or filter/output failure inputs are stopped and events are also drained.
 type Pipeline struct {
-	inputs []input.Input
+	inputs  []input.Input
+	filters []filter.Filter
+	outputs []output.Output
+	stores  *fstatdb.Stores // seek stores, shared by inputs
 
 	fchan chan *event.Event
 	ochan chan *event.Event
@@ -17,20 +31,196 @@ type Pipeline struct {
 
 func New(ctx context.Context, common *config.Common, inputs []config.ConfigRaw, filters []config.ConfigRaw, outputs []config.ConfigRaw) (*Pipeline, error) {
 	p := &Pipeline{
-		inputs: make([]input.Input, 0, len(inputs)),
-		// filters: filters,
-		// outputs: outputs,
+		inputs:  make([]input.Input, 0, len(inputs)),
+		filters: make([]filter.Filter, 0, len(filters)),
+		outputs: make([]output.Output, 0, len(outputs)),
+		stores:  fstatdb.NewStores(),
 
 		fchan: make(chan *event.Event, 10*len(inputs)),
 		ochan: make(chan *event.Event, 10*len(inputs)),
 	}
+	types := make(map[string]bool)
 	for i := range inputs {
-		if in, err := input.New(&inputs[i], common); err == nil {
-			p.inputs = append(p.inputs, in)
+		typ := inputs[i].GetStringWithDefault("type", "")
+		if inputs[i].GetStringWithDefault("id", "") == "" {
+			// default id is a type for first input of type, type with index for next
+			if types[typ] {
+				inputs[i]["id"] = typ + "-" + strconv.Itoa(i)
+			}
+			types[typ] = true
+		}
+		in, err := input.New(&inputs[i], common)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := in.(input.Seeking); ok {
+			if path, opts := s.Seek(); path != "" {
+				ns, err := p.stores.Namespace(path, opts)
+				if err != nil {
+					if err == fstatdb.ErrDuplicateInput {
+						return nil, errors.New("input '" + typ + "': id '" + opts.Input + "' already used with seek_file " + path + ", set unique id")
+					}
+					return nil, errors.New("input '" + typ + "': seek_file " + path + ": " + err.Error())
+				}
+				s.SetSeeker(ns)
+			}
+		}
+		p.inputs = append(p.inputs, in)
+	}
+	for i := range filters {
+		if fi, err := filter.New(&filters[i], common); err == nil {
+			p.filters = append(p.filters, fi)
+		} else {
+			return nil, err
+		}
+	}
+	for i := range outputs {
+		if out, err := output.New(&outputs[i], common); err == nil {
+			p.outputs = append(p.outputs, out)
 		} else {
 			return nil, err
 		}
 	}
 
+	for i, in := range p.inputs {
+		if a, ok := in.(input.Acking); !ok || !a.WaitAck() {
+			continue
+		}
+		// with not acknowledging output state is committed before delivery
+		for _, out := range p.outputs {
+			if _, ok := out.(output.Acknowledging); !ok {
+				return nil, errors.New("input '" + inputs[i].GetStringWithDefault("type", "") + "': wait_ack not supported with output '" + out.Name() + "'")
+			}
+		}
+	}
+
 	return p, nil
 }
+
+// drain read events from channel (after filter or output failure) for prevent pipeline lock, events acknowledged as not delivered
+func drain(ch <-chan *event.Event) {
+	for e := range ch {
+		e.Failed = true
+		event.Put(e)
+	}
+}
+
+// Start run pipeline and wait for completion
+func (p *Pipeline) Start(ctx context.Context) error {
+	var inWg, drainWg sync.WaitGroup
+
+	// drain in background, failed filter or output must return for stop inputs
+	drainAsync := func(ch <-chan *event.Event) {
+		drainWg.Add(1)
+		go func() {
+			defer drainWg.Done()
+			drain(ch)
+		}()
+	}
+
+	// inputs are stopped on filter or output failure
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, in := range p.inputs {
+		in := in
+		inWg.Add(1)
+		eg.Go(func() error {
+			defer inWg.Done()
+			return in.Start(ctx, p.fchan)
+		})
+	}
+	go func() {
+		inWg.Wait()
+		close(p.fchan)
+	}()
+
+	// filters chain
+	inChan := p.fchan
+	for i, fi := range p.filters {
+		fi := fi
+		in := inChan
+		out := p.ochan
+		if i < len(p.filters)-1 {
+			out = make(chan *event.Event, cap(p.ochan))
+		}
+		eg.Go(func() error {
+			defer close(out)
+			err := fi.Start(in, out)
+			drainAsync(in)
+			return err
+		})
+		inChan = out
+	}
+	if len(p.filters) == 0 {
+		p.ochan = p.fchan
+	}
+
+	// outputs, processed events returned to rchan for reuse
+	rchan := make(chan *event.Event, cap(p.ochan))
+	switch len(p.outputs) {
+	case 0:
+		// no outputs, events are dropped
+		go func() {
+			for e := range p.ochan {
+				rchan <- e
+			}
+			close(rchan)
+		}()
+	case 1:
+		out := p.outputs[0]
+		eg.Go(func() error {
+			defer close(rchan)
+			err := out.Start(p.ochan, rchan)
+			drainAsync(p.ochan)
+			return err
+		})
+	default:
+		var outWg sync.WaitGroup
+		chans := make([]chan *event.Event, len(p.outputs))
+		for i, out := range p.outputs {
+			out := out
+			ch := make(chan *event.Event, cap(p.ochan))
+			chans[i] = ch
+			outWg.Add(1)
+			eg.Go(func() error {
+				defer outWg.Done()
+				err := out.Start(ch, rchan)
+				drainAsync(ch)
+				return err
+			})
+		}
+		go func() {
+			last := len(chans) - 1
+			for e := range p.ochan {
+				// clone before send, original event can be returned to pool after output,
+				// event acknowledged after all outputs
+				event.ShareAck(e, len(chans))
+				for _, ch := range chans[:last] {
+					c := event.Clone(e)
+					c.Acker = e.Acker
+					ch <- c
+				}
+				chans[last] <- e
+			}
+			for _, ch := range chans {
+				close(ch)
+			}
+		}()
+		go func() {
+			outWg.Wait()
+			close(rchan)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for e := range rchan {
+			event.Put(e)
+		}
+		close(done)
+	}()
+
+	err := eg.Wait()
+	drainWg.Wait()
+	<-done
+	return err
+}
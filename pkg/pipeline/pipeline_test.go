@@ -0,0 +1,284 @@
+package pipeline_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/msaf1980/log-exporter/pkg/codec_init"
+	"github.com/msaf1980/log-exporter/pkg/config"
+	"github.com/msaf1980/log-exporter/pkg/event"
+	_ "github.com/msaf1980/log-exporter/pkg/filter_init"
+	_ "github.com/msaf1980/log-exporter/pkg/input_init"
+	"github.com/msaf1980/log-exporter/pkg/output"
+	_ "github.com/msaf1980/log-exporter/pkg/output_init"
+	"github.com/msaf1980/log-exporter/pkg/pipeline"
+	"github.com/msaf1980/log-exporter/pkg/test"
+	"github.com/rs/zerolog"
+)
+
+type collect struct {
+	name string
+}
+
+var (
+	collectedMu sync.Mutex
+	collected   = map[string][]*event.Event{}
+)
+
+func (c *collect) Name() string {
+	return "collect"
+}
+
+func (c *collect) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	for e := range inChan {
+		collectedMu.Lock()
+		collected[c.name] = append(collected[c.name], event.Clone(e))
+		collectedMu.Unlock()
+		outChan <- e
+	}
+	return nil
+}
+
+// fail is output, failed on start
+type fail struct{}
+
+func (f *fail) Name() string {
+	return "fail"
+}
+
+func (f *fail) Start(inChan <-chan *event.Event, outChan chan<- *event.Event) error {
+	return errors.New("output 'fail': failed")
+}
+
+func init() {
+	logLevel := os.Getenv("GO_TESTS_LEVEL")
+	if logLevel == "trace" {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else if logLevel == "debug" {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	} else if logLevel == "info" {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+
+	output.Set("collect", func(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+		return &collect{name: cfg.GetStringWithDefault("name", "")}, nil
+	})
+	output.Set("fail", func(cfg *config.ConfigRaw, common *config.Common) (output.Output, error) {
+		return &fail{}, nil
+	})
+}
+
+func TestPipelineDrain(t *testing.T) {
+	collectedMu.Lock()
+	collected = map[string][]*event.Event{}
+	collectedMu.Unlock()
+
+	testDir, err := os.MkdirTemp("", "log-exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testDir)
+
+	lines := test.Strings(64, 100)
+	fpath := path.Join(testDir, "f1.log")
+	f, err := os.Create(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines {
+		f.WriteString(line + "\n")
+	}
+	f.Close()
+
+	common := &config.Common{Hostname: "localhost"}
+	inputs := []config.ConfigRaw{
+		{"type": "file", "path": path.Join(testDir, "*.log"), "seek_file": path.Join(testDir, "seek.db"), "mode": 1},
+	}
+	filters := []config.ConfigRaw{
+		{"type": "add_field", "fields": map[string]interface{}{"test": "%{host}"}},
+		{"type": "remove_field", "fields": []interface{}{"type"}},
+	}
+	outputs := []config.ConfigRaw{
+		{"type": "collect", "name": "out1"},
+		{"type": "collect", "name": "out2"},
+	}
+
+	p, err := pipeline.New(context.Background(), common, inputs, filters, outputs)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err = p.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	wantEvents := make([]*event.Event, 0, len(lines))
+	for _, line := range lines {
+		wantEvents = append(wantEvents, &event.Event{
+			Fields: map[string]interface{}{"name": "line", "host": "localhost", "message": line, "path": fpath, "test": "localhost"},
+			Tags:   map[string]int{},
+		})
+	}
+	for _, name := range []string{"out1", "out2"} {
+		if eq, diff := test.EventsCmp(wantEvents, collected[name], true, true, false); !eq {
+			t.Errorf("%s events (want %d, got %d) mismatch:\n%s", name, len(wantEvents), len(collected[name]), diff)
+		}
+	}
+}
+
+func TestPipelineOutputFailed(t *testing.T) {
+	testDir := t.TempDir()
+	fpath := path.Join(testDir, "f1.log")
+	if err := os.WriteFile(fpath, []byte("line1\nline2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	common := &config.Common{Hostname: "localhost"}
+	inputs := []config.ConfigRaw{
+		{"type": "file", "path": fpath},
+	}
+	outputs := []config.ConfigRaw{
+		{"type": "fail"},
+	}
+
+	p, err := pipeline.New(context.Background(), common, inputs, nil, outputs)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	// tailed file never ended, inputs must be stopped on output failure
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.Start(context.Background())
+	}()
+	select {
+	case err = <-errCh:
+		if err == nil || err.Error() != "output 'fail': failed" {
+			t.Errorf("Start() error = %v, want output failure", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start() not stopped on output failure")
+	}
+}
+
+func TestPipelineSeekFile(t *testing.T) {
+	testDir := t.TempDir()
+	for _, name := range []string{"f1", "f2"} {
+		if err := os.WriteFile(path.Join(testDir, name+".log"), []byte(name+" line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seekFile := path.Join(testDir, "seek.db")
+	newInput := func(name, id string) config.ConfigRaw {
+		cfg := config.ConfigRaw{"type": "file", "path": path.Join(testDir, name+".log"), "seek_file": seekFile, "mode": 1}
+		if id != "" {
+			cfg["id"] = id
+		}
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		inputs  []config.ConfigRaw
+		wantErr bool
+		want    int // events on first start (nothing readed again on second start)
+	}{
+		{name: "default ids", inputs: []config.ConfigRaw{newInput("f1", ""), newInput("f2", "")}, want: 2},
+		{name: "duplicate ids", inputs: []config.ConfigRaw{newInput("f1", "logs"), newInput("f2", "logs")}, wantErr: true},
+		{name: "duplicate default id", inputs: []config.ConfigRaw{newInput("f1", ""), newInput("f2", "file")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(seekFile)
+			for n, want := range []int{tt.want, 0} {
+				collectedMu.Lock()
+				collected = map[string][]*event.Event{}
+				collectedMu.Unlock()
+
+				common := &config.Common{Hostname: "localhost"}
+				outputs := []config.ConfigRaw{{"type": "collect", "name": "out"}}
+				p, err := pipeline.New(context.Background(), common, tt.inputs, nil, outputs)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if err = p.Start(context.Background()); err != nil {
+					t.Fatalf("[%d] Start() error = %v", n, err)
+				}
+				if got := len(collected["out"]); got != want {
+					t.Errorf("[%d] events = %d, want %d", n, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPipelineWaitAck(t *testing.T) {
+	testDir := t.TempDir()
+	fpath := path.Join(testDir, "f1.log")
+	if err := os.WriteFile(fpath, []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		status   int32
+		requests int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	common := &config.Common{Hostname: "localhost"}
+	inputs := []config.ConfigRaw{
+		{"type": "file", "path": fpath, "seek_file": path.Join(testDir, "seek.db"), "mode": 1, "wait_ack": true},
+	}
+
+	if _, err := pipeline.New(context.Background(), common, inputs, nil, []config.ConfigRaw{
+		{"type": "graphite", "address": "127.0.0.1:2003", "metrics": []interface{}{map[string]interface{}{"path": "lines"}}},
+	}); err == nil || !strings.Contains(err.Error(), "wait_ack not supported") {
+		t.Fatalf("New() with not acknowledging output error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		status       int
+		wantRequests int32
+	}{
+		// delivery failed, offset not committed
+		{name: "rejected", status: http.StatusBadRequest, wantRequests: 1},
+		// lines readed again
+		{name: "delivered", status: http.StatusOK, wantRequests: 1},
+		// offset committed
+		{name: "nothing to read", status: http.StatusOK, wantRequests: 0},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&status, int32(tt.status))
+		atomic.StoreInt32(&requests, 0)
+		outputs := []config.ConfigRaw{
+			{"type": "http", "url": srv.URL, "flush_interval": 10 * time.Millisecond, "retry_backoff": time.Millisecond},
+		}
+		p, err := pipeline.New(context.Background(), common, inputs, nil, outputs)
+		if err != nil {
+			t.Fatalf("%s: New() error = %v", tt.name, err)
+		}
+		if err = p.Start(context.Background()); err != nil {
+			t.Fatalf("%s: Start() error = %v", tt.name, err)
+		}
+		if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+			t.Errorf("%s: requests = %d, want %d", tt.name, got, tt.wantRequests)
+		}
+	}
+}